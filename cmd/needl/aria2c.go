@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// downloadEngine is what does the transfer of each queued file
+type downloadEngine string
+
+const (
+	engineBuiltin downloadEngine = "builtin" // DownloadToFile (the default)
+	engineAria2c  downloadEngine = "aria2c"  // an aria2c process for each file (see downloadWithAria2c)
+)
+
+// aria2c's split must be at least 1MiB and at most 1GiB
+const (
+	aria2cMinSplitSize = 1 << 20
+	aria2cMaxSplitSize = 1 << 30
+)
+
+// aria2cChecksumFailed is the status that aria2c exits with when a download doesn't match its checksum
+const aria2cChecksumFailed = 32
+
+func parseDownloadEngine(s string) (downloadEngine, error) {
+	switch downloadEngine(s) {
+	case "":
+		return engineBuiltin, nil
+	case engineBuiltin, engineAria2c:
+		return downloadEngine(s), nil
+	}
+	return "", fmt.Errorf("unrecognized engine '%s' (expected one of: %s, %s)", s, engineBuiltin, engineAria2c)
+}
+
+// findAria2c returns the path of the aria2c executable to download with, or an empty string if the
+// built-in downloader should be used, either because it was asked for, or because aria2c can't be
+// used (which is logged).
+func findAria2c(log frog.Logger, engine downloadEngine, cfg config.Config, scfg config.Scraper) string {
+	if engine != engineAria2c {
+		return ""
+	}
+	var reason string
+	switch {
+	case len(scfg.UnixSocket) > 0:
+		reason = "aria2c can't connect over a unix socket"
+	case len(cfg.RateLimit) > 0:
+		reason = "aria2c can't share the rate_limit"
+	}
+	if len(reason) > 0 {
+		log.Warning("Using the built-in downloader instead of aria2c", frog.String("reason", reason))
+		return ""
+	}
+	path, err := exec.LookPath("aria2c")
+	if err != nil {
+		log.Warning("Using the built-in downloader instead of aria2c", frog.String("reason", "aria2c not found"), frog.Err(err))
+		return ""
+	}
+	log.Verbose("downloading with aria2c", frog.PathAbs(path))
+	return path
+}
+
+// aria2cChecksumAlgos maps the checksum algorithms that aria2c also knows to its names for them
+var aria2cChecksumAlgos = map[string]string{"md5": "md5", "sha1": "sha-1", "sha256": "sha-256", "sha512": "sha-512"}
+
+// aria2cInput returns the input file (as read by aria2c's --input-file) for downloading remoteURL into
+// dir, as out. The options go in the input, rather than on the command line, so that the password (and
+// any cookies) aren't visible to other processes.
+func aria2cInput(remoteURL, dir, out string, opts DownloadOptions) string {
+	var b strings.Builder
+	b.WriteString(remoteURL + "\n")
+	option := func(key, value string) {
+		b.WriteString(" " + key + "=" + value + "\n")
+	}
+	option("dir", dir)
+	option("out", out)
+	option("continue", "true")
+	option("allow-overwrite", "true")
+	option("auto-file-renaming", "false")
+	// the listing's time is applied afterwards (if known), so this is only used when it isn't
+	option("remote-time", "true")
+	// both count every attempt (so zero is unlimited for both)
+	option("max-tries", strconv.FormatUint(uint64(opts.MaxRetry), 10))
+
+	split := opts.PartConcurrency
+	if split <= 0 {
+		split = defaultPartConcurrency
+	}
+	if opts.PartSize <= 0 || opts.Sequential || opts.Connections != nil {
+		// each aria2c holds a single connection from the limit
+		split = 1
+	}
+	option("split", strconv.Itoa(split))
+	option("max-connection-per-server", strconv.Itoa(split))
+	if split > 1 {
+		option("min-split-size", strconv.FormatInt(min(max(opts.PartSize, aria2cMinSplitSize), aria2cMaxSplitSize), 10))
+	}
+
+	if !opts.Checksum.IsZero() {
+		if algo, ok := aria2cChecksumAlgos[opts.Checksum.Algo]; ok {
+			option("checksum", algo+"="+opts.Checksum.Hex)
+		}
+	}
+	if len(opts.UserAgent) > 0 {
+		option("user-agent", opts.UserAgent)
+	}
+	if len(opts.Username) > 0 || len(opts.Password) > 0 {
+		option("http-user", opts.Username)
+		option("http-passwd", opts.Password)
+	}
+	keys := make([]string, 0, len(opts.Headers))
+	for key := range opts.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		option("header", key+": "+opts.Headers[key])
+	}
+	if opts.Client != nil && opts.Client.Jar != nil {
+		if u, err := url.Parse(remoteURL); err == nil {
+			var cookies []string
+			for _, c := range opts.Client.Jar.Cookies(u) {
+				cookies = append(cookies, c.Name+"="+c.Value)
+			}
+			if len(cookies) > 0 {
+				option("header", "Cookie: "+strings.Join(cookies, "; "))
+			}
+		}
+	}
+	return b.String()
+}
+
+// downloadWithAria2c downloads a file from a URL to a local path, as DownloadToFile does, but has the
+// aria2c at aria2cPath do the transfer. The result is checked (against the expected size, prefix, and
+// checksum) and moved into place, and its time is set, the same as with DownloadToFile. An interrupted
+// download is resumed by the next run. The options that only the built-in downloader supports (such as
+// OnProgress, HostBreaker, and RateLimit) are ignored.
+func downloadWithAria2c(
+	ctx context.Context,
+	log frog.Logger,
+	aria2cPath string,
+	remoteURL string,
+	localPath string,
+	opts DownloadOptions,
+) (DownloadResults, error) {
+	res := DownloadResults{
+		ExpectedSize: opts.ExpectedSize,
+		LastModified: opts.ExpectedLastModified,
+		Path:         localPath,
+		FinalURL:     remoteURL,
+	}
+
+	if opts.Lock {
+		lock, err := acquireFileLock(localPath)
+		if err != nil {
+			return res, fmt.Errorf("lock file: %w", err)
+		}
+		defer lock.release()
+	}
+	if err := opts.Connections.acquire(ctx); err != nil {
+		return res, err
+	}
+	defer opts.Connections.release()
+
+	tmpPath, _ := tempPaths(remoteURL, localPath, opts.ExpectedSize)
+	if opts.Sequential {
+		// start over, rather than resuming
+		_ = os.Remove(tmpPath)
+		_ = os.Remove(tmpPath + plan.Aria2cControlSuffix)
+	}
+	// until the download is moved into place, any error leaves the temp file to OnFailure
+	moved := false
+	defer func() {
+		if !moved {
+			cleanUpFailedDownload(log, opts.OnFailure, tmpPath, tmpPath+plan.Aria2cControlSuffix, localPath)
+		}
+	}()
+
+	var checksumRetries uint
+	for {
+		err := runAria2c(ctx, log, aria2cPath, remoteURL, tmpPath, opts)
+		if err != nil && !errors.Is(err, errChecksumMismatch) {
+			if ctx.Err() != nil {
+				return res, ctx.Err()
+			}
+			// the temp file (and aria2c's control file) are left for the next run to resume, unless
+			// OnFailure says otherwise
+			return res, err
+		}
+		if err == nil {
+			err = verifyAria2cDownload(log, tmpPath, opts)
+		}
+		if err == nil {
+			break
+		}
+		// a bad download can't be resumed, so it starts over
+		_ = os.Remove(tmpPath)
+		_ = os.Remove(tmpPath + plan.Aria2cControlSuffix)
+		if !errors.Is(err, errChecksumMismatch) || checksumRetries >= opts.ChecksumRetries {
+			return res, err
+		}
+		checksumRetries++
+		log.Verbose("checksum mismatch, downloading again",
+			frog.Uint("checksum_retry", checksumRetries),
+			frog.Uint("max_checksum_retries", opts.ChecksumRetries),
+			frog.String("url", remoteURL),
+			frog.Err(err),
+		)
+	}
+	res.Retries = checksumRetries
+
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		return res, fmt.Errorf("stat file: %w", err)
+	}
+	res.ActualSize = fi.Size()
+	if res.LastModified.IsZero() {
+		// aria2c set it from the Last-Modified header, if there was one
+		res.LastModified = fi.ModTime()
+	}
+
+	if opts.Sync {
+		if err := syncFile(tmpPath); err != nil {
+			return res, fmt.Errorf("sync file: %w", err)
+		}
+	}
+	if err := moveFile(log, tmpPath, localPath); err != nil {
+		return res, fmt.Errorf("move: %w", err)
+	}
+	moved = true
+	if opts.Sync {
+		if err := syncDir(filepath.Dir(localPath)); err != nil {
+			return res, fmt.Errorf("sync folder: %w", err)
+		}
+	}
+
+	log.Transient("setting file time", frog.Time("time", res.LastModified), frog.Path(localPath))
+	if err := modifyFileTime(localPath, res.LastModified); err != nil {
+		return res, fmt.Errorf("set time failed: %w", err)
+	}
+	return res, nil
+}
+
+// runAria2c runs aria2c to download remoteURL into tmpPath, and returns an error (with what aria2c
+// printed) if it fails
+func runAria2c(ctx context.Context, log frog.Logger, aria2cPath, remoteURL, tmpPath string, opts DownloadOptions) error {
+	input := aria2cInput(remoteURL, filepath.Dir(tmpPath), filepath.Base(tmpPath), opts)
+	cmd := exec.CommandContext(ctx, aria2cPath,
+		"--input-file=-", "--quiet=true", "--console-log-level=error", "--download-result=hide",
+	)
+	cmd.Stdin = strings.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	log.Verbose("start download with aria2c", frog.Int64("total", opts.ExpectedSize), frog.String("url", remoteURL))
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == aria2cChecksumFailed {
+			return fmt.Errorf("%w: %s", errChecksumMismatch, strings.TrimSpace(out.String()))
+		}
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("aria2c exited with status %d: %s", exitErr.ExitCode(), strings.TrimSpace(out.String()))
+		}
+		return fmt.Errorf("aria2c: %w", err)
+	}
+	return nil
+}
+
+// verifyAria2cDownload checks the file that aria2c downloaded against the expected size, prefix, and
+// checksum (since aria2c doesn't know every checksum algorithm)
+func verifyAria2cDownload(log frog.Logger, tmpPath string, opts DownloadOptions) error {
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+	if opts.ExpectedSize > 0 && fi.Size() != opts.ExpectedSize {
+		return fmt.Errorf("expected %d bytes, but aria2c downloaded %d", opts.ExpectedSize, fi.Size())
+	}
+	if len(opts.ExpectedPrefix) > 0 {
+		f, err := os.Open(tmpPath)
+		if err != nil {
+			return fmt.Errorf("open file: %w", err)
+		}
+		err = verifyPrefix(f, opts.ExpectedPrefix)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+	}
+	if !opts.Checksum.IsZero() {
+		log.Transient("verifying checksum", frog.String("algo", opts.Checksum.Algo), frog.Path(tmpPath))
+		if err := verifyFileChecksum(tmpPath, opts.Checksum); err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncFile flushes the file at path to disk
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_ParseDownloadEngine(t *testing.T) {
+	cases := []struct {
+		Input       string
+		Expected    downloadEngine
+		ExpectedErr bool
+	}{
+		{"", engineBuiltin, false},
+		{"builtin", engineBuiltin, false},
+		{"aria2c", engineAria2c, false},
+		{"curl", "", true},
+	}
+	for _, tc := range cases {
+		actual, err := parseDownloadEngine(tc.Input)
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("'%s': expected error %v, but got %v", tc.Input, tc.ExpectedErr, err)
+		}
+		if actual != tc.Expected {
+			t.Errorf("'%s': expected '%s', but got '%s'", tc.Input, tc.Expected, actual)
+		}
+	}
+}
+
+func Test_Aria2cInput(t *testing.T) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://example.com/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "abc"}})
+
+	input := aria2cInput("https://example.com/a.bin", "/dl", "a.tmp", DownloadOptions{
+		MaxRetry:  3,
+		PartSize:  64 << 20,
+		Checksum:  Checksum{Algo: "sha256", Hex: "00ff"},
+		UserAgent: "needl",
+		Username:  "user",
+		Password:  "secret",
+		Headers:   map[string]string{"X-B": "2", "X-A": "1"},
+		Client:    &http.Client{Jar: jar},
+	})
+	expected := strings.Join([]string{
+		"https://example.com/a.bin",
+		" dir=/dl",
+		" out=a.tmp",
+		" continue=true",
+		" allow-overwrite=true",
+		" auto-file-renaming=false",
+		" remote-time=true",
+		" max-tries=3",
+		" split=4",
+		" max-connection-per-server=4",
+		" min-split-size=67108864",
+		" checksum=sha-256=00ff",
+		" user-agent=needl",
+		" http-user=user",
+		" http-passwd=secret",
+		" header=X-A: 1",
+		" header=X-B: 2",
+		" header=Cookie: session=abc",
+		"",
+	}, "\n")
+	if input != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, input)
+	}
+
+	// without a part size, or with a connection limit, each file uses a single connection
+	input = aria2cInput("https://example.com/a.bin", "/dl", "a.tmp", DownloadOptions{PartSize: 64 << 20, Connections: newConnLimiter(2)})
+	if !strings.Contains(input, " split=1\n") || strings.Contains(input, "min-split-size") {
+		t.Errorf("expected a single connection, but got:\n%s", input)
+	}
+}
+
+// fakeAria2c writes a script that acts as aria2c, by writing content to the dir and out from its
+// input, and exiting with status.
+func fakeAria2c(t *testing.T, content string, status int) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	path := filepath.Join(t.TempDir(), "aria2c")
+	script := "#!/bin/sh\n" +
+		"while read -r line; do\n" +
+		"  case \"$line\" in dir=*) dir=\"${line#dir=}\";; out=*) out=\"${line#out=}\";; esac\n" +
+		"done\n" +
+		"printf '%s' '" + content + "' > \"$dir/$out\"\n" +
+		"exit " + strconv.Itoa(status) + "\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_DownloadWithAria2c(t *testing.T) {
+	stamp := time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC)
+	good := sha256Checksum([]byte("hello"))
+	cases := []struct {
+		Name        string
+		Content     string
+		Status      int
+		Checksum    Checksum
+		Expected    string
+		ExpectedErr bool
+	}{
+		{"ok", "hello", 0, good, "hello", false},
+		{"size mismatch", "hell", 0, Checksum{}, "", true},
+		{"checksum mismatch", "jello", 0, good, "", true},
+		{"aria2c failed", "hello", 1, Checksum{}, "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			aria2c := fakeAria2c(t, tc.Content, tc.Status)
+			dir := t.TempDir()
+			localPath := filepath.Join(dir, "a.bin")
+			res, err := downloadWithAria2c(context.Background(), &frog.NullLogger{}, aria2c, "https://example.com/a.bin", localPath,
+				DownloadOptions{ExpectedSize: 5, ExpectedLastModified: stamp, Checksum: tc.Checksum},
+			)
+			if (err != nil) != tc.ExpectedErr {
+				t.Fatalf("expected error %v, but got %v", tc.ExpectedErr, err)
+			}
+			b, readErr := os.ReadFile(localPath)
+			if tc.ExpectedErr {
+				if readErr == nil {
+					t.Errorf("expected no local file, but found '%s'", b)
+				}
+				return
+			}
+			if string(b) != tc.Expected {
+				t.Errorf("expected '%s', but got '%s'", tc.Expected, b)
+			}
+			if res.ActualSize != 5 {
+				t.Errorf("expected size 5, but got %d", res.ActualSize)
+			}
+			if fi, err := os.Stat(localPath); err != nil || !fi.ModTime().Equal(stamp) {
+				t.Errorf("expected time %v, but got %v (%v)", stamp, fi.ModTime(), err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("expected only the download to be left, but found %d files", len(entries))
+			}
+		})
+	}
+}
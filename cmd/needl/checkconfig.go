@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// checkConfig loads and validates the config and scrapers files without listing or downloading
+// anything, logs every problem found, and returns the exit status (non-zero if there were problems).
+// If probe is set, then each scraper's base URLs are also sent a HEAD request, to check they are reachable
+// (or for the exec type, its command is looked up).
+func checkConfig(log frog.Logger, configPath, scrapersPath string, probe bool) int {
+	problems := 0
+	fnProblem := func(msg string, err error, fields ...frog.Fielder) {
+		// errors.Join separates each problem with a newline, so log them one at a time
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				problems++
+				log.Error(msg, append(fields, frog.Err(e))...)
+			}
+			return
+		}
+		problems++
+		log.Error(msg, append(fields, frog.Err(err))...)
+	}
+
+	log.Info("Checking config...", frog.Path(configPath))
+	cfg, err := config.Load(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Info("Config file not found (it is optional)", frog.PathAbs(configPath))
+	} else if err != nil {
+		fnProblem("config", err, frog.PathAbs(configPath))
+	} else {
+		if err := cfg.Validate(); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := plan.ParseDuplicatePolicy(cfg.Duplicates); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := plan.ParseLongNamePolicy(cfg.LongNames); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := parseLocalNewerPolicy(cfg.LocalNewer); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := parseUnknownSizePolicy(cfg.UnknownSize); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := parseFailurePolicy(cfg.FailedDownloads); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := parseMatchByPolicy(cfg.MatchBy); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := parseDownloadEngine(cfg.Engine); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if _, err := parseWebhookPolicy(cfg.WebhookOn); err != nil {
+			fnProblem("config", err, frog.PathAbs(configPath))
+		}
+		if len(cfg.Window) > 0 {
+			if _, err := parseDownloadWindow(cfg.Window); err != nil {
+				fnProblem("config", fmt.Errorf("window: %w", err), frog.PathAbs(configPath))
+			}
+		}
+	}
+
+	log.Info("Checking scrapers...", frog.Path(scrapersPath))
+	scrapers, err := config.LoadScrapers(scrapersPath)
+	if err != nil {
+		fnProblem("scrapers", err, frog.PathAbs(scrapersPath))
+	}
+
+	if len(cfg.Scraper) > 0 && scrapers != nil {
+		if _, ok := scrapers[cfg.Scraper]; !ok {
+			fnProblem("config", fmt.Errorf("scraper '%s' not found in %s", cfg.Scraper, scrapersPath), frog.PathAbs(configPath))
+		}
+	}
+
+	names := make([]string, 0, len(scrapers))
+	for name := range scrapers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scfg := cfg.Defaults.Apply(scrapers[name])
+		client := http.Client{Timeout: 15 * time.Second, Transport: plan.ScraperTransport(scfg)}
+		if err := scfg.Validate(); err != nil {
+			fnProblem("scraper", err, frog.String("name", name))
+			continue
+		}
+		if !probe {
+			continue
+		}
+		if err := scfg.ResolveSecrets(); err != nil {
+			fnProblem("probe", err, frog.String("name", name))
+			continue
+		}
+		if scfg.Type == "exec" {
+			// the program lists the files, so its urls aren't requested, but it has to be found
+			if _, err := exec.LookPath(scfg.Command[0]); err != nil {
+				fnProblem("probe", err, frog.String("name", name))
+			}
+			continue
+		}
+		for _, u := range scfg.BaseURLs() {
+			log.Verbose("probing", frog.String("name", name), frog.String("url", u))
+			req, err := http.NewRequest("HEAD", u, nil)
+			if err != nil {
+				fnProblem("probe", err, frog.String("name", name), frog.String("url", u))
+				continue
+			}
+			if len(scfg.UserAgent) > 0 {
+				req.Header.Set("User-Agent", scfg.UserAgent)
+			}
+			for key, value := range scfg.Headers {
+				req.Header.Set(key, value)
+			}
+			if len(scfg.Username) > 0 || len(scfg.Password) > 0 {
+				req.SetBasicAuth(scfg.Username, scfg.Password)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				fnProblem("probe", err, frog.String("name", name), frog.String("url", u))
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				fnProblem("probe", fmt.Errorf("unexpected status %d", resp.StatusCode), frog.String("name", name), frog.String("url", u))
+			}
+		}
+	}
+
+	if problems > 0 {
+		log.Error("Config check failed", frog.Int("problems", problems))
+		return 5
+	}
+	log.Info("Config OK", frog.Int("scrapers", len(scrapers)))
+	return 0
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// errChecksumMismatch is returned when some bytes don't match their expected checksum
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// errPrefixMismatch is returned when a file doesn't start with the bytes it is expected to
+var errPrefixMismatch = errors.New("prefix mismatch")
+
+// Checksum is an expected digest of some bytes, such as a whole file, or a part of one.
+type Checksum struct {
+	Algo string // one of "md5", "sha1", "sha256", or "sha512"
+	Hex  string // lowercase hex encoded digest
+}
+
+func (c Checksum) IsZero() bool {
+	return len(c.Hex) == 0
+}
+
+func (c Checksum) String() string {
+	return c.Algo + ":" + c.Hex
+}
+
+// ParseChecksum parses an "algo:hex" string, as used on the command line and in config files.
+func ParseChecksum(s string) (Checksum, error) {
+	algo, digest, ok := strings.Cut(s, ":")
+	if !ok {
+		return Checksum{}, fmt.Errorf("checksum '%s' is not in the form 'algo:hex'", s)
+	}
+	c := Checksum{Algo: strings.ToLower(algo), Hex: strings.ToLower(digest)}
+	h, err := c.NewHash()
+	if err != nil {
+		return Checksum{}, err
+	}
+	if b, err := hex.DecodeString(c.Hex); err != nil || len(b) != h.Size() {
+		return Checksum{}, fmt.Errorf("checksum '%s' is not a valid %s digest", s, c.Algo)
+	}
+	return c, nil
+}
+
+// NewHash returns a new hash.Hash for the checksum's algorithm.
+func (c Checksum) NewHash() (hash.Hash, error) {
+	switch c.Algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm '%s'", c.Algo)
+}
+
+// Verify returns an error if the hash's sum doesn't match the checksum.
+func (c Checksum) Verify(h hash.Hash) error {
+	return c.VerifyHex(hex.EncodeToString(h.Sum(nil)))
+}
+
+// VerifyHex returns an error if the hex encoded digest doesn't match the checksum.
+func (c Checksum) VerifyHex(actual string) error {
+	if actual != strings.ToLower(c.Hex) {
+		return fmt.Errorf("%w: expected %s to be %s, but is %s", errChecksumMismatch, c.Algo, strings.ToLower(c.Hex), actual)
+	}
+	return nil
+}
+
+// verifyFileChecksum reads the file at path and compares it to the checksum.
+func verifyFileChecksum(path string, c Checksum) error {
+	actual, err := fileDigest(path, c.Algo)
+	if err != nil {
+		return err
+	}
+	return c.VerifyHex(actual)
+}
+
+// verifyPrefix reads the start of r, and compares it to prefix.
+func verifyPrefix(r io.ReaderAt, prefix []byte) error {
+	b := make([]byte, len(prefix))
+	n, err := r.ReadAt(b, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if !bytes.Equal(b[:n], prefix) {
+		return fmt.Errorf("%w: expected to start with %x, but starts with %x", errPrefixMismatch, prefix, b[:n])
+	}
+	return nil
+}
+
+// fileDigest reads the file at path, and returns its hex encoded digest using the given algorithm.
+func fileDigest(path, algo string) (string, error) {
+	h, err := Checksum{Algo: algo}.NewHash()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read '%s': %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	natomic "github.com/natefinch/atomic"
+)
+
+// checksumIndexVersion is bumped whenever the index's format changes, so that an old index is ignored
+const checksumIndexVersion = 1
+
+// checksumIndex remembers the digests of local files, so that a file whose size and modification time
+// haven't changed since it was last hashed doesn't need to be hashed again. Entries are keyed by the
+// file's absolute path. All methods are safe to call on a nil *checksumIndex, which never has an entry,
+// and from more than one goroutine at once.
+type checksumIndex struct {
+	mu    sync.Mutex
+	path  string
+	files map[string]checksumIndexEntry
+	dirty bool
+}
+
+// checksumIndexFile is how the index is stored on disk
+type checksumIndexFile struct {
+	Version int                           `json:"version"`
+	Files   map[string]checksumIndexEntry `json:"files"`
+}
+
+type checksumIndexEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Algo    string    `json:"algo"`
+	Hex     string    `json:"hex"`
+}
+
+// loadChecksumIndex reads the index at path, or returns an empty index if there isn't one yet (or it
+// was written by a different version).
+func loadChecksumIndex(path string) (*checksumIndex, error) {
+	ix := &checksumIndex{path: path, files: map[string]checksumIndexEntry{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored checksumIndexFile
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, fmt.Errorf("decode '%s': %w", path, err)
+	}
+	if stored.Version == checksumIndexVersion && stored.Files != nil {
+		ix.files = stored.Files
+	}
+	return ix, nil
+}
+
+// lookup returns the stored digest of the file at path, if it was hashed with algo, and its size and
+// modification time still match info.
+func (ix *checksumIndex) lookup(path string, info fs.FileInfo, algo string) (string, bool) {
+	if ix == nil {
+		return "", false
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	ix.mu.Lock()
+	e, ok := ix.files[key]
+	ix.mu.Unlock()
+	if !ok || e.Algo != algo || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	return e.Hex, true
+}
+
+// store records the digest of the file at path, replacing any entry it had
+func (ix *checksumIndex) store(path string, info fs.FileInfo, algo, hex string) {
+	if ix == nil {
+		return
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.files[key] = checksumIndexEntry{Size: info.Size(), ModTime: info.ModTime().UTC(), Algo: algo, Hex: hex}
+	ix.dirty = true
+}
+
+// save writes the index back to its path, if anything was stored since it was loaded
+func (ix *checksumIndex) save() error {
+	if ix == nil {
+		return nil
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ix.dirty {
+		return nil
+	}
+	b, err := json.Marshal(checksumIndexFile{Version: checksumIndexVersion, Files: ix.files})
+	if err != nil {
+		return err
+	}
+	if err := natomic.WriteFile(ix.path, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	ix.dirty = false
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_ChecksumIndex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, testContent(t, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexPath := filepath.Join(dir, "index.json")
+	ix, err := loadChecksumIndex(indexPath)
+	if err != nil {
+		t.Fatalf("unexpected error loading a missing index: %v", err)
+	}
+	if _, ok := ix.lookup(path, info, "sha256"); ok {
+		t.Errorf("expected an empty index")
+	}
+	ix.store(path, info, "sha256", "abcd")
+	if err := ix.save(); err != nil {
+		t.Fatalf("unexpected error saving: %v", err)
+	}
+
+	ix, err = loadChecksumIndex(indexPath)
+	if err != nil {
+		t.Fatalf("unexpected error loading: %v", err)
+	}
+	if hex, ok := ix.lookup(path, info, "sha256"); !ok || hex != "abcd" {
+		t.Errorf("expected the stored digest, but got '%s' (ok: %v)", hex, ok)
+	}
+	if _, ok := ix.lookup(path, info, "md5"); ok {
+		t.Errorf("expected no digest for a different algorithm")
+	}
+
+	// a new time (or size) means the file has to be hashed again
+	later := info.ModTime().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ix.lookup(path, info, "sha256"); ok {
+		t.Errorf("expected the entry to be stale after the time changed")
+	}
+}
+
+func Test_VerifyIndexed(t *testing.T) {
+	dir := t.TempDir()
+	content := testContent(t, 100)
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := loadChecksumIndex(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256Checksum(content)
+	if err := verifyIndexed(&frog.NullLogger{}, path, sum, false, ix); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// change the contents without changing the size or time, so only the index knows the old digest
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append(testContent(t, 99), 0)
+	if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyIndexed(&frog.NullLogger{}, path, sum, false, ix); err != nil {
+		t.Errorf("expected the indexed digest to be used, but got: %v", err)
+	}
+	if err := verifyIndexed(&frog.NullLogger{}, path, sum, false, nil); err == nil {
+		t.Errorf("expected a mismatch without the index")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// gzipDigest decompresses the gzip file as it is hashed, and returns the hex encoded digest of its
+// uncompressed contents, using the given algorithm
+func gzipDigest(path, algo string) (string, error) {
+	h, err := Checksum{Algo: algo}.NewHash()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return "", fmt.Errorf("read '%s': %w", path, err)
+	}
+	if _, err := io.Copy(h, zr); err != nil {
+		return "", fmt.Errorf("decompress '%s': %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compressDownload gzips the downloaded file at localPath into "<localPath>.gz" (with the same
+// modification time), and removes the uncompressed file. It returns the path of the gzipped file.
+func compressDownload(log frog.Logger, localPath string, sync bool) (string, error) {
+	gzPath := localPath + plan.GzipSuffix
+	log.Transient("compressing", frog.Path(localPath))
+
+	in, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// compress into a temp file, so that an interrupted run doesn't leave a partial .gz behind
+	tmpPath := gzPath + plan.TempFileSuffix
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(localPath)
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil && sync {
+		err = out.Sync()
+	}
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = moveFile(log, tmpPath, gzPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("compress '%s': %w", localPath, err)
+	}
+	if err := modifyFileTime(gzPath, info.ModTime()); err != nil {
+		return "", fmt.Errorf("set time failed: %w", err)
+	}
+
+	in.Close()
+	if err := os.Remove(localPath); err != nil {
+		return "", err
+	}
+	if sync {
+		if err := syncDir(filepath.Dir(localPath)); err != nil {
+			return "", fmt.Errorf("sync folder: %w", err)
+		}
+	}
+	return gzPath, nil
+}
+
+// removeStaleGzip removes "<localPath>.gz", now that the uncompressed file at localPath replaces it
+func removeStaleGzip(log frog.Logger, localPath string) error {
+	gzPath := localPath + plan.GzipSuffix
+	err := os.Remove(gzPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		log.Verbose("removed compressed copy replaced by download", frog.Path(gzPath))
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+func writeGzipFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_CompressDownload(t *testing.T) {
+	dir := t.TempDir()
+	content := testContent(t, 5000)
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	gzPath, err := compressDownload(&frog.NullLogger{}, path, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gzPath != path+".gz" {
+		t.Errorf("expected '%s', but got '%s'", path+".gz", gzPath)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the uncompressed file to be removed")
+	}
+	info, err := os.Stat(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("expected time %v, but got %v", modTime, info.ModTime())
+	}
+	if size, err := plan.GzipSize(gzPath); err != nil || size != int64(len(content)) {
+		t.Errorf("expected uncompressed size %d, but got %d (err: %v)", len(content), size, err)
+	}
+	if actual, err := gzipDigest(gzPath, "sha256"); err != nil || actual != sha256Checksum(content).Hex {
+		t.Errorf("expected digest of the uncompressed content, but got '%s' (err: %v)", actual, err)
+	}
+}
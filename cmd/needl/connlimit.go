@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// connLimiter caps the number of HTTP requests (and their response bodies) that are open at once,
+// across every download and every part of a download. A nil connLimiter has no limit.
+type connLimiter struct {
+	slots chan struct{}
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a free connection, or returns the context's error if it is canceled first
+func (l *connLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *connLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// do sends the request once a connection is free, and holds that connection until the response
+// body is closed (or the request fails). The body is read no faster than the RateLimit (if any).
+func (dc *downloadContext) do(req *http.Request) (*http.Response, error) {
+	l := dc.opts.Connections
+	if err := l.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := dc.client().Do(req)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	if l != nil {
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: l.release}
+	}
+	if dc.opts.RateLimit != nil {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, ctx: req.Context(), limiter: dc.opts.RateLimit}
+	}
+	return resp, nil
+}
+
+// releasingBody releases a connLimiter's connection the first time it's closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
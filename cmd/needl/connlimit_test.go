@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_DownloadToFile_Connections(t *testing.T) {
+	content := testContent(t, 10000)
+	var open, maxOpen atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := open.Add(1)
+		defer open.Add(-1)
+		for {
+			m := maxOpen.Load()
+			if n <= m || maxOpen.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// three files, each in four parts that are all downloaded at once, but only two connections
+	connections := newConnLimiter(2)
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = DownloadToFile(context.Background(), nil, fmt.Sprintf("%s/%d", srv.URL, i), filepath.Join(dir, fmt.Sprint(i)), DownloadOptions{
+				ExpectedSize:    int64(len(content)),
+				PartSize:        2500,
+				PartConcurrency: 4,
+				Connections:     connections,
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error downloading file %d: %v", i, err)
+		}
+	}
+	if n := maxOpen.Load(); n != 2 {
+		t.Errorf("expected at most 2 requests at once (and for the limit to be reached), but got %d", n)
+	}
+	if n := len(connections.slots); n != 0 {
+		t.Errorf("expected every connection to be released, but %d are held", n)
+	}
+}
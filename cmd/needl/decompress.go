@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// decompressDownload decompresses the downloaded gzip file at gzPath into the same path without its
+// ".gz" suffix (with the same modification time), and removes the gzip file. It returns the path of
+// the decompressed file. If the gzip file can't be decompressed, it is removed anyway, so that it is
+// downloaded again by the next run, rather than being compared as is.
+func decompressDownload(log frog.Logger, gzPath string, sync bool) (string, error) {
+	localPath := strings.TrimSuffix(gzPath, plan.GzipSuffix)
+	log.Transient("decompressing", frog.Path(gzPath))
+
+	path, err := gunzipFile(log, gzPath, localPath, sync)
+	if errRemove := os.Remove(gzPath); err == nil {
+		err = errRemove
+	}
+	if err != nil {
+		return "", err
+	}
+	if sync {
+		if err := syncDir(filepath.Dir(localPath)); err != nil {
+			return "", fmt.Errorf("sync folder: %w", err)
+		}
+	}
+	return path, nil
+}
+
+func gunzipFile(log frog.Logger, gzPath, localPath string, sync bool) (string, error) {
+	in, err := os.Open(gzPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return "", fmt.Errorf("decompress '%s': %w", gzPath, err)
+	}
+
+	// decompress into a temp file, so that an interrupted run doesn't leave a partial file behind
+	tmpPath := localPath + plan.TempFileSuffix
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, zr)
+	if err == nil {
+		err = zr.Close()
+	}
+	if err == nil && sync {
+		err = out.Sync()
+	}
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = moveFile(log, tmpPath, localPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("decompress '%s': %w", gzPath, err)
+	}
+	if err := modifyFileTime(localPath, info.ModTime()); err != nil {
+		return "", fmt.Errorf("set time failed: %w", err)
+	}
+	return localPath, nil
+}
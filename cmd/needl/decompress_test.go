@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_DecompressDownload(t *testing.T) {
+	dir := t.TempDir()
+	content := testContent(t, 10000)
+	gzPath := filepath.Join(dir, "a.txt.gz")
+	writeGzipFile(t, gzPath, content)
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	if err := modifyFileTime(gzPath, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := decompressDownload(&frog.NullLogger{}, gzPath, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != filepath.Join(dir, "a.txt") {
+		t.Errorf("expected the path without .gz, but got '%s'", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("decompressed content doesn't match")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(modTime) {
+		t.Errorf("expected time %v, but got %v", modTime, fi.ModTime())
+	}
+	if _, err := os.Stat(gzPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the gzip file to be removed, but got: %v", err)
+	}
+}
+
+func Test_DecompressDownload_NotGzip(t *testing.T) {
+	dir := t.TempDir()
+	gzPath := filepath.Join(dir, "a.txt.gz")
+	if err := os.WriteFile(gzPath, []byte("not gzipped"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decompressDownload(&frog.NullLogger{}, gzPath, false); err == nil {
+		t.Fatalf("expected an error")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the download (and any temp file) to be removed, but found %d files", len(entries))
+	}
+}
@@ -1,18 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/danbrakeley/frog"
 	"github.com/dustin/go-humanize"
-	"github.com/natefinch/atomic"
 )
 
 // DownloadOptions is used to configure DownloadToFile
@@ -30,9 +35,112 @@ type DownloadOptions struct {
 	// If zero, or there is no Last-Modified header, then it is ignored.
 	ExpectedLastModified time.Time
 
+	// LastModifiedPrecision is the precision of ExpectedLastModified (for example,
+	// time.Minute for a listing that only shows hours and minutes), and is used to
+	// compare it to the Last-Modified header, which has a precision of one second.
+	// If zero, then ExpectedLastModified is assumed to be exact.
+	LastModifiedPrecision time.Duration
+
 	// MaxRetry is the maximum number of times to retry after an error.
 	// If zero, then will retry forever.
 	MaxRetry uint
+
+	// MaxRetryDuration, if non-zero, is the longest a download may spend retrying, counted from its
+	// first error (and including the backoff between attempts). A retry whose backoff would end
+	// after that gives up instead. Either this or MaxRetry being reached stops the retries.
+	MaxRetryDuration time.Duration
+
+	// UserAgent, if set, is sent as the User-Agent header.
+	UserAgent string
+
+	// Headers are extra headers sent with each request.
+	Headers map[string]string
+
+	// OnProgress, if set, is called as the file is downloaded, with the number of bytes of the file
+	// that have been read so far, and the expected size (or zero if unknown). It is called for every
+	// write, so it must be quick.
+	OnProgress func(read, total int64)
+
+	// ProgressInterval is the least time between the "download progress" lines that are logged as the
+	// file is downloaded (or zero for defaultProgressInterval). A final line is always logged once the
+	// download completes, however soon after the one before it.
+	ProgressInterval time.Duration
+
+	// Username and Password, if either is set, are sent using HTTP basic auth.
+	Username string
+	Password string
+
+	// Client makes the requests (or http.DefaultClient, if nil), such as to send a session's cookies.
+	Client *http.Client
+
+	// Checksum, if set, is verified against the completed download, before it is
+	// moved to its final location.
+	Checksum Checksum
+
+	// ChecksumRetries is how many times a download that doesn't match its Checksum is downloaded
+	// again from the start, before the mismatch is returned. Zero means it's never downloaded again.
+	ChecksumRetries uint
+
+	// ExpectedPrefix, if set, are the bytes that the completed download must start with (such as the
+	// magic number of its file format), which catches a server that sent something else, such as an
+	// HTML error page, without needing a checksum.
+	ExpectedPrefix []byte
+
+	// PartSize, if non-zero, splits downloads larger than PartSize (with a known
+	// ExpectedSize) into parts of PartSize bytes, which are downloaded concurrently
+	// and retried individually. If the server doesn't support byte ranges, then the
+	// file is downloaded as a single stream instead.
+	PartSize int64
+
+	// PartConcurrency is the max number of parts downloaded at once (default 4).
+	PartConcurrency int
+
+	// UseContentDisposition, if set, saves the file with the name from the server's
+	// Content-Disposition header (in the same folder as the local path), if it sent one.
+	UseContentDisposition bool
+
+	// Lock, if set, takes an advisory lock on the local path for the length of the download,
+	// and returns errFileLocked (without downloading anything) if another process holds it.
+	Lock bool
+
+	// HostBreaker, if set, is told about each failed request, and stops the download with
+	// errHostUnhealthy (instead of retrying) once the host it's downloading from is unhealthy.
+	// Hosts are tracked by the remote URL's host, even if the download was redirected elsewhere.
+	HostBreaker *hostBreaker
+
+	// RetryLog, if set, coalesces the log lines for retries from the same host (see retryLogThrottle).
+	RetryLog *retryLogThrottle
+
+	// Connections, if set, limits how many requests are open at once, across every download
+	// that shares it (including each part of a download that's in parts).
+	Connections *connLimiter
+
+	// RateLimit, if set, caps how fast response bodies are read, across every download that shares it.
+	RateLimit *rateLimiter
+
+	// Sync, if set, flushes the downloaded file to disk before it is moved into place, and then
+	// flushes its folder, so that a completed download survives a crash or power failure.
+	// Without it, a download that finished just before a crash may be left empty or partly written
+	// (under its final name), but each download is faster, especially on slow disks.
+	Sync bool
+
+	// HeadFirst, if set, and ExpectedSize isn't known, sends a HEAD request before downloading, to
+	// learn the file's size and whether the server supports byte ranges. Knowing the size lets a
+	// partial download from an earlier run be resumed (and large files be downloaded in parts).
+	// If the HEAD request fails, then the download goes ahead without it.
+	HeadFirst bool
+
+	// Sequential, if set, only ever writes the temp file from start to finish: it is never seeked or
+	// truncated, and isn't written in parts. On any retry, the download starts over from the beginning,
+	// in a new temp file. This is slower to recover from errors, but suits filesystems (such as network
+	// or FUSE mounts) where anything other than appending is slow or unsupported.
+	Sequential bool
+
+	// OnFailure is what happens to the temp file if the download fails (or is canceled) after it was
+	// created: it's kept for a later run to resume (the default), removed, or renamed to the local
+	// path plus ".partial" (see failurePolicy). A download that fails its checksum (or prefix) is
+	// always removed, since it can't be resumed.
+	OnFailure failurePolicy
 }
 
 // DownloadResults is returned by DownloadToFile
@@ -44,11 +152,23 @@ type DownloadResults struct {
 	// ActualSize is the size we actually downloaded.
 	ActualSize int64
 
+	// ResumedSize is how many of ActualSize's bytes were already in the temp file from an earlier run,
+	// rather than transferred by this one. aria2c's downloads always report zero.
+	ResumedSize int64
+
 	// LastModified is the Last-Modified header we received from the server (or zero).
 	LastModified time.Time
 
 	// Retries is the number of times we retried after an error.
 	Retries uint
+
+	// Path is where the file was written, which is the local path, unless
+	// DownloadOptions.UseContentDisposition chose a different name.
+	Path string
+
+	// FinalURL is the URL that the file was served from, after following any redirects
+	// (or the remote URL, if there were none).
+	FinalURL string
 }
 
 // DownloadToFile downloads a file from a URL to a local path.
@@ -59,7 +179,10 @@ type DownloadResults struct {
 // to that value.
 // While downloading, any errors are retried according to the options.
 // Upon retry, the download is resumed from where it left off, if possible.
+// If ctx is canceled, then any in-progress request or backoff is abandoned,
+// and the context's error is returned.
 func DownloadToFile(
+	ctx context.Context,
 	log frog.Logger,
 	remoteURL string,
 	localPath string,
@@ -83,42 +206,181 @@ func DownloadToFile(
 		ActualSize:   0,
 		LastModified: opts.ExpectedLastModified,
 		Retries:      0,
+		Path:         localPath,
+	}
+
+	if !opts.HostBreaker.healthy(remoteURL) {
+		return res, errHostUnhealthy
+	}
+
+	if opts.Lock {
+		lock, err := acquireFileLock(localPath)
+		if err != nil {
+			return res, fmt.Errorf("lock file: %w", err)
+		}
+		defer lock.release()
 	}
 
-	tmpPath := localPath + ".tmp"
+	dc := downloadContext{remoteURL: remoteURL, opts: opts, finalURL: remoteURL}
+	// a partial download can only be resumed if the server supports byte ranges, which is
+	// assumed, unless a HEAD request said otherwise
+	rangesKnownUnsupported := false
+	if opts.HeadFirst && opts.ExpectedSize <= 0 {
+		if err := dc.head(ctx, log); err == nil {
+			rangesKnownUnsupported = !dc.canResume
+		} else {
+			if ctx.Err() != nil {
+				return res, ctx.Err()
+			}
+			log.Verbose("HEAD failed, downloading without it", frog.String("url", remoteURL), frog.Err(err))
+		}
+		opts = dc.opts
+	}
+
+	tmpPath, sidecarPath := tempPaths(remoteURL, localPath, opts.ExpectedSize)
 	log.Verbose("creating file", frog.PathAbs(tmpPath))
 
-	f, err := os.Create(tmpPath)
+	// a partial download from an earlier run can be resumed, and if it was downloaded in parts (which
+	// don't fill in the file from start to finish), then the sidecar records which of them are done
+	multiPart := opts.PartSize > 0 && opts.ExpectedSize > opts.PartSize && !opts.Sequential
+	mode := resumeStream
+	if multiPart {
+		mode = resumeParts
+	}
+	if rangesKnownUnsupported || opts.Sequential {
+		mode = resumeNever
+	}
+	if opts.Sequential {
+		// start with a new file, rather than truncating one left by an earlier run
+		_ = os.Remove(tmpPath)
+	}
+	info := resumeInfo{
+		URL:          remoteURL,
+		Size:         opts.ExpectedSize,
+		LastModified: opts.ExpectedLastModified,
+		Name:         filepath.Base(localPath),
+	}
+	osFile, resumeAt, err := openTempFile(log, tmpPath, sidecarPath, info, mode)
 	if err != nil {
 		return res, fmt.Errorf("create file: %w", err)
 	}
+	var f tempFile = osFile
+	if opts.Sequential {
+		f = &sequentialFile{File: osFile}
+		// a sequential download starts over on every retry, rather than resuming
+		dc.canResume = false
+	}
 	defer f.Close()
+	// until the download is moved into place, any error leaves the temp file to OnFailure
+	moved := false
+	defer func() {
+		if !moved {
+			f.Close()
+			cleanUpFailedDownload(log, opts.OnFailure, tmpPath, sidecarPath, localPath)
+		}
+	}()
+
+	dc.sidecarPath, dc.info = sidecarPath, info
+	if resumeAt > 0 && mode == resumeStream {
+		// the server will be asked for the rest of the file, and if it instead sends
+		// the whole thing (such as because it changed since), then the partial download is thrown away
+		dc.bytesRead = resumeAt
+		dc.resumed = resumeAt
+		dc.canResume = true
+		if prev, err := readResumeInfo(sidecarPath); err == nil {
+			dc.info.Validator = prev.Validator
+		}
+	}
+	if resumeAt > 0 && mode == resumeParts {
+		if prev, err := readResumeInfo(sidecarPath); err == nil {
+			dc.info.Parts = prev.Parts
+		}
+	}
+	err = dc.download(ctx, log, f, multiPart, resumeAt)
+
+	if err == nil && len(opts.ExpectedPrefix) > 0 {
+		log.Transient("verifying prefix", frog.Int("length", len(opts.ExpectedPrefix)), frog.Path(tmpPath))
+		if prefixErr := verifyPrefix(f, opts.ExpectedPrefix); prefixErr != nil {
+			f.Close()
+			removeTempFile(tmpPath, sidecarPath)
+			err = fmt.Errorf("verify: %w", prefixErr)
+		}
+	}
+
+	// a file that doesn't match its checksum may have been corrupted on the way, and so may be
+	// downloaded again from the start, up to ChecksumRetries times
+	var checksumRetries uint
+	for err == nil && !opts.Checksum.IsZero() {
+		log.Transient("verifying checksum", frog.String("algo", opts.Checksum.Algo), frog.Path(tmpPath))
+		verifyErr := verifyFileChecksum(tmpPath, opts.Checksum)
+		if verifyErr == nil {
+			break
+		}
+		if checksumRetries >= opts.ChecksumRetries {
+			f.Close()
+			removeTempFile(tmpPath, sidecarPath)
+			err = fmt.Errorf("verify: %w", verifyErr)
+			break
+		}
+		checksumRetries++
+		log.Verbose("checksum mismatch, downloading again",
+			frog.Uint("checksum_retry", checksumRetries),
+			frog.Uint("max_checksum_retries", opts.ChecksumRetries),
+			frog.String("url", remoteURL),
+			frog.Err(verifyErr),
+		)
+		dc.bytesRead = 0
+		dc.resumed = 0
+		dc.canResume = false
+		if err = restartFile(f); err == nil {
+			err = dc.download(ctx, log, f, multiPart, 0)
+		}
+	}
 
-	dc := downloadContext{remoteURL: remoteURL, opts: opts}
-	err = dc.downloadImpl(log, f)
 	// this is useful to have up to date even if there's an error...
 	res.ExpectedSize = dc.opts.ExpectedSize
 	res.ActualSize = dc.bytesRead
+	res.ResumedSize = dc.resumed
 	res.LastModified = dc.opts.ExpectedLastModified
-	res.Retries = dc.curRetry
+	res.Retries = dc.curRetry + checksumRetries
+	res.FinalURL = dc.finalURL
 	// ... and then handle the error
 	if err != nil {
 		return res, err
 	}
+	opts.HostBreaker.succeeded(remoteURL)
 
+	if opts.Sync {
+		if err := f.Sync(); err != nil {
+			return res, fmt.Errorf("sync file: %w", err)
+		}
+	}
 	if err := f.Close(); err != nil {
 		return res, fmt.Errorf("close file: %w", err)
 	}
 
+	if opts.UseContentDisposition && len(dc.dispositionName) > 0 {
+		localPath = filepath.Join(filepath.Dir(localPath), dc.dispositionName)
+		log.Verbose("using Content-Disposition filename", frog.String("name", dc.dispositionName))
+	}
+	res.Path = localPath
+
 	log.Transient("moving",
 		frog.String("dst", filepath.ToSlash(localPath)),
 		frog.String("src", filepath.ToSlash(tmpPath)),
 	)
-	if err := atomic.ReplaceFile(tmpPath, localPath); err != nil {
+	if err := moveFile(log, tmpPath, localPath); err != nil {
 		log.Verbose("moving from", frog.PathAbs(tmpPath))
 		log.Verbose("moving to", frog.PathAbs(localPath))
 		return res, fmt.Errorf("move: %w", err)
 	}
+	moved = true
+	_ = os.Remove(sidecarPath)
+	if opts.Sync {
+		if err := syncDir(filepath.Dir(localPath)); err != nil {
+			return res, fmt.Errorf("sync folder: %w", err)
+		}
+	}
 
 	log.Transient("setting file time", frog.Time("time", res.LastModified), frog.Path(localPath))
 	if err := modifyFileTime(localPath, res.LastModified); err != nil {
@@ -135,6 +397,72 @@ type downloadContext struct {
 	bytesRead int64
 	curRetry  uint
 	canResume bool
+
+	// firstFailure is when the first error that was retried happened (or zero if there hasn't been one)
+	firstFailure time.Time
+
+	// dispositionName is the (sanitized) filename from the Content-Disposition header, if any
+	dispositionName string
+
+	// finalURL is the URL of the last response, after following any redirects
+	finalURL string
+
+	// sidecarPath is where info is written, to record what the temp file holds for a later run.
+	// Its Validator is sent as If-Range when resuming.
+	sidecarPath string
+	info        resumeInfo
+
+	// resumed is how many of bytesRead were in the temp file from an earlier run
+	resumed int64
+
+	// partsMu guards info.Parts, which is filled in as each part of a download in parts is finished
+	partsMu sync.Mutex
+}
+
+// checkResumed returns an error unless resp is a 206 with a Content-Range that starts where the
+// download left off (and agrees with the expected size, if it's known).
+func (dc *downloadContext) checkResumed(resp *http.Response) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("expected status %d, but got %d", http.StatusPartialContent, resp.StatusCode)
+	}
+	start, _, total, err := parseContentRange(resp.Header)
+	if err != nil {
+		return err
+	}
+	if start != dc.bytesRead {
+		return fmt.Errorf("expected range to start at %d, but starts at %d", dc.bytesRead, start)
+	}
+	if dc.opts.ExpectedSize > 0 && total >= 0 && total != dc.opts.ExpectedSize {
+		return fmt.Errorf("expected range total to be %d, but is %d", dc.opts.ExpectedSize, total)
+	}
+	return nil
+}
+
+// setValidator records the response's strong ETag (or else its Last-Modified header), to be sent as
+// If-Range when resuming, including in its sidecar, for a later run. A weak ETag can't be used for
+// If-Range, since it doesn't promise the bytes are the same.
+func (dc *downloadContext) setValidator(resp *http.Response) {
+	v := resp.Header.Get("ETag")
+	if len(v) == 0 || strings.HasPrefix(v, "W/") {
+		v = resp.Header.Get("Last-Modified")
+	}
+	if v == dc.info.Validator {
+		return
+	}
+	dc.info.Validator = v
+	dc.writeSidecar()
+}
+
+// setFinalURL records the URL that actually served resp, and logs it if the request was redirected
+func (dc *downloadContext) setFinalURL(log frog.Logger, resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	finalURL := resp.Request.URL.String()
+	if finalURL != dc.finalURL && finalURL != dc.remoteURL {
+		log.Verbose("redirected", frog.String("url", dc.remoteURL), frog.String("final_url", finalURL))
+	}
+	dc.finalURL = finalURL
 }
 
 type WriteSeekTruncater interface {
@@ -142,15 +470,133 @@ type WriteSeekTruncater interface {
 	Truncate(size int64) error
 }
 
+// tempFile is the file that a download is written into (before it is moved into place)
+type tempFile interface {
+	WriteSeekTruncater
+	partsFile
+	Sync() error
+	Close() error
+}
+
+// sequentialFile is a temp file that is never seeked or truncated (see DownloadOptions.Sequential).
+// To start over, it's replaced by a new, empty file.
+type sequentialFile struct {
+	*os.File
+}
+
+func (f *sequentialFile) restart() error {
+	path := f.Name()
+	_ = f.File.Close()
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove: %w", err)
+	}
+	nf, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	f.File = nf
+	return nil
+}
+
+// download downloads the file into f, in parts if multiPart is set (and the server supports it), or
+// as a single stream. For parts, partial is how many bytes f already holds from an earlier run.
+func (dc *downloadContext) download(ctx context.Context, log frog.Logger, f tempFile, multiPart bool, partial int64) error {
+	if !multiPart {
+		return dc.downloadImpl(ctx, log, f)
+	}
+	err := dc.downloadParts(ctx, log, f, partial)
+	if errors.Is(err, errRangesNotSupported) {
+		log.Verbose("byte ranges not supported, downloading as a single stream", frog.String("url", dc.remoteURL))
+		if err := restartFile(f); err != nil {
+			return err
+		}
+		return dc.downloadImpl(ctx, log, f)
+	}
+	return err
+}
+
+// restartFile empties f, so that a download can start over from the beginning
+func restartFile(f WriteSeekTruncater) error {
+	if sf, ok := f.(*sequentialFile); ok {
+		return sf.restart()
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek to start: %w", err)
+	}
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+	return nil
+}
+
+// newRequest creates a GET request for the remote URL
+func (dc *downloadContext) newRequest(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", dc.remoteURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if len(dc.opts.UserAgent) > 0 {
+		req.Header.Set("User-Agent", dc.opts.UserAgent)
+	}
+	for key, value := range dc.opts.Headers {
+		req.Header.Set(key, value)
+	}
+	if len(dc.opts.Username) > 0 || len(dc.opts.Password) > 0 {
+		req.SetBasicAuth(dc.opts.Username, dc.opts.Password)
+	}
+	return req, nil
+}
+
+// head sends a HEAD request for the remote URL, and uses the response's headers to fill in the
+// expected size and last modified time (where they aren't already known), and whether the server
+// supports byte ranges.
+func (dc *downloadContext) head(ctx context.Context, log frog.Logger) error {
+	req, err := dc.newRequest(ctx)
+	if err != nil {
+		return err
+	}
+	req.Method = http.MethodHead
+	resp, err := dc.do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if cl := parseContentLength(resp.Header); cl > 0 {
+		dc.opts.ExpectedSize = cl
+	}
+	if mt := parseLastModified(resp.Header); !mt.IsZero() && dc.opts.ExpectedLastModified.IsZero() {
+		dc.opts.ExpectedLastModified = mt
+	}
+	dc.canResume = resp.Header.Get("Accept-Ranges") == "bytes"
+	log.Verbose("HEAD",
+		frog.Int64("size", dc.opts.ExpectedSize),
+		frog.Bool("accept_ranges", dc.canResume),
+		frog.String("url", dc.remoteURL),
+	)
+	return nil
+}
+
+// client returns the client to make requests with
+func (dc *downloadContext) client() *http.Client {
+	if dc.opts.Client != nil {
+		return dc.opts.Client
+	}
+	return http.DefaultClient
+}
+
 // downloadImpl does the downloading, including retrying and resuming
-func (dc *downloadContext) downloadImpl(log frog.Logger, f WriteSeekTruncater) error {
+func (dc *downloadContext) downloadImpl(ctx context.Context, log frog.Logger, f WriteSeekTruncater) error {
 	if dc.opts.MaxRetry > 0 && dc.curRetry >= dc.opts.MaxRetry {
 		return fmt.Errorf("max retries (%d) exceeded", dc.opts.MaxRetry)
 	}
 
-	req, err := http.NewRequest("GET", dc.remoteURL, nil)
+	req, err := dc.newRequest(ctx)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return err
 	}
 
 	if dc.canResume && dc.bytesRead > 0 {
@@ -162,6 +608,11 @@ func (dc *downloadContext) downloadImpl(log frog.Logger, f WriteSeekTruncater) e
 			frog.String("url", dc.remoteURL),
 		)
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", dc.bytesRead))
+		if len(dc.info.Validator) > 0 {
+			// if the file has changed since, then the server sends all of the new one, instead of
+			// the rest of it being appended to the start of the old one
+			req.Header.Set("If-Range", dc.info.Validator)
+		}
 	} else {
 		log.Verbose("start download",
 			frog.Int64("total", dc.opts.ExpectedSize),
@@ -174,55 +625,115 @@ func (dc *downloadContext) downloadImpl(log frog.Logger, f WriteSeekTruncater) e
 	// this wrapper func will be used going forward to handle errors that we may be
 	// able to ignore by retrying the request, assuming we still have retries left
 	fnRetryOrErr := func(err error) error {
+		// if we've been canceled, then there's no point in retrying
+		if ctx.Err() != nil {
+			return err
+		}
+
+		// if the host keeps failing (for us, or for other downloads), then stop trying it for now
+		dc.opts.HostBreaker.failed(dc.remoteURL)
+		if !dc.opts.HostBreaker.healthy(dc.remoteURL) {
+			return fmt.Errorf("%w: %w", errHostUnhealthy, err)
+		}
+
 		// if we have no retries left, then this is the error we'll return
 		dc.curRetry += 1
 		if dc.opts.MaxRetry > 0 && dc.curRetry >= dc.opts.MaxRetry {
 			return err
 		}
 
-		// we want to retry! first, backoff.
+		// we want to retry! first, backoff (as long as there's time left to).
 		d := backoff(dc.curRetry)
-		log.Verbose("error, but will retry",
-			frog.Dur("backoff", d),
-			frog.Int64("bytes_read", dc.bytesRead),
-			frog.Int64("size", dc.opts.ExpectedSize),
-			frog.Uint("cur_retry", dc.curRetry),
-			frog.Uint("max_retry", dc.opts.MaxRetry),
-			frog.String("url", dc.remoteURL),
-			frog.Err(err),
-		)
-		time.Sleep(d)
+		if !retryTimeLeft(&dc.firstFailure, d, dc.opts.MaxRetryDuration) {
+			return fmt.Errorf("max retry duration (%v) exceeded: %w", dc.opts.MaxRetryDuration, err)
+		}
+		if dc.opts.RetryLog.allow(log, dc.finalURL) {
+			log.Verbose("error, but will retry",
+				frog.Dur("backoff", d),
+				frog.Int64("bytes_read", dc.bytesRead),
+				frog.Int64("size", dc.opts.ExpectedSize),
+				frog.Uint("cur_retry", dc.curRetry),
+				frog.Uint("max_retry", dc.opts.MaxRetry),
+				frog.String("url", dc.remoteURL),
+				frog.Err(err),
+			)
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return err
+		}
 
 		// and retry
-		return dc.downloadImpl(log, f)
+		return dc.downloadImpl(ctx, log, f)
 	}
 
 	// begin request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := dc.do(req)
 	if err != nil {
 		return fnRetryOrErr(fmt.Errorf("do request: %w", err))
 	}
 	defer resp.Body.Close()
+	dc.setFinalURL(log, resp)
 
 	// before parsing the body, parse the response headers
 
+	// If we asked for the rest of the file, then only append the body if the server actually sent
+	// the rest of the file (since canResume may have been assumed, rather than advertised).
+	// If the server sent all of it, then start over with this body, and otherwise start over with
+	// a new request for the whole file. An error status (other than the range not being satisfiable)
+	// says nothing about the partial download, so it's kept for the retry.
+	resumeAnswered := (resp.StatusCode >= 200 && resp.StatusCode < 300) || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable
+	if req.Header.Get("Range") != "" && resumeAnswered {
+		if err := dc.checkResumed(resp); err != nil {
+			log.Verbose("server did not resume, starting over",
+				frog.Int64("bytes_read", dc.bytesRead),
+				frog.Int("status", resp.StatusCode),
+				frog.String("if_range", req.Header.Get("If-Range")),
+				frog.String("url", dc.remoteURL),
+				frog.Err(err),
+			)
+			if err := restartFile(f); err != nil {
+				return err
+			}
+			dc.bytesRead = 0
+			dc.resumed = 0
+			dc.canResume = false
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return dc.downloadImpl(ctx, log, f)
+			}
+		}
+	}
+
+	// an error status has an error page for a body, rather than the file, so only retry the ones that
+	// may be temporary
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		err := fmt.Errorf("unexpected status %d", resp.StatusCode)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			_ = resp.Body.Close()
+			return fnRetryOrErr(err)
+		}
+		return err
+	}
+
+	dc.setValidator(resp)
+
 	// If we already know we can resume, then don't check for the header again.
 	// This is because some (all?) servers don't include the Accept-Ranges header
 	// in the response when the request includes a Range header.
-	if !dc.canResume {
+	if !dc.canResume && !dc.opts.Sequential {
 		dc.canResume = resp.Header.Get("Accept-Ranges") == "bytes"
 	}
 
 	// if we've previously read bytes, then we're hoping to resume...
 	if dc.bytesRead > 0 && !dc.canResume {
 		// ... but if we can't resume, then we need to truncate the read bytes
-		if _, err := f.Seek(0, io.SeekStart); err != nil {
-			return fmt.Errorf("seek to start: %w", err)
-		}
-		if err := f.Truncate(0); err != nil {
-			return fmt.Errorf("truncate: %w", err)
+		if err := restartFile(f); err != nil {
+			return err
 		}
 		dc.bytesRead = 0
+		dc.resumed = 0
 		log.Verbose("truncating file",
 			frog.Int64("bytes_read", dc.bytesRead),
 			frog.Int64("size", dc.opts.ExpectedSize),
@@ -251,19 +762,26 @@ func (dc *downloadContext) downloadImpl(log frog.Logger, f WriteSeekTruncater) e
 		}
 	}
 
-	mt := parseLastModifiedMinute(resp.Header)
+	if name := parseContentDisposition(resp.Header); len(name) > 0 {
+		dc.dispositionName = name
+	}
+
+	mt := parseLastModified(resp.Header)
 	if !mt.IsZero() {
 		if dc.opts.ExpectedLastModified.IsZero() {
 			dc.opts.ExpectedLastModified = mt
-		} else if !mt.Equal(dc.opts.ExpectedLastModified) {
+		} else if !dc.matchesLastModified(mt) {
 			return fmt.Errorf("expected Last-Modified to be %v, but is %v", dc.opts.ExpectedLastModified, mt)
 		}
 	}
 
 	// download file contents (parse the body)
-	pw := newProgressWriter(log, dc.remoteURL, dc.opts.ExpectedSize-dc.bytesRead)
+	pw := newProgressWriter(log, dc.remoteURL, dc.opts.ExpectedSize-dc.bytesRead, dc.opts.ProgressInterval, dc.progressFunc(dc.bytesRead))
 	n, err := io.Copy(io.MultiWriter(f, pw), resp.Body)
 	dc.bytesRead += n
+	if err == nil {
+		pw.finish()
+	}
 	if err != nil {
 		// ensure previous body is closed (TODO: is this necessary?)
 		// purposely ignoring the error here, because we're already in an error state
@@ -284,38 +802,141 @@ func (dc *downloadContext) downloadImpl(log frog.Logger, f WriteSeekTruncater) e
 	return nil
 }
 
-func newProgressWriter(log frog.Logger, URL string, total int64) io.Writer {
-	return &progressWriter{
-		log:       log,
-		remoteURL: URL,
-		total:     total,
-		totalStr:  humanize.Bytes(uint64(total)),
+// progressFunc returns a func that passes the progress of a request that started at the given offset
+// into the file along to OnProgress, or nil if there is no OnProgress
+func (dc *downloadContext) progressFunc(offset int64) func(int64) {
+	if dc.opts.OnProgress == nil {
+		return nil
+	}
+	total := max(dc.opts.ExpectedSize, 0)
+	return func(n int64) {
+		dc.opts.OnProgress(offset+n, total)
+	}
+}
+
+// defaultProgressInterval is the least time between progress lines, unless ProgressInterval is set
+const defaultProgressInterval = 500 * time.Millisecond
+
+// newProgressWriter returns a writer that logs the progress of a download of total bytes (or of an
+// unknown number of bytes, if total isn't positive), at most once per interval (or per
+// defaultProgressInterval, if interval isn't positive)
+func newProgressWriter(
+	log frog.Logger, URL string, total int64, interval time.Duration, onProgress func(int64),
+) *progressWriter {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	pw := &progressWriter{
+		log:        log,
+		remoteURL:  URL,
+		interval:   interval,
+		onProgress: onProgress,
+	}
+	if total > 0 {
+		pw.total = total
+		pw.totalStr = humanize.Bytes(uint64(total))
 	}
+	return pw
 }
 
 type progressWriter struct {
-	log        frog.Logger
-	remoteURL  string
-	total      int64 // for the percent math
-	progress   int64
-	totalStr   string // humanized copy of Total
-	lastUpdate time.Time
+	log          frog.Logger
+	remoteURL    string
+	total        int64 // for the percent math (zero if unknown)
+	progress     int64
+	totalStr     string        // humanized copy of total (empty if unknown)
+	interval     time.Duration // least time between progress lines
+	lastUpdate   time.Time
+	lastProgress int64   // progress at the time of lastUpdate
+	speed        float64 // exponential moving average, in bytes per second
+	onProgress   func(int64)
 }
 
+// speedSmoothing is the weight given to the newest speed sample in the moving average
+const speedSmoothing = 0.3
+
 func (pw *progressWriter) Write(p []byte) (int, error) {
-	const timeBetweenUpdates = time.Millisecond * 500
 	n := len(p)
 	pw.progress += int64(n)
-	if pw.lastUpdate.IsZero() || time.Since(pw.lastUpdate) > timeBetweenUpdates {
-		pw.log.Transient(
-			"download progress",
+	if pw.onProgress != nil {
+		pw.onProgress(pw.progress)
+	}
+	if pw.lastUpdate.IsZero() || time.Since(pw.lastUpdate) > pw.interval {
+		pw.logProgress()
+	}
+	return n, nil
+}
+
+// finish logs the final progress of a download that completed, unless its last write was already
+// logged, so that the last progress line shows the download reaching its end (such as 100%), rather
+// than wherever it was at the last interval.
+func (pw *progressWriter) finish() {
+	if !pw.lastUpdate.IsZero() && pw.lastProgress == pw.progress {
+		return
+	}
+	pw.logProgress()
+}
+
+func (pw *progressWriter) logProgress() {
+	now := time.Now()
+	if !pw.lastUpdate.IsZero() {
+		pw.updateSpeed(pw.progress-pw.lastProgress, now.Sub(pw.lastUpdate))
+	}
+	pw.log.Transient("download progress", pw.fields()...)
+	pw.lastUpdate = now
+	pw.lastProgress = pw.progress
+}
+
+// updateSpeed folds a new sample of bytes read over the given elapsed time into the moving average
+func (pw *progressWriter) updateSpeed(bytes int64, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	sample := float64(bytes) / elapsed.Seconds()
+	if pw.speed == 0 {
+		pw.speed = sample
+	} else {
+		pw.speed = speedSmoothing*sample + (1-speedSmoothing)*pw.speed
+	}
+}
+
+func (pw *progressWriter) fields() []frog.Fielder {
+	fields := make([]frog.Fielder, 0, 5)
+	hasTotal := pw.total > 0
+	if hasTotal {
+		fields = append(fields,
 			frog.String("total", pw.totalStr),
 			frog.String("percent", fmt.Sprintf("%.2f%%", float64(pw.progress)/float64(pw.total)*100)),
-			frog.String("url", pw.remoteURL),
 		)
-		pw.lastUpdate = time.Now()
+	} else {
+		// without a total, there's no percent (or eta), so show how much has been downloaded instead
+		fields = append(fields, frog.String("downloaded", humanize.Bytes(uint64(pw.progress))))
 	}
-	return n, nil
+	if pw.speed > 0 {
+		fields = append(fields, frog.String("speed", formatSpeed(pw.speed)))
+		if hasTotal && pw.progress < pw.total {
+			eta := time.Duration(float64(pw.total-pw.progress) / pw.speed * float64(time.Second))
+			fields = append(fields, frog.Dur("eta", eta.Round(time.Second)))
+		}
+	}
+	return append(fields, frog.String("url", pw.remoteURL))
+}
+
+// formatSpeed returns a human readable speed, such as "12 MB/s"
+func formatSpeed(bytesPerSec float64) string {
+	return humanize.Bytes(uint64(bytesPerSec)) + "/s"
+}
+
+// retryTimeLeft sets *first to now if it's zero (as the time of the first failure), and returns
+// false if a backoff of d would end more than limit after it. A limit of zero never runs out.
+func retryTimeLeft(first *time.Time, d, limit time.Duration) bool {
+	if limit <= 0 {
+		return true
+	}
+	if first.IsZero() {
+		*first = time.Now()
+	}
+	return time.Since(*first)+d <= limit
 }
 
 func backoff(curRetry uint) time.Duration {
@@ -357,8 +978,39 @@ func parseContentLength(h http.Header) int64 {
 	return n
 }
 
-// parseLastModifiedMinute returns zero if the header is not present or cannot be parsed
-func parseLastModifiedMinute(h http.Header) time.Time {
+// matchesLastModified compares a Last-Modified header's time to the expected time, at the
+// coarser of the two precisions.
+func (dc *downloadContext) matchesLastModified(mt time.Time) bool {
+	precision := max(dc.opts.LastModifiedPrecision, time.Second)
+	return mt.Truncate(precision).Equal(dc.opts.ExpectedLastModified.Truncate(precision))
+}
+
+// parseContentDisposition returns the filename from a header like
+// `Content-Disposition: attachment; filename="name.zip"`, or "" if there isn't one.
+// Any folders are removed from the name, so that it can't be used to write outside the local path.
+func parseContentDisposition(h http.Header) string {
+	raw := h.Get("Content-Disposition")
+	if len(raw) == 0 {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(raw)
+	if err != nil {
+		return ""
+	}
+	name := params["filename"]
+	if strings.ContainsRune(name, 0) {
+		return ""
+	}
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	switch name {
+	case ".", "..", "/":
+		return ""
+	}
+	return name
+}
+
+// parseLastModified returns zero if the header is not present or cannot be parsed
+func parseLastModified(h http.Header) time.Time {
 	modRaw := h.Get("Last-Modified")
 	if len(modRaw) == 0 {
 		return time.Time{}
@@ -367,5 +1019,5 @@ func parseLastModifiedMinute(h http.Header) time.Time {
 	if err != nil {
 		return time.Time{}
 	}
-	return t.Truncate(time.Minute)
+	return t
 }
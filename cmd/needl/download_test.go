@@ -0,0 +1,938 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func testContent(t *testing.T, size int) []byte {
+	t.Helper()
+	b := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(b)
+	return b
+}
+
+func sha256Checksum(b []byte) Checksum {
+	sum := sha256.Sum256(b)
+	return Checksum{Algo: "sha256", Hex: hex.EncodeToString(sum[:])}
+}
+
+func Test_DownloadToFile_Parts(t *testing.T) {
+	content := testContent(t, 10000)
+	const partSize = 3000
+
+	// fail the first request for bytes 3000-5999, to ensure just that part is re-fetched
+	var failed atomic.Bool
+	var requests atomic.Int32
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Range") == "bytes=3000-5999" && failed.CompareAndSwap(false, true) {
+			http.Error(w, "oops", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file", modTime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	res, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+		ExpectedSize: int64(len(content)),
+		Checksum:     sha256Checksum(content),
+		PartSize:     partSize,
+		MaxRetry:     3,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Retries != 1 {
+		t.Errorf("expected 1 retry, but got %d", res.Retries)
+	}
+	// 1 probe, 4 parts, and 1 retried part
+	if n := requests.Load(); n != 6 {
+		t.Errorf("expected 6 requests, but got %d", n)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content does not match")
+	}
+}
+
+func Test_DownloadToFile_PartsResume(t *testing.T) {
+	content := testContent(t, 10000)
+	const partSize = 3000
+	var partChecksums []Checksum
+	for i := 0; i < len(content); i += partSize {
+		partChecksums = append(partChecksums, sha256Checksum(content[i:min(i+partSize, len(content))]))
+	}
+
+	var mu sync.Mutex
+	var gotRanges []string
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotRanges = append(gotRanges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "file", modTime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// an earlier run finished parts 0, 1 (but with a byte that has since been corrupted), and 3
+	path := filepath.Join(t.TempDir(), "file")
+	tmpPath, sidecarPath := tempPaths(srv.URL, path, int64(len(content)))
+	info := resumeInfo{URL: srv.URL, Size: int64(len(content)), LastModified: modTime, Parts: []string{
+		partChecksums[0].Hex, partChecksums[1].Hex, "", partChecksums[3].Hex,
+	}}
+	f, _, err := openTempFile(&frog.NullLogger{}, tmpPath, sidecarPath, info, resumeNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := bytes.Clone(content[:6000])
+	partial[4000] ^= 0xff
+	_, err = f.WriteAt(partial, 0)
+	if err == nil {
+		_, err = f.WriteAt(content[9000:], 9000)
+	}
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := &progressLog{}
+	var lastProgress int64
+	res, err := DownloadToFile(context.Background(), log, srv.URL, path, DownloadOptions{
+		ExpectedSize:         int64(len(content)),
+		ExpectedLastModified: modTime,
+		Checksum:             sha256Checksum(content),
+		PartSize:             partSize,
+		ProgressInterval:     time.Hour,
+		OnProgress:           func(read, total int64) { lastProgress = read },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// parts 0 and 3 are reused
+	if res.ResumedSize != 4000 {
+		t.Errorf("expected 4000 bytes resumed, but got %d", res.ResumedSize)
+	}
+	// the reused parts are counted as already downloaded, so the progress still reaches the end
+	if lastProgress != int64(len(content)) {
+		t.Errorf("expected the progress to reach %d, but it stopped at %d", len(content), lastProgress)
+	}
+	if len(log.lines) == 0 || log.lines[len(log.lines)-1]["percent"] != "100.00%" {
+		t.Errorf("expected the last progress line to be at 100.00%%, but got %v", log.lines)
+	}
+	slices.Sort(gotRanges)
+	expected := []string{"bytes=0-0", "bytes=3000-5999", "bytes=6000-8999"}
+	if !slices.Equal(gotRanges, expected) {
+		t.Errorf("expected Range headers %q, but got %q", expected, gotRanges)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content does not match")
+	}
+}
+
+func Test_DownloadToFile_PartsInterrupted(t *testing.T) {
+	content := testContent(t, 10000)
+	const partSize = 3000
+
+	// the first run fails on the last part, and the second run should only need to fetch it
+	var failing atomic.Bool
+	failing.Store(true)
+	var mu sync.Mutex
+	var gotRanges []string
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotRanges = append(gotRanges, r.Header.Get("Range"))
+		mu.Unlock()
+		if r.Header.Get("Range") == "bytes=9000-9999" && failing.Load() {
+			http.Error(w, "oops", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file", modTime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	opts := DownloadOptions{
+		ExpectedSize:         int64(len(content)),
+		ExpectedLastModified: modTime,
+		Checksum:             sha256Checksum(content),
+		PartSize:             partSize,
+		PartConcurrency:      1,
+		MaxRetry:             1,
+	}
+	if _, err := DownloadToFile(context.Background(), nil, srv.URL, path, opts); err == nil {
+		t.Fatalf("expected the first run to fail")
+	}
+
+	failing.Store(false)
+	mu.Lock()
+	gotRanges = nil
+	mu.Unlock()
+	if _, err := DownloadToFile(context.Background(), nil, srv.URL, path, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"bytes=0-0", "bytes=9000-9999"}
+	if !slices.Equal(gotRanges, expected) {
+		t.Errorf("expected Range headers %q, but got %q", expected, gotRanges)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content does not match")
+	}
+}
+
+func Test_DownloadToFile_PartsFallback(t *testing.T) {
+	content := testContent(t, 5000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ignore any Range header
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	_, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+		ExpectedSize: int64(len(content)),
+		Checksum:     sha256Checksum(content),
+		PartSize:     1000,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content does not match")
+	}
+}
+
+func Test_DownloadToFile_ResumeFromEarlierRun(t *testing.T) {
+	content := testContent(t, 5000)
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	cases := []struct {
+		Name            string
+		Server          string // "", "ignore range", "wrong range", or "busy"
+		SidecarURL      string
+		ExpectedRanges  []string
+		ExpectedResumed int64
+	}{
+		{"resumes", "", "", []string{"bytes=2000-"}, 2000},
+		{"server ignores range", "ignore range", "", []string{"bytes=2000-"}, 0},
+		{"server sends wrong range", "wrong range", "", []string{"bytes=2000-", ""}, 0},
+		{"server busy", "busy", "", []string{"bytes=2000-", "bytes=2000-"}, 2000},
+		{"sidecar for another url", "", "http://example.com/other", []string{""}, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var gotRanges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRanges = append(gotRanges, r.Header.Get("Range"))
+				switch {
+				case tc.Server == "ignore range":
+					r.Header.Del("Range")
+				case tc.Server == "busy" && len(gotRanges) == 1:
+					// an error, rather than an answer to the range request
+					http.Error(w, "busy", http.StatusServiceUnavailable)
+					return
+				case tc.Server == "wrong range" && len(r.Header.Get("Range")) > 0:
+					// a 206, but not of the requested range
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes 1000-%d/%d", len(content)-1, len(content)))
+					w.WriteHeader(http.StatusPartialContent)
+					_, _ = w.Write(content[1000:])
+					return
+				}
+				http.ServeContent(w, r, "file", modTime, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+
+			// leave behind the first 2000 bytes of the file, as if an earlier run was interrupted
+			path := filepath.Join(t.TempDir(), "file")
+			tmpPath, sidecarPath := tempPaths(srv.URL, path, int64(len(content)))
+			info := resumeInfo{URL: srv.URL, Size: int64(len(content)), LastModified: modTime}
+			if len(tc.SidecarURL) > 0 {
+				info.URL = tc.SidecarURL
+			}
+			f, _, err := openTempFile(&frog.NullLogger{}, tmpPath, sidecarPath, info, resumeNever)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.Write(content[:2000])
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+				ExpectedSize:         int64(len(content)),
+				ExpectedLastModified: modTime,
+				Checksum:             sha256Checksum(content),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(gotRanges) != fmt.Sprint(tc.ExpectedRanges) {
+				t.Errorf("expected Range headers %q, but got %q", tc.ExpectedRanges, gotRanges)
+			}
+			if res.ActualSize != int64(len(content)) || res.ResumedSize != tc.ExpectedResumed {
+				t.Errorf("expected %d bytes with %d resumed, but got %d with %d resumed",
+					len(content), tc.ExpectedResumed, res.ActualSize, res.ResumedSize)
+			}
+			actual, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error reading download: %v", err)
+			}
+			if !bytes.Equal(actual, content) {
+				t.Errorf("downloaded content does not match")
+			}
+			for _, p := range []string{tmpPath, sidecarPath} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("expected '%s' to be removed", filepath.Base(p))
+				}
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_IfRange(t *testing.T) {
+	content := testContent(t, 5000)
+	changed := append([]byte("changed!"), content[8:]...)
+	cases := []struct {
+		Name            string
+		Validator       string // in the sidecar left by the earlier run
+		ServerETag      string
+		Served          []byte
+		ExpectedIfRange string
+	}{
+		{"unchanged", `"v1"`, `"v1"`, content, `"v1"`},
+		{"changed", `"v1"`, `"v2"`, changed, `"v1"`},
+		{"no validator", "", `"v1"`, content, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var gotIfRange []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotIfRange = append(gotIfRange, r.Header.Get("If-Range"))
+				w.Header().Set("ETag", tc.ServerETag)
+				http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(tc.Served))
+			}))
+			defer srv.Close()
+
+			// leave behind the first 2000 bytes of the original file, as if an earlier run was interrupted
+			path := filepath.Join(t.TempDir(), "file")
+			tmpPath, sidecarPath := tempPaths(srv.URL, path, int64(len(content)))
+			info := resumeInfo{URL: srv.URL, Size: int64(len(content)), Validator: tc.Validator}
+			f, _, err := openTempFile(&frog.NullLogger{}, tmpPath, sidecarPath, info, resumeNever)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.Write(content[:2000])
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+				ExpectedSize: int64(len(content)),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(gotIfRange) != 1 || gotIfRange[0] != tc.ExpectedIfRange {
+				t.Errorf("expected one request with If-Range %q, but got %q", tc.ExpectedIfRange, gotIfRange)
+			}
+			actual, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error reading download: %v", err)
+			}
+			if !bytes.Equal(actual, tc.Served) {
+				t.Errorf("downloaded content does not match what was served")
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_SavesValidator(t *testing.T) {
+	content := testContent(t, 5000)
+	cases := []struct {
+		Name     string
+		ETag     string
+		Expected string
+	}{
+		{"strong", `"v1"`, `"v1"`},
+		{"weak", `W/"v1"`, "Thu, 02 Jan 2020 03:04:00 GMT"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			var sidecar resumeInfo
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", tc.ETag)
+				w.Header().Set("Last-Modified", "Thu, 02 Jan 2020 03:04:00 GMT")
+				w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write(content[:1000])
+				w.(http.Flusher).Flush()
+				// the validator is saved as soon as the headers arrive, before the body is read
+				_, sidecarPath := tempPaths("http://"+r.Host, path, int64(len(content)))
+				for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+					if sidecar, _ = readResumeInfo(sidecarPath); len(sidecar.Validator) > 0 {
+						break
+					}
+				}
+				_, _ = w.Write(content[1000:])
+			}))
+			defer srv.Close()
+
+			_, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{ExpectedSize: int64(len(content))})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sidecar.Validator != tc.Expected {
+				t.Errorf("expected the sidecar's validator to be %q, but got %q", tc.Expected, sidecar.Validator)
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_Sequential(t *testing.T) {
+	content := testContent(t, 5000)
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	var gotRanges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRanges = append(gotRanges, r.Header.Get("Range"))
+		if len(gotRanges) == 1 {
+			// send some of the file, then drop the connection
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			_, _ = w.Write(content[:3000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "file", modTime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// leave behind the first 2000 bytes of the file, as if an earlier run was interrupted
+	path := filepath.Join(t.TempDir(), "file")
+	tmpPath, sidecarPath := tempPaths(srv.URL, path, int64(len(content)))
+	info := resumeInfo{URL: srv.URL, Size: int64(len(content)), LastModified: modTime}
+	f, _, err := openTempFile(&frog.NullLogger{}, tmpPath, sidecarPath, info, resumeNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(content[:2000])
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither the earlier run's bytes, nor those from before the connection dropped, are resumed
+	res, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+		ExpectedSize:         int64(len(content)),
+		ExpectedLastModified: modTime,
+		PartSize:             1000,
+		Sequential:           true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(gotRanges) != fmt.Sprint([]string{"", ""}) {
+		t.Errorf("expected two requests without a Range header, but got %q", gotRanges)
+	}
+	if res.Retries != 1 {
+		t.Errorf("expected 1 retry, but got %d", res.Retries)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content does not match")
+	}
+}
+
+func Test_DownloadToFile_HeadFirst(t *testing.T) {
+	content := testContent(t, 5000)
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	cases := []struct {
+		Name             string
+		Server           string // "", "no ranges", or "no head"
+		ExpectedRequests []string
+	}{
+		{"resumes", "", []string{"HEAD ", "GET bytes=2000-"}},
+		{"server doesn't support ranges", "no ranges", []string{"HEAD ", "GET "}},
+		{"server doesn't support HEAD", "no head", []string{"HEAD ", "GET "}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var gotRequests []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRequests = append(gotRequests, r.Method+" "+r.Header.Get("Range"))
+				switch {
+				case tc.Server == "no head" && r.Method == http.MethodHead:
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				case tc.Server == "no ranges":
+					w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+					w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+					if r.Method != http.MethodHead {
+						_, _ = w.Write(content)
+					}
+					return
+				}
+				http.ServeContent(w, r, "file", modTime, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+
+			// leave behind the first 2000 bytes of the file, as if an earlier run (that knew the size)
+			// was interrupted
+			path := filepath.Join(t.TempDir(), "file")
+			tmpPath, sidecarPath := tempPaths(srv.URL, path, int64(len(content)))
+			info := resumeInfo{URL: srv.URL, Size: int64(len(content)), LastModified: modTime}
+			f, _, err := openTempFile(&frog.NullLogger{}, tmpPath, sidecarPath, info, resumeNever)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.Write(content[:2000])
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+				ExpectedSize: -1,
+				Checksum:     sha256Checksum(content),
+				HeadFirst:    true,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(gotRequests) != fmt.Sprint(tc.ExpectedRequests) {
+				t.Errorf("expected requests %q, but got %q", tc.ExpectedRequests, gotRequests)
+			}
+			if res.ExpectedSize != int64(len(content)) {
+				t.Errorf("expected size %d, but got %d", len(content), res.ExpectedSize)
+			}
+			if !res.LastModified.Equal(modTime) {
+				t.Errorf("expected last modified %v, but got %v", modTime, res.LastModified)
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_MaxRetryDuration(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// the first backoff is about 1s, and the second is about 2s, which would end after the limit
+	start := time.Now()
+	_, err := DownloadToFile(context.Background(), nil, srv.URL, filepath.Join(t.TempDir(), "file"), DownloadOptions{
+		MaxRetryDuration: 1500 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "max retry duration") {
+		t.Fatalf("expected a max retry duration error, but got %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 requests, but got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("expected to give up within the limit, but took %v", elapsed)
+	}
+}
+
+func Test_DownloadToFile_ChecksumMismatch(t *testing.T) {
+	content := testContent(t, 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	_, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+		Checksum: sha256Checksum(content[1:]),
+	})
+	if err == nil {
+		t.Fatalf("expected a checksum error")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("expected file to not be moved into place")
+	}
+}
+
+func Test_DownloadToFile_ExpectedPrefix(t *testing.T) {
+	content := append([]byte("\x89HDF\r\n\x1a\n"), testContent(t, 1000)...)
+	cases := []struct {
+		Name          string
+		Prefix        string
+		ExpectedError bool
+	}{
+		{"matches", "\x89HDF", false},
+		{"whole file", string(content), false},
+		{"differs", "<html>", true},
+		{"longer than file", string(content) + "x", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(content)
+			}))
+			defer srv.Close()
+
+			path := filepath.Join(t.TempDir(), "file")
+			_, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+				ExpectedPrefix: []byte(tc.Prefix),
+			})
+			if tc.ExpectedError {
+				if !errors.Is(err, errPrefixMismatch) {
+					t.Errorf("expected a prefix mismatch, but got %v", err)
+				}
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("expected file to not be moved into place")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_ChecksumRetries(t *testing.T) {
+	content := testContent(t, 1000)
+	cases := []struct {
+		Name             string
+		Corrupt          int32 // how many responses are corrupted
+		ChecksumRetries  uint
+		ExpectedRequests int32
+		ExpectedError    bool
+	}{
+		{"recovers", 2, 2, 3, false},
+		{"gives up", 3, 2, 3, true},
+		{"no retries", 1, 0, 1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b := content
+				if requests.Add(1) <= tc.Corrupt {
+					b = bytes.Clone(content)
+					b[500] ^= 0xff
+				}
+				_, _ = w.Write(b)
+			}))
+			defer srv.Close()
+
+			path := filepath.Join(t.TempDir(), "file")
+			res, err := DownloadToFile(context.Background(), nil, srv.URL, path, DownloadOptions{
+				Checksum:        sha256Checksum(content),
+				ChecksumRetries: tc.ChecksumRetries,
+			})
+			if n := requests.Load(); n != tc.ExpectedRequests {
+				t.Errorf("expected %d requests, but got %d", tc.ExpectedRequests, n)
+			}
+			if tc.ExpectedError {
+				if !errors.Is(err, errChecksumMismatch) {
+					t.Errorf("expected a checksum mismatch, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Retries != tc.ChecksumRetries {
+				t.Errorf("expected %d retries, but got %d", tc.ChecksumRetries, res.Retries)
+			}
+			actual, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error reading download: %v", err)
+			}
+			if !bytes.Equal(actual, content) {
+				t.Errorf("downloaded content does not match")
+			}
+		})
+	}
+}
+
+func Test_ParseContentDisposition(t *testing.T) {
+	cases := []struct {
+		Header   string
+		Expected string
+	}{
+		{``, ""},
+		{`inline`, ""},
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename=plain.txt`, "plain.txt"},
+		{`attachment; filename*=UTF-8''na%C3%AFve.txt`, "naïve.txt"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename="..\\..\\win.ini"`, "win.ini"},
+		{`attachment; filename=".."`, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Header, func(t *testing.T) {
+			h := http.Header{}
+			if len(tc.Header) > 0 {
+				h.Set("Content-Disposition", tc.Header)
+			}
+			if actual := parseContentDisposition(h); actual != tc.Expected {
+				t.Errorf("expected '%s', but got '%s'", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_ContentDisposition(t *testing.T) {
+	content := testContent(t, 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../real-name.bin"`)
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	res, err := DownloadToFile(context.Background(), nil, srv.URL+"/download?id=123", filepath.Join(dir, "download"),
+		DownloadOptions{UseContentDisposition: true},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := filepath.Join(dir, "real-name.bin")
+	if res.Path != expected {
+		t.Errorf("expected path '%s', but got '%s'", expected, res.Path)
+	}
+	actual, err := os.ReadFile(expected)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content does not match")
+	}
+}
+
+func Test_DownloadToFile_FinalURL(t *testing.T) {
+	content := testContent(t, 1000)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/datanode/file.bin", http.StatusFound)
+	})
+	mux.HandleFunc("/datanode/file.bin", func(w http.ResponseWriter, r *http.Request) {
+		// supports byte ranges, for the multi-part case
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		URL      string
+		PartSize int64
+		Expected string
+	}{
+		{"not redirected", srv.URL + "/datanode/file.bin", 0, srv.URL + "/datanode/file.bin"},
+		{"redirected", srv.URL + "/file.bin", 0, srv.URL + "/datanode/file.bin"},
+		{"redirected in parts", srv.URL + "/file.bin", 300, srv.URL + "/datanode/file.bin"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			res, err := DownloadToFile(context.Background(), nil, tc.URL, filepath.Join(t.TempDir(), "file.bin"),
+				DownloadOptions{ExpectedSize: int64(len(content)), PartSize: tc.PartSize},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.FinalURL != tc.Expected {
+				t.Errorf("expected final url '%s', but got '%s'", tc.Expected, res.FinalURL)
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_Sync(t *testing.T) {
+	content := testContent(t, 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	localPath := filepath.Join(t.TempDir(), "file.bin")
+	_, err := DownloadToFile(context.Background(), nil, srv.URL+"/file.bin", localPath,
+		DownloadOptions{ExpectedSize: int64(len(content)), Sync: true},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content does not match")
+	}
+}
+
+func Test_ProgressWriter_Fields(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Total    int64
+		Speed    float64
+		Expected map[string]string // the fields other than url
+	}{
+		{"known total", 2000, 0, map[string]string{"total": "2.0 kB", "percent": "25.00%"}},
+		{"known total, with speed", 2000, 100, map[string]string{
+			"total": "2.0 kB", "percent": "25.00%", "speed": "100 B/s", "eta": "15s",
+		}},
+		{"unknown total", 0, 0, map[string]string{"downloaded": "500 B"}},
+		{"unknown total, with speed", 0, 100, map[string]string{"downloaded": "500 B", "speed": "100 B/s"}},
+		// an unknown ExpectedSize (-1), less the bytes already read when resuming
+		{"negative total", -1001, 100, map[string]string{"downloaded": "500 B", "speed": "100 B/s"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pw := newProgressWriter(&frog.NullLogger{}, "https://example.com/file", tc.Total, 0, nil)
+			if _, err := pw.Write(make([]byte, 500)); err != nil {
+				t.Fatal(err)
+			}
+			pw.speed = tc.Speed
+
+			actual := map[string]string{}
+			for _, f := range frog.Fieldify(pw.fields()) {
+				if f.Name != "url" {
+					actual[f.Name] = f.Value
+				}
+			}
+			if len(actual) != len(tc.Expected) {
+				t.Fatalf("expected %v, but got %v", tc.Expected, actual)
+			}
+			for k, v := range tc.Expected {
+				if actual[k] != v {
+					t.Errorf("%s: expected '%s', but got '%s'", k, v, actual[k])
+				}
+			}
+		})
+	}
+}
+
+// progressLog records the fields of each "download progress" line
+type progressLog struct {
+	frog.NullLogger
+	mu    sync.Mutex
+	lines []map[string]string
+}
+
+func (l *progressLog) Transient(msg string, fielders ...frog.Fielder) frog.Logger {
+	l.LogImpl(frog.Transient, msg, fielders, nil, frog.ImplData{})
+	return l
+}
+
+// LogImpl is where the lines of a child logger (such as a download's anchor) end up
+func (l *progressLog) LogImpl(level frog.Level, msg string, fielders []frog.Fielder, _ []frog.PrinterOption, _ frog.ImplData) {
+	if level != frog.Transient || msg != "download progress" {
+		return
+	}
+	line := map[string]string{}
+	for _, f := range frog.Fieldify(fielders) {
+		line[f.Name] = f.Value
+	}
+	l.mu.Lock()
+	l.lines = append(l.lines, line)
+	l.mu.Unlock()
+}
+
+func Test_ProgressWriter_Finish(t *testing.T) {
+	log := &progressLog{}
+	pw := newProgressWriter(log, "https://example.com/file", 300, time.Hour, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := pw.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the first write is logged, within the interval
+	if len(log.lines) != 1 || log.lines[0]["percent"] != "33.33%" {
+		t.Fatalf("expected one line at 33.33%%, but got %v", log.lines)
+	}
+	pw.finish()
+	if len(log.lines) != 2 || log.lines[1]["percent"] != "100.00%" {
+		t.Fatalf("expected a final line at 100.00%%, but got %v", log.lines)
+	}
+	// the final progress was already logged
+	pw.finish()
+	if len(log.lines) != 2 {
+		t.Errorf("expected no more lines, but got %v", log.lines[2:])
+	}
+}
+
+func Test_DownloadToFile_FinalProgress(t *testing.T) {
+	// large enough to be copied in more than one write
+	content := testContent(t, 200000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Opts     DownloadOptions
+		Expected string // the last line's field
+	}{
+		{"stream", DownloadOptions{ExpectedSize: int64(len(content))}, "percent=100.00%"},
+		{"parts", DownloadOptions{ExpectedSize: int64(len(content)), PartSize: 64000}, "percent=100.00%"},
+		{"unknown size", DownloadOptions{}, "percent=100.00%"}, // the Content-Length is known
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			log := &progressLog{}
+			tc.Opts.ProgressInterval = time.Hour
+			path := filepath.Join(t.TempDir(), "file")
+			if _, err := DownloadToFile(context.Background(), log, srv.URL+"/file", path, tc.Opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(log.lines) == 0 {
+				t.Fatalf("expected progress to be logged")
+			}
+			key, value, _ := strings.Cut(tc.Expected, "=")
+			if last := log.lines[len(log.lines)-1]; last[key] != value {
+				t.Errorf("expected the last line to have %s, but got %v", tc.Expected, last)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/danbrakeley/needl/internal/plan"
+	natomic "github.com/natefinch/atomic"
+)
+
+// writeExtrasFile writes the full path (within dir) of each local-only file to path, one per line
+func writeExtrasFile(path, dir string, extras []plan.LocalFile) error {
+	var b bytes.Buffer
+	for _, l := range extras {
+		fmt.Fprintf(&b, "%s\n", filepath.Join(dir, filepath.FromSlash(l.FileName())))
+	}
+	if err := natomic.WriteFile(path, &b); err != nil {
+		return fmt.Errorf("write '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+func Test_WriteExtrasFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "extras.txt")
+	extras := []plan.LocalFile{
+		localFile(t, "a.zip", "", 1),
+		localFile(t, "2020/01/b.zip", "", 1),
+		{Name: "c.txt", SortName: "c.txt", Compressed: true},
+	}
+	if err := writeExtrasFile(path, "/data", extras); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join("/data", "a.zip") + "\n" +
+		filepath.Join("/data", "2020", "01", "b.zip") + "\n" +
+		filepath.Join("/data", "c.txt.gz") + "\n"
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// firstFailure records the first file that fails to download, and stops the run when it does (for
+// --fail-fast), by canceling the queue and any downloads in flight. A nil firstFailure lets the run
+// carry on past failed files.
+type firstFailure struct {
+	cancel func()
+
+	mu   sync.Mutex
+	name string
+	url  string
+	err  error
+}
+
+func newFirstFailure(cancel func()) *firstFailure {
+	return &firstFailure{cancel: cancel}
+}
+
+// fail is called for each file that fails to download, and only the first is kept
+func (f *firstFailure) fail(name, url string, err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil || err == nil {
+		return
+	}
+	f.name, f.url, f.err = name, url, err
+	f.cancel()
+}
+
+// failed returns the first file that failed, if any
+func (f *firstFailure) failed() (name, url string, err error, ok bool) {
+	if f == nil {
+		return "", "", nil, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.name, f.url, f.err, f.err != nil
+}
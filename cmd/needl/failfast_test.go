@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_FirstFailure(t *testing.T) {
+	var canceled int
+	f := newFirstFailure(func() { canceled++ })
+	if _, _, _, ok := f.failed(); ok {
+		t.Fatalf("expected no failure yet")
+	}
+
+	f.fail("a", "http://example.com/a", nil)
+	f.fail("b", "http://example.com/b", errors.New("first"))
+	f.fail("c", "http://example.com/c", errors.New("second"))
+
+	name, url, err, ok := f.failed()
+	if !ok || name != "b" || url != "http://example.com/b" || err == nil || err.Error() != "first" {
+		t.Errorf("expected the first failure (b), but got %s %s %v %v", name, url, err, ok)
+	}
+	if canceled != 1 {
+		t.Errorf("expected the run to be canceled once, but it was canceled %d times", canceled)
+	}
+}
+
+func Test_FirstFailure_Nil(t *testing.T) {
+	var f *firstFailure
+	f.fail("a", "http://example.com/a", errors.New("failed"))
+	if _, _, _, ok := f.failed(); ok {
+		t.Errorf("expected a nil firstFailure to never fail")
+	}
+}
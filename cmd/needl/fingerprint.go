@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// matchByPolicy decides how the diff pairs up remote files with local files
+type matchByPolicy string
+
+const (
+	matchByName        matchByPolicy = "name"        // only by name (the default)
+	matchByFingerprint matchByPolicy = "fingerprint" // also by content, for local files that were renamed on the remote
+)
+
+func parseMatchByPolicy(s string) (matchByPolicy, error) {
+	switch matchByPolicy(s) {
+	case "":
+		return matchByName, nil
+	case matchByName, matchByFingerprint:
+		return matchByPolicy(s), nil
+	}
+	return "", fmt.Errorf("unrecognized match_by policy '%s' (expected one of: %s, %s)",
+		s, matchByName, matchByFingerprint)
+}
+
+// fingerprintChunk is how many bytes, from the start and from the end of a file, its fingerprint hashes
+const fingerprintChunk = 16 * 1024
+
+// fingerprint is a cheap stand-in for a file's content: a hash of its size, and of its first and last
+// fingerprintChunk bytes (or all of it, for a file that is smaller than two chunks)
+type fingerprint [sha256.Size]byte
+
+// fingerprintRanges returns the offset and length of each range of a file of the given size that its
+// fingerprint hashes
+func fingerprintRanges(size int64) [][2]int64 {
+	if size <= 2*fingerprintChunk {
+		return [][2]int64{{0, size}}
+	}
+	return [][2]int64{{0, fingerprintChunk}, {size - fingerprintChunk, fingerprintChunk}}
+}
+
+// fingerprintFrom hashes the ranges of a file of the given size (see fingerprintRanges), with read
+// returning each range's content
+func fingerprintFrom(size int64, read func(offset, length int64) (io.ReadCloser, error)) (fingerprint, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", size)
+	for _, r := range fingerprintRanges(size) {
+		rc, err := read(r[0], r[1])
+		if err != nil {
+			return fingerprint{}, err
+		}
+		n, err := io.Copy(h, io.LimitReader(rc, r[1]))
+		rc.Close()
+		if err != nil {
+			return fingerprint{}, err
+		}
+		if n != r[1] {
+			return fingerprint{}, fmt.Errorf("expected %d bytes at %d, but read %d", r[1], r[0], n)
+		}
+	}
+	var fp fingerprint
+	h.Sum(fp[:0])
+	return fp, nil
+}
+
+// localFingerprint returns the fingerprint of the file at path, which is expected to be size bytes
+func localFingerprint(path string, size int64) (fingerprint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fingerprint{}, err
+	}
+	defer f.Close()
+	return fingerprintFrom(size, func(offset, length int64) (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(f, offset, length)), nil
+	})
+}
+
+// remoteFingerprint returns the fingerprint of the remote file (of the given size), which it gets by
+// requesting just the ranges it needs. A server that doesn't support byte ranges can't be fingerprinted.
+func remoteFingerprint(ctx context.Context, remoteURL string, size int64, opts DownloadOptions) (fingerprint, error) {
+	dc := downloadContext{remoteURL: remoteURL, opts: opts, finalURL: remoteURL}
+	return fingerprintFrom(size, func(offset, length int64) (io.ReadCloser, error) {
+		req, err := dc.newRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		end := offset + length - 1
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end))
+		resp, err := dc.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("do request: %w", err)
+		}
+		if resp.StatusCode == http.StatusOK && offset == 0 && length == size {
+			// the whole file was asked for, so it doesn't matter that the server ignored the range
+			return resp.Body, nil
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return nil, fmt.Errorf("expected status %d, but got %d", http.StatusPartialContent, resp.StatusCode)
+		}
+		if start, stop, _, err := parseContentRange(resp.Header); err != nil || start != offset || stop != end {
+			resp.Body.Close()
+			return nil, fmt.Errorf("requested bytes %d-%d, but got a Content-Range of '%s'",
+				offset, end, resp.Header.Get("Content-Range"))
+		}
+		return resp.Body, nil
+	})
+}
+
+// renamedLocal is a local file that isn't in the remote listing, whose content matches a remote file
+// that isn't in the local listing (so it was probably renamed on the remote).
+type renamedLocal struct {
+	Local  plan.LocalFile
+	Remote scraper.RemoteFile
+}
+
+// fingerprinter compares the content of local and remote files (for matchByFingerprint)
+type fingerprinter struct {
+	LocalPath string
+	Checksums scraper.ChecksumProvider // may be nil
+	Index     *checksumIndex           // may be nil
+	Remote    func(r scraper.RemoteFile) (fingerprint, error)
+}
+
+// findRenamedLocals pairs up the local files that aren't in the remote listing with the remote files
+// that aren't in the local listing, by their content. Only files of the same (exact) size are compared,
+// and only those are fingerprinted. A remote file that has a checksum is instead compared by checking
+// the local file against it. Each local file is paired at most once, with the first remote file (in
+// name order) that it matches. Linked and compressed local files are never paired.
+func findRenamedLocals(log frog.Logger, locals []plan.LocalFile, remotes []scraper.RemoteFile, fp fingerprinter) []renamedLocal {
+	var extras []plan.LocalFile
+	var missing []scraper.RemoteFile
+	plan.DiffSortedFilesFunc(locals, remotes, func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile) {
+		switch kind {
+		case plan.DiffExtra:
+			if !local.Linked && !local.Compressed && !local.Decompressed && local.Size > 0 {
+				extras = append(extras, local)
+			}
+		case plan.DiffMissing:
+			if remote.ExactSize() > 0 {
+				missing = append(missing, remote)
+			}
+		}
+	})
+	bySize := map[int64][]int{}
+	for i, l := range extras {
+		bySize[l.Size] = append(bySize[l.Size], i)
+	}
+
+	used := make([]bool, len(extras))
+	fingerprints := map[int]fingerprint{}
+	var renamed []renamedLocal
+	for _, r := range missing {
+		var candidates []int
+		for _, i := range bySize[r.Size] {
+			if !used[i] {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		c, hasChecksum := checksumFor(fp.Checksums, r.ListedName())
+		var rfp fingerprint
+		if !hasChecksum {
+			var err error
+			if rfp, err = fp.Remote(r); err != nil {
+				log.Verbose("failed to fingerprint remote file", frog.String("name", r.Name), frog.Err(err))
+				continue
+			}
+		}
+		for _, i := range candidates {
+			path := filepath.Join(fp.LocalPath, filepath.FromSlash(extras[i].Name))
+			var match bool
+			if hasChecksum {
+				match = verifyIndexed(log, path, c, false, fp.Index) == nil
+			} else {
+				lfp, ok := fingerprints[i]
+				if !ok {
+					var err error
+					if lfp, err = localFingerprint(path, extras[i].Size); err != nil {
+						// the zero fingerprint won't match anything
+						log.Verbose("failed to fingerprint local file", frog.String("name", extras[i].Name), frog.Err(err))
+					}
+					fingerprints[i] = lfp
+				}
+				match = lfp == rfp
+			}
+			if match {
+				used[i] = true
+				renamed = append(renamed, renamedLocal{Local: extras[i], Remote: r})
+				break
+			}
+		}
+	}
+	return renamed
+}
+
+// renameLocals renames each of the local files to the name of the remote file it matched (and sets its
+// time to the remote's), and returns the local listing, updated to match. In audit mode, the matches
+// are only logged. A local file that can't be renamed is logged, and left as it was.
+func renameLocals(
+	log frog.Logger, localPath string, locals []plan.LocalFile, renamed []renamedLocal, audit bool, stats *runStats,
+) []plan.LocalFile {
+	if len(renamed) == 0 {
+		return locals
+	}
+	byName := make(map[string]int, len(locals))
+	for i, l := range locals {
+		byName[l.Name] = i
+	}
+	for _, rn := range renamed {
+		fields := []frog.Fielder{frog.String("name", rn.Remote.Name), frog.String("local_name", rn.Local.Name)}
+		if audit {
+			log.Info("Local file matches renamed remote file", fields...)
+			continue
+		}
+		src := filepath.Join(localPath, filepath.FromSlash(rn.Local.Name))
+		dst := filepath.Join(localPath, filepath.FromSlash(rn.Remote.Name))
+		if err := renameLocal(log, src, dst, rn.Remote); err != nil {
+			log.Warning("renaming local file to match remote", append(fields, frog.Err(err))...)
+			continue
+		}
+		stats.filesRenamed.Add(1)
+		log.Info("Renamed local file to match remote", fields...)
+
+		i := byName[rn.Local.Name]
+		locals[i].Name, locals[i].SortName = rn.Remote.Name, rn.Remote.SortName
+		if !rn.Remote.Timestamp.IsZero() {
+			locals[i].Timestamp = rn.Remote.Timestamp
+		}
+	}
+	sort.Slice(locals, func(i, j int) bool {
+		return locals[i].SortName < locals[j].SortName
+	})
+	return locals
+}
+
+// renameLocal moves src to dst (which must not already exist), and sets its time to the remote file's
+func renameLocal(log frog.Logger, src, dst string, remote scraper.RemoteFile) error {
+	if _, err := os.Lstat(dst); !errors.Is(err, fs.ErrNotExist) {
+		if err == nil {
+			err = fs.ErrExist
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := moveFile(log, src, dst); err != nil {
+		return err
+	}
+	if remote.Timestamp.IsZero() {
+		return nil
+	}
+	return modifyFileTime(dst, remote.Timestamp)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_ParseMatchByPolicy(t *testing.T) {
+	cases := []struct {
+		Input       string
+		Expected    matchByPolicy
+		ExpectedErr bool
+	}{
+		{"", matchByName, false},
+		{"name", matchByName, false},
+		{"fingerprint", matchByFingerprint, false},
+		{"content", "", true},
+	}
+	for _, tc := range cases {
+		actual, err := parseMatchByPolicy(tc.Input)
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("'%s': expected error %v, but got %v", tc.Input, tc.ExpectedErr, err)
+		}
+		if actual != tc.Expected {
+			t.Errorf("'%s': expected '%s', but got '%s'", tc.Input, tc.Expected, actual)
+		}
+	}
+}
+
+func bytesFingerprint(t *testing.T, b []byte) fingerprint {
+	t.Helper()
+	r := bytes.NewReader(b)
+	fp, err := fingerprintFrom(int64(len(b)), func(offset, length int64) (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(r, offset, length)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func Test_RemoteFingerprint(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Size        int
+		IgnoreRange bool
+		ExpectedErr bool
+	}{
+		{"small", 5000, false, false},
+		{"large", 100000, false, false},
+		{"small, ranges ignored", 5000, true, false},
+		{"large, ranges ignored", 100000, true, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			content := testContent(t, tc.Size)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.IgnoreRange {
+					_, _ = w.Write(content)
+					return
+				}
+				http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+
+			actual, err := remoteFingerprint(context.Background(), srv.URL+"/file", int64(len(content)), DownloadOptions{})
+			if (err != nil) != tc.ExpectedErr {
+				t.Fatalf("expected error %v, but got %v", tc.ExpectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			expected, err := localFingerprint(path, int64(len(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != expected {
+				t.Errorf("expected the remote fingerprint to match the local file's")
+			}
+		})
+	}
+}
+
+func Test_Fingerprint_Differs(t *testing.T) {
+	a := testContent(t, 100000)
+	b := append([]byte(nil), a...)
+	b[len(b)-1] ^= 0xff
+	if bytesFingerprint(t, a) == bytesFingerprint(t, b) {
+		t.Errorf("expected a change to the last byte to change the fingerprint")
+	}
+	// the middle of a large file isn't hashed
+	b = append([]byte(nil), a...)
+	b[len(b)/2] ^= 0xff
+	if bytesFingerprint(t, a) != bytesFingerprint(t, b) {
+		t.Errorf("expected a change in the middle to leave the fingerprint as it was")
+	}
+	// but the size is
+	if bytesFingerprint(t, a) == bytesFingerprint(t, a[:len(a)-1]) {
+		t.Errorf("expected a change of size to change the fingerprint")
+	}
+}
+
+func Test_FindRenamedLocals(t *testing.T) {
+	dir := t.TempDir()
+	contentA := testContent(t, 5000)
+	contentB := append([]byte(nil), contentA...)
+	contentB[0] ^= 0xff
+	contentC := testContent(t, 3000)
+	for name, b := range map[string][]byte{
+		"a-old.bin": contentA, "b-old.bin": contentB, "c-old.bin": contentC, "keep.bin": contentC,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	locals := []plan.LocalFile{
+		localFile(t, "a-old.bin", "2020-01-02 03:04", 5000),
+		localFile(t, "b-old.bin", "2020-01-02 03:04", 5000),
+		localFile(t, "c-old.bin", "2020-01-02 03:04", 3000),
+		withLinked(localFile(t, "d-old.bin", "2020-01-02 03:04", 3000)),
+		localFile(t, "keep.bin", "2020-01-02 03:04", 3000),
+	}
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "a-new.bin", "2021-01-02 03:04", 5000),
+		remoteFile(t, "b-new.bin", "2021-01-02 03:04", 5000),
+		remoteFile(t, "c-new.bin", "2021-01-02 03:04", 3001),
+		remoteFile(t, "d-new.bin", "2021-01-02 03:04", 3000),
+		remoteFile(t, "keep.bin", "2020-01-02 03:04", 3000),
+	}
+	sumA := sha256Checksum(contentA)
+	checksums := scraper.Checksums{"a-new.bin": {Algo: sumA.Algo, Hex: sumA.Hex}}
+
+	var fingerprinted []string
+	remoteContent := map[string][]byte{"b-new.bin": contentB, "d-new.bin": testContent(t, 2999)}
+	renamed := findRenamedLocals(&frog.NullLogger{}, locals, remotes, fingerprinter{
+		LocalPath: dir,
+		Checksums: checksums,
+		Remote: func(r scraper.RemoteFile) (fingerprint, error) {
+			fingerprinted = append(fingerprinted, r.Name)
+			return bytesFingerprint(t, remoteContent[r.Name]), nil
+		},
+	})
+
+	expected := [][2]string{{"a-old.bin", "a-new.bin"}, {"b-old.bin", "b-new.bin"}}
+	if len(renamed) != len(expected) {
+		t.Fatalf("expected %d renamed files, but got %d: %v", len(expected), len(renamed), renamed)
+	}
+	for i, e := range expected {
+		if renamed[i].Local.Name != e[0] || renamed[i].Remote.Name != e[1] {
+			t.Errorf("%d: expected %s -> %s, but got %s -> %s", i, e[0], e[1], renamed[i].Local.Name, renamed[i].Remote.Name)
+		}
+	}
+	// a remote file with a checksum, or without a local file of its size, isn't fingerprinted (and a
+	// linked local file is never a candidate, so d-new.bin is only compared to c-old.bin)
+	if len(fingerprinted) != 2 || fingerprinted[0] != "b-new.bin" || fingerprinted[1] != "d-new.bin" {
+		t.Errorf("expected b-new.bin and d-new.bin to be fingerprinted, but got %v", fingerprinted)
+	}
+}
+
+func Test_RenameLocals(t *testing.T) {
+	for _, audit := range []bool{false, true} {
+		dir := t.TempDir()
+		content := testContent(t, 100)
+		if err := os.WriteFile(filepath.Join(dir, "old.bin"), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		locals := []plan.LocalFile{
+			localFile(t, "another.bin", "2020-01-02 03:04", 50),
+			localFile(t, "old.bin", "2020-01-02 03:04", 100),
+		}
+		remote := remoteFile(t, "sub/new.bin", "2021-01-02 03:04", 100)
+		var stats runStats
+		locals = renameLocals(&frog.NullLogger{}, dir, locals,
+			[]renamedLocal{{Local: locals[1], Remote: remote}}, audit, &stats,
+		)
+
+		if audit {
+			if _, err := os.Stat(filepath.Join(dir, "old.bin")); err != nil {
+				t.Errorf("audit: expected the local file to be left alone, but got %v", err)
+			}
+			if locals[1].Name != "old.bin" || stats.filesRenamed.Load() != 0 {
+				t.Errorf("audit: expected the listing to be left alone, but got %s (%d renamed)",
+					locals[1].Name, stats.filesRenamed.Load())
+			}
+			continue
+		}
+
+		fi, err := os.Stat(filepath.Join(dir, "sub", "new.bin"))
+		if err != nil {
+			t.Fatalf("expected the local file to be renamed, but got %v", err)
+		}
+		if !fi.ModTime().Equal(remote.Timestamp) {
+			t.Errorf("expected time %v, but got %v", remote.Timestamp, fi.ModTime())
+		}
+		if n := stats.filesRenamed.Load(); n != 1 {
+			t.Errorf("expected 1 renamed file, but got %d", n)
+		}
+		if len(locals) != 2 || locals[0].Name != "another.bin" || locals[1].Name != "sub/new.bin" {
+			t.Fatalf("expected the listing to be renamed and sorted, but got %v", locals)
+		}
+		// the renamed file is now unchanged, as far as the diff is concerned
+		plan.DiffSortedFilesFunc(locals, []scraper.RemoteFile{remote}, func(kind plan.DiffKind, local plan.LocalFile, r scraper.RemoteFile) {
+			if r.Name == remote.Name && kind != plan.DiffUnchanged {
+				t.Errorf("expected %s to be unchanged, but got %v", r.Name, kind)
+			}
+		})
+	}
+}
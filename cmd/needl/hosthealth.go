@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const defaultHostFailureWindow = time.Minute
+
+// errHostUnhealthy is returned when a download gives up on its host, because the host has
+// failed too many times in a row (across all downloads).
+var errHostUnhealthy = errors.New("host has failed too many times in a row")
+
+// hostBreaker is a circuit breaker for each host, shared by all of the download workers.
+// After threshold consecutive failed requests to a host (each within window of the one before),
+// the host is unhealthy for window, and downloads from it stop retrying (rather than each worker
+// spending its whole retry budget on it). Once window has passed, requests are allowed again,
+// but the first failure makes the host unhealthy again, while any success makes it healthy.
+// A nil hostBreaker considers every host healthy.
+type hostBreaker struct {
+	threshold int
+	window    time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*hostHealth
+}
+
+type hostHealth struct {
+	failures    int
+	lastFailure time.Time
+	openUntil   time.Time // zero unless the breaker has tripped
+}
+
+func newHostBreaker(threshold int, window time.Duration) *hostBreaker {
+	if window <= 0 {
+		window = defaultHostFailureWindow
+	}
+	return &hostBreaker{
+		threshold: threshold,
+		window:    window,
+		now:       time.Now,
+		hosts:     make(map[string]*hostHealth),
+	}
+}
+
+// healthy returns false if requests to rawURL's host should not be made (or retried) right now
+func (b *hostBreaker) healthy(rawURL string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.hosts[hostOf(rawURL)]
+	return !ok || !b.now().Before(h.openUntil)
+}
+
+// failed records a failed request to rawURL's host
+func (b *hostBreaker) failed(rawURL string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	host := hostOf(rawURL)
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &hostHealth{}
+		b.hosts[host] = h
+	}
+
+	now := b.now()
+	if !h.openUntil.IsZero() {
+		// the breaker already tripped, and this was a trial request after it reopened
+		h.openUntil = now.Add(b.window)
+		h.lastFailure = now
+		return
+	}
+	if now.Sub(h.lastFailure) > b.window {
+		h.failures = 0
+	}
+	h.failures++
+	h.lastFailure = now
+	if h.failures >= b.threshold {
+		h.openUntil = now.Add(b.window)
+	}
+}
+
+// succeeded records a successful request to rawURL's host, which makes it healthy again
+func (b *hostBreaker) succeeded(rawURL string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.hosts, hostOf(rawURL))
+}
+
+// reopensAt returns when the last of the unhealthy hosts allows requests again (or zero if none are unhealthy)
+func (b *hostBreaker) reopensAt() time.Time {
+	if b == nil {
+		return time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var last time.Time
+	for _, h := range b.hosts {
+		if h.openUntil.After(last) {
+			last = h.openUntil
+		}
+	}
+	return last
+}
+
+// hostOf returns the host (and port, if any) of rawURL, or rawURL itself if it doesn't parse
+func hostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && len(u.Host) > 0 {
+		return u.Host
+	}
+	return rawURL
+}
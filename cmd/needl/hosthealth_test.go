@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_HostBreaker(t *testing.T) {
+	const window = time.Minute
+	cases := []struct {
+		Name     string
+		Events   []string // "fail a", "ok a", or "wait <duration>"
+		Expected map[string]bool
+	}{
+		{"no failures", nil, map[string]bool{"a": true}},
+		{"below threshold", []string{"fail a", "fail a"}, map[string]bool{"a": true}},
+		{"at threshold", []string{"fail a", "fail a", "fail a"}, map[string]bool{"a": false, "b": true}},
+		{"success resets", []string{"fail a", "fail a", "ok a", "fail a"}, map[string]bool{"a": true}},
+		{"failures outside window", []string{"fail a", "fail a", "wait 2m", "fail a"}, map[string]bool{"a": true}},
+		{"recovers after window", []string{"fail a", "fail a", "fail a", "wait 61s"}, map[string]bool{"a": true}},
+		{"trips again on first failure after window", []string{"fail a", "fail a", "fail a", "wait 61s", "fail a"}, map[string]bool{"a": false}},
+		{"ports are separate hosts", []string{"fail a:81", "fail a:81", "fail a:81"}, map[string]bool{"a:81": false, "a": true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			b := newHostBreaker(3, window)
+			b.now = func() time.Time { return now }
+			for _, e := range tc.Events {
+				var op, arg string
+				if _, err := fmt.Sscan(e, &op, &arg); err != nil {
+					t.Fatalf("bad event '%s': %v", e, err)
+				}
+				switch op {
+				case "fail":
+					b.failed("http://" + arg + "/file")
+				case "ok":
+					b.succeeded("http://" + arg + "/file")
+				case "wait":
+					d, err := time.ParseDuration(arg)
+					if err != nil {
+						t.Fatalf("bad event '%s': %v", e, err)
+					}
+					now = now.Add(d)
+				}
+			}
+			for host, expected := range tc.Expected {
+				if actual := b.healthy("http://" + host + "/other"); actual != expected {
+					t.Errorf("expected %s healthy to be %v, but got %v", host, expected, actual)
+				}
+				if !expected && !now.Before(b.reopensAt()) {
+					t.Errorf("expected %s to reopen after %v, but it reopens at %v", host, now, b.reopensAt())
+				}
+			}
+		})
+	}
+}
+
+func Test_DownloadToFile_UnhealthyHost(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// drop the connection without a response
+		requests.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	b := newHostBreaker(1, time.Minute)
+	dir := t.TempDir()
+	for i, name := range []string{"a.bin", "b.bin"} {
+		_, err := DownloadToFile(context.Background(), nil, srv.URL+"/"+name, filepath.Join(dir, name),
+			DownloadOptions{HostBreaker: b},
+		)
+		if !errors.Is(err, errHostUnhealthy) {
+			t.Fatalf("download %d: expected errHostUnhealthy, but got %v", i, err)
+		}
+	}
+	// the second download shouldn't have been attempted
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, but got %d", n)
+	}
+}
+
+func Test_DownloadToFile_UnhealthyRedirectedHost(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer mirror.Close()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Redirect(w, r, mirror.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	// the failures from the mirror count against the host of the url that was asked for
+	b := newHostBreaker(1, time.Minute)
+	dir := t.TempDir()
+	for i, name := range []string{"a.bin", "b.bin"} {
+		_, err := DownloadToFile(context.Background(), nil, srv.URL+"/"+name, filepath.Join(dir, name),
+			DownloadOptions{HostBreaker: b},
+		)
+		if !errors.Is(err, errHostUnhealthy) {
+			t.Fatalf("download %d: expected errHostUnhealthy, but got %v", i, err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, but got %d", n)
+	}
+}
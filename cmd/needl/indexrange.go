@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// indexRange selects a window of the files to download, by their index in the sorted list of
+// missing and changed files. Like a Go slice expression, start is inclusive and end is exclusive,
+// and an end of -1 means there is no end. The zero value selects nothing, so use allIndexes instead.
+type indexRange struct {
+	start, end int
+}
+
+var allIndexes = indexRange{start: 0, end: -1}
+
+// parseIndexRange parses "START:END", where either side can be left out (eg ":5" is the first
+// five files, and "100:" is every file from the 101st on).
+func parseIndexRange(s string) (indexRange, error) {
+	startStr, endStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return indexRange{}, fmt.Errorf("expected START:END, but got '%s'", s)
+	}
+	r := allIndexes
+	var err error
+	if len(startStr) > 0 {
+		if r.start, err = strconv.Atoi(startStr); err != nil || r.start < 0 {
+			return indexRange{}, fmt.Errorf("invalid start '%s'", startStr)
+		}
+	}
+	if len(endStr) > 0 {
+		if r.end, err = strconv.Atoi(endStr); err != nil || r.end < 0 {
+			return indexRange{}, fmt.Errorf("invalid end '%s'", endStr)
+		}
+		if r.end < r.start {
+			return indexRange{}, fmt.Errorf("end %d is before start %d", r.end, r.start)
+		}
+	}
+	return r, nil
+}
+
+// contains returns whether the file at index i is in the range
+func (r indexRange) contains(i int) bool {
+	return i >= r.start && (r.end < 0 || i < r.end)
+}
+
+// all returns whether the range selects every index
+func (r indexRange) all() bool {
+	return r.start == 0 && r.end < 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func Test_ParseIndexRange(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected indexRange
+		Err      bool
+	}{
+		{":", allIndexes, false},
+		{":5", indexRange{0, 5}, false},
+		{"100:110", indexRange{100, 110}, false},
+		{"100:", indexRange{100, -1}, false},
+		{"3:3", indexRange{3, 3}, false},
+		{"5", indexRange{}, true},
+		{"", indexRange{}, true},
+		{"a:5", indexRange{}, true},
+		{"-1:5", indexRange{}, true},
+		{"1:-5", indexRange{}, true},
+		{"5:1", indexRange{}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Input, func(t *testing.T) {
+			actual, err := parseIndexRange(tc.Input)
+			if tc.Err {
+				if err == nil {
+					t.Errorf("expected an error, but got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != tc.Expected {
+				t.Errorf("expected %v, but got %v", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func Test_IndexRange_Contains(t *testing.T) {
+	cases := []struct {
+		Range    indexRange
+		Index    int
+		Expected bool
+	}{
+		{allIndexes, 0, true},
+		{allIndexes, 1000, true},
+		{indexRange{0, 5}, 4, true},
+		{indexRange{0, 5}, 5, false},
+		{indexRange{100, 110}, 99, false},
+		{indexRange{100, 110}, 100, true},
+		{indexRange{100, -1}, 1000, true},
+		{indexRange{3, 3}, 3, false},
+	}
+
+	for _, tc := range cases {
+		if actual := tc.Range.contains(tc.Index); actual != tc.Expected {
+			t.Errorf("expected %v contains %d to be %v, but got %v", tc.Range, tc.Index, tc.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+	natomic "github.com/natefinch/atomic"
+)
+
+// listingStateVersion is bumped whenever the state's format changes, so that an old state is ignored
+const listingStateVersion = 1
+
+// listingState remembers each scraper's remote listing from the last run (for listing_state), so that
+// files that have since disappeared from the remote can be reported. Listings are keyed by the scraper's
+// name, so one state file can be shared by every scraper. All methods are safe to call on a nil
+// *listingState, which never has a previous listing.
+type listingState struct {
+	path     string
+	scrapers map[string]map[string]listingStateEntry
+	dirty    bool
+}
+
+// listingStateFile is how the state is stored on disk
+type listingStateFile struct {
+	Version  int                                     `json:"version"`
+	Scrapers map[string]map[string]listingStateEntry `json:"scrapers"`
+}
+
+// listingStateEntry is a remote file from a previous listing, keyed by its name
+type listingStateEntry struct {
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"time"`
+}
+
+// removedRemote is a remote file that was in the previous listing, but isn't in the current one
+type removedRemote struct {
+	Name string
+	listingStateEntry
+}
+
+// compareListingState logs each file in the scraper's previous listing (from the state at path) that
+// isn't in remotes, and then (if save is set) saves remotes as its listing for the next run. It returns
+// the exit code if the state can't be read. Failing to save the state is only logged.
+func compareListingState(
+	log frog.Logger, path, scraperName string, remotes []scraper.RemoteFile, stats *runStats, save bool,
+) int {
+	st, err := loadListingState(path)
+	if err != nil {
+		log.Error("loading listing state", frog.PathAbs(path), frog.Err(err))
+		return 37
+	}
+	for _, r := range st.removed(scraperName, remotes) {
+		stats.filesRemoved.Add(1)
+		log.Info("Remote file removed", frog.String("name", r.Name), frog.Int64("size", r.Size), frog.Time("time", r.Timestamp))
+	}
+	if !save {
+		return 0
+	}
+	st.update(scraperName, remotes)
+	if err := st.save(); err != nil {
+		log.Warning("saving listing state", frog.PathAbs(path), frog.Err(err))
+	}
+	return 0
+}
+
+// loadListingState reads the state at path, or returns an empty state if there isn't one yet (or it was
+// written by a different version).
+func loadListingState(path string) (*listingState, error) {
+	st := &listingState{path: path, scrapers: map[string]map[string]listingStateEntry{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored listingStateFile
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, fmt.Errorf("decode '%s': %w", path, err)
+	}
+	if stored.Version == listingStateVersion && stored.Scrapers != nil {
+		st.scrapers = stored.Scrapers
+	}
+	return st, nil
+}
+
+// removed returns the files in the scraper's previous listing that aren't in remotes (compared by
+// SortName), sorted by name. If the scraper has no previous listing, then nothing was removed.
+func (st *listingState) removed(scraperName string, remotes []scraper.RemoteFile) []removedRemote {
+	if st == nil {
+		return nil
+	}
+	prev := st.scrapers[scraperName]
+	if len(prev) == 0 {
+		return nil
+	}
+	listed := make(map[string]bool, len(remotes))
+	for _, r := range remotes {
+		listed[r.SortName] = true
+	}
+	var removed []removedRemote
+	for name, e := range prev {
+		if !listed[scraper.SortName(name)] {
+			removed = append(removed, removedRemote{Name: name, listingStateEntry: e})
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].Name < removed[j].Name
+	})
+	return removed
+}
+
+// update replaces the scraper's previous listing with remotes
+func (st *listingState) update(scraperName string, remotes []scraper.RemoteFile) {
+	if st == nil {
+		return
+	}
+	files := make(map[string]listingStateEntry, len(remotes))
+	for _, r := range remotes {
+		files[r.Name] = listingStateEntry{Size: r.Size, Timestamp: r.Timestamp.UTC()}
+	}
+	st.scrapers[scraperName] = files
+	st.dirty = true
+}
+
+// save writes the state back to its path, if it was updated since it was loaded
+func (st *listingState) save() error {
+	if st == nil || !st.dirty {
+		return nil
+	}
+	b, err := json.Marshal(listingStateFile{Version: listingStateVersion, Scrapers: st.scrapers})
+	if err != nil {
+		return err
+	}
+	if err := natomic.WriteFile(st.path, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	st.dirty = false
+	return nil
+}
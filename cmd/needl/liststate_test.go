@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_ListingState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	st, err := loadListingState(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading missing state: %v", err)
+	}
+	first := []scraper.RemoteFile{
+		remoteFile(t, "a", "2020-01-01 00:00", 10),
+		remoteFile(t, "B", "2020-01-01 00:00", 20),
+		remoteFile(t, "c", "2020-01-01 00:00", 30),
+	}
+	if removed := st.removed("tv", first); len(removed) != 0 {
+		t.Errorf("expected nothing removed without a previous listing, but got %v", removed)
+	}
+	st.update("tv", first)
+	st.update("radio", []scraper.RemoteFile{remoteFile(t, "z", "2020-01-01 00:00", 1)})
+	if err := st.save(); err != nil {
+		t.Fatalf("unexpected error saving state: %v", err)
+	}
+
+	st, err = loadListingState(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading state: %v", err)
+	}
+	second := []scraper.RemoteFile{
+		remoteFile(t, "b", "2020-02-01 00:00", 25),
+		remoteFile(t, "d", "2020-01-01 00:00", 40),
+	}
+	removed := st.removed("tv", second)
+	expected := []string{"a", "c"}
+	if len(removed) != len(expected) {
+		t.Fatalf("expected %d removed, but got %v", len(expected), removed)
+	}
+	for i, name := range expected {
+		if removed[i].Name != name {
+			t.Errorf("%d: expected '%s' removed, but got '%s'", i, name, removed[i].Name)
+		}
+	}
+	if removed[0].Size != 10 || !removed[0].Timestamp.Equal(first[0].Timestamp) {
+		t.Errorf("expected the previous size and time of 'a', but got %d %v", removed[0].Size, removed[0].Timestamp)
+	}
+	if removed := st.removed("radio", nil); len(removed) != 1 || removed[0].Name != "z" {
+		t.Errorf("expected each scraper's listing to be kept separately, but got %v", removed)
+	}
+}
+
+func Test_CompareListingState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	log := &frog.NullLogger{}
+
+	var stats runStats
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "a", "2020-01-01 00:00", 10),
+		remoteFile(t, "b", "2020-01-01 00:00", 20),
+	}
+	if code := compareListingState(log, path, "tv", remotes, &stats, true); code != 0 {
+		t.Fatalf("expected exit code 0, but got %d", code)
+	}
+	// an audit reports the removed file, but doesn't save the listing, so the next run reports it too
+	if code := compareListingState(log, path, "tv", remotes[1:], &stats, false); code != 0 {
+		t.Fatalf("expected exit code 0, but got %d", code)
+	}
+	if n := stats.filesRemoved.Load(); n != 1 {
+		t.Errorf("expected 1 removed, but got %d", n)
+	}
+	if code := compareListingState(log, path, "tv", remotes[1:], &stats, true); code != 0 {
+		t.Fatalf("expected exit code 0, but got %d", code)
+	}
+	if n := stats.filesRemoved.Load(); n != 2 {
+		t.Errorf("expected 2 removed, but got %d", n)
+	}
+	// the saved listing replaced the first, so 'a' isn't reported again
+	if code := compareListingState(log, path, "tv", remotes[1:], &stats, true); code != 0 {
+		t.Fatalf("expected exit code 0, but got %d", code)
+	}
+	if n := stats.filesRemoved.Load(); n != 2 {
+		t.Errorf("expected 2 removed, but got %d", n)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := compareListingState(log, path, "tv", remotes, &stats, true); code != 37 {
+		t.Errorf("expected exit code 37 for a corrupt state, but got %d", code)
+	}
+}
+
+func Test_ListingState_OtherVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"version":0,"scrapers":{"tv":{"a":{"size":1}}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadListingState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed := st.removed("tv", nil); len(removed) != 0 {
+		t.Errorf("expected a state from another version to be ignored, but got %v", removed)
+	}
+}
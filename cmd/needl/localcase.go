@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// localCase remembers the local names of files that are downloaded again, where the local file's name
+// only differs from the remote file's by case (as on a case-insensitive filesystem), so that they're
+// written under the local name, instead of flipping its case each run (for preserve_local_case).
+// A nil localCase keeps every remote name.
+type localCase struct {
+	mu    sync.Mutex
+	names map[string]string // remote name to local name
+}
+
+func newLocalCase() *localCase {
+	return &localCase{names: map[string]string{}}
+}
+
+// keep records that the remote file should be written under the local file's name, if they differ
+func (c *localCase) keep(local plan.LocalFile, remoteName string) {
+	if c == nil || local.Name == remoteName || len(local.Name) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names[remoteName] = local.Name
+}
+
+// name returns the name to write the remote file under
+func (c *localCase) name(remoteName string) string {
+	if c == nil {
+		return remoteName
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if local, ok := c.names[remoteName]; ok {
+		return local
+	}
+	return remoteName
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+func Test_LocalCase(t *testing.T) {
+	c := newLocalCase()
+	c.keep(plan.LocalFile{Name: "file.mp4"}, "file.MP4")
+	c.keep(plan.LocalFile{Name: "same.txt"}, "same.txt")
+	c.keep(plan.LocalFile{}, "missing.txt")
+
+	cases := []struct {
+		Remote   string
+		Expected string
+	}{
+		{"file.MP4", "file.mp4"},
+		{"same.txt", "same.txt"},
+		{"missing.txt", "missing.txt"},
+		{"other.txt", "other.txt"},
+	}
+	for _, tc := range cases {
+		if actual := c.name(tc.Remote); actual != tc.Expected {
+			t.Errorf("'%s': expected '%s', but got '%s'", tc.Remote, tc.Expected, actual)
+		}
+	}
+	if len(c.names) != 1 {
+		t.Errorf("expected only the name that differs to be kept, but got %v", c.names)
+	}
+}
+
+func Test_LocalCase_Nil(t *testing.T) {
+	var c *localCase
+	c.keep(plan.LocalFile{Name: "file.mp4"}, "file.MP4")
+	if actual := c.name("file.MP4"); actual != "file.MP4" {
+		t.Errorf("expected the remote name, but got '%s'", actual)
+	}
+}
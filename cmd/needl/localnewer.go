@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// localNewerPolicy decides what happens when a local file that the diff considers changed was
+// modified more recently than the remote file, which usually means it was edited locally.
+type localNewerPolicy string
+
+const (
+	localNewerWarn      localNewerPolicy = "warn"      // log a warning, then download it anyway (the default)
+	localNewerSkip      localNewerPolicy = "skip"      // keep the local file
+	localNewerOverwrite localNewerPolicy = "overwrite" // download it, without a warning
+)
+
+func parseLocalNewerPolicy(s string) (localNewerPolicy, error) {
+	switch localNewerPolicy(s) {
+	case "":
+		return localNewerWarn, nil
+	case localNewerWarn, localNewerSkip, localNewerOverwrite:
+		return localNewerPolicy(s), nil
+	}
+	return "", fmt.Errorf("unrecognized local_newer policy '%s' (expected one of: %s, %s, %s)",
+		s, localNewerWarn, localNewerSkip, localNewerOverwrite)
+}
+
+// isLocalNewer returns whether the local file was modified after the remote file (at the precision
+// of the remote timestamp). Linked files, and remote files without a timestamp, are never newer.
+func isLocalNewer(local plan.LocalFile, remote scraper.RemoteFile) bool {
+	if local.Linked || remote.Timestamp.IsZero() {
+		return false
+	}
+	return local.Timestamp.Truncate(remote.TimestampPrecision).After(remote.Timestamp)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_IsLocalNewer(t *testing.T) {
+	cases := []struct {
+		Name        string
+		LocalStamp  string
+		RemoteStamp string
+		Linked      bool
+		Expected    bool
+	}{
+		{"newer", "2020-01-02 03:05", "2020-01-02 03:04", false, true},
+		{"older", "2020-01-02 03:03", "2020-01-02 03:04", false, false},
+		{"same", "2020-01-02 03:04", "2020-01-02 03:04", false, false},
+		{"no remote time", "2020-01-02 03:05", "", false, false},
+		{"linked", "2020-01-02 03:05", "2020-01-02 03:04", true, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			local := localFile(t, "a.zip", tc.LocalStamp, 100)
+			local.Linked = tc.Linked
+			remote := remoteFile(t, "a.zip", tc.RemoteStamp, 100)
+			if actual := isLocalNewer(local, remote); actual != tc.Expected {
+				t.Errorf("expected %v, but got %v", tc.Expected, actual)
+			}
+		})
+	}
+
+	// seconds past the remote's minute aren't newer, for a listing that only has minutes
+	local := localFile(t, "a.zip", "2020-01-02 03:04", 100)
+	local.Timestamp = local.Timestamp.Add(30 * time.Second)
+	remote := remoteFile(t, "a.zip", "2020-01-02 03:04", 100)
+	remote.TimestampPrecision = time.Minute
+	if isLocalNewer(local, remote) {
+		t.Errorf("expected a time within the remote's precision to not be newer")
+	}
+}
+
+func Test_ParseLocalNewerPolicy(t *testing.T) {
+	cases := []struct {
+		Input       string
+		Expected    localNewerPolicy
+		ExpectedErr bool
+	}{
+		{"", localNewerWarn, false},
+		{"warn", localNewerWarn, false},
+		{"skip", localNewerSkip, false},
+		{"overwrite", localNewerOverwrite, false},
+		{"keep", "", true},
+	}
+	for _, tc := range cases {
+		actual, err := parseLocalNewerPolicy(tc.Input)
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("'%s': expected error %v, but got %v", tc.Input, tc.ExpectedErr, err)
+		}
+		if actual != tc.Expected {
+			t.Errorf("'%s': expected '%s', but got '%s'", tc.Input, tc.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// errFileLocked is returned when another process holds the lock on a local path
+var errFileLocked = errors.New("file is being downloaded by another process")
+
+// fileLock is an advisory lock on a local path, held while it is being downloaded
+type fileLock struct {
+	f    *os.File
+	path string
+}
+
+// lockFilePath returns the path of the lock file for localPath, which is beside it, and is
+// named by a hash of its name (so that it isn't any longer than a temp file's name).
+func lockFilePath(localPath string) string {
+	sum := sha256.Sum256([]byte(filepath.Base(localPath)))
+	return filepath.Join(filepath.Dir(localPath), hex.EncodeToString(sum[:8])+plan.LockFileSuffix)
+}
+
+// acquireFileLock locks localPath against other processes that also lock it, or returns
+// errFileLocked (without waiting) if another process already holds the lock.
+func acquireFileLock(localPath string) (*fileLock, error) {
+	path := lockFilePath(localPath)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := tryLockFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		// the holder removes the lock file as it releases the lock, so if the file was removed
+		// after it was opened, then this locked a file that no one else will see, and must try again
+		fi, err := f.Stat()
+		if err == nil {
+			var cur os.FileInfo
+			cur, err = os.Stat(path)
+			if err == nil && os.SameFile(fi, cur) {
+				return &fileLock{f: f, path: path}, nil
+			}
+		}
+		f.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
+// release removes the lock file and releases the lock
+func (l *fileLock) release() {
+	unlockFile(l.f, l.path)
+}
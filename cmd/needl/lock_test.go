@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_AcquireFileLock(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "file.bin")
+
+	lock, err := acquireFileLock(localPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := acquireFileLock(localPath); !errors.Is(err, errFileLocked) {
+		t.Fatalf("expected errFileLocked, but got %v", err)
+	}
+	lock.release()
+	if _, err := os.Stat(lockFilePath(localPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected lock file to be removed, but got %v", err)
+	}
+
+	lock, err = acquireFileLock(localPath)
+	if err != nil {
+		t.Fatalf("unexpected error after release: %v", err)
+	}
+	lock.release()
+}
+
+func Test_DownloadToFile_Locked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no request while locked, but got one for '%s'", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	localPath := filepath.Join(t.TempDir(), "file.bin")
+	lock, err := acquireFileLock(localPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer lock.release()
+
+	_, err = DownloadToFile(context.Background(), nil, srv.URL+"/file.bin", localPath, DownloadOptions{Lock: true})
+	if !errors.Is(err, errFileLocked) {
+		t.Fatalf("expected errFileLocked, but got %v", err)
+	}
+	if _, err := os.Stat(localPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no local file, but got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func tryLockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errFileLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File, path string) {
+	// remove while still locked, so that whoever locks the file next is sure to be holding the
+	// only lock file at that path (see acquireFileLock)
+	_ = os.Remove(path)
+	_ = f.Close()
+}
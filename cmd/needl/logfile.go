@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+// logFileToken, in a --log-file path, is replaced with the time the run started, so that each run
+// gets its own file
+const logFileToken = "{timestamp}"
+
+// logFilePath returns path, with any {timestamp} token replaced with start (in local time, as
+// YYYYMMDD-HHMMSS, so that the files sort in the order they were written)
+func logFilePath(path string, start time.Time) string {
+	return strings.ReplaceAll(path, logFileToken, start.Format("20060102-150405"))
+}
+
+// logFile is a logger that writes to a file, which is closed along with the logger
+type logFile struct {
+	frog.RootLogger
+	f *os.File
+}
+
+// openLogFile opens the log file at path (and creates its folder, if needed), and returns a logger that
+// appends to it, as JSON, or as plain text, with the time of each line (but no colors).
+func openLogFile(path string, jsonLogs bool) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var prn frog.Printer = &frog.JSONPrinter{}
+	if !jsonLogs {
+		prn = (&frog.TextPrinter{}).SetOptions(frog.POTime(true), frog.POLevel(true), frog.POFieldIndent(26))
+	}
+	// the level is otherwise up to the logger it's teed from, but progress lines would just be noise
+	l := frog.NewUnbuffered(f, prn)
+	l.SetMinLevel(frog.Verbose)
+	return &logFile{RootLogger: l, f: f}, nil
+}
+
+func (l *logFile) Close() {
+	l.RootLogger.Close()
+	_ = l.f.Close()
+}
+
+// teeLogger logs to both the terminal's logger and a log file, which are both closed with it
+type teeLogger struct {
+	*frog.TeeLogger
+	close func()
+}
+
+func newTeeLogger(term frog.RootLogger, file *logFile) *teeLogger {
+	tee, close := frog.NewRootTee(term, file)
+	return &teeLogger{TeeLogger: tee, close: close}
+}
+
+func (l *teeLogger) Close() {
+	l.close()
+}
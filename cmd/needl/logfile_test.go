@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LogFilePath(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	cases := []struct {
+		Path     string
+		Expected string
+	}{
+		{"needl.log", "needl.log"},
+		{"logs/needl-{timestamp}.log", "logs/needl-20240102-030405.log"},
+		{"{timestamp}/{timestamp}.log", "20240102-030405/20240102-030405.log"},
+	}
+	for _, tc := range cases {
+		if actual := logFilePath(tc.Path, start); actual != tc.Expected {
+			t.Errorf("expected '%s' to be '%s', but got '%s'", tc.Path, tc.Expected, actual)
+		}
+	}
+}
+
+func Test_OpenLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "needl.log")
+	for _, msg := range []string{"first run", "second run"} {
+		l, err := openLogFile(path, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		l.Info(msg)
+		l.Verbose("also " + msg)
+		l.Close()
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], "first run") || !strings.Contains(lines[2], "second run") {
+		t.Errorf("expected both runs to be appended, but got:\n%s", b)
+	}
+	if strings.Contains(string(b), "\x1b[") {
+		t.Errorf("expected no colors in the log file, but got:\n%s", b)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// login posts the scraper's Login form (if it has one), and keeps the cookies it sets in scfg.Jar, so
+// that the session is used for the listing and downloads. The cookies are only kept in memory.
+// It is an error if the form's response is an error status, or if it set no cookies for the first
+// base URL (which usually means the login was rejected, and the form was shown again).
+func login(log frog.Logger, scfg *config.Scraper) error {
+	if scfg.Login == nil {
+		return nil
+	}
+	log.Info("Logging in...", frog.String("url", scfg.Login.URL))
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	for key, value := range scfg.Login.Fields {
+		form.Set(key, value)
+	}
+	req, err := http.NewRequest("POST", scfg.Login.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to make new POST request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(scfg.UserAgent) > 0 {
+		req.Header.Set("User-Agent", scfg.UserAgent)
+	}
+	for key, value := range scfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Jar: jar, Timeout: scfg.ScrapeTimeout, Transport: plan.ScraperTransport(*scfg)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to do request: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected request status %d", resp.StatusCode)
+	}
+
+	if urls := scfg.BaseURLs(); len(urls) > 0 {
+		u, err := url.Parse(urls[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse base url '%s': %w", urls[0], err)
+		}
+		if len(jar.Cookies(u)) == 0 {
+			return fmt.Errorf("login set no cookies for '%s'", urls[0])
+		}
+	}
+	scfg.Jar = jar
+	return nil
+}
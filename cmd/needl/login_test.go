@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+)
+
+func Test_Login(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.PostFormValue("user") != "alice" || r.PostFormValue("pass") != "secret" {
+			// a rejected login just shows the form again
+			w.Write([]byte("<form>"))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/files/", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cases := []struct {
+		Name        string
+		Pass        string
+		ExpectedErr bool
+	}{
+		{"accepted", "secret", false},
+		{"rejected", "wrong", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			scfg := config.Scraper{
+				URL:   srv.URL + "/files/",
+				Login: &config.Login{URL: srv.URL + "/login", Fields: map[string]string{"user": "alice", "pass": tc.Pass}},
+			}
+			err := login(&frog.NullLogger{}, &scfg)
+			if tc.ExpectedErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			resp, err := (&http.Client{Jar: scfg.Jar}).Get(scfg.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected the session to be sent, but got status %d", resp.StatusCode)
+			}
+		})
+	}
+}
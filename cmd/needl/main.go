@@ -1,27 +1,40 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata" // for scraper timezones, on systems without a zoneinfo database
 
 	"github.com/danbrakeley/frog"
 	"github.com/danbrakeley/needl/internal/buildvar"
 	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
 	"github.com/danbrakeley/needl/internal/scraper"
+	"github.com/dustin/go-humanize"
 )
 
 const (
 	defaultConfigPath   = "needl.toml"
 	defaultScrapersPath = "scrapers.toml"
-	defaultThreadCount  = 4
+
+	// maxRuntimeGrace is how long in-flight downloads are given to finish after --max-runtime is reached
+	maxRuntimeGrace = 30 * time.Second
 )
 
 func PrintUsage() {
@@ -45,18 +58,67 @@ func PrintUsage() {
 			"%s",
 			"",
 			"Usage:",
-			"\tneedl [options] <scraper_name> <download_path>",
-			"\tneedl --version",
+			"\tneedl [options] [<scraper_name>] [<download_path>]",
+			"\tneedl [options] --scraper-type TYPE --scraper-url URL [<scraper_name>] <download_path>",
+			"\tneedl [options] --url URL [--out PATH] [--sha256 HEX]",
+			"\tneedl --check-config [--probe]",
+			"\tneedl --list-scrapers",
+			"\tneedl --version [--build-info]",
 			"\tneedl --help",
 			"Options:",
-			"\t-c, --config PATH     Config TOML file (default: '%s')",
-			"\t    --scrapers PATH   Scrapers TOML file (default: '%s')",
-			"\t-t, --threads NUM     Max number of concurrent downloads (default: '%d')",
-			"\t-v, --verbose         Extra output (for debugging)",
-			"\t    --version         Print just the version number (to stdout)",
-			"\t-h, --help            Print this message (to stderr)",
+			"\t-c, --config PATH           Config TOML file (default: '%s')",
+			"\t    --scrapers PATH         Scrapers TOML file, or a folder of them (default: '%s')",
+			"\t    --scraper-type TYPE     Override the scraper's type (one of: %s)",
+			"\t    --scraper-url URL       Override the scraper's base URL(s)",
+			"\t    --url URL               Download just URL, without any config or scrapers",
+			"\t    --out PATH              With --url, the path to download to (default: the URL's file name)",
+			"\t    --sha256 HEX            With --url, verify the download has this SHA-256 checksum",
+			"\t-t, --threads NUM           Max number of concurrent downloads (default: '%d')",
+			"\t    --scrape-threads NUM    Max number of concurrent lookups of unknown sizes (default: same as --threads)",
+			"\t    --verify-threads NUM    Max number of concurrent checksum verifications (default: same as --threads)",
+			"\t    --max-connections NUM   Max number of concurrent download requests, including each part of a file (default: no limit)",
+			"\t    --rate-limit RATE       Max bytes per second (eg '500KiB') to download, across all downloads (default: no limit)",
+			"\t    --trickle               Download slowly in the background: one file at a time, in small batches, until caught up",
+			"\t    --window START-END      Only start downloads between these local times of day (eg '01:00-06:00')",
+			"\t    --metrics-file PATH     Write Prometheus metrics to PATH at the end of the run",
+			"\t    --status-addr ADDR      Serve the run's status as JSON on ADDR (eg ':8080') while it runs",
+			"\t    --audit                 Only report differences, without writing anything to the download path",
+			"\t    --emit-script PATH      Like --audit, but also write a shell script to PATH that downloads the differences",
+			"\t    --extras-file PATH      Write the path of each local file that isn't in the remote listing to PATH, one per line",
+			"\t    --verify-checksums      Hash unchanged local files, and re-download any that don't match their checksum",
+			"\t    --force                 Re-download every remote file, even if it matches the local file",
+			"\t    --repair NAME           Only check the local file NAME (and its checksum), and re-download it if it differs",
+			"\t    --repair-empties        Only re-download the local files that are empty (but whose remote file isn't known to be)",
+			"\t    --range START:END       Only download the missing and changed files from index START up to (not including) END",
+			"\t    --verify-manifest SRC   Verify every local file listed in the checksums file SRC (a path or URL), without listing",
+			"\t                            the remote files (with --repair, SRC is the only checksums source instead)",
+			"\t    --fail-on-empty         Exit with status 34 if the remote listing has no files",
+			"\t    --fail-fast             Stop the run at the first file that fails to download, and exit with status 62",
+			"\t    --strict-scrape         Fail the listing if too many of its lines that link to a file couldn't be parsed",
+			"\t    --lock                  Skip files that another process is downloading into the download path",
+			"\t    --head-first            Before downloading a file of unknown size, HEAD it to learn its size (so that it can be resumed)",
+			"\t    --store PATH            Keep downloads in a content-addressed store at PATH, linked from the download path",
+			"\t    --part-size SIZE        Download files larger than SIZE (eg '64MiB') in parts of SIZE",
+			"\t    --engine NAME           Download with 'builtin' (the default) or 'aria2c' (if it's installed)",
+			"\t    --size-only             Treat local files with the same size as their remote file as unchanged, and fix their times",
+			"\t    --sequential            Only write each file from start to finish, starting over (instead of resuming) after an error",
+			"\t    --xattrs                Record each download's URL, time, and checksum in its extended attributes",
+			"\t    --max-runtime DUR       Stop starting new downloads after DUR (eg '2h30m'), and exit with status 40",
+			"\t    --prune-tmp             Remove the temp files that interrupted downloads left in the download path, then exit",
+			"\t    --prune-tmp-age DUR     With --prune-tmp, only remove temp files that haven't been modified in DUR (eg '24h')",
+			"\t-v, --verbose               Extra output (for debugging)",
+			"\t-q, --quiet                 Only log warnings and errors (the summary is still shown)",
+			"\t    --json                  Log as JSON, one object per line",
+			"\t    --log-file PATH         Also append the log to PATH, where '{timestamp}' is replaced with the run's start time",
+			"\t    --progress MODE         Show download progress as 'log' lines (default), or as a 'bar' per download",
+			"\t    --check-config          Validate the config and scrapers files, then exit",
+			"\t    --probe                 With --check-config, also send a HEAD request to each scraper URL",
+			"\t    --list-scrapers         Print the available scraper types (to stdout)",
+			"\t    --version               Print just the version number (to stdout)",
+			"\t    --build-info            Print the version, build time, Go version, and platform (to stdout)",
+			"\t-h, --help                  Print this message (to stderr)",
 			"",
-		}, "\n"), version, buildTime, url, defaultConfigPath, defaultScrapersPath, defaultThreadCount,
+		}, "\n"), version, buildTime, url, defaultConfigPath, defaultScrapersPath, strings.Join(sortedScraperTypes(), ", "), plan.DefaultThreadCount,
 	)
 }
 
@@ -71,35 +133,123 @@ func main() {
 	}
 }
 
-type LocalFile struct {
-	Name      string
-	SortName  string
-	Timestamp time.Time
-	Size      int64
-}
-
-func mainExit() int {
+func mainExit() (exitCode int) {
 	start := time.Now()
 	flag.Usage = PrintUsage
 
 	var configPath string
 	var scrapersPath string
+	var scraperType string
+	var scraperURL string
+	var singleURL string
+	var outPath string
+	var sha256Hex string
 	var threadCount int
+	var scrapeThreadCount int
+	var verifyThreadCount int
+	var maxConnections int
+	var rateLimitStr string
+	var trickle bool
+	var windowStr string
+	var metricsPath string
+	var statusAddr string
+	var audit bool
+	var emitScriptPath string
+	var extrasPath string
+	var force bool
+	var verifyChecksums bool
+	var repairName string
+	var manifestSrc string
+	var failOnEmpty bool
+	var failFast bool
+	var lockFiles bool
+	var headFirst bool
+	var sequential bool
+	var sizeOnly bool
+	var xattrs bool
+	var strictScrape bool
+	var repairEmpties bool
+	var rangeStr string
+	var maxRuntime time.Duration
+	var pruneTmp bool
+	var pruneTmpAge time.Duration
+	var partSizeStr string
+	var storePath string
+	var engineStr string
 	var verbose bool
+	var quiet bool
+	var jsonLogs bool
+	var logFilePathStr string
+	var progressMode string
+	var checkOnly bool
+	var probe bool
+	var listScrapers bool
 	var showVersion bool
+	var buildInfo bool
 	var showHelp bool
 	flag.StringVar(&configPath, "config", defaultConfigPath, "path to optional config file")
 	flag.StringVar(&configPath, "c", defaultConfigPath, "path to optional config file")
 	flag.StringVar(&scrapersPath, "scrapers", defaultScrapersPath, "path to scrapers file")
+	flag.StringVar(&scraperType, "scraper-type", "", "override the scraper type")
+	flag.StringVar(&scraperURL, "scraper-url", "", "override the scraper url")
+	flag.StringVar(&singleURL, "url", "", "download just this url, without any config or scrapers")
+	flag.StringVar(&outPath, "out", "", "with --url, the path to download to")
+	flag.StringVar(&sha256Hex, "sha256", "", "with --url, the expected sha256 of the download")
 	flag.IntVar(&threadCount, "threads", 0, "number of simultaneous downloads")
 	flag.IntVar(&threadCount, "t", 0, "number of simultaneous downloads")
+	flag.IntVar(&scrapeThreadCount, "scrape-threads", 0, "number of simultaneous size lookups")
+	flag.IntVar(&verifyThreadCount, "verify-threads", 0, "number of simultaneous checksum verifications")
+	flag.IntVar(&maxConnections, "max-connections", 0, "max number of simultaneous download requests")
+	flag.StringVar(&rateLimitStr, "rate-limit", "", "max bytes per second to download")
+	flag.BoolVar(&trickle, "trickle", false, "download slowly in the background until caught up")
+	flag.StringVar(&windowStr, "window", "", "only start downloads between these local times of day")
+	flag.StringVar(&metricsPath, "metrics-file", "", "path to write prometheus metrics")
+	flag.StringVar(&statusAddr, "status-addr", "", "address to serve the run's status on, as JSON")
+	flag.BoolVar(&audit, "audit", false, "report differences without changing anything")
+	flag.StringVar(&emitScriptPath, "emit-script", "", "write a download script instead of downloading")
+	flag.StringVar(&extrasPath, "extras-file", "", "write the paths of local-only files")
+	flag.BoolVar(&verifyChecksums, "verify-checksums", false, "verify local files against known checksums")
+	flag.BoolVar(&force, "force", false, "re-download all remote files")
+	flag.StringVar(&repairName, "repair", "", "only check and re-download the given file")
+	flag.StringVar(&manifestSrc, "verify-manifest", "", "verify local files against a checksums manifest, without listing")
+	flag.BoolVar(&failOnEmpty, "fail-on-empty", false, "fail if the remote listing is empty")
+	flag.BoolVar(&failFast, "fail-fast", false, "stop at the first failed download")
+	flag.BoolVar(&strictScrape, "strict-scrape", false, "fail if too many listing lines can't be parsed")
+	flag.BoolVar(&lockFiles, "lock", false, "skip files that another process is downloading")
+	flag.BoolVar(&headFirst, "head-first", false, "send a HEAD request before downloading a file of unknown size")
+	flag.BoolVar(&repairEmpties, "repair-empties", false, "only re-download local files that are empty")
+	flag.StringVar(&rangeStr, "range", "", "only download the files in this window of indexes")
+	flag.DurationVar(&maxRuntime, "max-runtime", 0, "max time to run before stopping")
+	flag.BoolVar(&pruneTmp, "prune-tmp", false, "remove temp files left by interrupted downloads, then exit")
+	flag.DurationVar(&pruneTmpAge, "prune-tmp-age", 0, "with --prune-tmp, only remove temp files at least this old")
+	flag.StringVar(&storePath, "store", "", "path to content-addressed store")
+	flag.StringVar(&engineStr, "engine", "", "what downloads each file: builtin or aria2c")
+	flag.StringVar(&partSizeStr, "part-size", "", "size of each part of a multi-part download")
+	flag.BoolVar(&sizeOnly, "size-only", false, "treat files with the same size as unchanged, and fix their times")
+	flag.BoolVar(&sequential, "sequential", false, "only write downloads sequentially, and start over after errors")
+	flag.BoolVar(&xattrs, "xattrs", false, "record where each download came from in its extended attributes")
 	flag.BoolVar(&verbose, "v", false, "extra logging for debugging")
 	flag.BoolVar(&verbose, "verbose", false, "extra logging for debugging")
+	flag.BoolVar(&quiet, "q", false, "only log warnings and errors")
+	flag.BoolVar(&quiet, "quiet", false, "only log warnings and errors")
+	flag.BoolVar(&jsonLogs, "json", false, "log as json")
+	flag.StringVar(&logFilePathStr, "log-file", "", "also write the log to this file")
+	flag.StringVar(&progressMode, "progress", "log", "how to show download progress")
+	flag.BoolVar(&checkOnly, "check-config", false, "validate config files and exit")
+	flag.BoolVar(&probe, "probe", false, "with --check-config, probe each scraper url")
+	flag.BoolVar(&listScrapers, "list-scrapers", false, "list the scraper types")
 	flag.BoolVar(&showVersion, "version", false, "show version info")
+	flag.BoolVar(&buildInfo, "build-info", false, "show full build info")
 	flag.BoolVar(&showHelp, "h", false, "show this help message")
 	flag.BoolVar(&showHelp, "help", false, "show this help message")
 	flag.Parse()
 
+	// --version on its own prints just the number, for scripts, so the fuller info needs asking for
+	if buildInfo || (showVersion && verbose) {
+		printBuildInfo(os.Stdout)
+		return 0
+	}
+
 	if showVersion {
 		if len(buildvar.Version) == 0 {
 			fmt.Printf("unknown\n")
@@ -114,27 +264,149 @@ func mainExit() int {
 		return 0
 	}
 
+	if listScrapers {
+		printScraperTypes(os.Stdout)
+		return 0
+	}
+
 	if len(flag.Args()) > 2 {
 		fmt.Printf("unrecognized arguments: %v\n", strings.Join(flag.Args(), " "))
 		flag.Usage()
 		return 1
 	}
 
-	log := frog.New(frog.Auto, frog.POFieldIndent(26))
+	if len(scraperType) > 0 && !slices.Contains(scraper.ListTypes(), scraperType) {
+		fmt.Printf("unrecognized scraper type '%s' (expected one of: %s)\n",
+			scraperType, strings.Join(sortedScraperTypes(), ", "))
+		return 1
+	}
+	switch progressMode {
+	case "log", "bar":
+	default:
+		fmt.Printf("unrecognized progress mode '%s' (expected 'log' or 'bar')\n", progressMode)
+		return 1
+	}
+	var singleChecksum Checksum
+	if len(singleURL) > 0 {
+		if len(flag.Args()) > 0 {
+			fmt.Printf("unrecognized arguments with --url: %v\n", strings.Join(flag.Args(), " "))
+			return 1
+		}
+		if audit || len(emitScriptPath) > 0 {
+			// a single download has nothing to compare against, so there would be nothing to report
+			fmt.Printf("--audit and --emit-script can't be used with --url\n")
+			return 1
+		}
+		if len(outPath) == 0 {
+			outPath = nameFromURL(singleURL)
+		}
+		if len(sha256Hex) > 0 {
+			c, err := ParseChecksum("sha256:" + sha256Hex)
+			if err != nil {
+				fmt.Printf("invalid --sha256: %v\n", err)
+				return 1
+			}
+			singleChecksum = c
+		}
+	} else if len(outPath) > 0 || len(sha256Hex) > 0 {
+		fmt.Printf("--out and --sha256 require --url\n")
+		return 1
+	}
+	queueRange := allIndexes
+	if len(rangeStr) > 0 {
+		r, err := parseIndexRange(rangeStr)
+		if err != nil {
+			fmt.Printf("invalid --range: %v\n", err)
+			return 1
+		}
+		queueRange = r
+	}
+	if len(emitScriptPath) > 0 {
+		// the script does the downloading instead
+		audit = true
+	}
+	// with both the type and url on the command line, the scrapers file isn't needed
+	adhoc := len(scraperType) > 0 && len(scraperURL) > 0
+
+	// queueCtx stops new downloads from being started, and dlCtx cancels any in-flight downloads
+	queueCtx, dlCtx := context.Background(), context.Background()
+	if maxRuntime > 0 {
+		var cancelQueue, cancelDl context.CancelFunc
+		queueCtx, cancelQueue = context.WithDeadline(queueCtx, start.Add(maxRuntime))
+		defer cancelQueue()
+		dlCtx, cancelDl = context.WithDeadline(dlCtx, start.Add(maxRuntime+maxRuntimeGrace))
+		defer cancelDl()
+	}
+	// with --fail-fast, the first failed download cancels both
+	var firstFail *firstFailure
+	if failFast {
+		var cancelQueue, cancelDl context.CancelFunc
+		queueCtx, cancelQueue = context.WithCancel(queueCtx)
+		defer cancelQueue()
+		dlCtx, cancelDl = context.WithCancel(dlCtx)
+		defer cancelDl()
+		firstFail = newFirstFailure(func() {
+			cancelQueue()
+			cancelDl()
+		})
+	}
+
+	// progress bars need a terminal to redraw in, so otherwise fall back to logging progress
+	var bars *progressBars
+	var log frog.RootLogger
+	if jsonLogs {
+		log = frog.New(frog.JSON)
+	} else if progressMode == "bar" && frog.HasTerminal(os.Stdout) {
+		// log lines are printed above the bars, and the transient progress lines are dropped
+		bars = newProgressBars(os.Stdout)
+		prn := &frog.TextPrinter{}
+		log = frog.NewUnbuffered(bars, prn.SetOptions(
+			frog.POPalette(frog.DefaultPalette), frog.POTime(true), frog.POLevel(true), frog.POFieldIndent(26),
+		))
+	} else {
+		log = frog.New(frog.Auto, frog.POFieldIndent(26))
+	}
+	// the log file gets the same lines as the terminal (other than progress lines), for a post-mortem
+	var fileLog *logFile
+	if len(logFilePathStr) > 0 {
+		path := logFilePath(logFilePathStr, start)
+		var err error
+		fileLog, err = openLogFile(path, jsonLogs)
+		if err != nil {
+			log.Error("opening log file", frog.PathAbs(path), frog.Err(err))
+			log.Close()
+			return 1
+		}
+		log = newTeeLogger(log, fileLog)
+	}
 	if verbose {
 		log.SetMinLevel(frog.Verbose)
+	} else if quiet {
+		log.SetMinLevel(frog.Warning)
 	}
+	var stats runStats
+	showSummary := false
 	defer func() {
+		if showSummary && jsonLogs {
+			// the summary is shown even when quiet
+			log.SetMinLevel(frog.Info)
+			logSummary(log, &stats)
+		} else if showSummary && fileLog != nil {
+			// the summary table is only printed to the terminal, so log it to the file instead
+			logSummary(fileLog, &stats)
+		}
 		dur := time.Now().Sub(start)
 		log.Info("Done", frog.Dur("time", dur))
 		log.Close()
+		bars.close()
+		if showSummary && !jsonLogs {
+			writeSummaryTable(os.Stdout, &stats)
+		}
 	}()
 
-	// parse arguments
-	var scraperName string
-	var dstPath string
-	scraperName = flag.Arg(0)
-	dstPath = flag.Arg(1)
+	if checkOnly {
+		return checkConfig(log, configPath, scrapersPath, probe)
+	}
 
 	log.Info("Loading config...", frog.Path(configPath))
 	cfg, err := config.Load(configPath)
@@ -144,37 +416,211 @@ func mainExit() int {
 	}
 
 	// override config with command-line flags
-	if len(scraperName) > 0 {
-		cfg.Scraper = scraperName
-	}
-	if len(dstPath) > 0 {
-		cfg.LocalPath = dstPath
-	}
 	if threadCount > 0 {
 		cfg.Threads = threadCount
 	} else if cfg.Threads == 0 {
-		cfg.Threads = defaultThreadCount
+		cfg.Threads = plan.DefaultThreadCount
+	}
+	if scrapeThreadCount > 0 {
+		cfg.ScrapeThreads = scrapeThreadCount
+	} else if cfg.ScrapeThreads == 0 {
+		cfg.ScrapeThreads = cfg.Threads
+	}
+	if verifyThreadCount > 0 {
+		cfg.VerifyThreads = verifyThreadCount
+	} else if cfg.VerifyThreads == 0 {
+		cfg.VerifyThreads = cfg.Threads
+	}
+	if maxConnections > 0 {
+		cfg.MaxConnections = maxConnections
+	}
+	if len(rateLimitStr) > 0 {
+		cfg.RateLimit = rateLimitStr
+	}
+	if len(windowStr) > 0 {
+		cfg.Window = windowStr
+	}
+	if trickle {
+		cfg.Trickle = true
+	}
+	if cfg.Trickle {
+		applyTrickle(&cfg)
+	}
+	if len(partSizeStr) > 0 {
+		cfg.PartSize = partSizeStr
+	}
+	if len(storePath) > 0 {
+		cfg.Store = storePath
+	}
+	if len(engineStr) > 0 {
+		cfg.Engine = engineStr
 	}
 	if verbose {
 		cfg.Verbose = true
 	}
+	if strictScrape {
+		cfg.StrictScrape = true
+	}
+	if xattrs {
+		cfg.Xattrs = true
+	}
+	if sizeOnly {
+		cfg.SizeOnly = true
+	}
 	// now that the config is loaded, ensure the log level is set properly
 	if cfg.Verbose {
 		log.SetMinLevel(frog.Verbose)
+	} else if quiet {
+		log.SetMinLevel(frog.Warning)
 	} else {
 		log.SetMinLevel(frog.Info)
 	}
 
+	// the webhook is set up as soon as the config is loaded, so that it's sent for any run that ends after
+	// this (even one that exits early)
+	webhookOn, err := parseWebhookPolicy(cfg.WebhookOn)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	if len(cfg.WebhookURL) > 0 {
+		defer func() {
+			success := exitCode == 0 && stats.filesFailed.Load() == 0 && stats.scrapeFailures.Load() == 0
+			if success && webhookOn == webhookFailure {
+				return
+			}
+			name := cfg.Scraper
+			if len(singleURL) > 0 {
+				name = filepath.Base(outPath)
+			}
+			payload := newWebhookPayload(name, &stats, time.Now().Sub(start), success)
+			// a webhook that's down shouldn't fail a run that otherwise succeeded
+			if err := sendWebhook(&http.Client{Timeout: webhookTimeout}, cfg.WebhookURL, payload); err != nil {
+				log.Warning("sending webhook", frog.Err(err))
+			}
+		}()
+	}
+
+	// every run checks the config as --check-config does, so that a config it rejects (such as negative
+	// threads, or a layout that leads outside the download path) can't get any further
+	if err := cfg.Validate(); err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+
+	if len(singleURL) > 0 {
+		var onProgress func(read, total int64)
+		if bars != nil {
+			bars.queue()
+			bars.start(0, filepath.Base(outPath), 0)
+			defer bars.end(0)
+			onProgress = func(read, total int64) {
+				bars.update(0, read, total)
+			}
+		}
+		return downloadSingle(dlCtx, log, singleURL, outPath, DownloadOptions{
+			Checksum:   singleChecksum,
+			OnProgress: onProgress,
+			Lock:       lockFiles,
+			HeadFirst:  headFirst,
+			Sequential: sequential,
+		}, xattrs)
+	}
+
+	// pruning only needs the download path
+	if pruneTmp {
+		if len(flag.Args()) > 1 {
+			log.Error("--prune-tmp takes at most a download path", frog.String("args", strings.Join(flag.Args(), " ")))
+			return 1
+		}
+		if len(flag.Args()) == 1 {
+			cfg.LocalPath = flag.Arg(0)
+		}
+		if len(cfg.LocalPath) == 0 {
+			log.Error("no download path given (pass one, or set 'path' in the config)", frog.PathAbs(configPath))
+			return 1
+		}
+		count, bytes, err := pruneTempFiles(log, cfg.LocalPath, pruneTmpAge, time.Now())
+		log.Info("Pruned temp files", frog.Int("files", count), frog.String("reclaimed", humanize.Bytes(uint64(bytes))))
+		if err != nil {
+			log.Error("pruning temp files", frog.PathAbs(cfg.LocalPath), frog.Err(err))
+			return 20
+		}
+		return 0
+	}
+
+	// verifying against a manifest doesn't need a scraper, unless a file is to be repaired
+	if len(manifestSrc) > 0 && len(repairName) == 0 {
+		if len(flag.Args()) > 1 {
+			log.Error("--verify-manifest takes at most a download path", frog.String("args", strings.Join(flag.Args(), " ")))
+			return 1
+		}
+		if len(flag.Args()) == 1 {
+			cfg.LocalPath = flag.Arg(0)
+		}
+		if len(cfg.LocalPath) == 0 {
+			log.Error("no download path given (pass one, or set 'path' in the config)", frog.PathAbs(configPath))
+			return 1
+		}
+		var index *checksumIndex
+		if len(cfg.ChecksumIndex) > 0 {
+			index, err = loadChecksumIndex(cfg.ChecksumIndex)
+			if err != nil {
+				log.Error("loading checksum index", frog.PathAbs(cfg.ChecksumIndex), frog.Err(err))
+				return 33
+			}
+		}
+		showSummary = true
+		status := auditManifest(log, manifestSrc, cfg.LocalPath, cfg.Compressed, index, &stats)
+		if err := index.save(); err != nil {
+			log.Warning("saving checksum index", frog.PathAbs(cfg.ChecksumIndex), frog.Err(err))
+		}
+		return status
+	}
+
 	log.Info("Loading scrapers...", frog.Path(scrapersPath))
 	scrapers, err := config.LoadScrapers(scrapersPath)
-	if err != nil {
+	if err != nil && !(adhoc && errors.Is(err, fs.ErrNotExist)) {
 		log.Error("loading scrapers", frog.PathAbs(scrapersPath), frog.Err(err))
 		return 6
 	}
 
+	// parse arguments, which override the config's scraper and path
+	var scraperName string
+	var dstPath string
+	switch {
+	case len(flag.Args()) == 2:
+		scraperName, dstPath = flag.Arg(0), flag.Arg(1)
+	case len(flag.Args()) == 1:
+		// a lone argument is the download path if the scraper is already known (from the command line,
+		// or the config's default) and the argument isn't also the name of a scraper
+		if _, isScraper := scrapers[flag.Arg(0)]; adhoc || (len(cfg.Scraper) > 0 && !isScraper) {
+			dstPath = flag.Arg(0)
+		} else {
+			scraperName = flag.Arg(0)
+		}
+	}
+	if len(scraperName) > 0 {
+		cfg.Scraper = scraperName
+	}
+	if len(dstPath) > 0 {
+		cfg.LocalPath = dstPath
+	}
+
 	scfg, ok := scrapers[cfg.Scraper]
+	if !ok && adhoc {
+		// everything the run needs came from the command line
+		ok = true
+		if len(cfg.Scraper) == 0 {
+			cfg.Scraper = "adhoc"
+		}
+	}
 	if !ok {
-		log.Error("scraper not found", frog.String("name", cfg.Scraper), frog.PathAbs(scrapersPath))
+		if len(cfg.Scraper) == 0 {
+			log.Error("no scraper given (pass a scraper name, or set 'scraper' in the config)", frog.PathAbs(configPath))
+		} else {
+			log.Error("scraper not found", frog.String("name", cfg.Scraper), frog.PathAbs(scrapersPath))
+		}
 		log.Close()
 		flag.CommandLine.SetOutput(os.Stderr)
 		flag.Usage()
@@ -189,215 +635,853 @@ func mainExit() int {
 		return 7
 	}
 
-	// ensure local path exists
-	if err := os.MkdirAll(cfg.LocalPath, 0o755); err != nil {
-		log.Error("creating local path", frog.PathAbs(cfg.LocalPath), frog.Err(err))
+	// override scraper with command-line flags
+	if len(scraperType) > 0 {
+		scfg.Type = scraperType
+	}
+	if len(scraperURL) > 0 {
+		scfg.URL = scraperURL
+		scfg.URLs = nil
+	}
+
+	// fill in anything the scraper leaves unset from the config's defaults (for its type, and then for all)
+	scfg = cfg.Defaults.Apply(scfg)
+	// a bad scraper config (such as a malformed url) fails now, rather than when it's scraped (or, with
+	// continue_on_error, not at all)
+	if err := scfg.Validate(); err != nil {
+		log.Error("config error", frog.String("name", cfg.Scraper), frog.PathAbs(scrapersPath), frog.Err(err))
+		return 5
+	}
+	// the cache is set for every scraper, so one whose type can't use it just goes without
+	if info, _ := scraper.Describe(scfg.Type); len(cfg.ScrapeCache) > 0 && !slices.Contains(info.Optional, "CacheDir") {
+		log.Warning("Scraper type doesn't cache its listing, so scrape_cache is ignored", frog.String("type", scfg.Type))
 	}
 
-	// list local and remote files
-	locals, remotes, errno := listFiles(log, cfg, scfg)
-	if errno > 0 {
-		return errno
+	if len(manifestSrc) > 0 {
+		scfg.Checksums = []string{manifestSrc}
 	}
 
-	// diff local vs remote
-	extra, missing, changed := diffSortedFiles(locals, remotes)
+	if err := scfg.ResolveSecrets(); err != nil {
+		log.Error("resolving scraper secrets", frog.String("name", cfg.Scraper), frog.Err(err))
+		return 8
+	}
+	if err := login(log, &scfg); err != nil {
+		log.Error("logging in", frog.String("name", cfg.Scraper), frog.Err(err))
+		return 9
+	}
+
+	dups, err := plan.ParseDuplicatePolicy(cfg.Duplicates)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	longNames, err := plan.ParseLongNamePolicy(cfg.LongNames)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	localNewer, err := parseLocalNewerPolicy(cfg.LocalNewer)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	unknownSize, err := parseUnknownSizePolicy(cfg.UnknownSize)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	onFailure, err := parseFailurePolicy(cfg.FailedDownloads)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	matchBy, err := parseMatchByPolicy(cfg.MatchBy)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	engine, err := parseDownloadEngine(cfg.Engine)
+	if err != nil {
+		log.Error("config error", frog.PathAbs(configPath), frog.Err(err))
+		return 5
+	}
+	aria2cPath := findAria2c(log, engine, cfg, scfg)
+	if cfg.MaxNameLength <= 0 {
+		cfg.MaxNameLength = plan.DefaultMaxNameLength
+	}
+	if len(cfg.LayoutFallback) == 0 {
+		cfg.LayoutFallback = plan.DefaultLayoutFallback
+	}
+	var partSize uint64
+	if len(cfg.PartSize) > 0 {
+		partSize, err = humanize.ParseBytes(cfg.PartSize)
+		if err != nil {
+			log.Error("config error", frog.String("part_size", cfg.PartSize), frog.Err(err))
+			return 5
+		}
+	}
+	// new downloads only start within the window (if there is one)
+	var window *downloadWindow
+	if len(cfg.Window) > 0 {
+		window, err = parseDownloadWindow(cfg.Window)
+		if err != nil {
+			log.Error("config error", frog.String("window", cfg.Window), frog.Err(err))
+			return 5
+		}
+	}
+	// every download shares the one rate limit
+	var rateLimit *rateLimiter
+	if len(cfg.RateLimit) > 0 {
+		bytesPerSec, err := humanize.ParseBytes(cfg.RateLimit)
+		if err == nil && bytesPerSec == 0 {
+			err = errors.New("must be more than zero")
+		}
+		if err != nil {
+			log.Error("config error", frog.String("rate_limit", cfg.RateLimit), frog.Err(err))
+			return 5
+		}
+		rateLimit = newRateLimiter(int64(bytesPerSec))
+	}
+
+	completed := false
+	succeeded := func() bool {
+		return completed && stats.filesFailed.Load() == 0 && stats.scrapeFailures.Load() == 0
+	}
+	if len(metricsPath) > 0 {
+		defer func() {
+			err := writeMetrics(metricsPath, cfg.Scraper, &stats, time.Now().Sub(start), time.Now(), succeeded())
+			if err != nil {
+				log.Error("writing metrics", frog.PathAbs(metricsPath), frog.Err(err))
+			}
+		}()
+	}
+	// ensure local path exists, and can be written to, before anything is listed (unless auditing,
+	// where the local path may be read-only)
+	if !audit {
+		if err := ensureWritable(cfg.LocalPath); err != nil {
+			log.Error("download path is not writable", frog.PathAbs(cfg.LocalPath), frog.Err(err))
+			return 21
+		}
+	}
 
-	// call out files that are local-only
-	for _, v := range extra {
-		log.Info("Local file not in remote", frog.String("name", v.Name))
+	// the status server (if any) reports on each pass, until the run ends
+	var status *runStatus
+	if len(statusAddr) > 0 {
+		status = newRunStatus(&stats)
+		stop, err := serveStatus(log, statusAddr, status)
+		if err != nil {
+			log.Error("starting status server", frog.String("addr", statusAddr), frog.Err(err))
+			return 1
+		}
+		defer stop()
 	}
 
-	var wg sync.WaitGroup
-	ch := make(chan scraper.RemoteFile)
-	// spawn workers
-	wg.Add(cfg.Threads)
-	for i := 0; i < cfg.Threads; i++ {
-		go func() {
-			for r := range ch {
-				log.Info("Start download",
-					frog.String("name", r.Name), frog.Int64("size", r.Size),
-					frog.Time("time", r.Timestamp), frog.String("url", r.URL),
+	// each pass lists the files, and downloads the differences (in trickle mode, just a batch of them)
+	var leftForNextBatch int
+	runPass := func() (code int) {
+		leftForNextBatch = 0
+		status.startCycle()
+		defer func() { status.endCycle(code) }()
+
+		// list local and remote files (or, to repair a file, just look up that one)
+		var locals []plan.LocalFile
+		var remotes []scraper.RemoteFile
+		var errno int
+		incomplete := stats.scrapeFailures.Load() + stats.listingsTruncated.Load()
+		if len(repairName) > 0 {
+			locals, remotes, errno = statRepairFile(log, cfg, scfg, filepath.ToSlash(repairName), audit)
+		} else {
+			locals, remotes, errno = listFiles(log, cfg, scfg, &stats, audit)
+		}
+		if errno > 0 {
+			return errno
+		}
+		if failOnEmpty && len(remotes) == 0 {
+			log.Error("remote listing is empty (has the source moved?)", frog.String("urls", strings.Join(scfg.BaseURLs(), " ")))
+			return 34
+		}
+
+		// report the files that have disappeared from the remote since the last run (as listed, before
+		// they're given local paths), unless some of this listing is missing. An audit only reports them,
+		// so that they're reported again by the next run that syncs.
+		if len(cfg.ListingState) > 0 && len(repairName) == 0 {
+			if stats.scrapeFailures.Load()+stats.listingsTruncated.Load() > incomplete {
+				log.Warning("Skipping the comparison with the previous listing, since this one is incomplete",
+					frog.PathAbs(cfg.ListingState),
+				)
+			} else if errno = compareListingState(log, cfg.ListingState, cfg.Scraper, remotes, &stats, !audit); errno > 0 {
+				return errno
+			}
+		}
+
+		// ensure each remote file has its own local path, that is short enough for the filesystem
+		remotes, err = plan.ResolveRemotes(log, cfg, remotes, dups, longNames)
+		if err != nil {
+			return logPlanError(log, err)
+		}
+		if scfg.Decompress {
+			locals = plan.DecompressedLocals(locals, remotes)
+		}
+
+		// find the expected checksums of remote files (if any are configured)
+		checksums, err := loadChecksumsFor(log, cfg, scfg)
+		if err != nil {
+			log.Error("loading checksums", frog.Err(err))
+			return 33
+		}
+
+		if queueCtx.Err() != nil {
+			log.Warning("Max runtime reached before downloads could start", frog.Dur("max_runtime", maxRuntime))
+			return 40
+		}
+
+		// a host that keeps failing has its files left for a second pass, instead of tying up the workers
+		var breaker *hostBreaker
+		if cfg.HostFailures > 0 {
+			breaker = newHostBreaker(cfg.HostFailures, cfg.HostFailureWindow)
+		}
+		// files that a worker took while waiting for the download window, but never started
+		var outsideWindow atomic.Int64
+		var deferredMu sync.Mutex
+		var deferred []scraper.RemoteFile
+
+		// when a host is having trouble, every worker downloading from it retries, so its retries are
+		// logged once per window, with a count of the rest
+		retryLog := newRetryLogThrottle(defaultRetryLogWindow)
+		defer retryLog.flush(log)
+
+		// the parts of each file are downloaded at once, so the number of open requests can be more than
+		// the number of workers, unless it's capped
+		var connections *connLimiter
+		if cfg.MaxConnections > 0 {
+			connections = newConnLimiter(cfg.MaxConnections)
+		}
+
+		// downloads share the session's cookies (if there is one), and the scraper's socket (if set)
+		downloadClient := plan.ScraperClient(scfg)
+
+		// a local file whose name only differs from the remote's by case may keep its name
+		var localNames *localCase
+		if cfg.PreserveLocalCase {
+			localNames = newLocalCase()
+		}
+
+		// download is run by the workers for each file, and lastPass is set if a file from an unhealthy
+		// host should fail, rather than be left for a later pass
+		download := func(r scraper.RemoteFile, lastPass bool, worker int) {
+			log.Info("Start download",
+				frog.String("name", r.Name), frog.Int64("size", r.Size),
+				frog.Time("time", r.Timestamp), frog.String("url", r.URL),
+			)
+			name := localNames.name(r.Name)
+			if len(name) == 0 {
+				// the listing had no name, so hope that the server sends one
+				name = nameFromURL(r.URL)
+			}
+			path := filepath.Join(cfg.LocalPath, name)
+			if len(cfg.Layout) > 0 || cfg.NameTransform != nil {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					stats.filesFailed.Add(1)
+					status.setError(err)
+					firstFail.fail(r.Name, r.URL, err)
+					log.Error("unrecoverable error",
+						frog.String("name", r.Name), frog.String("url", r.URL), frog.PathAbs(path), frog.Err(err),
+					)
+					return
+				}
+			}
+			checksum, _ := checksumFor(checksums, r.ListedName())
+			var onProgress func(read, total int64)
+			if bars != nil {
+				bars.start(worker, name, r.ExactSize())
+				defer bars.end(worker)
+				onProgress = func(read, total int64) {
+					bars.update(worker, read, total)
+				}
+			}
+			opts := DownloadOptions{
+				ExpectedSize:          r.ExactSize(),
+				ExpectedLastModified:  r.Timestamp,
+				LastModifiedPrecision: r.TimestampPrecision,
+				OnProgress:            onProgress,
+				UserAgent:             scfg.UserAgent,
+				Headers:               scfg.Headers,
+				Username:              scfg.Username,
+				Password:              scfg.Password,
+				Client:                downloadClient,
+				PartSize:              int64(partSize),
+				Checksum:              checksum,
+				ChecksumRetries:       uint(max(cfg.ChecksumRetries, 0)),
+				UseContentDisposition: scfg.ContentDisposition || len(r.Name) == 0,
+				Lock:                  lockFiles,
+				HeadFirst:             headFirst,
+				Sequential:            sequential,
+				HostBreaker:           breaker,
+				RetryLog:              retryLog,
+				MaxRetryDuration:      cfg.MaxRetryDuration,
+				Connections:           connections,
+				RateLimit:             rateLimit,
+				Sync:                  cfg.Sync,
+				OnFailure:             onFailure,
+			}
+			dlStart := time.Now()
+			var res DownloadResults
+			var err error
+			if len(aria2cPath) > 0 && !opts.UseContentDisposition {
+				res, err = downloadWithAria2c(dlCtx, log, aria2cPath, r.URL, path, opts)
+			} else {
+				res, err = DownloadToFile(dlCtx, log, r.URL, path, opts)
+			}
+			elapsed := time.Since(dlStart)
+			if errors.Is(err, errFileLocked) {
+				stats.filesLocked.Add(1)
+				log.Warning("Skipping file being downloaded by another process",
+					frog.String("name", r.Name), frog.PathAbs(path),
+				)
+				return
+			}
+			if err != nil && dlCtx.Err() != nil {
+				stats.filesCanceled.Add(1)
+				log.Warning("download canceled",
+					frog.String("name", r.Name), frog.Int64("size", res.ActualSize),
+					frog.String("url", r.URL), frog.PathAbs(path), frog.Err(err),
 				)
-				path := filepath.Join(cfg.LocalPath, r.Name)
-				res, err := DownloadToFile(log, r.URL, path,
-					DownloadOptions{ExpectedSize: r.Size, ExpectedLastModified: r.Timestamp},
+				return
+			}
+			if errors.Is(err, errHostUnhealthy) && !lastPass {
+				log.Warning("Host is failing, so leaving file for a later pass",
+					frog.String("name", r.Name), frog.String("url", r.URL),
+					frog.String("final_url", res.FinalURL), frog.Err(err),
+				)
+				deferredMu.Lock()
+				deferred = append(deferred, r)
+				deferredMu.Unlock()
+				return
+			}
+			if err != nil {
+				stats.filesFailed.Add(1)
+				status.setError(err)
+				firstFail.fail(r.Name, r.URL, err)
+				log.Error("unrecoverable error",
+					frog.String("name", r.Name), frog.Int64("size", res.ActualSize),
+					frog.Time("time", res.LastModified), frog.String("url", r.URL),
+					frog.String("final_url", res.FinalURL), frog.PathAbs(path), frog.Err(err),
 				)
+				return
+			}
+			path = res.Path
+			if scfg.Decompress && strings.HasSuffix(path, plan.GzipSuffix) {
+				path, err = decompressDownload(log, path, cfg.Sync)
+				if err != nil {
+					stats.filesFailed.Add(1)
+					status.setError(err)
+					firstFail.fail(r.Name, r.URL, err)
+					log.Error("unrecoverable error",
+						frog.String("name", r.Name), frog.String("url", r.URL),
+						frog.PathAbs(res.Path), frog.Err(err),
+					)
+					return
+				}
+			}
+			if plan.MatchesCompressed(cfg.Compressed, r.Name) {
+				if cfg.CompressDownloads {
+					path, err = compressDownload(log, path, cfg.Sync)
+				} else {
+					err = removeStaleGzip(log, path)
+				}
+				if err != nil {
+					stats.filesFailed.Add(1)
+					status.setError(err)
+					firstFail.fail(r.Name, r.URL, err)
+					log.Error("unrecoverable error",
+						frog.String("name", r.Name), frog.String("url", r.URL),
+						frog.PathAbs(res.Path), frog.Err(err),
+					)
+					return
+				}
+			}
+			if cfg.Xattrs {
+				// after compressing, so they are on the file that is kept, and before storing, so that the
+				// first download of some content is the one that's recorded
+				recordProvenance(log, path, provenance{SourceURL: r.URL, Downloaded: dlStart.Add(elapsed), Checksum: checksum})
+			}
+			if len(cfg.Store) > 0 {
+				objPath, existed, err := storeFile(log, cfg.Store, path)
 				if err != nil {
+					stats.filesFailed.Add(1)
+					status.setError(err)
+					firstFail.fail(r.Name, r.URL, err)
 					log.Error("unrecoverable error",
-						frog.String("name", r.Name), frog.Int64("size", res.ActualSize),
-						frog.Time("time", res.LastModified), frog.String("url", r.URL),
+						frog.String("name", r.Name), frog.String("url", r.URL),
 						frog.PathAbs(path), frog.Err(err),
 					)
-					continue
+					return
 				}
-				log.Info("File written", frog.String("name", r.Name),
-					frog.Time("time", r.Timestamp), frog.Int64("size", r.Size),
-					frog.Path(path),
+				log.Verbose("linked to store", frog.String("name", r.Name),
+					frog.Path(objPath), frog.Bool("deduplicated", existed),
 				)
 			}
-			wg.Done()
-		}()
-	}
-
-	if cfg.Verbose {
-		for _, v := range changed {
-			log.Verbose("queuing changed file", frog.String("name", v.Name))
+			stats.filesDownloaded.Add(1)
+			// the bytes resumed from an earlier run weren't transferred by this one
+			transferred := res.ActualSize - res.ResumedSize
+			stats.bytesDownloaded.Add(transferred)
+			stats.bytesResumed.Add(res.ResumedSize)
+			log.Info("File written", frog.String("name", r.Name),
+				frog.Time("time", r.Timestamp), frog.Int64("size", r.Size), frog.Int64("resumed", res.ResumedSize),
+				frog.Dur("elapsed", elapsed), frog.String("speed", formatSpeed(float64(transferred)/elapsed.Seconds())),
+				frog.Path(path),
+			)
 		}
-		for _, v := range missing {
-			log.Verbose("queuing missing file", frog.String("name", v.Name))
+		startWorkers := func(ch <-chan scraper.RemoteFile, lastPass bool) *sync.WaitGroup {
+			var wg sync.WaitGroup
+			wg.Add(cfg.Threads)
+			for i := 0; i < cfg.Threads; i++ {
+				go func(worker int) {
+					for r := range ch {
+						if !window.wait(queueCtx, log) {
+							outsideWindow.Add(1)
+							continue
+						}
+						download(r, lastPass, worker)
+						if cfg.Trickle {
+							sleepCtx(queueCtx, cfg.TrickleDelay)
+						}
+					}
+					wg.Done()
+				}(i)
+			}
+			return &wg
 		}
-	}
 
-	// feed work to the workers
-	for _, v := range changed {
-		ch <- v
-	}
-	for _, v := range missing {
-		ch <- v
-	}
+		// start the workers before diffing (unless auditing), so that downloads begin as soon as the diff
+		// finds them, instead of waiting for the whole diff (and any checksum verification) to finish
+		ch := make(chan scraper.RemoteFile)
+		var wg *sync.WaitGroup
+		if !audit {
+			wg = startWorkers(ch, breaker == nil)
+		}
 
-	// let idle workers know they can stop
-	close(ch)
-	// wait for all workers to complete and shutdown
-	wg.Wait()
+		if force {
+			// ignore the diff's opinion of which local files are still good, and queue everything
+			log.Info("Forcing download of all remote files", frog.Int("count", len(remotes)))
+		} else if verifyChecksums {
+			if checksums == nil {
+				log.Warning("No checksums are configured for this scraper, so there is nothing to verify")
+			} else {
+				log.Info("Verifying checksums of unchanged local files...")
+			}
+		}
+		// a file being repaired is always checked against its checksum (if it has one)
+		verify := !force && (verifyChecksums || len(repairName) > 0) && checksums != nil
+		// the unknown_size "checksum" policy verifies some unchanged files, even without --verify-checksums
+		verifyUnknownSize := !force && unknownSize == unknownSizeChecksum && checksums != nil
+		var index *checksumIndex
+		// matching by fingerprint checks local files against checksums too (when they have them)
+		fingerprints := !force && len(repairName) == 0 && matchBy == matchByFingerprint
+		if (verify || verifyUnknownSize || (fingerprints && checksums != nil)) && len(cfg.ChecksumIndex) > 0 {
+			index, err = loadChecksumIndex(cfg.ChecksumIndex)
+			if err != nil {
+				log.Error("loading checksum index", frog.PathAbs(cfg.ChecksumIndex), frog.Err(err))
+				return 33
+			}
+			defer func() {
+				if err := index.save(); err != nil {
+					log.Warning("saving checksum index", frog.PathAbs(cfg.ChecksumIndex), frog.Err(err))
+				}
+			}()
+		}
 
-	return 0
-}
+		// a local file that isn't listed may be a listed file that was renamed on the remote
+		if fingerprints {
+			renamed := findRenamedLocals(log, locals, remotes, fingerprinter{
+				LocalPath: cfg.LocalPath,
+				Checksums: checksums,
+				Index:     index,
+				Remote: func(r scraper.RemoteFile) (fingerprint, error) {
+					return remoteFingerprint(queueCtx, r.URL, r.ExactSize(), DownloadOptions{
+						UserAgent:   scfg.UserAgent,
+						Headers:     scfg.Headers,
+						Username:    scfg.Username,
+						Password:    scfg.Password,
+						Client:      downloadClient,
+						Connections: connections,
+					})
+				},
+			})
+			locals = renameLocals(log, cfg.LocalPath, locals, renamed, audit, &stats)
+		}
 
-// listFiles concurrently lists both the local and remote files
-func listFiles(log frog.Logger, cfg config.Config, scfg config.Scraper) ([]LocalFile, []scraper.RemoteFile, int) {
-	var locals []LocalFile
-	var errLocal error
-	var remotes []scraper.RemoteFile
-	var errRemote error
+		// diff local vs remote, and feed each difference to the workers as soon as it is found,
+		// until we run out of work or time
+		var numExtra, numMissing, numChanged, numNoChecksum, numOutOfRange, queued, notStarted int
+		var diffIndex int
+		var script []scriptEntry
+		var extras []plan.LocalFile
+		status.setPhase(phaseDiffing)
+		// checksums of files that the diff will want verified are hashed on a pool, ahead of the diff
+		var pool *checksumPool
+		if (verify || verifyUnknownSize) && cfg.VerifyThreads > 1 {
+			pool = newChecksumPool(log, cfg.VerifyThreads)
+		}
+		prefetch := func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile) bool {
+			switch {
+			case force:
+				return false
+			case kind == plan.DiffUnchanged:
+				return verify
+			case kind == plan.DiffUnknownSize:
+				// a HEAD request may yet find that the file changed, so don't hash it until then
+				return unknownSize != unknownSizeHead && (verify || verifyUnknownSize)
+			case kind == plan.DiffTimeOnly:
+				return cfg.SizeOnly && verify
+			}
+			return false
+		}
+		verifyLocal := func(local plan.LocalFile, remote scraper.RemoteFile) (mismatch, known bool) {
+			return verifyLocalChecksum(log, cfg.LocalPath, checksums, index, &stats, local, remote)
+		}
+		diffSortedFilesVerified(locals, remotes, pool, prefetch, verifyLocal, func(
+			kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile, sum *pendingChecksum,
+		) {
+			checkLocal := func() (mismatch, known bool) {
+				if sum != nil {
+					return sum.wait()
+				}
+				return verifyLocal(local, remote)
+			}
+			if force && kind != plan.DiffExtra {
+				kind = plan.DiffMissing
+			}
+			if kind == plan.DiffTimeOnly && !cfg.SizeOnly {
+				kind = plan.DiffChanged
+			}
+			verifyThis := verify
+			if kind == plan.DiffUnknownSize {
+				kind = plan.DiffUnchanged
+				switch unknownSize {
+				case unknownSizeHead:
+					size, err := headRemoteSize(dlCtx, log, remote.URL, DownloadOptions{
+						UserAgent: scfg.UserAgent,
+						Headers:   scfg.Headers,
+						Username:  scfg.Username,
+						Password:  scfg.Password,
+						Client:    downloadClient,
+					})
+					if err != nil {
+						log.Warning("failed to look up size", frog.String("name", remote.Name), frog.Err(err))
+					} else if size >= 0 {
+						remote.Size, remote.SizePrecision = size, 0
+						if !local.SizeMatches(size) {
+							log.Info("Remote file of unknown size has a different size",
+								frog.String("name", remote.Name), frog.Int64("local_size", local.Size), frog.Int64("remote_size", size),
+							)
+							kind = plan.DiffChanged
+						}
+					}
+				case unknownSizeChecksum:
+					verifyThis = verifyThis || verifyUnknownSize
+				}
+			}
+			switch kind {
+			case plan.DiffExtra:
+				numExtra++
+				extras = append(extras, local)
+				log.Info("Local file not in remote", frog.String("name", local.Name))
+				return
+			case plan.DiffUnchanged:
+				if !verifyThis {
+					return
+				}
+				mismatch, known := checkLocal()
+				if !known {
+					numNoChecksum++
+				}
+				if !mismatch {
+					return
+				}
+				kind = plan.DiffChanged
+			case plan.DiffTimeOnly:
+				// the size is trusted, so just fix the time (unless the checksum says otherwise)
+				if verify {
+					mismatch, known := checkLocal()
+					if !known {
+						numNoChecksum++
+					}
+					if mismatch {
+						kind = plan.DiffChanged
+						break
+					}
+				}
+				fixLocalTime(log, cfg.LocalPath, local, remote, audit, &stats)
+				return
+			case plan.DiffChanged:
+				if isLocalNewer(local, remote) {
+					fields := []frog.Fielder{
+						frog.String("name", remote.Name), frog.Time("local_time", local.Timestamp),
+						frog.Time("remote_time", remote.Timestamp),
+					}
+					switch localNewer {
+					case localNewerSkip:
+						stats.filesLocalNewer.Add(1)
+						log.Warning("Keeping local file that is newer than remote", fields...)
+						return
+					case localNewerWarn:
+						log.Warning("Replacing local file that is newer than remote", fields...)
+					}
+				}
+			}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
+			if kind == plan.DiffChanged {
+				numChanged++
+			} else {
+				numMissing++
+			}
+			if repairEmpties && (kind != plan.DiffChanged || local.Size != 0) {
+				return
+			}
+			diffIndex++
+			if !queueRange.contains(diffIndex - 1) {
+				numOutOfRange++
+				return
+			}
 
-	go func() {
-		defer wg.Done()
-		log.Info("Listing local files...", frog.Path(cfg.LocalPath))
-		locals, errLocal = getSortedLocals(cfg.LocalPath)
-	}()
+			if kind == plan.DiffChanged {
+				localNames.keep(local, remote.Name)
+			}
 
-	go func() {
-		defer wg.Done()
-		log.Info("Listing remote files...", frog.String("url", scfg.URL))
-		remotes, errRemote = getSortedRemotes(scfg)
-	}()
+			if audit {
+				if len(emitScriptPath) > 0 {
+					name := localNames.name(remote.Name)
+					if len(name) == 0 {
+						name = nameFromURL(remote.URL)
+					}
+					script = append(script, scriptEntry{
+						Name: name, URL: remote.URL, Timestamp: remote.Timestamp, Changed: kind == plan.DiffChanged,
+					})
+				}
+				if kind == plan.DiffChanged {
+					log.Info("Local file differs from remote",
+						frog.String("name", remote.Name), frog.Int64("size", remote.Size), frog.Time("time", remote.Timestamp),
+					)
+				} else {
+					log.Info("Remote file not in local",
+						frog.String("name", remote.Name), frog.Int64("size", remote.Size), frog.Time("time", remote.Timestamp),
+					)
+				}
+				return
+			}
 
-	wg.Wait()
+			status.addPending()
+			if cfg.Trickle && queued >= cfg.TrickleBatch {
+				leftForNextBatch++
+				return
+			}
 
-	if errLocal != nil {
-		log.Error("list local files", frog.Err(errLocal), frog.PathAbs(cfg.LocalPath))
-		return nil, nil, 20
-	}
+			if kind == plan.DiffChanged {
+				log.Verbose("queuing changed file", frog.String("name", remote.Name))
+			} else {
+				log.Verbose("queuing missing file", frog.String("name", remote.Name))
+			}
+			if queueCtx.Err() == nil {
+				select {
+				case ch <- remote:
+					queued++
+					bars.queue()
+					return
+				case <-queueCtx.Done():
+				}
+			}
+			notStarted++
+		})
+		if numNoChecksum > 0 {
+			log.Info("Some unchanged files have no checksum to verify", frog.Int("files", numNoChecksum))
+		}
+		if !queueRange.all() {
+			log.Info("Skipped files outside of --range", frog.String("range", rangeStr), frog.Int("files", numOutOfRange))
+		}
+		stats.filesChecked.Store(int64(len(remotes)))
+		stats.filesUnchanged.Store(int64(len(remotes) - numMissing - numChanged))
+		stats.filesMissing.Store(int64(numMissing))
+		stats.filesChanged.Store(int64(numChanged))
+		stats.filesExtra.Store(int64(numExtra))
+		showSummary = true
 
-	if errRemote != nil {
-		log.Error("list remote files", frog.Err(errRemote), frog.String("url", scfg.URL))
-		return nil, nil, 30
-	}
+		if len(extrasPath) > 0 {
+			dir, err := filepath.Abs(cfg.LocalPath)
+			if err == nil {
+				err = writeExtrasFile(extrasPath, dir, extras)
+			}
+			if err != nil {
+				log.Error("writing extras file", frog.PathAbs(extrasPath), frog.Err(err))
+				return 51
+			}
+			log.Info("Wrote local-only files", frog.PathAbs(extrasPath), frog.Int("files", len(extras)))
+		}
 
-	return locals, remotes, 0
-}
+		if audit {
+			if len(emitScriptPath) > 0 {
+				dir, err := filepath.Abs(cfg.LocalPath)
+				if err == nil {
+					err = writeScriptFile(emitScriptPath, dir, len(scfg.Username) > 0 || len(scfg.Password) > 0, script)
+				}
+				if err != nil {
+					log.Error("writing script", frog.PathAbs(emitScriptPath), frog.Err(err))
+					return 50
+				}
+				log.Info("Wrote download script", frog.PathAbs(emitScriptPath), frog.Int("files", len(script)))
+			}
+			completed = true
+			return 0
+		}
 
-func getSortedLocals(path string) ([]LocalFile, error) {
-	locals := make([]LocalFile, 0, 256)
+		// let idle workers know they can stop
+		status.setPhase(phaseDownloading)
+		close(ch)
+		// wait for all workers to complete and shutdown
+		wg.Wait()
 
-	entries, err := os.ReadDir(path)
-	if err != nil {
-		return nil, err
-	}
+		// give the files from unhealthy hosts one more chance, once their hosts have had time to recover
+		if wait := time.Until(breaker.reopensAt()); len(deferred) > 0 && wait > 0 {
+			log.Info("Waiting for failing hosts to recover", frog.Dur("wait", wait), frog.Int("deferred", len(deferred)))
+			select {
+			case <-time.After(wait):
+			case <-queueCtx.Done():
+				notStarted += len(deferred)
+				deferred = nil
+			}
+		}
+		if len(deferred) > 0 {
+			log.Info("Retrying files that were left for a later pass", frog.Int("count", len(deferred)))
+			ch = make(chan scraper.RemoteFile)
+			wg = startWorkers(ch, true)
+		retry:
+			for i, r := range deferred {
+				select {
+				case ch <- r:
+					bars.queue()
+				case <-queueCtx.Done():
+					notStarted += len(deferred) - i
+					break retry
+				}
+			}
+			close(ch)
+			wg.Wait()
+		}
 
-	for _, e := range entries {
-		i, err := e.Info()
-		if err != nil {
-			return nil, err
+		notStarted += int(outsideWindow.Load())
+		stats.filesNotStarted.Store(int64(notStarted))
+		if name, url, err, ok := firstFail.failed(); ok {
+			log.Error("Stopped at the first failed download",
+				frog.String("name", name), frog.String("url", url),
+				frog.Int("not_started", notStarted), frog.Int64("canceled", stats.filesCanceled.Load()),
+				frog.Err(err),
+			)
+			return 62
 		}
-		locals = append(locals, LocalFile{
-			Name:      e.Name(),
-			SortName:  strings.ToLower(e.Name()),
-			Timestamp: i.ModTime().UTC(),
-			Size:      i.Size(),
-		})
+		if notStarted > 0 || stats.filesCanceled.Load() > 0 {
+			log.Warning("Max runtime reached",
+				frog.Dur("max_runtime", maxRuntime),
+				frog.Int("not_started", notStarted),
+				frog.Int64("canceled", stats.filesCanceled.Load()),
+			)
+			return 40
+		}
+
+		completed = true
+		return 0
 	}
 
-	sort.Slice(locals, func(i, j int) bool {
-		return locals[i].SortName < locals[j].SortName
-	})
+	if !cfg.Trickle {
+		return runPass()
+	}
+	return runTrickle(queueCtx, log, cfg, runPass, func() int { return leftForNextBatch })
+}
 
-	return locals, nil
+// logPlanError logs an error from planning, and returns the exit code for it.
+func logPlanError(log frog.Logger, err error) int {
+	var pe *plan.Error
+	if !errors.As(err, &pe) {
+		log.Error("planning", frog.Err(err))
+		return 1
+	}
+	log.Error(pe.Msg, append([]frog.Fielder{frog.Err(pe.Err)}, pe.Fields...)...)
+	return pe.Code
 }
 
-func getSortedRemotes(scfg config.Scraper) ([]scraper.RemoteFile, error) {
-	s, err := scraper.Create(scfg.Type, scraper.BaseURL(scfg.URL))
+// listFiles concurrently lists both the local and remote files, and logs any error, returning the
+// exit code for it. If missingLocalOK is set, then a local path that doesn't exist is treated as empty.
+func listFiles(
+	log frog.Logger, cfg config.Config, scfg config.Scraper, stats *runStats, missingLocalOK bool,
+) ([]plan.LocalFile, []scraper.RemoteFile, int) {
+	locals, remotes, failed, truncated, err := plan.ListFiles(log, cfg, scfg, missingLocalOK)
+	stats.scrapeFailures.Add(int64(failed))
+	stats.listingsTruncated.Add(int64(truncated))
 	if err != nil {
-		return nil, fmt.Errorf("error creating scraper of type '%s': %w", scfg.Type, err)
+		return nil, nil, logPlanError(log, err)
 	}
+	return locals, remotes, 0
+}
 
-	remotes, err := s.ScrapeRemotes()
+// loadChecksumsFor loads the scraper's checksum sources, using the same options as the listing
+func loadChecksumsFor(log frog.Logger, cfg config.Config, scfg config.Scraper) (scraper.ChecksumProvider, error) {
+	opts, err := plan.ScraperOptions(cfg, scfg)
 	if err != nil {
-		return nil, fmt.Errorf("error while scraping: %w", err)
+		return nil, err
 	}
-
-	sort.Slice(remotes, func(i, j int) bool {
-		return remotes[i].SortName < remotes[j].SortName
-	})
-
-	return remotes, nil
+	p, err := loadChecksums(log, scfg, opts...)
+	if err != nil || p == nil || (len(scfg.StripPrefix) == 0 && len(scfg.StripSuffix) == 0) {
+		return p, err
+	}
+	return strippedChecksums{p: p, prefix: scfg.StripPrefix, suffix: scfg.StripSuffix}, nil
 }
 
-// diffSortedFiles compares two sorted lists of files and returns the differences.
-// Because the input is already sorted, this diff has a linear running time.
-// If the remote file has no timestamp or size, then those fields are ignored.
-func diffSortedFiles(
-	locals []LocalFile,
-	remotes []scraper.RemoteFile,
-) (
-	extra []LocalFile,
-	missing []scraper.RemoteFile,
-	changed []scraper.RemoteFile,
-) {
-	extra = make([]LocalFile, 0, len(locals))
-	missing = make([]scraper.RemoteFile, 0, len(remotes))
-	changed = make([]scraper.RemoteFile, 0, len(remotes))
-	i, j := 0, 0
-	for i < len(locals) && j < len(remotes) {
-		local := locals[i]
-		remote := remotes[j]
-
-		if local.SortName < remote.SortName {
-			extra = append(extra, local)
-			i++
-			continue
+// nameFromURL returns the last element of the URL's path, or "download" if there isn't a usable one
+func nameFromURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		switch name := path.Base(u.Path); name {
+		case ".", "/", "..":
+		default:
+			return name
 		}
-
-		if local.SortName > remote.SortName {
-			missing = append(missing, remote)
-			j++
-			continue
-		}
-
-		if !remote.Timestamp.IsZero() && !local.Timestamp.Equal(remote.Timestamp) {
-			changed = append(changed, remote)
-		} else if remote.Size > 0 && local.Size != remote.Size {
-			changed = append(changed, remote)
-		}
-
-		i++
-		j++
 	}
+	return "download"
+}
 
-	for i < len(locals) {
-		extra = append(extra, locals[i])
-		i++
+// printBuildInfo writes what a bug report needs to know about this build, one "name: value" per line
+func printBuildInfo(w io.Writer) {
+	version := buildvar.Version
+	if len(version) == 0 {
+		version = "unknown"
 	}
+	buildTime := buildvar.BuildTime
+	if len(buildTime) == 0 {
+		buildTime = "unknown"
+	}
+	fmt.Fprintf(w, "version: %s\n", strings.TrimPrefix(version, "v"))
+	fmt.Fprintf(w, "built: %s\n", buildTime)
+	if len(buildvar.ReleaseURL) > 0 {
+		fmt.Fprintf(w, "release: %s\n", buildvar.ReleaseURL)
+	}
+	fmt.Fprintf(w, "go: %s\n", runtime.Version())
+	fmt.Fprintf(w, "platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
 
-	for j < len(remotes) {
-		missing = append(missing, remotes[j])
-		j++
+// printScraperTypes writes each scraper type, and its description, one per line
+func printScraperTypes(w io.Writer) {
+	types := sortedScraperTypes()
+	width := 0
+	for _, typ := range types {
+		width = max(width, len(typ))
 	}
+	for _, typ := range types {
+		info, _ := scraper.Describe(typ)
+		fmt.Fprintf(w, "%-*s  %s\n", width, typ, info.Description)
+	}
+}
 
-	return extra, missing, changed
+// sortedScraperTypes returns the registered scraper types, in alphabetical order
+func sortedScraperTypes() []string {
+	types := scraper.ListTypes()
+	sort.Strings(types)
+	return types
 }
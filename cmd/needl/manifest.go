@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// auditManifest verifies the local copy (in localPath) of each file listed in the sums file or JSON
+// manifest at src, without listing or downloading any remote files. Each name in the manifest is the
+// file's slash separated path within localPath. Files matching the compressed patterns may be stored
+// as "<name>.gz". It returns the exit status: 33 if the manifest can't be loaded, 35 if any file is
+// missing or doesn't match its checksum, and otherwise 0. Digests are looked up in (and added to) the index, if there is one.
+func auditManifest(
+	log frog.Logger, src, localPath string, compressed []string, index *checksumIndex, stats *runStats,
+) int {
+	log.Info("Loading checksums...", frog.String("source", src))
+	sums, err := loadChecksumFile(src)
+	if err != nil {
+		log.Error("loading checksums", frog.String("source", src), frog.Err(err))
+		return 33
+	}
+
+	keys := make([]string, 0, len(sums))
+	for key := range sums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := path.Clean(key)
+		stats.filesChecked.Add(1)
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			stats.filesFailed.Add(1)
+			log.Warning("Skipping manifest entry outside the download path", frog.String("name", name))
+			continue
+		}
+		local, err := statLocal(localPath, name)
+		if err == nil && local == nil && plan.MatchesCompressed(compressed, name) {
+			local, err = statLocal(localPath, name+plan.GzipSuffix)
+			if err == nil && local != nil {
+				locals := plan.UncompressLocals(localPath, []plan.LocalFile{*local}, compressed)
+				local = &locals[0]
+			}
+		}
+		if err != nil {
+			log.Error("stat local file", frog.String("name", name), frog.PathAbs(localPath), frog.Err(err))
+			return 20
+		}
+		if local == nil {
+			stats.filesMissing.Add(1)
+			log.Warning("Local file is missing", frog.String("name", name))
+			continue
+		}
+		verifyLocalChecksum(log, localPath, sums, index, stats, *local, scraper.RemoteFile{Name: key})
+	}
+
+	if stats.filesMissing.Load() > 0 || stats.filesMismatched.Load() > 0 || stats.filesFailed.Load() > 0 {
+		return 35
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_AuditManifest(t *testing.T) {
+	dir := t.TempDir()
+	good := testContent(t, 100)
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range map[string][]byte{"good": good, "sub/good": good, "bad": testContent(t, 200)} {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeGzipFile(t, filepath.Join(dir, "zipped.txt.gz"), good)
+
+	cases := []struct {
+		Name     string
+		Files    []string // each with the checksum of good
+		Expected int
+		Verified int64
+	}{
+		{"all good", []string{"good", "./sub/good", "zipped.txt"}, 0, 3},
+		{"mismatch", []string{"good", "bad"}, 35, 1},
+		{"missing", []string{"good", "missing"}, 35, 1},
+		{"outside", []string{"good", "../good"}, 35, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var sb strings.Builder
+			for _, name := range tc.Files {
+				fmt.Fprintf(&sb, "%s  %s\n", sha256Checksum(good).Hex, name)
+			}
+			src := filepath.Join(t.TempDir(), "SHA256SUMS")
+			if err := os.WriteFile(src, []byte(sb.String()), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var stats runStats
+			status := auditManifest(&frog.NullLogger{}, src, dir, []string{"*.txt"}, nil, &stats)
+			if status != tc.Expected {
+				t.Errorf("expected status %d, but got %d", tc.Expected, status)
+			}
+			if n := stats.filesVerified.Load(); n != tc.Verified {
+				t.Errorf("expected %d verified, but got %d", tc.Verified, n)
+			}
+		})
+	}
+}
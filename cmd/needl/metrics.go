@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	natomic "github.com/natefinch/atomic"
+)
+
+// runStats holds counts that are updated by the diff and download workers as they run
+type runStats struct {
+	filesChecked    atomic.Int64
+	filesUnchanged  atomic.Int64
+	filesMissing    atomic.Int64
+	filesChanged    atomic.Int64
+	filesExtra      atomic.Int64
+	filesDownloaded atomic.Int64
+	bytesDownloaded atomic.Int64 // transferred by this run, not counting bytesResumed
+	bytesResumed    atomic.Int64 // already in temp files from an earlier run, when their downloads resumed
+	filesFailed     atomic.Int64
+	filesCanceled   atomic.Int64
+	filesNotStarted atomic.Int64
+	scrapeFailures  atomic.Int64
+	filesVerified   atomic.Int64
+	filesMismatched atomic.Int64
+	filesLocked     atomic.Int64
+	filesLocalNewer atomic.Int64
+	filesRetimed    atomic.Int64
+	filesRemoved    atomic.Int64
+	filesRenamed    atomic.Int64
+
+	// listingsTruncated counts the listings that stopped at their max_files (or max_pages), and were used anyway
+	listingsTruncated atomic.Int64
+}
+
+// writeMetrics writes the given stats to path in the Prometheus text exposition format
+// (as consumed by node_exporter's textfile collector).
+// The file is written to a temp file and then renamed, so a reader never sees a partial file.
+// If the run did not succeed, the last success timestamp is carried over from any existing
+// file at path, so that it continues to reflect the last run that actually succeeded.
+func writeMetrics(path, scraperName string, stats *runStats, dur time.Duration, now time.Time, success bool) error {
+	lastSuccess := float64(now.Unix())
+	if !success {
+		lastSuccess = readLastSuccessMetric(path)
+	}
+
+	labels := fmt.Sprintf(`{scraper="%s"}`, escapeMetricLabel(scraperName))
+
+	var b bytes.Buffer
+	writeMetric := func(name, typ, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, typ)
+		fmt.Fprintf(&b, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	writeMetric("needl_files_downloaded_total", "counter", "Number of files downloaded by the last run.",
+		float64(stats.filesDownloaded.Load()))
+	writeMetric("needl_bytes_downloaded_total", "counter", "Number of bytes downloaded by the last run.",
+		float64(stats.bytesDownloaded.Load()))
+	writeMetric("needl_bytes_resumed_total", "counter", "Number of bytes of the last run's downloads that were resumed from an earlier run's temp files.",
+		float64(stats.bytesResumed.Load()))
+	writeMetric("needl_files_failed_total", "counter", "Number of files that failed to download in the last run.",
+		float64(stats.filesFailed.Load()))
+	writeMetric("needl_scrape_failures_total", "counter", "Number of remote listings that failed and were skipped in the last run.",
+		float64(stats.scrapeFailures.Load()))
+	writeMetric("needl_run_duration_seconds", "gauge", "Duration of the last run in seconds.",
+		dur.Seconds())
+	if lastSuccess > 0 {
+		writeMetric("needl_last_success_timestamp", "gauge", "Unix time of the last run that completed without errors.",
+			lastSuccess)
+	}
+
+	if err := natomic.WriteFile(path, &b); err != nil {
+		return fmt.Errorf("write '%s': %w", path, err)
+	}
+	return nil
+}
+
+// readLastSuccessMetric returns the needl_last_success_timestamp value from a previously
+// written metrics file, or zero if the file or metric does not exist.
+func readLastSuccessMetric(path string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "needl_last_success_timestamp") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+	return 0
+}
+
+func escapeMetricLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_WriteMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "needl.prom")
+
+	var stats runStats
+	stats.filesDownloaded.Add(3)
+	stats.bytesDownloaded.Add(12345)
+	stats.bytesResumed.Add(678)
+	first := time.Unix(1700000000, 0)
+	if err := writeMetrics(path, "tv", &stats, 1500*time.Millisecond, first, true); err != nil {
+		t.Fatalf("unexpected error writing metrics: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading metrics: %v", err)
+	}
+	for _, expected := range []string{
+		`needl_files_downloaded_total{scraper="tv"} 3`,
+		`needl_bytes_downloaded_total{scraper="tv"} 12345`,
+		`needl_bytes_resumed_total{scraper="tv"} 678`,
+		`needl_files_failed_total{scraper="tv"} 0`,
+		`needl_run_duration_seconds{scraper="tv"} 1.5`,
+		`needl_last_success_timestamp{scraper="tv"} 1700000000`,
+	} {
+		if !strings.Contains(string(b), expected+"\n") {
+			t.Errorf("expected metrics to contain '%s', but got:\n%s", expected, string(b))
+		}
+	}
+
+	// a failed run should keep the previous success timestamp
+	stats.filesFailed.Add(1)
+	if err := writeMetrics(path, "tv", &stats, time.Second, first.Add(time.Hour), false); err != nil {
+		t.Fatalf("unexpected error writing metrics: %v", err)
+	}
+	if v := readLastSuccessMetric(path); v != 1700000000 {
+		t.Errorf("expected last success to be kept at 1700000000, but is %v", v)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/danbrakeley/frog"
+	"github.com/natefinch/atomic"
+)
+
+// replaceFile is atomic.ReplaceFile, except when tests need to simulate a failure
+var replaceFile = atomic.ReplaceFile
+
+// moveFile moves src to dst, replacing dst if it exists.
+// If src and dst are on different filesystems (so they can't just be renamed), then src is
+// copied to a temp file beside dst, which is then renamed over dst, and finally src is removed.
+// dst is still replaced all at once, but if something goes wrong part way, src may be left behind.
+func moveFile(log frog.Logger, src, dst string) error {
+	err := replaceFile(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	log.Warning("cross-device move, falling back to copy",
+		frog.String("src", filepath.ToSlash(src)),
+		frog.String("dst", filepath.ToSlash(dst)),
+	)
+	if err := copyFileBeside(src, dst); err != nil {
+		return fmt.Errorf("cross-device copy: %w", err)
+	}
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("remove after cross-device copy: %w", err)
+	}
+	return nil
+}
+
+// copyFileBeside copies src into a temp file in dst's directory, keeping its mode and
+// modification time, then renames the temp file to dst.
+func copyFileBeside(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := out.Name()
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_MoveFile_CrossDevice(t *testing.T) {
+	// simulate src and dst being on different filesystems
+	orig := replaceFile
+	replaceFile = func(src, dst string) error {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+	}
+	defer func() { replaceFile = orig }()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	content := testContent(t, 1000)
+	if err := os.WriteFile(src, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile(&frog.NullLogger{}, src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("expected src to be removed, but got: %v", err)
+	}
+	actual, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("unexpected error reading dst: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("dst content does not match")
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("expected mod time %v, but got %v", modTime, info.ModTime())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only dst to be left, but got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+// defaultPartConcurrency is the number of parts of a single file that are downloaded at once
+const defaultPartConcurrency = 4
+
+// errRangesNotSupported is returned by downloadParts when the server won't serve byte ranges
+var errRangesNotSupported = errors.New("server does not support byte ranges")
+
+// downloadParts downloads the file as a set of byte ranges, each opts.PartSize bytes (except
+// for the last), with up to opts.PartConcurrency parts in flight at once.
+// Each part is retried on its own, so a failed request only costs that one part, instead of the whole file.
+// As each part is finished, its checksum is recorded in the sidecar, and if f already holds partial bytes
+// from an earlier run, then any part within them that still matches its recorded checksum is kept,
+// rather than downloaded again.
+// If the server does not support range requests, errRangesNotSupported is returned before
+// anything is written, so that the caller may fall back to a single stream.
+func (dc *downloadContext) downloadParts(ctx context.Context, log frog.Logger, f partsFile, partial int64) error {
+	size := dc.opts.ExpectedSize
+	partSize := dc.opts.PartSize
+	numParts := int((size + partSize - 1) / partSize)
+
+	if err := dc.probeRanges(ctx, log); err != nil {
+		return err
+	}
+	dc.canResume = true
+
+	concurrency := dc.opts.PartConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultPartConcurrency
+	}
+
+	log.Verbose("start multi-part download",
+		frog.Int64("total", size),
+		frog.Int64("part_size", partSize),
+		frog.Int("parts", numParts),
+		frog.Int("concurrency", concurrency),
+		frog.String("url", dc.remoteURL),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var bytesRead atomic.Int64
+	done := dc.verifyPartial(log, f, partial, numParts)
+	for idx, ok := range done {
+		if ok {
+			bytesRead.Add(min(int64(idx+1)*partSize, size) - int64(idx)*partSize)
+		}
+	}
+	// the parts from an earlier run count as already downloaded
+	reused := bytesRead.Load()
+	dc.resumed = reused
+	progress := newProgressWriter(log, dc.remoteURL, size-reused, dc.opts.ProgressInterval, dc.progressFunc(reused))
+	pw := &syncWriter{w: progress}
+	var retries atomic.Uint64
+	var firstErr error
+	var errOnce sync.Once
+
+	parts := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range parts {
+				start := int64(idx) * partSize
+				end := min(start+partSize, size) - 1
+				n, r, err := dc.downloadPart(ctx, log, f, pw, idx, start, end)
+				bytesRead.Add(n)
+				retries.Add(uint64(r))
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("part %d (bytes %d-%d): %w", idx, start, end, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for idx := 0; idx < numParts; idx++ {
+		if done[idx] {
+			continue
+		}
+		select {
+		case parts <- idx:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	dc.bytesRead = bytesRead.Load()
+	dc.curRetry += uint(retries.Load())
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dc.bytesRead != size {
+		return fmt.Errorf("expected final size to be %d, but is %d", size, dc.bytesRead)
+	}
+	progress.finish()
+	return nil
+}
+
+// partsFile is a temp file that parts are written into, and read back from when they're verified
+type partsFile interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// verifyPartial returns which parts are already in the first partial bytes of f, and match the
+// checksums that the sidecar recorded for them. The rest of the parts are cleared from the sidecar,
+// since they're downloaded again.
+func (dc *downloadContext) verifyPartial(log frog.Logger, f io.ReaderAt, partial int64, numParts int) []bool {
+	done := make([]bool, numParts)
+	prev := dc.info.Parts
+	dc.info.Parts = make([]string, numParts)
+	if partial <= 0 || len(prev) != numParts {
+		dc.writeSidecar()
+		return done
+	}
+	var good, bad int
+	for idx := 0; idx < numParts; idx++ {
+		start := int64(idx) * dc.opts.PartSize
+		end := min(start+dc.opts.PartSize, dc.opts.ExpectedSize)
+		if end > partial || len(prev[idx]) == 0 {
+			continue
+		}
+		expected := Checksum{Algo: "sha256", Hex: prev[idx]}
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, start, end-start)); err == nil && expected.Verify(h) == nil {
+			done[idx] = true
+			dc.info.Parts[idx] = prev[idx]
+			good++
+		} else {
+			bad++
+		}
+	}
+	dc.writeSidecar()
+	log.Verbose("verified partial download",
+		frog.Int("good_parts", good),
+		frog.Int("bad_parts", bad),
+		frog.Int("parts", numParts),
+		frog.String("url", dc.remoteURL),
+	)
+	return done
+}
+
+// recordPart records the checksum of a part that has been downloaded in full, so a later run can reuse it
+func (dc *downloadContext) recordPart(idx int, sum string) {
+	dc.partsMu.Lock()
+	defer dc.partsMu.Unlock()
+	dc.info.Parts[idx] = sum
+	dc.writeSidecar()
+}
+
+// writeSidecar (re-)writes the sidecar, if there is one
+func (dc *downloadContext) writeSidecar() {
+	if len(dc.sidecarPath) > 0 {
+		_ = writeResumeInfo(dc.sidecarPath, dc.info)
+	}
+}
+
+// probeRanges requests the first byte of the file to confirm that the server supports ranges,
+// and that it agrees with us about the size of the file.
+func (dc *downloadContext) probeRanges(ctx context.Context, log frog.Logger) error {
+	req, err := dc.newRequest(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := dc.do(req)
+	if err != nil {
+		return fmt.Errorf("probe ranges: %w", err)
+	}
+	defer resp.Body.Close()
+	dc.setFinalURL(log, resp)
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return errRangesNotSupported
+	}
+	_, _, total, err := parseContentRange(resp.Header)
+	if err != nil {
+		return errRangesNotSupported
+	}
+	dc.dispositionName = parseContentDisposition(resp.Header)
+	if total >= 0 && total != dc.opts.ExpectedSize {
+		return fmt.Errorf("expected size to be %d, but server reports %d", dc.opts.ExpectedSize, total)
+	}
+	return nil
+}
+
+// downloadPart downloads bytes start through end (inclusive) of the file into f, retrying as needed.
+// Returns the number of bytes successfully written, and the number of retries.
+func (dc *downloadContext) downloadPart(
+	ctx context.Context, log frog.Logger, f io.WriterAt, progress io.Writer, idx int, start, end int64,
+) (int64, uint, error) {
+	var retry uint
+	var firstFailure time.Time
+	for {
+		sum, err := dc.downloadPartOnce(ctx, f, progress, start, end)
+		if err == nil {
+			dc.recordPart(idx, sum)
+			return end - start + 1, retry, nil
+		}
+		if ctx.Err() != nil {
+			return 0, retry, err
+		}
+
+		dc.opts.HostBreaker.failed(dc.remoteURL)
+		if !dc.opts.HostBreaker.healthy(dc.remoteURL) {
+			return 0, retry, fmt.Errorf("%w: %w", errHostUnhealthy, err)
+		}
+
+		retry++
+		if dc.opts.MaxRetry > 0 && retry >= dc.opts.MaxRetry {
+			return 0, retry, err
+		}
+
+		d := backoff(retry)
+		if !retryTimeLeft(&firstFailure, d, dc.opts.MaxRetryDuration) {
+			return 0, retry, fmt.Errorf("max retry duration (%v) exceeded: %w", dc.opts.MaxRetryDuration, err)
+		}
+		if dc.opts.RetryLog.allow(log, dc.finalURL) {
+			log.Verbose("part error, but will retry",
+				frog.Int("part", idx),
+				frog.Int64("start", start),
+				frog.Int64("end", end),
+				frog.Dur("backoff", d),
+				frog.Uint("cur_retry", retry),
+				frog.Uint("max_retry", dc.opts.MaxRetry),
+				frog.String("url", dc.remoteURL),
+				frog.Err(err),
+			)
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return 0, retry, err
+		}
+	}
+}
+
+// downloadPartOnce downloads bytes start through end (inclusive) of the file into f, and returns their sha256
+// (as lowercase hex).
+func (dc *downloadContext) downloadPartOnce(
+	ctx context.Context, f io.WriterAt, progress io.Writer, start, end int64,
+) (string, error) {
+	req, err := dc.newRequest(ctx)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := dc.do(req)
+	if err != nil {
+		return "", fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return "", fmt.Errorf("expected status %d, but got %d", http.StatusPartialContent, resp.StatusCode)
+	}
+	crStart, crEnd, _, err := parseContentRange(resp.Header)
+	if err != nil {
+		return "", err
+	}
+	if crStart != start || crEnd != end {
+		return "", fmt.Errorf("requested bytes %d-%d, but got %d-%d", start, end, crStart, crEnd)
+	}
+
+	mt := parseLastModified(resp.Header)
+	if !mt.IsZero() && !dc.opts.ExpectedLastModified.IsZero() && !dc.matchesLastModified(mt) {
+		return "", fmt.Errorf("expected Last-Modified to be %v, but is %v", dc.opts.ExpectedLastModified, mt)
+	}
+
+	h := sha256.New()
+	w := io.MultiWriter(io.NewOffsetWriter(f, start), h)
+
+	length := end - start + 1
+	n, err := io.Copy(io.MultiWriter(w, progress), io.LimitReader(resp.Body, length))
+	if err != nil {
+		return "", fmt.Errorf("download: %w", err)
+	}
+	if n != length {
+		return "", fmt.Errorf("expected %d bytes, but got %d", length, n)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parseContentRange parses a header like "Content-Range: bytes 0-99/1234".
+// The returned total is -1 if the server sent "*" for the complete length.
+func parseContentRange(h http.Header) (start, end, total int64, err error) {
+	raw := h.Get("Content-Range")
+	spec, ok := strings.CutPrefix(raw, "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unrecognized Content-Range '%s'", raw)
+	}
+	rng, totalStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unrecognized Content-Range '%s'", raw)
+	}
+	startStr, endStr, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unrecognized Content-Range '%s'", raw)
+	}
+	if start, err = strconv.ParseInt(startStr, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("unrecognized Content-Range '%s': %w", raw, err)
+	}
+	if end, err = strconv.ParseInt(endStr, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("unrecognized Content-Range '%s': %w", raw, err)
+	}
+	total = -1
+	if totalStr != "*" {
+		if total, err = strconv.ParseInt(totalStr, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("unrecognized Content-Range '%s': %w", raw, err)
+		}
+	}
+	return start, end, total, nil
+}
+
+// syncWriter serializes writes to an io.Writer that is shared by multiple goroutines
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(p)
+}
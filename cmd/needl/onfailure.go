@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/danbrakeley/frog"
+)
+
+// failurePolicy decides what happens to the temp file of a download that fails (or is canceled),
+// and so won't be moved into place (see DownloadOptions.OnFailure).
+type failurePolicy string
+
+const (
+	failureKeep    failurePolicy = "keep"    // leave the temp file, for a later run to resume (the default)
+	failureRemove  failurePolicy = "remove"  // remove the temp file
+	failurePartial failurePolicy = "partial" // rename the temp file to the local path plus partialSuffix
+)
+
+// partialSuffix is added to the local path of a failed download that is kept by failurePartial
+const partialSuffix = ".partial"
+
+func parseFailurePolicy(s string) (failurePolicy, error) {
+	switch failurePolicy(s) {
+	case "":
+		return failureKeep, nil
+	case failureKeep, failureRemove, failurePartial:
+		return failurePolicy(s), nil
+	}
+	return "", fmt.Errorf("unrecognized failed_downloads policy '%s' (expected one of: %s, %s, %s)",
+		s, failureKeep, failureRemove, failurePartial)
+}
+
+// cleanUpFailedDownload applies the policy to the temp file (at tmpPath) of a download into localPath
+// that failed. sidecarPath is the file that records what the temp file holds, for resuming, which is
+// removed along with the temp file, unless it's kept. The temp file must already be closed. A temp
+// file that is already gone (such as one that failed its checksum), or is empty, is just removed.
+func cleanUpFailedDownload(log frog.Logger, policy failurePolicy, tmpPath, sidecarPath, localPath string) {
+	if policy == "" || policy == failureKeep {
+		return
+	}
+	fi, err := os.Stat(tmpPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warning("failed to stat failed download", frog.PathAbs(tmpPath), frog.Err(err))
+		return
+	}
+	if policy == failureRemove || err != nil || fi.Size() == 0 {
+		log.Verbose("removing failed download", frog.PathAbs(tmpPath))
+		removeTempFile(tmpPath, sidecarPath)
+		return
+	}
+	partialPath := localPath + partialSuffix
+	log.Verbose("keeping failed download", frog.Int64("bytes", fi.Size()), frog.PathAbs(partialPath))
+	if err := os.Rename(tmpPath, partialPath); err != nil {
+		// the temp file is still there, for a later run to resume
+		log.Warning("failed to rename failed download", frog.PathAbs(tmpPath), frog.Err(err))
+		return
+	}
+	_ = os.Remove(sidecarPath)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func Test_ParseFailurePolicy(t *testing.T) {
+	cases := []struct {
+		Input       string
+		Expected    failurePolicy
+		ExpectedErr bool
+	}{
+		{"", failureKeep, false},
+		{"keep", failureKeep, false},
+		{"remove", failureRemove, false},
+		{"partial", failurePartial, false},
+		{"delete", "", true},
+	}
+	for _, tc := range cases {
+		actual, err := parseFailurePolicy(tc.Input)
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("'%s': expected error %v, but got %v", tc.Input, tc.ExpectedErr, err)
+		}
+		if actual != tc.Expected {
+			t.Errorf("'%s': expected '%s', but got '%s'", tc.Input, tc.Expected, actual)
+		}
+	}
+}
+
+func Test_DownloadToFile_OnFailure(t *testing.T) {
+	content := testContent(t, 5000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// promise the whole file, but only send some of it
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write(content[:2000])
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Policy   failurePolicy
+		Path     string
+		Expected []string // the files left in the folder ("tmp" and "sidecar" for the temp file and its sidecar)
+	}{
+		{"default", "", "/", []string{"sidecar", "tmp"}},
+		{"keep", failureKeep, "/", []string{"sidecar", "tmp"}},
+		{"remove", failureRemove, "/", nil},
+		{"partial", failurePartial, "/", []string{"file.partial"}},
+		{"partial, but nothing downloaded", failurePartial, "/missing", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "file")
+			url := srv.URL + tc.Path
+			_, err := DownloadToFile(context.Background(), nil, url, path, DownloadOptions{
+				ExpectedSize: int64(len(content)),
+				MaxRetry:     1,
+				OnFailure:    tc.Policy,
+			})
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			tmpPath, sidecarPath := tempPaths(url, path, int64(len(content)))
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, e := range entries {
+				switch e.Name() {
+				case filepath.Base(tmpPath):
+					actual = append(actual, "tmp")
+				case filepath.Base(sidecarPath):
+					actual = append(actual, "sidecar")
+				default:
+					actual = append(actual, e.Name())
+				}
+			}
+			sort.Strings(actual)
+			if len(actual) != len(tc.Expected) {
+				t.Fatalf("expected %v, but found %v", tc.Expected, actual)
+			}
+			for i := range actual {
+				if actual[i] != tc.Expected[i] {
+					t.Fatalf("expected %v, but found %v", tc.Expected, actual)
+				}
+			}
+			if tc.Policy == failurePartial && len(tc.Expected) > 0 {
+				b, err := os.ReadFile(path + partialSuffix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(b) != 2000 {
+					t.Errorf("expected the 2000 bytes that were downloaded, but got %d", len(b))
+				}
+			}
+		})
+	}
+}
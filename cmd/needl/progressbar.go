@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	progressBarInterval  = 100 * time.Millisecond
+	progressBarWidth     = 20
+	progressBarNameWidth = 28
+)
+
+type progressEventKind int
+
+const (
+	progressQueued progressEventKind = iota
+	progressStart
+	progressUpdate
+	progressEnd
+)
+
+type progressEvent struct {
+	kind   progressEventKind
+	worker int
+	name   string
+	read   int64
+	total  int64 // zero if unknown
+}
+
+type progressBar struct {
+	active bool
+	name   string
+	read   int64
+	total  int64
+	start  time.Time
+}
+
+// progressBars draws a progress bar for each worker's download, and one for the whole run, at the
+// bottom of the terminal. The bars are drawn by their own goroutine, from the events sent to it by
+// the workers. Anything written to progressBars (such as log lines) is printed above the bars.
+// All methods are safe to call on a nil *progressBars, which does nothing.
+type progressBars struct {
+	w      io.Writer
+	events chan progressEvent
+	done   chan struct{}
+
+	mu       sync.Mutex
+	bars     []progressBar // indexed by worker
+	drawn    int           // number of lines drawn by the last draw
+	started  time.Time     // when the first download started
+	queued   int
+	finished int
+	bytes    int64 // read by finished downloads
+}
+
+func newProgressBars(w io.Writer) *progressBars {
+	pb := &progressBars{
+		w:      w,
+		events: make(chan progressEvent, 256),
+		done:   make(chan struct{}),
+	}
+	go pb.run()
+	return pb
+}
+
+// queue counts another file that is waiting for a worker
+func (pb *progressBars) queue() {
+	if pb != nil {
+		pb.events <- progressEvent{kind: progressQueued}
+	}
+}
+
+// start shows a bar for the named file, which the given worker is starting to download
+func (pb *progressBars) start(worker int, name string, total int64) {
+	if pb != nil {
+		pb.events <- progressEvent{kind: progressStart, worker: worker, name: name, total: max(total, 0)}
+	}
+}
+
+// update moves the bar of the given worker. Updates are dropped if the bars are behind, since each
+// update replaces the last.
+func (pb *progressBars) update(worker int, read, total int64) {
+	if pb == nil {
+		return
+	}
+	select {
+	case pb.events <- progressEvent{kind: progressUpdate, worker: worker, read: read, total: total}:
+	default:
+	}
+}
+
+// end removes the bar of the given worker, and counts its file (and the bytes read) as finished
+func (pb *progressBars) end(worker int) {
+	if pb != nil {
+		pb.events <- progressEvent{kind: progressEnd, worker: worker}
+	}
+}
+
+// close stops drawing, and clears the bars. It must not be called until the workers are done.
+func (pb *progressBars) close() {
+	if pb == nil {
+		return
+	}
+	close(pb.events)
+	<-pb.done
+}
+
+// Write prints p above the bars.
+func (pb *progressBars) Write(p []byte) (int, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.clear()
+	n, err := pb.w.Write(p)
+	pb.draw()
+	return n, err
+}
+
+func (pb *progressBars) run() {
+	ticker := time.NewTicker(progressBarInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-pb.events:
+			if !ok {
+				pb.mu.Lock()
+				pb.clear()
+				pb.mu.Unlock()
+				close(pb.done)
+				return
+			}
+			pb.mu.Lock()
+			pb.apply(e)
+			pb.mu.Unlock()
+		case <-ticker.C:
+			pb.mu.Lock()
+			pb.clear()
+			pb.draw()
+			pb.mu.Unlock()
+		}
+	}
+}
+
+// apply updates the bars from the event (with mu held)
+func (pb *progressBars) apply(e progressEvent) {
+	if e.kind == progressQueued {
+		pb.queued++
+		return
+	}
+	for len(pb.bars) <= e.worker {
+		pb.bars = append(pb.bars, progressBar{})
+	}
+	b := &pb.bars[e.worker]
+	switch e.kind {
+	case progressStart:
+		now := time.Now()
+		if pb.started.IsZero() {
+			pb.started = now
+		}
+		*b = progressBar{active: true, name: e.name, total: e.total, start: now}
+	case progressUpdate:
+		if b.active {
+			b.read = e.read
+			if e.total > 0 {
+				b.total = e.total
+			}
+		}
+	case progressEnd:
+		if b.active {
+			pb.finished++
+			pb.bytes += b.read
+		}
+		*b = progressBar{}
+	}
+}
+
+// clear erases the lines from the last draw, leaving the cursor where the first of them was (with mu held)
+func (pb *progressBars) clear() {
+	if pb.drawn > 0 {
+		// move to the start of the first drawn line, then erase to the end of the screen
+		fmt.Fprintf(pb.w, "\x1b[%dF\x1b[J", pb.drawn)
+		pb.drawn = 0
+	}
+}
+
+// draw writes a line for each active download, and one for the run, unless nothing has been queued yet
+// (with mu held)
+func (pb *progressBars) draw() {
+	if pb.queued == 0 {
+		return
+	}
+	now := time.Now()
+	var sb strings.Builder
+	lines := 0
+	read := pb.bytes
+	for _, b := range pb.bars {
+		if !b.active {
+			continue
+		}
+		read += b.read
+		fraction := -1.0
+		if b.total > 0 {
+			fraction = float64(b.read) / float64(b.total)
+		}
+		sb.WriteString(renderProgressLine(b.name, fraction, b.read, now.Sub(b.start)))
+		sb.WriteByte('\n')
+		lines++
+	}
+
+	name := fmt.Sprintf("%d/%d files", pb.finished, pb.queued)
+	var elapsed time.Duration
+	if !pb.started.IsZero() {
+		elapsed = now.Sub(pb.started)
+	}
+	sb.WriteString(renderProgressLine(name, float64(pb.finished)/float64(pb.queued), read, elapsed))
+	sb.WriteByte('\n')
+	lines++
+
+	_, _ = io.WriteString(pb.w, sb.String())
+	pb.drawn = lines
+}
+
+// renderProgressLine returns a line like "name  [=====>    ]  50%  12 MB  3.4 MB/s", with a fixed width name,
+// and with a blank bar and percent if fraction is negative (for an unknown total)
+func renderProgressLine(name string, fraction float64, read int64, elapsed time.Duration) string {
+	var sb strings.Builder
+	sb.WriteString(fitName(name, progressBarNameWidth))
+	if fraction >= 0 {
+		fraction = min(fraction, 1)
+		fmt.Fprintf(&sb, "  %s %3.0f%%", renderBar(fraction), fraction*100)
+	} else {
+		sb.WriteString(strings.Repeat(" ", 2+progressBarWidth+2+5))
+	}
+	fmt.Fprintf(&sb, "  %8s", humanize.Bytes(uint64(read)))
+	if elapsed > 0 {
+		fmt.Fprintf(&sb, "  %9s", formatSpeed(float64(read)/elapsed.Seconds()))
+	}
+	return sb.String()
+}
+
+// renderBar returns a bar, like "[=====>    ]", that is filled by fraction (from 0 to 1)
+func renderBar(fraction float64) string {
+	fraction = max(0, min(fraction, 1))
+	filled := int(fraction * progressBarWidth)
+	switch {
+	case filled == progressBarWidth:
+		return "[" + strings.Repeat("=", progressBarWidth) + "]"
+	case filled == 0:
+		return "[" + strings.Repeat(" ", progressBarWidth) + "]"
+	}
+	return "[" + strings.Repeat("=", filled-1) + ">" + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
+
+// fitName pads or shortens name to width runes, keeping its end (which has the extension), eg "…-name.zip"
+func fitName(name string, width int) string {
+	n := utf8.RuneCountInString(name)
+	if n <= width {
+		return name + strings.Repeat(" ", width-n)
+	}
+	runes := []rune(name)
+	return "…" + string(runes[len(runes)-(width-1):])
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_RenderBar(t *testing.T) {
+	cases := []struct {
+		Fraction float64
+		Expected string
+	}{
+		{-1, "[                    ]"},
+		{0, "[                    ]"},
+		{0.5, "[=========>          ]"},
+		{0.99, "[==================> ]"},
+		{1, "[====================]"},
+		{2, "[====================]"},
+	}
+	for _, tc := range cases {
+		if actual := renderBar(tc.Fraction); actual != tc.Expected {
+			t.Errorf("for %v, expected '%s', but got '%s'", tc.Fraction, tc.Expected, actual)
+		}
+	}
+}
+
+func Test_FitName(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Expected string
+	}{
+		{"a.zip", "a.zip     "},
+		{"exactly10!", "exactly10!"},
+		{"a-very-long-name.zip", "…-name.zip"},
+		{"naïve-naïve.zip", "…naïve.zip"},
+	}
+	for _, tc := range cases {
+		if actual := fitName(tc.Name, 10); actual != tc.Expected {
+			t.Errorf("for '%s', expected '%s', but got '%s'", tc.Name, tc.Expected, actual)
+		}
+	}
+}
+
+func Test_ProgressBars(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProgressBars(&buf)
+	pb.queue()
+	pb.queue()
+	pb.start(1, "b.zip", 1000)
+	pb.update(1, 500, 1000)
+	pb.start(0, "a.zip", 0)
+	pb.end(0)
+	pb.close()
+
+	// close waits for every event, so this draws the final state
+	buf.Reset()
+	pb.mu.Lock()
+	pb.draw()
+	pb.mu.Unlock()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, but got %d: %q", len(lines), lines)
+	}
+	if !strings.HasPrefix(lines[0], "b.zip ") || !strings.Contains(lines[0], " 50%") {
+		t.Errorf("expected b.zip to be half done, but got '%s'", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "1/2 files ") || !strings.Contains(lines[1], " 50%") {
+		t.Errorf("expected 1 of 2 files to be done, but got '%s'", lines[1])
+	}
+
+	// a write clears the bars, and then draws them again below it
+	buf.Reset()
+	if _, err := pb.Write([]byte("log line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "\x1b[2F\x1b[Jlog line\nb.zip ") {
+		t.Errorf("expected bars to be cleared and redrawn around the write, but got %q", buf.String())
+	}
+}
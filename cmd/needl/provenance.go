@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+// errXattrUnsupported is returned when the OS, or the filesystem a file is on, doesn't support extended attributes
+var errXattrUnsupported = errors.New("extended attributes are not supported")
+
+// xattrPrefix starts the name of each extended attribute that needl sets
+const xattrPrefix = "user.needl."
+
+// provenance is where a download came from, which is recorded in its extended attributes, so that
+// other tools can tell without a sidecar file.
+type provenance struct {
+	SourceURL  string
+	Downloaded time.Time
+	Checksum   Checksum // the checksum the download was verified against, if any
+}
+
+type xattr struct {
+	Name  string
+	Value string
+}
+
+// xattrs returns the extended attributes that record p
+func (p provenance) xattrs() []xattr {
+	attrs := []xattr{
+		{xattrPrefix + "source_url", p.SourceURL},
+		{xattrPrefix + "downloaded", p.Downloaded.UTC().Format(time.RFC3339)},
+	}
+	if !p.Checksum.IsZero() {
+		attrs = append(attrs, xattr{xattrPrefix + p.Checksum.Algo, p.Checksum.Hex})
+	}
+	return attrs
+}
+
+// recordProvenance sets p on the file at path, as extended attributes. Failing to isn't an error
+// for the download, so it is only logged: as a warning, unless the filesystem just doesn't support
+// extended attributes (which would otherwise log a warning for every file).
+func recordProvenance(log frog.Logger, path string, p provenance) {
+	for _, a := range p.xattrs() {
+		err := setXattr(path, a.Name, a.Value)
+		if errors.Is(err, errXattrUnsupported) {
+			log.Verbose("not setting extended attributes", frog.Path(path), frog.Err(err))
+			return
+		}
+		if err != nil {
+			log.Warning("setting extended attribute", frog.String("name", a.Name), frog.PathAbs(path), frog.Err(err))
+			return
+		}
+	}
+}
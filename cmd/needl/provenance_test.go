@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_RecordProvenance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setXattr(path, xattrPrefix+"probe", "x"); errors.Is(err, errXattrUnsupported) {
+		t.Skipf("not supported here: %v", err)
+	}
+
+	p := provenance{
+		SourceURL:  "https://example.com/files/file.bin",
+		Downloaded: time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("PST", -8*60*60)),
+		Checksum:   Checksum{Algo: "sha256", Hex: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	}
+	recordProvenance(&frog.NullLogger{}, path, p)
+
+	expected := map[string]string{
+		"user.needl.source_url": "https://example.com/files/file.bin",
+		"user.needl.downloaded": "2024-01-02T11:04:05Z",
+		"user.needl.sha256":     "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}
+	for name, value := range expected {
+		actual, err := getXattr(path, name)
+		if err != nil {
+			t.Errorf("getting %s: %v", name, err)
+		} else if actual != value {
+			t.Errorf("expected %s to be '%s', but got '%s'", name, value, actual)
+		}
+	}
+}
+
+func Test_Provenance_NoChecksum(t *testing.T) {
+	attrs := provenance{SourceURL: "https://example.com/a"}.xattrs()
+	if len(attrs) != 2 {
+		t.Errorf("expected just the url and time, but got %v", attrs)
+	}
+}
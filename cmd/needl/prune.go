@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// pruneTempFiles removes the temp files (and their sidecars) that interrupted downloads left
+// anywhere under root, as long as they were last modified at least minAge before now.
+// A temp file whose download is locked by another process (see --lock) is left alone.
+// It returns the number of files removed, and how many bytes they held.
+func pruneTempFiles(log frog.Logger, root string, minAge time.Duration, now time.Time) (int, int64, error) {
+	var count int
+	var bytes int64
+	remove := func(path string, size int64) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warning("removing temp file", frog.PathAbs(path), frog.Err(err))
+			return
+		}
+		log.Verbose("removed temp file", frog.Path(path), frog.Int64("size", size))
+		count++
+		bytes += size
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		var tmpPath, sidecarPath string
+		switch name := d.Name(); {
+		case strings.HasSuffix(name, plan.TempFileSuffix):
+			tmpPath = path
+			sidecarPath = strings.TrimSuffix(path, plan.TempFileSuffix) + plan.ResumeSidecarSuffix
+		case strings.HasSuffix(name, plan.ResumeSidecarSuffix):
+			// a sidecar is removed along with its temp file, unless the temp file is already gone
+			tmpPath = strings.TrimSuffix(path, plan.ResumeSidecarSuffix) + plan.TempFileSuffix
+			if _, err := os.Lstat(tmpPath); err == nil {
+				return nil
+			}
+			tmpPath = ""
+			sidecarPath = path
+		default:
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if now.Sub(info.ModTime()) < minAge {
+			log.Verbose("skipping recent temp file", frog.Path(path), frog.Time("modified", info.ModTime()))
+			return nil
+		}
+
+		// the sidecar names the file being downloaded, so take the lock that its download would hold
+		if b, err := os.ReadFile(sidecarPath); err == nil {
+			var ri resumeInfo
+			if json.Unmarshal(b, &ri) == nil && len(ri.Name) > 0 {
+				lock, err := acquireFileLock(filepath.Join(filepath.Dir(path), ri.Name))
+				if errors.Is(err, errFileLocked) {
+					log.Verbose("skipping temp file of a locked download", frog.Path(path), frog.String("name", ri.Name))
+					return nil
+				}
+				if err == nil {
+					defer lock.release()
+				}
+			}
+		}
+
+		if len(tmpPath) > 0 {
+			remove(tmpPath, info.Size())
+		}
+		if fi, err := os.Lstat(sidecarPath); err == nil {
+			remove(sidecarPath, fi.Size())
+		}
+		return nil
+	})
+	return count, bytes, err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+func Test_PruneTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	write := func(name string, b []byte, modTime time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sidecar := func(name string) []byte {
+		b, err := json.Marshal(resumeInfo{URL: "http://example.com/" + name, Size: 100, Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	write("a.zip", make([]byte, 10), old)
+	write("aaaa"+plan.TempFileSuffix, make([]byte, 40), old)
+	write("aaaa"+plan.ResumeSidecarSuffix, sidecar("a.zip"), old)
+	write("bbbb"+plan.TempFileSuffix, make([]byte, 40), now)
+	write("bbbb"+plan.ResumeSidecarSuffix, sidecar("b.zip"), now)
+	write("cccc"+plan.TempFileSuffix, make([]byte, 40), old)
+	write("cccc"+plan.ResumeSidecarSuffix, sidecar("c.zip"), old)
+	write("dddd"+plan.ResumeSidecarSuffix, sidecar("d.zip"), old)
+	write("2020/eeee"+plan.TempFileSuffix, make([]byte, 20), old)
+
+	// c.zip is being downloaded by another process
+	lock, err := acquireFileLock(filepath.Join(dir, "c.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.release()
+
+	count, _, err := pruneTempFiles(&frog.NullLogger{}, dir, 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 files removed, but got %d", count)
+	}
+
+	var remaining []string
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			remaining = append(remaining, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"a.zip",
+		"bbbb" + plan.ResumeSidecarSuffix, "bbbb" + plan.TempFileSuffix,
+		"cccc" + plan.ResumeSidecarSuffix, "cccc" + plan.TempFileSuffix,
+		filepath.Base(lockFilePath(filepath.Join(dir, "c.zip"))),
+	}
+	slices.Sort(expected)
+	if !slices.Equal(remaining, expected) {
+		t.Errorf("expected %v to remain, but got %v", expected, remaining)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimitChunk is the most that is read from a response body before waiting for the rate limiter,
+// so that a slow limit is smooth, rather than bursts of a whole buffer at a time.
+const rateLimitChunk = 16 * 1024
+
+// rateLimiter caps the rate at which bytes are read from response bodies, across every download and
+// every part of a download that shares it. A nil rateLimiter has no limit.
+type rateLimiter struct {
+	bytesPerSec float64
+
+	mu sync.Mutex
+	// next is when the bytes that have already been let through will have been read at the limit
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{bytesPerSec: float64(bytesPerSec)}
+}
+
+// wait waits until n more bytes can be read without going over the limit, or returns the context's
+// error if it is canceled first
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		// the limit isn't carried over from when nothing was being read
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSec * float64(time.Second)))
+	d := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedBody reads from a response body no faster than its rateLimiter allows
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rateLimiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > rateLimitChunk {
+		p = p[:rateLimitChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if waitErr := b.limiter.wait(b.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_DownloadToFile_RateLimit(t *testing.T) {
+	content := testContent(t, 64*1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// two parts at once, sharing the one limit, so the whole file takes about a quarter second
+	path := filepath.Join(t.TempDir(), "file")
+	start := time.Now()
+	_, err := DownloadToFile(context.Background(), nil, srv.URL+"/file", path, DownloadOptions{
+		ExpectedSize:    int64(len(content)),
+		PartSize:        32 * 1024,
+		PartConcurrency: 2,
+		RateLimit:       newRateLimiter(256 * 1024),
+	})
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("expected the download to take about 250ms, but took %v", elapsed)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded content doesn't match")
+	}
+}
+
+func Test_RateLimiter_Canceled(t *testing.T) {
+	l := newRateLimiter(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 1000); err == nil {
+		t.Errorf("expected an error from a canceled wait")
+	}
+
+	var none *rateLimiter
+	if err := none.wait(ctx, 1000); err != nil {
+		t.Errorf("expected no limit from a nil rateLimiter, but got %v", err)
+	}
+}
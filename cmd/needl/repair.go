@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// statRepairFile looks up just the named file, both locally and remotely, instead of listing every file.
+// The remote file is looked up directly if the scraper is a scraper.RemoteStatter, or else found in the
+// full remote listing. The local file is named by its slash separated path within the download path.
+// The results (and error numbers) are as from listFiles, with at most one file in each list.
+func statRepairFile(
+	log frog.Logger, cfg config.Config, scfg config.Scraper, name string, missingLocalOK bool,
+) ([]plan.LocalFile, []scraper.RemoteFile, int) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		log.Error("file to repair must be a relative path within the download path", frog.String("name", name))
+		return nil, nil, 1
+	}
+
+	local, err := statLocal(cfg.LocalPath, name)
+	if err == nil && local == nil && plan.MatchesCompressed(cfg.Compressed, name) {
+		local, err = statLocal(cfg.LocalPath, name+plan.GzipSuffix)
+		if err == nil && local != nil {
+			locals := plan.UncompressLocals(cfg.LocalPath, []plan.LocalFile{*local}, cfg.Compressed)
+			local = &locals[0]
+		}
+	}
+	if missingLocalOK && errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		log.Error("stat local file", frog.Err(err), frog.String("name", name), frog.PathAbs(cfg.LocalPath))
+		return nil, nil, 20
+	}
+	var locals []plan.LocalFile
+	if local != nil {
+		locals = append(locals, *local)
+	} else {
+		log.Info("Local file does not exist", frog.String("name", name))
+	}
+
+	// the remote file's name still has any prefix or suffix that is stripped from the local name
+	remote, err := statRemote(log, cfg, scfg, scfg.StripPrefix+path.Base(name)+scfg.StripSuffix)
+	if err != nil {
+		log.Error("stat remote file", frog.Err(err), frog.String("name", name),
+			frog.String("urls", strings.Join(scfg.BaseURLs(), " ")),
+		)
+		return nil, nil, 30
+	}
+	remotes := []scraper.RemoteFile{remote}
+	if err := plan.RewriteURLs(log, remotes, scfg.URLRewrite); err != nil {
+		log.Error("list remote files", frog.Err(err))
+		return nil, nil, 30
+	}
+	plan.StripNames(remotes, scfg.StripPrefix, scfg.StripSuffix)
+
+	return locals, remotes, 0
+}
+
+// statLocal returns the named file in dir, or nil if it doesn't exist (or is a dangling link)
+func statLocal(dir, name string) (*plan.LocalFile, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	li, err := os.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	linked := li.Mode()&fs.ModeSymlink != 0
+	i := li
+	if linked {
+		i, err = os.Stat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if i.IsDir() {
+		return nil, fmt.Errorf("'%s' is a folder", name)
+	}
+	return &plan.LocalFile{
+		Name:      name,
+		SortName:  scraper.SortName(name),
+		Timestamp: i.ModTime().UTC(),
+		Size:      i.Size(),
+		Linked:    linked,
+	}, nil
+}
+
+// statRemote finds the named remote file, checking each of the scraper's base URLs in order
+func statRemote(log frog.Logger, cfg config.Config, scfg config.Scraper, name string) (scraper.RemoteFile, error) {
+	opts, err := plan.ScraperOptions(cfg, scfg)
+	if err != nil {
+		return scraper.RemoteFile{}, err
+	}
+	urls := scfg.BaseURLs()
+	if len(urls) == 0 {
+		return scraper.RemoteFile{}, fmt.Errorf("no url specified")
+	}
+
+	for _, u := range urls {
+		s, err := scraper.Create(scfg.Type, append([]scraper.Option{scraper.BaseURL(u)}, opts...)...)
+		if err != nil {
+			return scraper.RemoteFile{}, fmt.Errorf("config error creating scraper of type '%s': %w", scfg.Type, err)
+		}
+
+		var r scraper.RemoteFile
+		if st, ok := s.(scraper.RemoteStatter); ok {
+			log.Info("Looking up remote file...", frog.String("name", name), frog.String("url", u))
+			r, err = st.StatRemote(name)
+		} else {
+			log.Info("Listing remote files...", frog.String("url", u))
+			r, err = findRemote(s, name)
+		}
+		switch {
+		case err == nil:
+			return r, nil
+		case errors.Is(err, scraper.ErrRemoteNotFound):
+			log.Verbose("remote file not found at url", frog.String("name", name), frog.String("url", u))
+		case scfg.ContinueOnError:
+			log.Warning("skipping failed remote lookup", frog.String("url", u), frog.Err(err))
+		default:
+			return scraper.RemoteFile{}, fmt.Errorf("error while looking up '%s' in '%s': %w", name, u, err)
+		}
+	}
+	return scraper.RemoteFile{}, fmt.Errorf("%w: %s", scraper.ErrRemoteNotFound, name)
+}
+
+// findRemote scrapes the whole listing, and returns the named file from it
+func findRemote(s scraper.Scraper, name string) (scraper.RemoteFile, error) {
+	remotes, err := s.ScrapeRemotes()
+	if err != nil {
+		return scraper.RemoteFile{}, err
+	}
+	sortName := scraper.SortName(name)
+	for _, r := range remotes {
+		if r.SortName == sortName {
+			return r, nil
+		}
+	}
+	return scraper.RemoteFile{}, fmt.Errorf("%w: %s", scraper.ErrRemoteNotFound, name)
+}
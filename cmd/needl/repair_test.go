@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_StatLocal(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	if err := os.WriteFile(filepath.Join(dir, "a.zip"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a.zip"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "2020", "01"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2020", "01", "b.zip"), make([]byte, 200), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name         string
+		ExpectedSize int64 // -1 for not found
+		ExpectedErr  bool
+	}{
+		{"a.zip", 100, false},
+		{"2020/01/b.zip", 200, false},
+		{"missing.zip", -1, false},
+		{"2020", -1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			local, err := statLocal(dir, tc.Name)
+			if tc.ExpectedErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.ExpectedSize < 0 {
+				if local != nil {
+					t.Errorf("expected no local file, but got %v", *local)
+				}
+				return
+			}
+			if local == nil {
+				t.Fatalf("expected a local file")
+			}
+			if local.Name != tc.Name || local.Size != tc.ExpectedSize {
+				t.Errorf("expected '%s' of size %d, but got '%s' of size %d", tc.Name, tc.ExpectedSize, local.Name, local.Size)
+			}
+		})
+	}
+
+	local, err := statLocal(dir, "a.zip")
+	if err != nil || !local.Timestamp.Equal(modTime) {
+		t.Errorf("expected time %v, but got %v (err: %v)", modTime, local, err)
+	}
+	if _, err := statLocal(filepath.Join(dir, "missing"), "a.zip"); err == nil {
+		t.Errorf("expected an error for a missing download path")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// resumeInfo is written beside a temp file, to record what is being downloaded into it,
+// so that a later run only resumes into a temp file that holds the start of the same file.
+type resumeInfo struct {
+	URL          string    `json:"url"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+
+	// Name is the (base) name of the local file being downloaded, so that --prune-tmp can tell
+	// if its download is locked. It isn't compared when resuming.
+	Name string `json:"name,omitempty"`
+
+	// Validator is the strong ETag (or else the Last-Modified header) of the response that the temp
+	// file holds the start of, which is sent as If-Range when resuming. It isn't compared when resuming.
+	Validator string `json:"validator,omitempty"`
+
+	// Parts is the sha256 (as lowercase hex) of each part that has been downloaded in full, or "" for a
+	// part that hasn't, for a download in parts. A later run checks each part against it before reusing it.
+	Parts []string `json:"parts,omitempty"`
+}
+
+// tempPaths returns the paths of the temp file and its sidecar, for downloading remoteURL
+// (of the given size) into localPath's folder. The name is a hash of the URL and size, so
+// that it stays the same from one run to the next, even if the local name changes.
+func tempPaths(remoteURL, localPath string, size int64) (string, string) {
+	sum := sha256.Sum256([]byte(remoteURL + "\n" + strconv.FormatInt(size, 10)))
+	base := filepath.Join(filepath.Dir(localPath), hex.EncodeToString(sum[:8]))
+	return base + plan.TempFileSuffix, base + plan.ResumeSidecarSuffix
+}
+
+// resumeMode is how a temp file from an earlier run may be reused
+type resumeMode int
+
+const (
+	resumeNever  resumeMode = iota
+	resumeStream            // the temp file holds the start of the file, so the rest can be appended
+	resumeParts             // the temp file holds parts of the file, anywhere in it, which are verified before reuse
+)
+
+// openTempFile opens the temp file for writing, and returns how many bytes it already holds.
+// Unless mode is resumeNever, if the sidecar shows that an earlier run was downloading the same
+// file, then the existing bytes are kept, so that the download can resume from where it left off.
+// Otherwise, the temp file is truncated, and the sidecar is (re-)written.
+func openTempFile(log frog.Logger, tmpPath, sidecarPath string, info resumeInfo, mode resumeMode) (*os.File, int64, error) {
+	if mode != resumeNever && info.Size > 0 {
+		if n := resumableSize(tmpPath, sidecarPath, info, mode); n > 0 {
+			f, err := os.OpenFile(tmpPath, os.O_RDWR, 0o644)
+			if err == nil {
+				if _, err = f.Seek(n, io.SeekStart); err == nil {
+					log.Verbose("found partial download", frog.Int64("bytes", n), frog.PathAbs(tmpPath))
+					return f, n, nil
+				}
+				f.Close()
+			}
+		}
+	}
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := writeResumeInfo(sidecarPath, info); err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("write sidecar: %w", err)
+	}
+	return f, 0, nil
+}
+
+func writeResumeInfo(sidecarPath string, info resumeInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sidecarPath, b, 0o644)
+}
+
+func readResumeInfo(sidecarPath string) (resumeInfo, error) {
+	var info resumeInfo
+	b, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+// resumableSize returns the number of bytes in the temp file that can be resumed from, or 0 if the
+// temp file is missing, too big, or its sidecar doesn't match info. In resumeStream mode, a
+// complete temp file is also too big.
+func resumableSize(tmpPath, sidecarPath string, info resumeInfo, mode resumeMode) int64 {
+	prev, err := readResumeInfo(sidecarPath)
+	if err != nil {
+		return 0
+	}
+	if prev.URL != info.URL || prev.Size != info.Size || !prev.LastModified.Equal(info.LastModified) {
+		return 0
+	}
+	fi, err := os.Stat(tmpPath)
+	if err != nil || fi.Size() > info.Size {
+		return 0
+	}
+	if mode == resumeStream && fi.Size() == info.Size {
+		// a complete temp file was left for a reason (such as a failed checksum), so don't trust it
+		return 0
+	}
+	return fi.Size()
+}
+
+// removeTempFile removes the temp file and its sidecar
+func removeTempFile(tmpPath, sidecarPath string) {
+	_ = os.Remove(tmpPath)
+	_ = os.Remove(sidecarPath)
+}
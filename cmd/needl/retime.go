@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// fixLocalTime sets the local file's time to the remote file's, for a file whose size matches, but
+// whose time doesn't (when size_only is set), instead of downloading it again. When auditing, the
+// difference is only logged. Failing to set the time is logged, but the file is still left as is,
+// since its content is trusted.
+func fixLocalTime(log frog.Logger, localPath string, local plan.LocalFile, remote scraper.RemoteFile, audit bool, stats *runStats) {
+	fields := []frog.Fielder{
+		frog.String("name", local.Name), frog.Time("local_time", local.Timestamp), frog.Time("remote_time", remote.Timestamp),
+	}
+	if audit {
+		log.Info("Local file time differs from remote", fields...)
+		return
+	}
+	path := filepath.Join(localPath, filepath.FromSlash(local.Name))
+	if err := modifyFileTime(path, remote.Timestamp); err != nil {
+		log.Warning("setting local file time", append(fields, frog.PathAbs(path), frog.Err(err))...)
+		return
+	}
+	stats.filesRetimed.Add(1)
+	log.Verbose("fixed local file time", fields...)
+}
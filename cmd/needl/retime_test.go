@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_FixLocalTime(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Audit         bool
+		ExpectedFixed bool
+	}{
+		{"fix", false, true},
+		{"audit", true, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			local := localFile(t, "a/foo", "2020-01-01 00:00", 4)
+			remote := remoteFile(t, "a/foo", "2021-06-01 12:30", 4)
+			path := filepath.Join(dir, "a", "foo")
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := modifyFileTime(path, local.Timestamp); err != nil {
+				t.Fatal(err)
+			}
+
+			var stats runStats
+			fixLocalTime(&frog.NullLogger{}, dir, local, remote, tc.Audit, &stats)
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := local.Timestamp
+			if tc.ExpectedFixed {
+				expected = remote.Timestamp
+			}
+			if !fi.ModTime().Equal(expected) {
+				t.Errorf("expected time %v, but got %v", expected, fi.ModTime())
+			}
+			var expectedCount int64
+			if tc.ExpectedFixed {
+				expectedCount = 1
+			}
+			if n := stats.filesRetimed.Load(); n != expectedCount {
+				t.Errorf("expected %d retimed, but got %d", expectedCount, n)
+			}
+		})
+	}
+}
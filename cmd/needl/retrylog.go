@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+// defaultRetryLogWindow is how long the retries from each host are coalesced into one log line
+const defaultRetryLogWindow = time.Minute
+
+// retryLogThrottle coalesces the log lines for retried errors, for each host, shared by all of the
+// download workers, so that an outage doesn't log a line for every retry of every file. The first
+// retry from a host in each window is logged as usual, and the rest are counted, and summarized in
+// one line once the window has passed (or at the end of the run).
+// A nil retryLogThrottle logs every retry.
+type retryLogThrottle struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*hostRetries
+}
+
+type hostRetries struct {
+	start      time.Time // when the window started
+	suppressed int       // how many retries weren't logged since then
+}
+
+func newRetryLogThrottle(window time.Duration) *retryLogThrottle {
+	if window <= 0 {
+		window = defaultRetryLogWindow
+	}
+	return &retryLogThrottle{
+		window: window,
+		now:    time.Now,
+		hosts:  make(map[string]*hostRetries),
+	}
+}
+
+// allow returns whether a retry for rawURL's host should be logged. If this starts a new window,
+// then the retries that weren't logged in the last window are summarized first.
+func (t *retryLogThrottle) allow(log frog.Logger, rawURL string) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	host := hostOf(rawURL)
+	now := t.now()
+	h, ok := t.hosts[host]
+	if ok && now.Sub(h.start) < t.window {
+		h.suppressed++
+		return false
+	}
+	if ok {
+		t.summarize(log, host, h, now)
+	}
+	t.hosts[host] = &hostRetries{start: now}
+	return true
+}
+
+// flush summarizes the retries from each host that weren't logged
+func (t *retryLogThrottle) flush(log frog.Logger) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hosts := make([]string, 0, len(t.hosts))
+	for host := range t.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	now := t.now()
+	for _, host := range hosts {
+		t.summarize(log, host, t.hosts[host], now)
+	}
+	t.hosts = make(map[string]*hostRetries)
+}
+
+func (t *retryLogThrottle) summarize(log frog.Logger, host string, h *hostRetries, now time.Time) {
+	if h.suppressed == 0 {
+		return
+	}
+	log.Verbose("more errors, which will also be retried",
+		frog.String("host", host),
+		frog.Int("retries", h.suppressed),
+		frog.Dur("in_last", now.Sub(h.start).Round(time.Second)),
+	)
+}
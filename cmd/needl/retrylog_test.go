@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_RetryLogThrottle(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Events   []string // "retry <host>", "wait <duration>", or "flush -"
+		Expected []bool   // what each retry's allow returned
+	}{
+		{"first retry is logged", []string{"retry a"}, []bool{true}},
+		{"rest of window is coalesced", []string{"retry a", "retry a", "retry a"}, []bool{true, false, false}},
+		{"hosts are separate", []string{"retry a", "retry b", "retry a:81", "retry a"}, []bool{true, true, true, false}},
+		{"next window is logged", []string{"retry a", "retry a", "wait 61s", "retry a", "retry a"}, []bool{true, false, true, false}},
+		{"inside window", []string{"retry a", "wait 59s", "retry a"}, []bool{true, false}},
+		{"flush starts over", []string{"retry a", "retry a", "flush -", "retry a"}, []bool{true, false, true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			log := &frog.NullLogger{}
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			rt := newRetryLogThrottle(time.Minute)
+			rt.now = func() time.Time { return now }
+			var actual []bool
+			for _, e := range tc.Events {
+				var op, arg string
+				if _, err := fmt.Sscan(e, &op, &arg); err != nil {
+					t.Fatalf("bad event '%s': %v", e, err)
+				}
+				switch op {
+				case "retry":
+					actual = append(actual, rt.allow(log, "http://"+arg+"/file"))
+				case "flush":
+					rt.flush(log)
+				case "wait":
+					d, err := time.ParseDuration(arg)
+					if err != nil {
+						t.Fatalf("bad event '%s': %v", e, err)
+					}
+					now = now.Add(d)
+				}
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.Expected) {
+				t.Errorf("expected %v, but got %v", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func Test_RetryLogThrottle_Nil(t *testing.T) {
+	var rt *retryLogThrottle
+	for i := 0; i < 3; i++ {
+		if !rt.allow(&frog.NullLogger{}, "http://a/file") {
+			t.Fatalf("expected a nil throttle to log every retry")
+		}
+	}
+	rt.flush(&frog.NullLogger{})
+}
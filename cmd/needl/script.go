@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	natomic "github.com/natefinch/atomic"
+)
+
+// scriptEntry is a single file to download in a script written by --emit-script
+type scriptEntry struct {
+	Name      string
+	URL       string
+	Timestamp time.Time // zero if unknown
+	Changed   bool      // the local file exists, but is different
+}
+
+// writeScriptFile writes the script to path, via a temp file (so a reader never sees a partial file)
+func writeScriptFile(path, dir string, auth bool, entries []scriptEntry) error {
+	var b bytes.Buffer
+	writeScript(&b, dir, auth, entries)
+	if err := natomic.WriteFile(path, &b); err != nil {
+		return fmt.Errorf("write '%s': %w", path, err)
+	}
+	return nil
+}
+
+// writeScript writes a POSIX shell script that uses curl to download each entry into dir (or into
+// the script's first argument, if it is given), resuming any partial download, and then sets each
+// file's modification time. If auth is set, then the script sends HTTP basic auth from $NEEDL_USER,
+// so that the password isn't written into the script.
+func writeScript(w io.Writer, dir string, auth bool, entries []scriptEntry) {
+	fmt.Fprintf(w, "#!/bin/sh\n")
+	fmt.Fprintf(w, "# Downloads the %d file(s) that needl found missing or changed.\n", len(entries))
+	fmt.Fprintf(w, "# Usage: sh <this script> [<download_path>]\n")
+	fmt.Fprintf(w, "set -e\n")
+	curlArgs := "-fL --retry 5 -C - --create-dirs"
+	if auth {
+		fmt.Fprintf(w, ": \"${NEEDL_USER:?set NEEDL_USER to 'username:password'}\"\n")
+		curlArgs += ` -u "$NEEDL_USER"`
+	}
+	fmt.Fprintf(w, "dir=${1:-%s}\n", shellQuote(dir))
+	fmt.Fprintf(w, "mkdir -p -- \"$dir\"\n")
+	fmt.Fprintf(w, "cd -- \"$dir\"\n")
+
+	for _, e := range entries {
+		name := shellQuote(e.Name)
+		fmt.Fprintf(w, "\n")
+		if e.Changed {
+			// a different file can't be resumed from, so start over
+			fmt.Fprintf(w, "rm -f -- %s\n", name)
+		}
+		fmt.Fprintf(w, "curl %s -o %s %s\n", curlArgs, name, shellQuote(e.URL))
+		if !e.Timestamp.IsZero() {
+			fmt.Fprintf(w, "touch -m -d %s -- %s\n", shellQuote(e.Timestamp.UTC().Format("2006-01-02T15:04:05Z")), name)
+		}
+	}
+}
+
+// shellQuote quotes s for a POSIX shell, as a single argument
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
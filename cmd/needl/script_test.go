@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_WriteScript(t *testing.T) {
+	entries := []scriptEntry{
+		{Name: "a.zip", URL: "https://example.com/a.zip", Timestamp: time.Date(2023, 10, 5, 12, 0, 0, 0, time.UTC)},
+		{Name: "it's changed.txt", URL: "https://example.com/it%27s%20changed.txt", Changed: true},
+	}
+
+	var b bytes.Buffer
+	writeScript(&b, "/data/dl", true, entries)
+
+	expected := `#!/bin/sh
+# Downloads the 2 file(s) that needl found missing or changed.
+# Usage: sh <this script> [<download_path>]
+set -e
+: "${NEEDL_USER:?set NEEDL_USER to 'username:password'}"
+dir=${1:-'/data/dl'}
+mkdir -p -- "$dir"
+cd -- "$dir"
+
+curl -fL --retry 5 -C - --create-dirs -u "$NEEDL_USER" -o 'a.zip' 'https://example.com/a.zip'
+touch -m -d '2023-10-05T12:00:00Z' -- 'a.zip'
+
+rm -f -- 'it'\''s changed.txt'
+curl -fL --retry 5 -C - --create-dirs -u "$NEEDL_USER" -o 'it'\''s changed.txt' 'https://example.com/it%27s%20changed.txt'
+`
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, b.String())
+	}
+}
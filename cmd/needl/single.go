@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+// downloadSingle downloads just the one URL to localPath, without any config or scrapers, and verifies
+// it against the checksum (if set). It returns the exit status: 60 if the download failed, or 61 if it
+// didn't match its checksum (in which case nothing is written to localPath). If xattrs is set, then
+// the download's provenance is recorded in its extended attributes.
+func downloadSingle(ctx context.Context, log frog.Logger, remoteURL, localPath string, opts DownloadOptions, xattrs bool) int {
+	log.Info("Start download", frog.String("url", remoteURL), frog.Path(localPath))
+	start := time.Now()
+	res, err := DownloadToFile(ctx, log, remoteURL, localPath, opts)
+	elapsed := time.Since(start)
+	fields := []frog.Fielder{
+		frog.Int64("size", res.ActualSize), frog.Int64("resumed", res.ResumedSize), frog.Uint("retries", res.Retries),
+		frog.Dur("elapsed", elapsed), frog.String("speed", formatSpeed(float64(res.ActualSize-res.ResumedSize)/elapsed.Seconds())),
+	}
+	if err != nil {
+		fields = append(fields, frog.String("url", remoteURL), frog.Err(err))
+		if errors.Is(err, errChecksumMismatch) {
+			log.Error("Download failed verification", fields...)
+			return 61
+		}
+		log.Error("Download failed", fields...)
+		return 60
+	}
+	if !opts.Checksum.IsZero() {
+		fields = append(fields, frog.String(opts.Checksum.Algo, "verified"))
+	}
+	if xattrs {
+		recordProvenance(log, res.Path, provenance{SourceURL: remoteURL, Downloaded: time.Now(), Checksum: opts.Checksum})
+	}
+	log.Info("File written", append(fields, frog.Path(res.Path))...)
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_DownloadSingle(t *testing.T) {
+	content := testContent(t, 5000)
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file.bin" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", modTime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Path     string
+		Checksum Checksum
+		Expected int
+	}{
+		{"verified", "/file.bin", sha256Checksum(content), 0},
+		{"unverified", "/file.bin", Checksum{}, 0},
+		{"mismatch", "/file.bin", sha256Checksum(content[1:]), 61},
+		{"not found", "/missing.bin", Checksum{}, 60},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.bin")
+			status := downloadSingle(context.Background(), &frog.NullLogger{}, srv.URL+tc.Path, path,
+				DownloadOptions{Checksum: tc.Checksum, MaxRetry: 1}, false,
+			)
+			if status != tc.Expected {
+				t.Fatalf("expected status %d, but got %d", tc.Expected, status)
+			}
+			actual, err := os.ReadFile(path)
+			if tc.Expected != 0 {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("expected nothing to be written, but got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error reading download: %v", err)
+			}
+			if !bytes.Equal(actual, content) {
+				t.Errorf("downloaded content does not match")
+			}
+		})
+	}
+}
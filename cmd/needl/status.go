@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+// statusShutdownTimeout is how long the status server waits for requests in flight, as it shuts down
+const statusShutdownTimeout = 5 * time.Second
+
+// runPhase is what a run is doing, as reported by the status server
+type runPhase string
+
+const (
+	phaseScraping    runPhase = "scraping"    // listing the local and remote files
+	phaseDiffing     runPhase = "diffing"     // comparing them (while the first downloads start)
+	phaseDownloading runPhase = "downloading" // waiting for the rest of the downloads
+	phaseIdle        runPhase = "idle"        // between passes (such as while trickle sleeps), or done
+)
+
+// runStatus tracks what a (possibly long-running) run is doing, for --status-addr. Each cycle is one
+// pass, which in trickle mode is one batch. A nil runStatus ignores every update.
+type runStatus struct {
+	stats *runStats
+	now   func() time.Time
+
+	mu          sync.Mutex
+	phase       runPhase
+	pending     int64 // files found to download this cycle
+	startDone   int64 // files done (downloaded, failed, etc) as of the start of the cycle
+	startBytes  int64 // bytes downloaded as of the start of the cycle
+	cycleStart  time.Time
+	lastErr     string
+	lastErrTime time.Time
+	lastSuccess time.Time
+}
+
+// statusReport is the JSON that the status server responds with
+type statusReport struct {
+	Phase          runPhase   `json:"phase"`
+	FilesRemaining int64      `json:"files_remaining"`
+	BytesThisCycle int64      `json:"bytes_this_cycle"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastErrorTime  *time.Time `json:"last_error_time,omitempty"`
+	LastSuccess    *time.Time `json:"last_success,omitempty"`
+}
+
+func newRunStatus(stats *runStats) *runStatus {
+	return &runStatus{stats: stats, now: time.Now, phase: phaseIdle}
+}
+
+// filesDone counts the files that won't be downloaded again this run, one way or another
+func (s *runStatus) filesDone() int64 {
+	return s.stats.filesDownloaded.Load() + s.stats.filesFailed.Load() + s.stats.filesCanceled.Load() +
+		s.stats.filesLocked.Load()
+}
+
+// startCycle is called at the start of each pass
+func (s *runStatus) startCycle() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phaseScraping
+	s.cycleStart = s.now()
+	s.pending = 0
+	s.startDone = s.filesDone()
+	s.startBytes = s.stats.bytesDownloaded.Load()
+}
+
+func (s *runStatus) setPhase(p runPhase) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = p
+}
+
+// addPending counts a file that the diff found to download (including any left for a later batch)
+func (s *runStatus) addPending() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending++
+}
+
+func (s *runStatus) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err.Error()
+	s.lastErrTime = s.now()
+}
+
+// endCycle is called at the end of each pass, with its exit status. A pass that exited with zero,
+// and had no files fail, is a success.
+func (s *runStatus) endCycle(status int) {
+	if s == nil {
+		return
+	}
+	if status != 0 {
+		s.setError(fmt.Errorf("pass exited with status %d", status))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phaseIdle
+	if status == 0 && s.lastErrTime.Before(s.cycleStart) {
+		s.lastSuccess = s.now()
+	}
+}
+
+func (s *runStatus) report() statusReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := statusReport{
+		Phase:          s.phase,
+		FilesRemaining: max(s.pending-(s.filesDone()-s.startDone), 0),
+		BytesThisCycle: s.stats.bytesDownloaded.Load() - s.startBytes,
+		LastError:      s.lastErr,
+	}
+	if !s.lastErrTime.IsZero() {
+		t := s.lastErrTime.UTC()
+		r.LastErrorTime = &t
+	}
+	if !s.lastSuccess.IsZero() {
+		t := s.lastSuccess.UTC()
+		r.LastSuccess = &t
+	}
+	return r
+}
+
+func (s *runStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := json.Marshal(s.report())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(b, '\n'))
+}
+
+// serveStatus starts serving the status as JSON on addr (such as ":8080"), and returns a func that
+// shuts the server down, waiting briefly for any requests in flight.
+func serveStatus(log frog.Logger, addr string, s *runStatus) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Warning("status server stopped", frog.Err(err))
+		}
+	}()
+	log.Info("Serving status", frog.String("addr", ln.Addr().String()))
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Verbose("status server shutdown", frog.Err(err))
+		}
+		<-done
+	}, nil
+}
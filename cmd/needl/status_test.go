@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_RunStatus(t *testing.T) {
+	var stats runStats
+	s := newRunStatus(&stats)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	report := func() statusReport {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, but got %d", rec.Code)
+		}
+		var r statusReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("error decoding report: %v", err)
+		}
+		return r
+	}
+
+	if r := report(); r.Phase != phaseIdle || r.LastSuccess != nil {
+		t.Errorf("expected a new status to be idle, without a last success, but got %+v", r)
+	}
+
+	// a cycle with a failed file isn't a success
+	stats.bytesDownloaded.Store(100)
+	s.startCycle()
+	s.setPhase(phaseDiffing)
+	s.addPending()
+	s.addPending()
+	s.addPending()
+	stats.filesDownloaded.Add(1)
+	stats.bytesDownloaded.Add(50)
+	s.setPhase(phaseDownloading)
+	r := report()
+	if r.Phase != phaseDownloading || r.FilesRemaining != 2 || r.BytesThisCycle != 50 {
+		t.Errorf("expected downloading, with 2 files left and 50 bytes, but got %+v", r)
+	}
+	stats.filesFailed.Add(1)
+	s.setError(errors.New("boom"))
+	s.endCycle(0)
+	r = report()
+	if r.Phase != phaseIdle || r.LastError != "boom" || r.LastErrorTime == nil || r.LastSuccess != nil {
+		t.Errorf("expected idle, with the error, and no success, but got %+v", r)
+	}
+
+	// the next cycle starts its counts over, and succeeds
+	now = now.Add(time.Minute)
+	s.startCycle()
+	s.addPending()
+	stats.filesDownloaded.Add(1)
+	s.endCycle(0)
+	r = report()
+	if r.FilesRemaining != 0 || r.BytesThisCycle != 0 || r.LastSuccess == nil || !r.LastSuccess.Equal(now) {
+		t.Errorf("expected no files left, and a success at %v, but got %+v", now, r)
+	}
+	if r.LastError != "boom" {
+		t.Errorf("expected the last error to be kept, but got '%s'", r.LastError)
+	}
+
+	// a pass that fails records its status as the error
+	now = now.Add(time.Minute)
+	s.startCycle()
+	s.endCycle(30)
+	if r := report(); r.LastError != "pass exited with status 30" || !r.LastSuccess.Equal(now.Add(-time.Minute)) {
+		t.Errorf("expected the failed pass to be the last error, but got %+v", r)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for a POST, but got %d", rec.Code)
+	}
+}
+
+func Test_RunStatus_Nil(t *testing.T) {
+	// a run without --status-addr has no status, which ignores every update
+	var s *runStatus
+	s.startCycle()
+	s.setPhase(phaseDiffing)
+	s.addPending()
+	s.setError(errors.New("boom"))
+	s.endCycle(1)
+}
+
+func Test_ServeStatus(t *testing.T) {
+	var stats runStats
+	s := newRunStatus(&stats)
+	stop, err := serveStatus(&frog.NullLogger{}, "127.0.0.1:0", s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stop()
+
+	if _, err := serveStatus(&frog.NullLogger{}, "not an address", s); err == nil {
+		t.Errorf("expected an error for a bad address")
+	}
+}
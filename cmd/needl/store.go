@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/danbrakeley/frog"
+)
+
+// storeFile moves a downloaded file into the content-addressed store at storeDir, as
+// storeDir/<first 2 hex digits>/<sha256>, and replaces the file at localPath with a link to it.
+// If the store already has a file with the same content, then the download is discarded, and
+// the link points at the existing copy.
+// The link is a relative symlink when possible, falling back to a hard link (for example
+// on Windows without the privilege to create symlinks).
+// Returns the path of the file in the store, and whether it was already there.
+func storeFile(log frog.Logger, storeDir, localPath string) (string, bool, error) {
+	digest, err := sha256File(localPath)
+	if err != nil {
+		return "", false, err
+	}
+	objPath := filepath.Join(storeDir, digest[:2], digest)
+
+	existed := false
+	if _, err := os.Stat(objPath); err == nil {
+		existed = true
+		if err := os.Remove(localPath); err != nil {
+			return "", false, fmt.Errorf("remove duplicate download: %w", err)
+		}
+	} else if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(objPath), 0o755); err != nil {
+			return "", false, fmt.Errorf("create store dir: %w", err)
+		}
+		if err := moveFile(log, localPath, objPath); err != nil {
+			return "", false, fmt.Errorf("move into store: %w", err)
+		}
+	} else {
+		return "", false, err
+	}
+
+	if err := linkToStore(objPath, localPath); err != nil {
+		return "", false, err
+	}
+	return objPath, existed, nil
+}
+
+// linkToStore creates (or replaces) a link at localPath that points at objPath.
+func linkToStore(objPath, localPath string) error {
+	absObj, err := filepath.Abs(objPath)
+	if err != nil {
+		return err
+	}
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Rel(filepath.Dir(absLocal), absObj)
+	if err != nil {
+		target = absObj
+	}
+
+	// create the link beside the final path, then rename it into place
+	tmpPath := localPath + ".link"
+	_ = os.Remove(tmpPath)
+	if err := os.Symlink(target, tmpPath); err != nil {
+		if err := os.Link(absObj, tmpPath); err != nil {
+			return fmt.Errorf("link '%s' to store: %w", localPath, err)
+		}
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("link '%s' to store: %w", localPath, err)
+	}
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read '%s': %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+func Test_StoreFile_Dedup(t *testing.T) {
+	dir := t.TempDir()
+	storeDir := filepath.Join(dir, "store")
+	localDir := filepath.Join(dir, "local")
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	content := testContent(t, 1000)
+	var objPaths []string
+	for i, name := range []string{"a.bin", "b.bin"} {
+		path := filepath.Join(localDir, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		objPath, existed, err := storeFile(&frog.NullLogger{}, storeDir, path)
+		if err != nil {
+			t.Fatalf("unexpected error storing %s: %v", name, err)
+		}
+		if existed != (i > 0) {
+			t.Errorf("%s: expected existed to be %v, but got %v", name, i > 0, existed)
+		}
+		objPaths = append(objPaths, objPath)
+
+		actual, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error reading link %s: %v", name, err)
+		}
+		if !bytes.Equal(actual, content) {
+			t.Errorf("%s: content through link does not match", name)
+		}
+	}
+	if objPaths[0] != objPaths[1] {
+		t.Errorf("expected both files to share '%s', but got '%s'", objPaths[0], objPaths[1])
+	}
+
+	locals, err := plan.ListLocals(localDir, false)
+	if err != nil {
+		t.Fatalf("unexpected error listing locals: %v", err)
+	}
+	if len(locals) != 2 {
+		t.Fatalf("expected 2 locals, but got %d", len(locals))
+	}
+	for _, l := range locals {
+		if l.Size != int64(len(content)) {
+			t.Errorf("%s: expected size %d, but got %d", l.Name, len(content), l.Size)
+		}
+	}
+}
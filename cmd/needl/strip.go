@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// strippedChecksums looks up checksums (which are listed under the remote files' names) by the names
+// that StripNames left, by putting the prefix and suffix back on any name that isn't found as is.
+type strippedChecksums struct {
+	p      scraper.ChecksumProvider
+	prefix string
+	suffix string
+}
+
+func (s strippedChecksums) ChecksumFor(name string) (string, string, bool) {
+	if algo, hex, ok := s.p.ChecksumFor(name); ok {
+		return algo, hex, true
+	}
+	dir, base := path.Split(name)
+	return s.p.ChecksumFor(dir + s.prefix + base + s.suffix)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_StrippedChecksums(t *testing.T) {
+	p := strippedChecksums{
+		p: scraper.Checksums{
+			"item_a.zip": {Algo: "sha256", Hex: "aa"},
+			"b.zip":      {Algo: "sha256", Hex: "bb"},
+		},
+		prefix: "item_",
+	}
+	cases := []struct {
+		Name     string
+		Expected string // empty if not found
+	}{
+		{"a.zip", "aa"},
+		{"item_a.zip", "aa"},
+		{"dir/a.zip", ""},
+		{"b.zip", "bb"},
+		{"c.zip", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, hex, ok := p.ChecksumFor(tc.Name)
+			if ok != (len(tc.Expected) > 0) || hex != tc.Expected {
+				t.Errorf("expected '%s', but got '%s' (found: %v)", tc.Expected, hex, ok)
+			}
+		})
+	}
+	if c, ok := checksumFor(p, "dir/a.zip"); !ok || c.Hex != "aa" {
+		t.Errorf("expected checksumFor to find 'dir/a.zip' by its base name, but got %v (found: %v)", c, ok)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/danbrakeley/frog"
+	"github.com/dustin/go-humanize"
+)
+
+// summaryRow is a single named count in the end of run summary
+type summaryRow struct {
+	key   string // for structured output
+	label string // for the table
+	value int64
+}
+
+func summaryRows(stats *runStats) []summaryRow {
+	rows := []summaryRow{
+		{"checked", "Remote files checked", stats.filesChecked.Load()},
+		{"unchanged", "Skipped (unchanged)", stats.filesUnchanged.Load()},
+		{"missing", "Missing locally", stats.filesMissing.Load()},
+		{"changed", "Changed", stats.filesChanged.Load()},
+	}
+	// only show verification counts when --verify-checksums found something to verify
+	if v, m := stats.filesVerified.Load(), stats.filesMismatched.Load(); v+m > 0 {
+		rows = append(rows,
+			summaryRow{"verified", "Checksum verified", v},
+			summaryRow{"mismatched", "Checksum mismatched", m},
+		)
+	}
+	rows = append(rows,
+		summaryRow{"downloaded", "Downloaded", stats.filesDownloaded.Load()},
+		summaryRow{"failed", "Failed", stats.filesFailed.Load()},
+	)
+	// only call out files that --lock skipped when there were some
+	if n := stats.filesLocked.Load(); n > 0 {
+		rows = append(rows, summaryRow{"locked", "Skipped (in progress elsewhere)", n})
+	}
+	// only call out local edits that local_newer kept when there were some
+	if n := stats.filesLocalNewer.Load(); n > 0 {
+		rows = append(rows, summaryRow{"local_newer", "Skipped (newer locally)", n})
+	}
+	// only call out the local times that size_only fixed when there were some
+	if n := stats.filesRetimed.Load(); n > 0 {
+		rows = append(rows, summaryRow{"retimed", "Time fixed (same size)", n})
+	}
+	// only call out the files that disappeared from the remote (for listing_state) when there were some
+	if n := stats.filesRemoved.Load(); n > 0 {
+		rows = append(rows, summaryRow{"removed", "Removed from remote", n})
+	}
+	// only call out the local files that match_by renamed when there were some
+	if n := stats.filesRenamed.Load(); n > 0 {
+		rows = append(rows, summaryRow{"renamed", "Renamed to match remote", n})
+	}
+	// only call out the time limit (or --fail-fast) when it actually cut the run short
+	if n := stats.filesCanceled.Load() + stats.filesNotStarted.Load(); n > 0 {
+		rows = append(rows, summaryRow{"time_limited", "Not finished (stopped early)", n})
+	}
+	rows = append(rows,
+		summaryRow{"extra", "Extra (local only)", stats.filesExtra.Load()},
+		summaryRow{"bytes", "Bytes downloaded", stats.bytesDownloaded.Load()},
+	)
+	// only call out the bytes that resumed downloads already had when there were some
+	if n := stats.bytesResumed.Load(); n > 0 {
+		rows = append(rows, summaryRow{"bytes_resumed", "Bytes resumed (from earlier runs)", n})
+	}
+	return rows
+}
+
+// logSummary emits the summary as a single log line with structured fields (for JSON logs)
+func logSummary(log frog.Logger, stats *runStats) {
+	rows := summaryRows(stats)
+	fields := make([]frog.Fielder, 0, len(rows))
+	for _, r := range rows {
+		fields = append(fields, frog.Int64(r.key, r.value))
+	}
+	log.Info("Summary", fields...)
+}
+
+// writeSummaryTable writes the summary as a human readable table
+func writeSummaryTable(w io.Writer, stats *runStats) {
+	rows := summaryRows(stats)
+	labelWidth, valueWidth := 0, 0
+	values := make([]string, len(rows))
+	for i, r := range rows {
+		values[i] = humanize.Comma(r.value)
+		labelWidth = max(labelWidth, len(r.label))
+		valueWidth = max(valueWidth, len(values[i]))
+	}
+
+	fmt.Fprintf(w, "\nSummary:\n")
+	for i, r := range rows {
+		fmt.Fprintf(w, "  %-*s  %*s", labelWidth+1, r.label+":", valueWidth, values[i])
+		if r.key == "bytes" {
+			fmt.Fprintf(w, " (%s)", humanize.Bytes(uint64(r.value)))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+// syncDir does nothing on windows, where a folder can't be flushed, and instead the rename
+// itself is written through to disk (by atomic.ReplaceFile).
+func syncDir(path string) error {
+	return nil
+}
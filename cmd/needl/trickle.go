@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+)
+
+const (
+	defaultTrickleBatch     = 10
+	defaultTrickleDelay     = 5 * time.Second
+	defaultTrickleInterval  = 15 * time.Minute
+	defaultTrickleRateLimit = "256KiB"
+)
+
+// applyTrickle sets up the config for a trickle run: one download (and one lookup) at a time, with
+// the trickle settings (and a rate limit) filled in from their defaults, if they aren't set.
+func applyTrickle(cfg *config.Config) {
+	cfg.Threads = 1
+	cfg.ScrapeThreads = 1
+	if cfg.TrickleBatch == 0 {
+		cfg.TrickleBatch = defaultTrickleBatch
+	}
+	if cfg.TrickleDelay == 0 {
+		cfg.TrickleDelay = defaultTrickleDelay
+	}
+	if cfg.TrickleInterval == 0 {
+		cfg.TrickleInterval = defaultTrickleInterval
+	}
+	if len(cfg.RateLimit) == 0 {
+		cfg.RateLimit = defaultTrickleRateLimit
+	}
+}
+
+// sleepCtx waits for d, and returns true, unless the context is canceled first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// runTrickle runs one pass (that downloads a batch) after another, sleeping for the TrickleInterval
+// in between, until a pass leaves nothing for the next batch (as reported by leftOver), or fails.
+// While sleeping, an interrupt (or SIGTERM) ends the run, as does ctx being done (at the max runtime).
+func runTrickle(ctx context.Context, log frog.Logger, cfg config.Config, runPass func() int, leftOver func() int) int {
+	for batch := 1; ; batch++ {
+		log.Info("Starting batch", frog.Int("batch", batch), frog.Int("max_files", cfg.TrickleBatch))
+		if status := runPass(); status != 0 {
+			return status
+		}
+		left := leftOver()
+		if left == 0 {
+			log.Info("Caught up", frog.Int("batches", batch))
+			return 0
+		}
+
+		log.Info("Sleeping before the next batch", frog.Int("files_left", left), frog.Dur("interval", cfg.TrickleInterval))
+		sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		slept := sleepCtx(sigCtx, cfg.TrickleInterval)
+		stop()
+		if !slept {
+			if ctx.Err() != nil {
+				log.Warning("Max runtime reached", frog.Int("files_left", left))
+				return 40
+			}
+			log.Info("Stopped before the next batch", frog.Int("files_left", left))
+			return 0
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+)
+
+func Test_ApplyTrickle(t *testing.T) {
+	cfg := config.Config{Threads: 8, ScrapeThreads: 8, TrickleBatch: 3}
+	applyTrickle(&cfg)
+	if cfg.Threads != 1 || cfg.ScrapeThreads != 1 {
+		t.Errorf("expected one thread, but got %d and %d", cfg.Threads, cfg.ScrapeThreads)
+	}
+	if cfg.TrickleBatch != 3 {
+		t.Errorf("expected the batch to be kept, but got %d", cfg.TrickleBatch)
+	}
+	if cfg.TrickleDelay != defaultTrickleDelay || cfg.TrickleInterval != defaultTrickleInterval || cfg.RateLimit != defaultTrickleRateLimit {
+		t.Errorf("expected defaults, but got %v, %v, and %s", cfg.TrickleDelay, cfg.TrickleInterval, cfg.RateLimit)
+	}
+}
+
+func Test_RunTrickle(t *testing.T) {
+	cases := []struct {
+		Name           string
+		Left           []int // what each pass leaves for the next batch
+		Status         int   // what the last pass returns
+		ExpectedPasses int
+		ExpectedStatus int
+	}{
+		{"caught up at once", []int{0}, 0, 1, 0},
+		{"caught up after three", []int{5, 2, 0}, 0, 3, 0},
+		{"failed pass", []int{5, 5}, 30, 2, 30},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cfg := config.Config{TrickleBatch: 1, TrickleInterval: time.Millisecond}
+			var passes int
+			runPass := func() int {
+				passes++
+				if passes == len(tc.Left) {
+					return tc.Status
+				}
+				return 0
+			}
+			leftOver := func() int { return tc.Left[passes-1] }
+			status := runTrickle(context.Background(), &frog.NullLogger{}, cfg, runPass, leftOver)
+			if passes != tc.ExpectedPasses || status != tc.ExpectedStatus {
+				t.Errorf("expected %d passes and status %d, but got %d and %d", tc.ExpectedPasses, tc.ExpectedStatus, passes, status)
+			}
+		})
+	}
+
+	// the max runtime can end the sleep between batches
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cfg := config.Config{TrickleBatch: 1, TrickleInterval: time.Hour}
+	status := runTrickle(ctx, &frog.NullLogger{}, cfg, func() int { return 0 }, func() int { return 1 })
+	if status != 40 {
+		t.Errorf("expected status 40, but got %d", status)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+func Test_ScraperClient_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "needl.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	content := testContent(t, 1000)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	})}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	if client := plan.ScraperClient(config.Scraper{}); client != nil {
+		t.Errorf("expected no client without a socket or cookies")
+	}
+
+	path := filepath.Join(t.TempDir(), "file")
+	_, err = DownloadToFile(context.Background(), nil, "http://placeholder/file", path, DownloadOptions{
+		Client: plan.ScraperClient(config.Scraper{UnixSocket: socket}),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if len(actual) != len(content) {
+		t.Errorf("expected %d bytes, but got %d", len(content), len(actual))
+	}
+}
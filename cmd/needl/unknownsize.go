@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danbrakeley/frog"
+)
+
+// unknownSizePolicy decides what happens to a local file whose remote file has an unknown size (and
+// either the same timestamp, or none), which the diff would otherwise consider unchanged.
+type unknownSizePolicy string
+
+const (
+	unknownSizeSkip     unknownSizePolicy = "skip"     // assume it's unchanged (the default)
+	unknownSizeHead     unknownSizePolicy = "head"     // look up its size with a HEAD request, and compare that
+	unknownSizeChecksum unknownSizePolicy = "checksum" // verify it against its checksum, if one is known
+)
+
+func parseUnknownSizePolicy(s string) (unknownSizePolicy, error) {
+	switch unknownSizePolicy(s) {
+	case "":
+		return unknownSizeSkip, nil
+	case unknownSizeSkip, unknownSizeHead, unknownSizeChecksum:
+		return unknownSizePolicy(s), nil
+	}
+	return "", fmt.Errorf("unrecognized unknown_size policy '%s' (expected one of: %s, %s, %s)",
+		s, unknownSizeSkip, unknownSizeHead, unknownSizeChecksum)
+}
+
+// headRemoteSize sends a HEAD request for the remote URL, and returns the size from its
+// Content-Length, or -1 if it didn't give a non-zero one. Only the request options (such as the client and headers)
+// are used from opts.
+func headRemoteSize(ctx context.Context, log frog.Logger, remoteURL string, opts DownloadOptions) (int64, error) {
+	opts.ExpectedSize = -1
+	dc := downloadContext{remoteURL: remoteURL, opts: opts, finalURL: remoteURL}
+	if err := dc.head(ctx, log); err != nil {
+		return -1, err
+	}
+	return dc.opts.ExpectedSize, nil
+}
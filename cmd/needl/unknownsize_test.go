@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_ParseUnknownSizePolicy(t *testing.T) {
+	cases := []struct {
+		Input       string
+		Expected    unknownSizePolicy
+		ExpectedErr bool
+	}{
+		{"", unknownSizeSkip, false},
+		{"skip", unknownSizeSkip, false},
+		{"head", unknownSizeHead, false},
+		{"checksum", unknownSizeChecksum, false},
+		{"get", "", true},
+	}
+	for _, tc := range cases {
+		actual, err := parseUnknownSizePolicy(tc.Input)
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("'%s': expected error %v, but got %v", tc.Input, tc.ExpectedErr, err)
+		}
+		if actual != tc.Expected {
+			t.Errorf("'%s': expected '%s', but got '%s'", tc.Input, tc.Expected, actual)
+		}
+	}
+}
+
+func Test_DiffFilesFunc_UnknownSize(t *testing.T) {
+	locals := []plan.LocalFile{
+		localFile(t, "a", "2020-01-01 00:00", 10),
+		localFile(t, "b", "2020-01-01 00:00", 10),
+		localFile(t, "c", "2020-01-01 00:00", 0),
+	}
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "a", "2020-01-01 00:00", -1),
+		remoteFile(t, "b", "2020-02-01 00:00", -1),
+		remoteFile(t, "c", "2020-01-01 00:00", -1),
+	}
+	expected := map[string]plan.DiffKind{"a": plan.DiffUnknownSize, "b": plan.DiffChanged, "c": plan.DiffChanged}
+	plan.DiffSortedFilesFunc(locals, remotes, func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile) {
+		if kind != expected[remote.Name] {
+			t.Errorf("%s: expected kind %d, but got %d", remote.Name, expected[remote.Name], kind)
+		}
+	})
+}
+
+func Test_HeadRemoteSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected a HEAD request, but got %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/sized":
+			w.Header().Set("Content-Length", "1234")
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Path        string
+		Expected    int64
+		ExpectedErr bool
+	}{
+		{"/sized", 1234, false},
+		{"/unsized", -1, false},
+		{"/missing", -1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Path, func(t *testing.T) {
+			size, err := headRemoteSize(context.Background(), &frog.NullLogger{}, srv.URL+tc.Path, DownloadOptions{})
+			if (err != nil) != tc.ExpectedErr {
+				t.Errorf("expected error %v, but got %v", tc.ExpectedErr, err)
+			}
+			if size != tc.Expected {
+				t.Errorf("expected size %d, but got %d", tc.Expected, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// checksumSourceScraper is the checksums entry that asks the scraper itself for checksums
+const checksumSourceScraper = "scraper"
+
+// loadChecksums loads each of the scraper's checksum sources, and returns them as a single provider
+// (which checks each source in the order they are listed), or nil if there are no sources.
+// Each source is either "scraper" (for scrapers that implement scraper.ChecksumSource), or the path
+// or http(s) URL of a sums file (as written by sha256sum, etc) or JSON manifest.
+func loadChecksums(log frog.Logger, scfg config.Scraper, opts ...scraper.Option) (scraper.ChecksumProvider, error) {
+	if len(scfg.Checksums) == 0 {
+		return nil, nil
+	}
+
+	var providers scraper.MultiChecksums
+	for _, src := range scfg.Checksums {
+		if src != checksumSourceScraper {
+			log.Info("Loading checksums...", frog.String("source", src))
+			p, err := loadChecksumFile(src)
+			if err != nil {
+				return nil, fmt.Errorf("checksums '%s': %w", src, err)
+			}
+			providers = append(providers, p)
+			continue
+		}
+
+		for _, u := range scfg.BaseURLs() {
+			log.Info("Loading checksums...", frog.String("url", u))
+			s, err := scraper.Create(scfg.Type, append([]scraper.Option{scraper.BaseURL(u)}, opts...)...)
+			if err != nil {
+				return nil, fmt.Errorf("config error creating scraper of type '%s': %w", scfg.Type, err)
+			}
+			cs, ok := s.(scraper.ChecksumSource)
+			if !ok {
+				return nil, fmt.Errorf("scraper type '%s' does not provide checksums", scfg.Type)
+			}
+			p, err := cs.ScrapeChecksums()
+			if err != nil {
+				return nil, fmt.Errorf("error while scraping checksums for '%s': %w", u, err)
+			}
+			providers = append(providers, p)
+		}
+	}
+	return providers, nil
+}
+
+// loadChecksumFile reads a sums file or JSON manifest from a local path or http(s) URL.
+// A JSON manifest is recognized by its first non-space character being '{'.
+func loadChecksumFile(src string) (scraper.Checksums, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := http.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected request status %d", resp.StatusCode)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return scraper.ParseJSONManifest(bytes.NewReader(data), "")
+	}
+	return scraper.ParseSumsFile(bytes.NewReader(data))
+}
+
+// checksumFor returns the expected checksum of the named remote file, if p knows it. The name should
+// be the one the file was listed under (see RemoteFile.ListedName), since that's what p lists it under.
+// If the name includes a folder (from the layout) that p doesn't know about, then it is ignored.
+func checksumFor(p scraper.ChecksumProvider, name string) (Checksum, bool) {
+	if p == nil {
+		return Checksum{}, false
+	}
+	algo, hex, ok := p.ChecksumFor(name)
+	if !ok && strings.Contains(name, "/") {
+		algo, hex, ok = p.ChecksumFor(path.Base(name))
+	}
+	if !ok {
+		return Checksum{}, false
+	}
+	return Checksum{Algo: algo, Hex: hex}, true
+}
+
+// verifyLocalChecksum hashes the local copy of a remote file that the diff considered unchanged, and
+// compares it to the remote file's expected checksum. It returns whether the local file doesn't match
+// (and so should be downloaded again), and whether the remote file's checksum is known at all.
+// A Compressed local file is decompressed as it is hashed, but a Decompressed one can't be verified
+// (since the checksum is of the compressed file). If the file's digest is in the index (and its size and
+// modification time haven't changed since), then it isn't hashed again.
+func verifyLocalChecksum(
+	log frog.Logger, localPath string, p scraper.ChecksumProvider, ix *checksumIndex, stats *runStats,
+	l plan.LocalFile, r scraper.RemoteFile,
+) (mismatch, known bool) {
+	c, ok := checksumFor(p, r.ListedName())
+	if !ok || l.Decompressed {
+		return false, false
+	}
+	path := filepath.Join(localPath, filepath.FromSlash(l.FileName()))
+	err := verifyIndexed(log, path, c, l.Compressed, ix)
+	if err != nil {
+		stats.filesMismatched.Add(1)
+		log.Warning("Local file checksum mismatch", frog.String("name", r.Name), frog.Err(err))
+		return true, true
+	}
+	stats.filesVerified.Add(1)
+	return false, true
+}
+
+// verifyIndexed compares the file at path to the checksum, using its digest from the index if it is
+// still current, or else hashing it (and storing the digest in the index)
+func verifyIndexed(log frog.Logger, path string, c Checksum, compressed bool, ix *checksumIndex) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if actual, ok := ix.lookup(path, info, c.Algo); ok {
+		log.Transient("using indexed checksum", frog.String("algo", c.Algo), frog.Path(path))
+		return c.VerifyHex(actual)
+	}
+
+	log.Transient("verifying checksum", frog.String("algo", c.Algo), frog.Path(path))
+	digest := fileDigest
+	if compressed {
+		digest = gzipDigest
+	}
+	actual, err := digest(path, c.Algo)
+	if err != nil {
+		return err
+	}
+	ix.store(path, info, c.Algo, actual)
+	return c.VerifyHex(actual)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_VerifyLocalChecksum(t *testing.T) {
+	dir := t.TempDir()
+	good := testContent(t, 100)
+	bad := testContent(t, 200)
+	for name, b := range map[string][]byte{"good": good, "bad": bad, "unknown": good} {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeGzipFile(t, filepath.Join(dir, "zipped.gz"), good)
+	goodSum := sha256Checksum(good)
+	sums := scraper.Checksums{
+		"good":   {Algo: goodSum.Algo, Hex: goodSum.Hex},
+		"bad":    {Algo: goodSum.Algo, Hex: goodSum.Hex},
+		"zipped": {Algo: goodSum.Algo, Hex: goodSum.Hex},
+	}
+
+	cases := []struct {
+		Name             string
+		Compressed       bool
+		ExpectedMismatch bool
+		ExpectedKnown    bool
+	}{
+		{"bad", false, true, true},
+		{"good", false, false, true},
+		{"unknown", false, false, false},
+		{"zipped", true, false, true},
+	}
+
+	var stats runStats
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			local := localFile(t, tc.Name, "", -1)
+			local.Compressed = tc.Compressed
+			mismatch, known := verifyLocalChecksum(&frog.NullLogger{}, dir, sums, nil, &stats, local, remoteFile(t, tc.Name, "", -1))
+			if mismatch != tc.ExpectedMismatch {
+				t.Errorf("expected mismatch %v, but got %v", tc.ExpectedMismatch, mismatch)
+			}
+			if known != tc.ExpectedKnown {
+				t.Errorf("expected known %v, but got %v", tc.ExpectedKnown, known)
+			}
+		})
+	}
+	if n := stats.filesVerified.Load(); n != 2 {
+		t.Errorf("expected 2 verified, but got %d", n)
+	}
+	if n := stats.filesMismatched.Load(); n != 1 {
+		t.Errorf("expected 1 mismatched, but got %d", n)
+	}
+}
+
+func Test_VerifyLocalChecksum_TransformedName(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	good := testContent(t, 100)
+	bad := testContent(t, 200)
+	for name, b := range map[string][]byte{"GOOD.BIN": good, "BAD.BIN": bad} {
+		if err := os.WriteFile(filepath.Join(dir, "files", name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goodSum := sha256Checksum(good)
+	// the sums are listed under the names that were scraped, not the names they're saved as
+	sums := scraper.Checksums{
+		"good.bin": {Algo: goodSum.Algo, Hex: goodSum.Hex},
+		"bad.bin":  {Algo: goodSum.Algo, Hex: goodSum.Hex},
+	}
+
+	cfg := config.Config{
+		MaxNameLength: plan.DefaultMaxNameLength,
+		NameTransform: func(r scraper.RemoteFile) string { return "files/" + strings.ToUpper(r.Name) },
+	}
+	remotes := []scraper.RemoteFile{remoteFile(t, "bad.bin", "", -1), remoteFile(t, "good.bin", "", -1)}
+	dups, _ := plan.ParseDuplicatePolicy("")
+	longNames, _ := plan.ParseLongNamePolicy("")
+	remotes, err := plan.ResolveRemotes(&frog.NullLogger{}, cfg, remotes, dups, longNames)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedMismatch := map[string]bool{"files/BAD.BIN": true, "files/GOOD.BIN": false}
+	var stats runStats
+	for _, r := range remotes {
+		mismatch, known := verifyLocalChecksum(&frog.NullLogger{}, dir, sums, nil, &stats, localFile(t, r.Name, "", -1), r)
+		if !known {
+			t.Errorf("%s: expected its checksum to be found under '%s'", r.Name, r.OrigName)
+		}
+		if mismatch != expectedMismatch[r.Name] {
+			t.Errorf("%s: expected mismatch %v, but got %v", r.Name, expectedMismatch[r.Name], mismatch)
+		}
+	}
+}
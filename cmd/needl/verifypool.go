@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// checksumLookahead is how many compared files the diff may get ahead of the ones that it has handled,
+// while their checksums are being verified
+const checksumLookahead = 256
+
+// checksumPool verifies local checksums on up to a fixed number of goroutines at once, so that the
+// diff can hash several files while it waits for the first (see diffSortedFilesVerified).
+type checksumPool struct {
+	log   frog.Logger
+	sem   chan struct{}
+	start time.Time
+	wg    sync.WaitGroup
+	done  atomic.Int64 // verifications that have finished
+}
+
+// pendingChecksum is the result of a checksum verification, which may still be running
+type pendingChecksum struct {
+	done     chan struct{}
+	mismatch bool
+	known    bool
+}
+
+func newChecksumPool(log frog.Logger, threads int) *checksumPool {
+	return &checksumPool{log: log, sem: make(chan struct{}, max(threads, 1)), start: time.Now()}
+}
+
+// verify runs fn on the pool (once one of its goroutines is free), and returns its pending result
+func (p *checksumPool) verify(fn func() (mismatch, known bool)) *pendingChecksum {
+	c := &pendingChecksum{done: make(chan struct{})}
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.sem }()
+		c.mismatch, c.known = fn()
+		close(c.done)
+		p.log.Transient("verifying checksums", frog.Int64("verified", p.done.Add(1)), frog.Int("threads", cap(p.sem)))
+	}()
+	return c
+}
+
+// finished waits for any verifications that are still running (whose results weren't needed), and
+// logs how many files were verified, and how long it took
+func (p *checksumPool) finished() {
+	p.wg.Wait()
+	if n := p.done.Load(); n > 0 {
+		p.log.Info("Verified checksums", frog.Int64("files", n), frog.Int("threads", cap(p.sem)),
+			frog.Dur("elapsed", time.Since(p.start)),
+		)
+	}
+}
+
+func (c *pendingChecksum) wait() (mismatch, known bool) {
+	<-c.done
+	return c.mismatch, c.known
+}
+
+// diffSortedFilesVerified is plan.DiffSortedFilesFunc, except that for each compared file that prefetch
+// returns true for, verify is started on the pool, ahead of fn. fn is still called for each file in
+// sorted order (and on the caller's goroutine), with the file's pending result, or nil if none was
+// started, so the outcome doesn't depend on the order that the verifications finish in. With a nil
+// pool, nothing is started ahead.
+func diffSortedFilesVerified(
+	locals []plan.LocalFile,
+	remotes []scraper.RemoteFile,
+	pool *checksumPool,
+	prefetch func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile) bool,
+	verify func(local plan.LocalFile, remote scraper.RemoteFile) (mismatch, known bool),
+	fn func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile, sum *pendingChecksum),
+) {
+	if pool == nil {
+		plan.DiffSortedFilesFunc(locals, remotes, func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile) {
+			fn(kind, local, remote, nil)
+		})
+		return
+	}
+
+	type compared struct {
+		kind   plan.DiffKind
+		local  plan.LocalFile
+		remote scraper.RemoteFile
+		sum    *pendingChecksum
+	}
+	ahead := make(chan compared, checksumLookahead)
+	go func() {
+		defer close(ahead)
+		plan.DiffSortedFilesFunc(locals, remotes, func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile) {
+			var sum *pendingChecksum
+			if prefetch(kind, local, remote) {
+				sum = pool.verify(func() (bool, bool) {
+					return verify(local, remote)
+				})
+			}
+			ahead <- compared{kind, local, remote, sum}
+		})
+	}()
+	for c := range ahead {
+		fn(c.kind, c.local, c.remote, c.sum)
+	}
+	pool.finished()
+}
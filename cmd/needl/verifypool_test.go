@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/plan"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_DiffSortedFilesVerified(t *testing.T) {
+	var locals []plan.LocalFile
+	var remotes []scraper.RemoteFile
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		locals = append(locals, localFile(t, name, "2020-01-01 00:00", 10))
+		remotes = append(remotes, remoteFile(t, name, "2020-01-01 00:00", 10))
+	}
+	// 'c' differs, so it isn't verified, and 'z' is only remote
+	locals[2].Size = 5
+	remotes = append(remotes, remoteFile(t, "z", "2020-01-01 00:00", 10))
+
+	cases := []struct {
+		Name    string
+		Threads int
+	}{
+		{"no pool", 0},
+		{"one thread", 1},
+		{"four threads", 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var pool *checksumPool
+			if tc.Threads > 0 {
+				pool = newChecksumPool(&frog.NullLogger{}, tc.Threads)
+			}
+			var mu sync.Mutex
+			verified := map[string]bool{}
+			var running, maxRunning atomic.Int32
+			verify := func(local plan.LocalFile, remote scraper.RemoteFile) (bool, bool) {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					m := maxRunning.Load()
+					if n <= m || maxRunning.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				// finish the first files last, so that the order they are handled in can't follow them
+				time.Sleep(time.Duration(8-int(remote.Name[0]-'a')) * time.Millisecond)
+				mu.Lock()
+				verified[remote.Name] = true
+				mu.Unlock()
+				return remote.Name == "e", true
+			}
+			prefetch := func(kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile) bool {
+				return kind == plan.DiffUnchanged
+			}
+
+			var order []string
+			var mismatched []string
+			diffSortedFilesVerified(locals, remotes, pool, prefetch, verify, func(
+				kind plan.DiffKind, local plan.LocalFile, remote scraper.RemoteFile, sum *pendingChecksum,
+			) {
+				order = append(order, remote.Name)
+				if (sum != nil) != (kind == plan.DiffUnchanged && pool != nil) {
+					t.Errorf("%s: unexpected pending checksum %v for kind %d", remote.Name, sum, kind)
+				}
+				if sum == nil {
+					return
+				}
+				if mismatch, known := sum.wait(); mismatch {
+					mismatched = append(mismatched, remote.Name)
+				} else if !known {
+					t.Errorf("%s: expected a known checksum", remote.Name)
+				}
+			})
+
+			expectedOrder := []string{"a", "b", "c", "d", "e", "f", "g", "h", "z"}
+			if len(order) != len(expectedOrder) {
+				t.Fatalf("expected %v, but got %v", expectedOrder, order)
+			}
+			for i := range expectedOrder {
+				if order[i] != expectedOrder[i] {
+					t.Fatalf("expected %v, but got %v", expectedOrder, order)
+				}
+			}
+			if pool == nil {
+				if len(verified) != 0 {
+					t.Errorf("expected nothing verified ahead without a pool, but got %v", verified)
+				}
+				return
+			}
+			if len(verified) != 7 || verified["c"] || verified["z"] {
+				t.Errorf("expected only the unchanged files to be verified, but got %v", verified)
+			}
+			if len(mismatched) != 1 || mismatched[0] != "e" {
+				t.Errorf("expected only 'e' to mismatch, but got %v", mismatched)
+			}
+			if n := maxRunning.Load(); int(n) > tc.Threads {
+				t.Errorf("expected at most %d verifications at once, but saw %d", tc.Threads, n)
+			}
+		})
+	}
+}
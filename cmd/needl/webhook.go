@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// webhookTimeout limits how long the end of run webhook may take, so that it can't hold up the run
+const webhookTimeout = 10 * time.Second
+
+// webhookPolicy decides which runs the webhook is sent for
+type webhookPolicy string
+
+const (
+	webhookAlways  webhookPolicy = "always"  // every run (the default)
+	webhookFailure webhookPolicy = "failure" // only runs that failed
+)
+
+func parseWebhookPolicy(s string) (webhookPolicy, error) {
+	switch webhookPolicy(s) {
+	case "":
+		return webhookAlways, nil
+	case webhookAlways, webhookFailure:
+		return webhookPolicy(s), nil
+	}
+	return "", fmt.Errorf("unrecognized webhook_on policy '%s' (expected one of: %s, %s)",
+		s, webhookAlways, webhookFailure)
+}
+
+// webhookPayload is the JSON that is posted to the webhook at the end of a run. Text and Content
+// hold the same one line description, which is what Slack and Discord (respectively) show, and the
+// other fields are for anything else.
+type webhookPayload struct {
+	Text    string           `json:"text"`
+	Content string           `json:"content"`
+	Scraper string           `json:"scraper"`
+	Success bool             `json:"success"`
+	Seconds float64          `json:"seconds"`
+	Summary map[string]int64 `json:"summary"`
+}
+
+func newWebhookPayload(scraperName string, stats *runStats, dur time.Duration, success bool) webhookPayload {
+	summary := make(map[string]int64)
+	for _, r := range summaryRows(stats) {
+		summary[r.key] = r.value
+	}
+	result := "succeeded"
+	if !success {
+		result = "failed"
+	}
+	text := fmt.Sprintf("needl sync of '%s' %s after %v: %d downloaded (%s), %d failed",
+		scraperName, result, dur.Round(time.Second), summary["downloaded"],
+		humanize.Bytes(uint64(summary["bytes"])), summary["failed"],
+	)
+	return webhookPayload{
+		Text:    text,
+		Content: text,
+		Scraper: scraperName,
+		Success: success,
+		Seconds: dur.Seconds(),
+		Summary: summary,
+	}
+}
+
+// sendWebhook posts the payload to webhookURL as JSON
+func sendWebhook(client *http.Client, webhookURL string, payload webhookPayload) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		// the error is logged, and the url's path usually holds the webhook's token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactWebhookURL(webhookURL)
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// redactWebhookURL returns just the scheme and host of the webhook's url, which are safe to log
+func redactWebhookURL(webhookURL string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil || len(u.Host) == 0 {
+		return "(redacted)"
+	}
+	return u.Scheme + "://" + u.Host + "/(redacted)"
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SendWebhook(t *testing.T) {
+	var got webhookPayload
+	var gotType string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("unexpected error decoding payload: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	var stats runStats
+	stats.filesDownloaded.Store(3)
+	stats.filesFailed.Store(1)
+	stats.bytesDownloaded.Store(2000000)
+	payload := newWebhookPayload("archive", &stats, 90*time.Second, false)
+	if err := sendWebhook(srv.Client(), srv.URL, payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotType != "application/json" {
+		t.Errorf("expected content type 'application/json', but got '%s'", gotType)
+	}
+	expectedText := "needl sync of 'archive' failed after 1m30s: 3 downloaded (2.0 MB), 1 failed"
+	if got.Text != expectedText || got.Content != expectedText {
+		t.Errorf("expected text and content '%s', but got '%s' and '%s'", expectedText, got.Text, got.Content)
+	}
+	if got.Scraper != "archive" || got.Success || got.Seconds != 90 {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+	if got.Summary["downloaded"] != 3 || got.Summary["failed"] != 1 || got.Summary["bytes"] != 2000000 {
+		t.Errorf("unexpected summary: %v", got.Summary)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sendWebhook(srv.Client(), srv.URL, payload); err == nil {
+		t.Errorf("expected an error for a failed webhook")
+	}
+}
+
+func Test_SendWebhook_RedactsURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	var stats runStats
+	payload := newWebhookPayload("archive", &stats, time.Second, true)
+	err := sendWebhook(&http.Client{}, srv.URL+"/services/T0/B0/secret-token", payload)
+	if err == nil {
+		t.Fatalf("expected an error for an unreachable webhook")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("expected the webhook's token to be redacted, but got: %v", err)
+	}
+	if !strings.Contains(err.Error(), strings.TrimPrefix(srv.URL, "http://")) {
+		t.Errorf("expected the webhook's host in the error, but got: %v", err)
+	}
+}
+
+func Test_ParseWebhookPolicy(t *testing.T) {
+	cases := []struct {
+		Value    string
+		Expected webhookPolicy
+		Error    bool
+	}{
+		{"", webhookAlways, false},
+		{"always", webhookAlways, false},
+		{"failure", webhookFailure, false},
+		{"failures", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Value, func(t *testing.T) {
+			actual, err := parseWebhookPolicy(tc.Value)
+			if (err != nil) != tc.Error {
+				t.Fatalf("expected error %v, but got %v", tc.Error, err)
+			}
+			if actual != tc.Expected {
+				t.Errorf("expected '%s', but got '%s'", tc.Expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+// downloadWindow is the time of day (on the local clock) during which new downloads may start. A
+// window whose end is before its start spans midnight (eg "22:00-06:00"). A nil *downloadWindow is
+// always open.
+type downloadWindow struct {
+	start, end time.Duration // since midnight
+
+	mu      sync.Mutex
+	waiting time.Time // when the window that workers are waiting for opens (so it's only logged once)
+}
+
+// parseDownloadWindow parses "START-END", with each side a 24-hour clock time ("HH:MM").
+func parseDownloadWindow(s string) (*downloadWindow, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected START-END, but got '%s'", s)
+	}
+	start, err := parseClockTime(startStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start '%s'", startStr)
+	}
+	end, err := parseClockTime(endStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end '%s'", endStr)
+	}
+	if start == end {
+		return nil, fmt.Errorf("start and end are both %s", startStr)
+	}
+	return &downloadWindow{start: start, end: end}, nil
+}
+
+// parseClockTime parses "HH:MM" into the time since midnight
+func parseClockTime(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns whether t is within the window
+func (w *downloadWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	h, m, s := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if w.start < w.end {
+		return d >= w.start && d < w.end
+	}
+	return d >= w.start || d < w.end
+}
+
+// nextOpen returns when the window next opens after t (or t itself, if the window is open then)
+func (w *downloadWindow) nextOpen(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+	y, mo, d := t.Date()
+	open := time.Date(y, mo, d, int(w.start/time.Hour), int(w.start%time.Hour/time.Minute), 0, 0, t.Location())
+	if !open.After(t) {
+		open = time.Date(y, mo, d+1, int(w.start/time.Hour), int(w.start%time.Hour/time.Minute), 0, 0, t.Location())
+	}
+	return open
+}
+
+// wait returns once the window is open (right away, if it already is), or false if ctx is done first.
+// Only the first of the workers to wait for each opening logs it.
+func (w *downloadWindow) wait(ctx context.Context, log frog.Logger) bool {
+	for {
+		now := time.Now()
+		open := w.nextOpen(now)
+		if !open.After(now) {
+			return ctx.Err() == nil
+		}
+		w.mu.Lock()
+		if !w.waiting.Equal(open) {
+			w.waiting = open
+			log.Info("Waiting for the download window", frog.Time("opens", open), frog.Dur("wait", open.Sub(now)))
+		}
+		w.mu.Unlock()
+		// a long wait is checked again every so often, in case the clock changes under it
+		if !sleepCtx(ctx, min(open.Sub(now), time.Minute)) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+)
+
+func Test_ParseDownloadWindow(t *testing.T) {
+	cases := []struct {
+		Input         string
+		ExpectedStart time.Duration
+		ExpectedEnd   time.Duration
+		ExpectedErr   bool
+	}{
+		{"01:00-06:00", time.Hour, 6 * time.Hour, false},
+		{"22:30-06:15", 22*time.Hour + 30*time.Minute, 6*time.Hour + 15*time.Minute, false},
+		{"00:00-23:59", 0, 23*time.Hour + 59*time.Minute, false},
+		{" 01:00 - 06:00 ", time.Hour, 6 * time.Hour, false},
+		{"01:00", 0, 0, true},
+		{"1am-6am", 0, 0, true},
+		{"01:00-24:00", 0, 0, true},
+		{"06:00-06:00", 0, 0, true},
+	}
+	for _, tc := range cases {
+		w, err := parseDownloadWindow(tc.Input)
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("'%s': expected error %v, but got %v", tc.Input, tc.ExpectedErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if w.start != tc.ExpectedStart || w.end != tc.ExpectedEnd {
+			t.Errorf("'%s': expected %v-%v, but got %v-%v", tc.Input, tc.ExpectedStart, tc.ExpectedEnd, w.start, w.end)
+		}
+	}
+}
+
+func Test_DownloadWindow_Contains(t *testing.T) {
+	cases := []struct {
+		Window   string
+		Time     string
+		Expected bool
+	}{
+		{"01:00-06:00", "00:59:59", false},
+		{"01:00-06:00", "01:00:00", true},
+		{"01:00-06:00", "05:59:59", true},
+		{"01:00-06:00", "06:00:00", false},
+		{"01:00-06:00", "12:00:00", false},
+		// spanning midnight
+		{"22:00-06:00", "21:59:59", false},
+		{"22:00-06:00", "22:00:00", true},
+		{"22:00-06:00", "23:59:59", true},
+		{"22:00-06:00", "00:00:00", true},
+		{"22:00-06:00", "05:59:59", true},
+		{"22:00-06:00", "06:00:00", false},
+		{"22:00-06:00", "12:00:00", false},
+	}
+	for _, tc := range cases {
+		w, err := parseDownloadWindow(tc.Window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := time.Parse("2006-01-02 15:04:05", "2024-03-01 "+tc.Time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := w.contains(ts); actual != tc.Expected {
+			t.Errorf("%s at %s: expected %v, but got %v", tc.Window, tc.Time, tc.Expected, actual)
+		}
+	}
+	var w *downloadWindow
+	if !w.contains(time.Now()) {
+		t.Errorf("expected a nil window to always be open")
+	}
+}
+
+func Test_DownloadWindow_NextOpen(t *testing.T) {
+	cases := []struct {
+		Window   string
+		Time     string
+		Expected string
+	}{
+		{"01:00-06:00", "2024-03-01 00:30", "2024-03-01 01:00"},
+		{"01:00-06:00", "2024-03-01 03:00", "2024-03-01 03:00"},
+		{"01:00-06:00", "2024-03-01 06:00", "2024-03-02 01:00"},
+		{"22:00-06:00", "2024-03-01 12:00", "2024-03-01 22:00"},
+		{"22:00-06:00", "2024-03-01 23:00", "2024-03-01 23:00"},
+		{"22:00-06:00", "2024-02-29 12:00", "2024-02-29 22:00"},
+		{"01:00-06:00", "2024-12-31 07:00", "2025-01-01 01:00"},
+	}
+	for _, tc := range cases {
+		w, err := parseDownloadWindow(tc.Window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := time.Parse("2006-01-02 15:04", tc.Time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := time.Parse("2006-01-02 15:04", tc.Expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := w.nextOpen(ts); !actual.Equal(expected) {
+			t.Errorf("%s at %s: expected %s, but got %s", tc.Window, tc.Time, tc.Expected, actual.Format("2006-01-02 15:04"))
+		}
+	}
+}
+
+func Test_DownloadWindow_Wait(t *testing.T) {
+	log := &frog.NullLogger{}
+	var open *downloadWindow
+	if !open.wait(context.Background(), log) {
+		t.Errorf("expected a nil window to not wait")
+	}
+
+	// a window that closed a minute ago
+	now := time.Now()
+	h, m, _ := now.Add(-2 * time.Minute).Clock()
+	start := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	closed := &downloadWindow{start: start, end: (start + time.Minute) % (24 * time.Hour)}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if closed.wait(ctx, log) {
+		t.Errorf("expected a closed window to wait until the context is done")
+	}
+	if time.Since(now) < 50*time.Millisecond {
+		t.Errorf("expected the wait to last until the context was done")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/danbrakeley/needl/internal/plan"
+)
+
+// ensureWritable creates the folder at path (if it doesn't already exist), and checks that files can
+// be written to it, by creating and removing a temp file (named like a download's temp file, so that
+// the listing ignores it, if it's somehow left behind).
+func ensureWritable(path string) error {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(path, "write-check-*"+plan.TempFileSuffix)
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	name := f.Name()
+	err = f.Close()
+	if rmErr := os.Remove(name); err == nil && rmErr != nil {
+		err = rmErr
+	}
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	return nil
+}
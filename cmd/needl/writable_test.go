@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func Test_EnsureWritable(t *testing.T) {
+	dir := t.TempDir()
+
+	// a missing folder is created, and left empty
+	path := filepath.Join(dir, "a", "b")
+	if err := ensureWritable(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the folder to be left empty, but found %d entries", len(entries))
+	}
+
+	// a file in the way can't be made into a folder
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureWritable(filepath.Join(file, "sub")); err == nil {
+		t.Errorf("expected an error for a folder under a file")
+	}
+
+	// a read-only folder (which root, and Windows, can still write to)
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		return
+	}
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureWritable(readOnly); err == nil {
+		t.Errorf("expected an error for a read-only folder")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+func setXattr(path, name, value string) error {
+	return errXattrUnsupported
+}
+
+func getXattr(path, name string) (string, error) {
+	return "", errXattrUnsupported
+}
@@ -8,6 +8,7 @@ require (
 	github.com/danbrakeley/frog v0.9.5
 	github.com/dustin/go-humanize v1.0.1
 	github.com/natefinch/atomic v1.0.1
+	golang.org/x/sys v0.12.0
 )
 
 require (
@@ -15,5 +16,4 @@ require (
 	github.com/magefile/mage v1.15.0 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/mattn/go-tty v0.0.5 // indirect
-	golang.org/x/sys v0.12.0 // indirect
 )
@@ -1,17 +1,140 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/danbrakeley/needl/internal/scraper"
+	"github.com/dustin/go-humanize"
 )
 
 type Config struct {
-	LocalPath string `toml:"path"`
-	Scraper   string `toml:"scraper"`
-	Threads   int    `toml:"threads"`
-	Verbose   bool   `toml:"verbose"`
+	LocalPath   string `toml:"path"`
+	Scraper     string `toml:"scraper"`
+	Threads     int    `toml:"threads"`
+	Verbose     bool   `toml:"verbose"`
+	ScrapeCache string `toml:"scrape_cache"`
+	Duplicates  string `toml:"duplicates"`
+	LocalNewer  string `toml:"local_newer"`
+	UnknownSize string `toml:"unknown_size"`
+	PartSize    string `toml:"part_size"`
+	Store       string `toml:"store"`
+	Engine      string `toml:"engine"`
+
+	// StrictScrape fails a listing if more than a few of its lines look like they list a file, but
+	// couldn't be parsed (for the scraper types that read a listing line by line).
+	StrictScrape bool `toml:"strict_scrape"`
+
+	// FailedDownloads is what happens to the temp file of a download that fails: "keep" (the default)
+	// leaves it for a later run to resume, "remove" removes it, and "partial" renames it to the local
+	// name plus ".partial".
+	FailedDownloads string `toml:"failed_downloads"`
+
+	// ScrapeThreads is how many unknown sizes may be looked up at once, for scrapers with
+	// refresh_unknown_sizes set. Zero means the same as Threads.
+	ScrapeThreads int `toml:"scrape_threads"`
+
+	// VerifyThreads is how many local files may have their checksums verified at once (for
+	// --verify-checksums, and unknown_size = "checksum"). Zero means the same as Threads.
+	VerifyThreads int `toml:"verify_threads"`
+
+	// MaxConnections, if non-zero, caps how many download requests are open at once, across every
+	// file and every part of a file (which may otherwise be Threads times the number of parts).
+	MaxConnections int `toml:"max_connections"`
+
+	// RateLimit, if set, caps how fast every download together may be read, in bytes per second
+	// (such as "500KiB").
+	RateLimit string `toml:"rate_limit"`
+
+	// Trickle runs needl as a background sync, that downloads one file at a time, slowly: up to
+	// TrickleBatch files, with TrickleDelay between each, then sleeping for TrickleInterval before
+	// listing again, until a batch finds nothing left to download.
+	Trickle         bool          `toml:"trickle"`
+	TrickleBatch    int           `toml:"trickle_batch"`
+	TrickleDelay    time.Duration `toml:"trickle_delay"`
+	TrickleInterval time.Duration `toml:"trickle_interval"`
+
+	// Window, if set, is the time of day ("HH:MM-HH:MM", on the local clock) during which new downloads
+	// may start. Outside of it, workers wait for it to open again, after finishing their current file.
+	Window string `toml:"window"`
+
+	// LongNames is what to do with names longer than MaxNameLength bytes ("skip", "error", or "truncate").
+	LongNames     string `toml:"long_names"`
+	MaxNameLength int    `toml:"max_name_length"`
+
+	// HostFailures, if non-zero, is how many failed requests in a row (each within HostFailureWindow
+	// of the last) make a host unhealthy, so that its files are left for a later pass.
+	HostFailures      int           `toml:"host_failures"`
+	HostFailureWindow time.Duration `toml:"host_failure_window"`
+
+	// MaxRetryDuration, if non-zero, is the longest each download may spend retrying after its first
+	// error, before it's counted as failed. Otherwise, downloads are retried forever.
+	MaxRetryDuration time.Duration `toml:"max_retry_duration"`
+
+	// Layout, if set, is a Go time layout (such as "2006/01") that names the folder each file is
+	// downloaded into, from its remote timestamp. Files without a timestamp go in LayoutFallback.
+	Layout         string `toml:"layout"`
+	LayoutFallback string `toml:"layout_fallback"`
+
+	// NameTransform, if set, returns the local path (relative to LocalPath, with "/" separators) of
+	// each remote file, in place of its Name. The Layout (if any) is applied after it. It can't be set
+	// in needl.toml, only by code that embeds needl (such as a caller of Plan).
+	NameTransform func(scraper.RemoteFile) string `toml:"-"`
+
+	// SizeOnly treats a local file with the same size as its remote file as unchanged, even if their
+	// times differ, and sets the local file's time to the remote's instead of downloading it again.
+	SizeOnly bool `toml:"size_only"`
+
+	// PreserveLocalCase downloads a changed file under the existing local file's name, when that only
+	// differs from the remote file's name by case, rather than renaming it to the remote's case.
+	PreserveLocalCase bool `toml:"preserve_local_case"`
+
+	// MatchBy is how remote files are paired up with local files: "name" (the default) only by name,
+	// and "fingerprint" also by content, so that a local file whose remote file was renamed is renamed
+	// to match, instead of being downloaded again.
+	MatchBy string `toml:"match_by"`
+
+	// Sync flushes each download to disk before moving it into place (slower, but crash safe).
+	Sync bool `toml:"sync"`
+
+	// Xattrs records where each download came from (its URL, when it was downloaded, and the checksum
+	// it was verified against, if any) in its extended attributes.
+	Xattrs bool `toml:"xattrs"`
+
+	// ChecksumRetries is how many times a download that doesn't match its checksum is downloaded
+	// again, before it's counted as failed.
+	ChecksumRetries int `toml:"checksum_retries"`
+
+	// ChecksumIndex, if set, is the path of a file that holds the digests of local files that have been
+	// verified, so that verifying them again skips any that haven't changed size or time since.
+	ChecksumIndex string `toml:"checksum_index"`
+
+	// ListingState, if set, is the path of a file that holds each scraper's remote listing from its last
+	// run, so that the files that have since been removed from the remote can be reported.
+	ListingState string `toml:"listing_state"`
+
+	// Compressed lists patterns (as in path.Match, against the file name) of the remote files that
+	// may be stored gzipped locally, as "<name>.gz". Such local files are compared using their
+	// uncompressed size. If CompressDownloads is set, then each download of a matching file is
+	// also gzipped, rather than left uncompressed.
+	Compressed        []string `toml:"compressed"`
+	CompressDownloads bool     `toml:"compress_downloads"`
+
+	// WebhookURL, if set, is sent a JSON summary of each run, as it ends. WebhookOn is "always" (the
+	// default), or "failure" to only send it for runs that failed.
+	WebhookURL string `toml:"webhook_url"`
+	WebhookOn  string `toml:"webhook_on"`
+
+	// Defaults holds the scraper settings used by any scraper that doesn't set them itself.
+	Defaults ScraperDefaults `toml:"defaults"`
 }
 
 func Load(path string) (Config, error) {
@@ -22,10 +145,91 @@ func Load(path string) (Config, error) {
 	defer f.Close()
 
 	var cfg Config
-	_, err = toml.NewDecoder(f).Decode(&cfg)
+	md, err := toml.NewDecoder(f).Decode(&cfg)
 	if err != nil {
 		return Config{}, fmt.Errorf("decode '%s': %w", path, err)
 	}
+	for typ, s := range cfg.Defaults.Types {
+		s.setDefined(md, "defaults", "types", typ)
+		cfg.Defaults.Types[typ] = s
+	}
 
 	return cfg, nil
 }
+
+// Validate returns an error describing every problem found in the config, or nil if there are none.
+// Values that are owned by the app (such as the duplicates policy) are validated by the app.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Threads < 0 {
+		errs = append(errs, fmt.Errorf("threads must not be negative (is %d)", c.Threads))
+	}
+	if c.ScrapeThreads < 0 {
+		errs = append(errs, fmt.Errorf("scrape_threads must not be negative (is %d)", c.ScrapeThreads))
+	}
+	if c.VerifyThreads < 0 {
+		errs = append(errs, fmt.Errorf("verify_threads must not be negative (is %d)", c.VerifyThreads))
+	}
+	if c.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("max_connections must not be negative (is %d)", c.MaxConnections))
+	}
+	if c.MaxNameLength < 0 {
+		errs = append(errs, fmt.Errorf("max_name_length must not be negative (is %d)", c.MaxNameLength))
+	}
+	if c.HostFailures < 0 {
+		errs = append(errs, fmt.Errorf("host_failures must not be negative (is %d)", c.HostFailures))
+	}
+	if c.ChecksumRetries < 0 {
+		errs = append(errs, fmt.Errorf("checksum_retries must not be negative (is %d)", c.ChecksumRetries))
+	}
+	if c.MaxRetryDuration < 0 {
+		errs = append(errs, fmt.Errorf("max_retry_duration must not be negative (is %v)", c.MaxRetryDuration))
+	}
+	if c.HostFailureWindow < 0 {
+		errs = append(errs, fmt.Errorf("host_failure_window must not be negative (is %v)", c.HostFailureWindow))
+	}
+	if c.TrickleBatch < 0 {
+		errs = append(errs, fmt.Errorf("trickle_batch must not be negative (is %d)", c.TrickleBatch))
+	}
+	if c.TrickleDelay < 0 {
+		errs = append(errs, fmt.Errorf("trickle_delay must not be negative (is %v)", c.TrickleDelay))
+	}
+	if c.TrickleInterval < 0 {
+		errs = append(errs, fmt.Errorf("trickle_interval must not be negative (is %v)", c.TrickleInterval))
+	}
+	for key, v := range map[string]string{"layout": c.Layout, "layout_fallback": c.LayoutFallback} {
+		if filepath.IsAbs(v) || strings.HasPrefix(v, "/") || slices.Contains(strings.Split(filepath.ToSlash(v), "/"), "..") {
+			errs = append(errs, fmt.Errorf("%s must be a relative path within the download path (is '%s')", key, v))
+		}
+	}
+	for _, pattern := range c.Compressed {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("compressed pattern '%s': %w", pattern, err))
+		}
+	}
+	if c.CompressDownloads && len(c.Compressed) == 0 {
+		errs = append(errs, fmt.Errorf("compress_downloads needs at least one 'compressed' pattern"))
+	}
+	if c.CompressDownloads && len(c.Store) > 0 {
+		errs = append(errs, fmt.Errorf("compress_downloads can't be used with store"))
+	}
+	if len(c.PartSize) > 0 {
+		if _, err := humanize.ParseBytes(c.PartSize); err != nil {
+			errs = append(errs, fmt.Errorf("part_size: %w", err))
+		}
+	}
+	if len(c.RateLimit) > 0 {
+		if n, err := humanize.ParseBytes(c.RateLimit); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit: %w", err))
+		} else if n == 0 {
+			errs = append(errs, fmt.Errorf("rate_limit must be more than zero"))
+		}
+	}
+	if len(c.WebhookURL) > 0 {
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("webhook_url '%s' must be an absolute http or https url", c.WebhookURL))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,20 +1,432 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/danbrakeley/needl/internal/scraper"
 )
 
 type Scrapers map[string]Scraper
 
 type Scraper struct {
-	Type string `toml:"type"`
-	URL  string `toml:"url"`
+	Type string   `toml:"type"`
+	URL  string   `toml:"url"`
+	URLs []string `toml:"urls"`
+
+	// Prefix limits the listing to names that start with this prefix (for scrapers that support it).
+	Prefix string `toml:"prefix"`
+
+	// TrailingSlash is one of "keep" (the default), "add", or "remove", and controls how
+	// a trailing '/' on each base URL is treated.
+	TrailingSlash string `toml:"trailing_slash"`
+
+	// UserAgent, if set, is sent as the User-Agent header, for both the listing and downloads.
+	UserAgent string `toml:"user_agent"`
+
+	// Headers are extra headers sent with each request, for both the listing and downloads.
+	Headers map[string]string `toml:"headers"`
+
+	// TimeZone is the IANA name (such as "America/New_York") of the time zone that the listing's
+	// times are in, for listings that don't say. The default is UTC.
+	TimeZone string `toml:"timezone"`
+
+	// Username and Password, if set, are sent using HTTP basic auth, for both the listing and downloads.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+
+	// Login, if set, is a form that is posted before anything else, to start a session. The
+	// cookies it sets are sent with the listing and download requests.
+	Login *Login `toml:"login"`
+
+	// UnixSocket, if set, is the path of a Unix domain socket that every request (for the listing,
+	// login, and downloads) is sent over, instead of connecting to the host in the URL, which is
+	// then just a placeholder (as in "http://localhost/files/").
+	UnixSocket string `toml:"unix_socket"`
+
+	// Jar holds the session's cookies, once the Login form has been posted (and is never saved).
+	Jar http.CookieJar `toml:"-"`
+
+	// SecretCommand, if set, is a command (and its arguments) that is run once at startup,
+	// and whose trimmed stdout is used as the Password. This allows the password to come
+	// from a secret manager, instead of being written to disk.
+	SecretCommand []string `toml:"secret_command"`
+
+	// ScrapeDelay is how long to wait between successive listing requests (for scrapers with
+	// multiple base URLs, or listings that span multiple pages). Zero means no delay.
+	ScrapeDelay time.Duration `toml:"scrape_delay"`
+
+	// ScrapeRetries is how many times each listing request is retried after an error that may be
+	// temporary (such as a 503), with a growing delay between attempts. Zero means no retries.
+	ScrapeRetries int `toml:"scrape_retries"`
+
+	// ScrapeTimeout limits how long each listing request may take. Zero means no limit.
+	ScrapeTimeout time.Duration `toml:"scrape_timeout"`
+
+	// RefreshUnknownSizes looks up the size of each listed file whose size is unknown (such as from
+	// an Apache listing), with a HEAD request, so that it can be compared to the local file's size.
+	RefreshUnknownSizes bool `toml:"refresh_unknown_sizes"`
+
+	// URLRewrite is a list of regex find/replace rules applied (in order) to each scraped file's
+	// URL, for example to download from a preferred mirror.
+	URLRewrite []URLRewrite `toml:"url_rewrite"`
+
+	// StripPrefix and StripSuffix, if set, are removed from each scraped file's name (where present),
+	// such as an item name that the uploader put at the start of every file name. The stripped name is
+	// the one saved to (and compared with) the download path.
+	StripPrefix string `toml:"strip_prefix"`
+	StripSuffix string `toml:"strip_suffix"`
+
+	// Checksums lists where to find the expected checksums of remote files, checked in order.
+	// Each is either "scraper" (to ask the scraper, for types that support it), or the path or
+	// URL of a sums file (as written by sha256sum, etc) or JSON manifest.
+	Checksums []string `toml:"checksums"`
+
+	// ContentDisposition saves each file with the name the server sends in its Content-Disposition
+	// header (if any), instead of the name from the listing.
+	ContentDisposition bool `toml:"content_disposition"`
+
+	// Decompress stores each downloaded "<name>.gz" decompressed, as "<name>" (after it has been
+	// verified against the remote file's size and checksum), and compares such local files to the
+	// remote file by time only.
+	Decompress bool `toml:"decompress"`
+
+	// ContinueOnError skips any base URL that fails to scrape, as long as at least
+	// one base URL succeeds.
+	ContinueOnError bool `toml:"continue_on_error"`
+
+	// MaxFiles caps how many files each listing may have, as a guard against a server that sends an
+	// endless listing. Zero means the scraper's default (scraper.DefaultMaxFiles).
+	MaxFiles int `toml:"max_files"`
+
+	// NextPage is a regular expression that finds the link to the next page of a paginated HTML listing
+	// (for the archive.org, nginx, and apache types), in its first capture group (or the whole match).
+	// Each page's files are added to the listing, until a page has no such link.
+	NextPage string `toml:"next_page"`
+
+	// MaxPages caps how many pages a paginated listing may have. Zero means the scraper's default
+	// (scraper.DefaultMaxPages).
+	MaxPages int `toml:"max_pages"`
+
+	// Command is the program (and its arguments) that lists the files, for the exec type. It's run
+	// with each base URL as its last argument, and prints the listing to stdout, as JSON or TSV.
+	Command []string `toml:"command"`
+
+	// defined holds the keys that were set in the file the scraper was loaded from (even if to the
+	// zero value), so that WithDefaults doesn't replace a setting that was turned off on purpose.
+	defined map[string]bool
 }
 
+// Login is a login form, which is posted (as application/x-www-form-urlencoded) to URL, with Fields.
+type Login struct {
+	URL    string            `toml:"url"`
+	Fields map[string]string `toml:"fields"`
+}
+
+// URLRewrite replaces each match of the regular expression From with To, which may refer to
+// capture groups (as in regexp.Regexp.ReplaceAllString, eg "$1").
+type URLRewrite struct {
+	From string `toml:"from"`
+	To   string `toml:"to"`
+}
+
+// ScraperDefaults are the settings used by any scraper that doesn't set them itself. Types holds the
+// defaults for each scraper type, which take precedence over the rest.
+type ScraperDefaults struct {
+	Scraper
+	Types map[string]Scraper `toml:"types"`
+}
+
+// Apply returns s with its defaults filled in, first from those for its type, and then from the rest.
+func (d ScraperDefaults) Apply(s Scraper) Scraper {
+	return s.WithDefaults(d.Types[s.Type]).WithDefaults(d.Scraper)
+}
+
+// WithDefaults returns s, with each setting that it leaves unset (as the zero value) taken from d.
+// Headers are merged instead, with those from s replacing any of the same name from d.
+// The base URLs are taken from d only if s has none, and the same goes for the credentials (the
+// username, password, and secret command), so that a scraper's username is never paired with a
+// default password. A setting that was set in the file s was loaded from is kept, even if it's the
+// zero value, so that s can turn off a setting that d turns on (such as continue_on_error).
+func (s Scraper) WithDefaults(d Scraper) Scraper {
+	sv := reflect.ValueOf(&s).Elem()
+	dv := reflect.ValueOf(d)
+	for i := 0; i < sv.NumField(); i++ {
+		field := sv.Type().Field(i)
+		switch field.Name {
+		case "URL", "URLs", "Username", "Password", "SecretCommand":
+			continue
+		}
+		key := field.Tag.Get("toml")
+		if !field.IsExported() || key == "-" {
+			continue
+		}
+		f, df := sv.Field(i), dv.Field(i)
+		switch {
+		case s.defined[key] && field.Type.Kind() != reflect.Map:
+			continue
+		case field.Type.Kind() == reflect.Map && df.Len() > 0:
+			merged := reflect.MakeMap(field.Type)
+			for _, m := range []reflect.Value{df, f} {
+				iter := m.MapRange()
+				for iter.Next() {
+					merged.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+			f.Set(merged)
+		case f.IsZero():
+			f.Set(df)
+		}
+	}
+
+	if len(s.BaseURLs()) == 0 {
+		s.URL, s.URLs = d.URL, d.URLs
+	}
+	if len(s.Username) == 0 && len(s.Password) == 0 && len(s.SecretCommand) == 0 {
+		s.Username, s.Password, s.SecretCommand = d.Username, d.Password, d.SecretCommand
+	}
+
+	// what d set is now set in s too, so that it isn't replaced by the next defaults (from
+	// ScraperDefaults.Apply)
+	if len(d.defined) > 0 {
+		defined := make(map[string]bool, len(s.defined)+len(d.defined))
+		for _, m := range []map[string]bool{s.defined, d.defined} {
+			for key := range m {
+				defined[key] = true
+			}
+		}
+		s.defined = defined
+	}
+	return s
+}
+
+// setDefined records which of the scraper's settings are defined in md, under the given key.
+func (s *Scraper) setDefined(md toml.MetaData, key ...string) {
+	t := reflect.TypeOf(*s)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("toml")
+		if len(name) == 0 || name == "-" || !md.IsDefined(append(key[:len(key):len(key)], name)...) {
+			continue
+		}
+		if s.defined == nil {
+			s.defined = make(map[string]bool)
+		}
+		s.defined[name] = true
+	}
+}
+
+// BaseURLs returns every base URL to be scraped, starting with URL (if set), followed by URLs.
+func (s Scraper) BaseURLs() []string {
+	urls := make([]string, 0, len(s.URLs)+1)
+	if len(s.URL) > 0 {
+		urls = append(urls, s.URL)
+	}
+	return append(urls, s.URLs...)
+}
+
+// optionSource is a scraper option that the scraper config can set
+type optionSource struct {
+	// example is any value of the option, for its name (as in scraper.Option.String)
+	example scraper.Option
+	// key is the config key(s) that set it, for error messages
+	key string
+	// download is set if the option's settings are also used by each download, so that it isn't
+	// ignored by a scraper type that doesn't use it for the listing
+	download bool
+	// isSet returns true if the config sets the option
+	isSet func(s Scraper) bool
+	// build returns the option(s) as configured (or nil for one that is passed some other way)
+	build func(s Scraper) ([]scraper.Option, error)
+}
+
+// optionSources are the scraper options that the scraper config can set, in the order they are passed
+// to the scraper. Options builds them, and Validate checks their names against the type's scraper.Info.
+var optionSources = []optionSource{
+	{
+		example: scraper.TrailingSlashMode(scraper.TrailingSlashKeep), key: "trailing_slash",
+		isSet: func(s Scraper) bool { return len(s.TrailingSlash) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			ts, err := scraper.ParseTrailingSlash(s.TrailingSlash)
+			if err != nil {
+				return nil, err
+			}
+			return []scraper.Option{scraper.TrailingSlashMode(ts)}, nil
+		},
+	},
+	{
+		// each base URL is given to its own scraper
+		example: scraper.BaseURL(""), key: "url' or 'urls",
+		isSet: func(s Scraper) bool { return len(s.BaseURLs()) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) { return nil, nil },
+	},
+	{
+		example: scraper.Prefix(""), key: "prefix",
+		isSet: func(s Scraper) bool { return len(s.Prefix) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) { return []scraper.Option{scraper.Prefix(s.Prefix)}, nil },
+	},
+	{
+		example: scraper.UserAgent(""), key: "user_agent", download: true,
+		isSet: func(s Scraper) bool { return len(s.UserAgent) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			return []scraper.Option{scraper.UserAgent(s.UserAgent)}, nil
+		},
+	},
+	{
+		example: scraper.Header("", ""), key: "headers", download: true,
+		isSet: func(s Scraper) bool { return len(s.Headers) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			var opts []scraper.Option
+			for key, value := range s.Headers {
+				opts = append(opts, scraper.Header(key, value))
+			}
+			return opts, nil
+		},
+	},
+	{
+		example: scraper.Timeout(0), key: "scrape_timeout",
+		isSet: func(s Scraper) bool { return s.ScrapeTimeout > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			return []scraper.Option{scraper.Timeout(s.ScrapeTimeout)}, nil
+		},
+	},
+	{
+		example: scraper.TimeZone(time.UTC), key: "timezone",
+		isSet: func(s Scraper) bool { return len(s.TimeZone) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			loc, err := time.LoadLocation(s.TimeZone)
+			if err != nil {
+				return nil, err
+			}
+			return []scraper.Option{scraper.TimeZone(loc)}, nil
+		},
+	},
+	{
+		example: scraper.Delay(0), key: "scrape_delay",
+		isSet: func(s Scraper) bool { return s.ScrapeDelay > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) { return []scraper.Option{scraper.Delay(s.ScrapeDelay)}, nil },
+	},
+	{
+		example: scraper.Retries(0), key: "scrape_retries",
+		isSet: func(s Scraper) bool { return s.ScrapeRetries > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			return []scraper.Option{scraper.Retries(s.ScrapeRetries)}, nil
+		},
+	},
+	{
+		// the password may come from the secret command, which is only run once the config is used
+		example: scraper.BasicAuth("", ""), key: "username' and 'password", download: true,
+		isSet: func(s Scraper) bool { return len(s.Username) > 0 || len(s.Password) > 0 || len(s.SecretCommand) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			if len(s.Username) == 0 && len(s.Password) == 0 {
+				return nil, nil
+			}
+			return []scraper.Option{scraper.BasicAuth(s.Username, s.Password)}, nil
+		},
+	},
+	{
+		example: scraper.MaxFiles(0), key: "max_files",
+		isSet: func(s Scraper) bool { return s.MaxFiles > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) { return []scraper.Option{scraper.MaxFiles(s.MaxFiles)}, nil },
+	},
+	{
+		example: scraper.NextPage(nil), key: "next_page",
+		isSet: func(s Scraper) bool { return len(s.NextPage) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) {
+			re, err := regexp.Compile(s.NextPage)
+			if err != nil {
+				return nil, fmt.Errorf("next_page: %w", err)
+			}
+			return []scraper.Option{scraper.NextPage(re)}, nil
+		},
+	},
+	{
+		example: scraper.MaxPages(0), key: "max_pages",
+		isSet: func(s Scraper) bool { return s.MaxPages > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) { return []scraper.Option{scraper.MaxPages(s.MaxPages)}, nil },
+	},
+	{
+		example: scraper.Command(), key: "command",
+		isSet: func(s Scraper) bool { return len(s.Command) > 0 },
+		build: func(s Scraper) ([]scraper.Option, error) { return []scraper.Option{scraper.Command(s.Command...)}, nil },
+	},
+}
+
+// optionKey returns the config key(s) that set the named scraper option
+func optionKey(name string) string {
+	for _, src := range optionSources {
+		if src.example.String() == name {
+			return src.key
+		}
+	}
+	return name
+}
+
+// Options returns the scraper options that this config sets, other than the base URL (which is
+// given to each scraper on its own), and those that come from the main config (such as CacheDir).
+func (s Scraper) Options() ([]scraper.Option, error) {
+	var opts []scraper.Option
+	for _, src := range optionSources {
+		if !src.isSet(s) {
+			continue
+		}
+		o, err := src.build(s)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, o...)
+	}
+	return opts, nil
+}
+
+// LoadScrapers loads the scrapers from the TOML file at path. If path is a directory, then every
+// "*.toml" file in it is loaded (in name order), and a scraper name may only be used in one of them.
 func LoadScrapers(path string) (Scrapers, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("open '%s': %w", path, err)
+	}
+	if !fi.IsDir() {
+		return loadScrapersFile(path)
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.toml"))
+	if err != nil {
+		return nil, fmt.Errorf("list '%s': %w", path, err)
+	}
+	sort.Strings(files)
+
+	scrapers := Scrapers{}
+	from := map[string]string{}
+	for _, file := range files {
+		s, err := loadScrapersFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for name, scfg := range s {
+			if prev, ok := from[name]; ok {
+				return nil, fmt.Errorf("scraper '%s' is in both '%s' and '%s'", name, prev, file)
+			}
+			from[name] = file
+			scrapers[name] = scfg
+		}
+	}
+	return scrapers, nil
+}
+
+func loadScrapersFile(path string) (Scrapers, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("open '%s': %w", path, err)
@@ -22,10 +434,128 @@ func LoadScrapers(path string) (Scrapers, error) {
 	defer f.Close()
 
 	var scrapers Scrapers
-	_, err = toml.NewDecoder(f).Decode(&scrapers)
+	md, err := toml.NewDecoder(f).Decode(&scrapers)
 	if err != nil {
 		return nil, fmt.Errorf("decode '%s': %w", path, err)
 	}
+	for name, s := range scrapers {
+		s.setDefined(md, name)
+		scrapers[name] = s
+	}
 
 	return scrapers, nil
 }
+
+// ResolveSecrets runs the SecretCommand (if there is one), and stores its output in Password.
+func (s *Scraper) ResolveSecrets() error {
+	if len(s.SecretCommand) == 0 {
+		return nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(s.SecretCommand[0], s.SecretCommand[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("secret command '%s' exited with status %d: %s",
+				s.SecretCommand[0], exitErr.ExitCode(), strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("secret command '%s': %w", s.SecretCommand[0], err)
+	}
+
+	secret := strings.TrimSpace(string(out))
+	if len(secret) == 0 {
+		return fmt.Errorf("secret command '%s' printed nothing", s.SecretCommand[0])
+	}
+	s.Password = secret
+	return nil
+}
+
+// Validate returns an error describing every problem found in the scraper config, or nil if there are none.
+func (s Scraper) Validate() error {
+	var errs []error
+	urls := s.BaseURLs()
+	if len(s.Type) == 0 {
+		errs = append(errs, fmt.Errorf("missing type"))
+	} else if info, ok := scraper.Describe(s.Type); !ok {
+		errs = append(errs, fmt.Errorf("unrecognized type '%s'", s.Type))
+	} else {
+		var names []string
+		for _, src := range optionSources {
+			if !src.isSet(s) {
+				continue
+			}
+			name := src.example.String()
+			names = append(names, name)
+			if !src.download && !slices.Contains(info.Required, name) && !slices.Contains(info.Optional, name) {
+				errs = append(errs, fmt.Errorf("scraper type '%s' doesn't support option %s (remove '%s')",
+					s.Type, name, src.key))
+			}
+		}
+		for _, required := range info.Required {
+			if !slices.Contains(names, required) {
+				errs = append(errs, fmt.Errorf("scraper type '%s' requires option %s (set '%s')",
+					s.Type, required, optionKey(required)))
+			}
+		}
+	}
+
+	ts, err := scraper.ParseTrailingSlash(s.TrailingSlash)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, u := range urls {
+		if _, err := scraper.NormalizeBaseURL(u, ts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(s.TimeZone) > 0 {
+		if _, err := time.LoadLocation(s.TimeZone); err != nil {
+			errs = append(errs, fmt.Errorf("invalid timezone: %w", err))
+		}
+	}
+	if s.ScrapeTimeout < 0 {
+		errs = append(errs, fmt.Errorf("scrape_timeout must not be negative (is %v)", s.ScrapeTimeout))
+	}
+	for key := range s.Headers {
+		if len(key) == 0 || strings.ContainsAny(key, " :\r\n") {
+			errs = append(errs, fmt.Errorf("invalid header name '%s'", key))
+		}
+	}
+	if s.ScrapeRetries < 0 {
+		errs = append(errs, fmt.Errorf("scrape_retries must not be negative (is %d)", s.ScrapeRetries))
+	}
+	if s.MaxFiles < 0 {
+		errs = append(errs, fmt.Errorf("max_files must not be negative (is %d)", s.MaxFiles))
+	}
+	if len(s.NextPage) > 0 {
+		if _, err := regexp.Compile(s.NextPage); err != nil {
+			errs = append(errs, fmt.Errorf("next_page: %w", err))
+		}
+	}
+	if s.MaxPages < 0 {
+		errs = append(errs, fmt.Errorf("max_pages must not be negative (is %d)", s.MaxPages))
+	}
+
+	for i, rw := range s.URLRewrite {
+		if len(rw.From) == 0 {
+			errs = append(errs, fmt.Errorf("url_rewrite %d is missing 'from'", i))
+		} else if _, err := regexp.Compile(rw.From); err != nil {
+			errs = append(errs, fmt.Errorf("url_rewrite %d: %w", i, err))
+		}
+	}
+
+	if s.Login != nil {
+		if u, err := url.Parse(s.Login.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("login url '%s' must be an absolute http or https url", s.Login.URL))
+		}
+	}
+
+	if len(s.SecretCommand) > 0 && len(s.SecretCommand[0]) == 0 {
+		errs = append(errs, fmt.Errorf("secret_command is missing the command to run"))
+	}
+	return errors.Join(errs...)
+}
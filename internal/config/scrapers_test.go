@@ -0,0 +1,205 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_ScraperDefaults_Apply(t *testing.T) {
+	var cfg Config
+	_, err := toml.Decode(`
+[defaults]
+user_agent = "needl (all)"
+scrape_timeout = "30s"
+scrape_retries = 2
+username = "me"
+password = "secret"
+headers = { X-A = "all", X-B = "all" }
+
+[defaults.types.nginx]
+user_agent = "needl (nginx)"
+url = "https://example.com/nginx/"
+headers = { X-B = "nginx" }
+`, &cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		Name     string
+		Scraper  Scraper
+		Expected Scraper
+	}{
+		{
+			"only defaults",
+			Scraper{Type: "apache", URL: "https://example.com/a/"},
+			Scraper{
+				Type: "apache", URL: "https://example.com/a/",
+				UserAgent: "needl (all)", ScrapeTimeout: 30 * time.Second, ScrapeRetries: 2,
+				Username: "me", Password: "secret",
+				Headers: map[string]string{"X-A": "all", "X-B": "all"},
+			},
+		},
+		{
+			"type defaults take precedence",
+			Scraper{Type: "nginx"},
+			Scraper{
+				Type: "nginx", URL: "https://example.com/nginx/",
+				UserAgent: "needl (nginx)", ScrapeTimeout: 30 * time.Second, ScrapeRetries: 2,
+				Username: "me", Password: "secret",
+				Headers: map[string]string{"X-A": "all", "X-B": "nginx"},
+			},
+		},
+		{
+			"scraper takes precedence",
+			Scraper{
+				Type: "nginx", URLs: []string{"https://example.com/b/"},
+				UserAgent: "mine", ScrapeRetries: 5, Username: "you",
+				Headers: map[string]string{"X-A": "mine", "X-C": "mine"},
+			},
+			Scraper{
+				Type: "nginx", URLs: []string{"https://example.com/b/"},
+				UserAgent: "mine", ScrapeTimeout: 30 * time.Second, ScrapeRetries: 5,
+				Username: "you",
+				Headers:  map[string]string{"X-A": "mine", "X-B": "nginx", "X-C": "mine"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual := cfg.Defaults.Apply(tc.Scraper)
+			if !reflect.DeepEqual(actual, tc.Expected) {
+				t.Errorf("expected\n%+v\nbut got\n%+v", tc.Expected, actual)
+			}
+		})
+	}
+
+	if s := (ScraperDefaults{}).Apply(Scraper{Type: "nginx"}); !reflect.DeepEqual(s, Scraper{Type: "nginx"}) {
+		t.Errorf("expected no defaults to leave the scraper alone, but got %+v", s)
+	}
+}
+
+func Test_ScraperDefaults_Apply_TurnedOff(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "needl.toml")
+	err := os.WriteFile(configPath, []byte(`
+[defaults]
+continue_on_error = true
+refresh_unknown_sizes = true
+scrape_retries = 3
+
+[defaults.types.apache]
+refresh_unknown_sizes = false
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrapersPath := filepath.Join(dir, "scrapers.toml")
+	err = os.WriteFile(scrapersPath, []byte(`
+[on]
+type = "nginx"
+url = "https://example.com/on/"
+
+[off]
+type = "nginx"
+url = "https://example.com/off/"
+continue_on_error = false
+scrape_retries = 0
+
+[apache]
+type = "apache"
+url = "https://example.com/apache/"
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	scrapers, err := LoadScrapers(scrapersPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		Name                string
+		ContinueOnError     bool
+		RefreshUnknownSizes bool
+		ScrapeRetries       int
+	}{
+		{"on", true, true, 3},
+		// set to the zero value in the scraper
+		{"off", false, true, 0},
+		// set to the zero value in the type's defaults
+		{"apache", true, false, 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := cfg.Defaults.Apply(scrapers[tc.Name])
+			if s.ContinueOnError != tc.ContinueOnError || s.RefreshUnknownSizes != tc.RefreshUnknownSizes || s.ScrapeRetries != tc.ScrapeRetries {
+				t.Errorf("expected continue_on_error %t, refresh_unknown_sizes %t, and scrape_retries %d, but got %t, %t, and %d",
+					tc.ContinueOnError, tc.RefreshUnknownSizes, tc.ScrapeRetries,
+					s.ContinueOnError, s.RefreshUnknownSizes, s.ScrapeRetries)
+			}
+		})
+	}
+}
+
+func Test_Scraper_Validate(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Scraper     Scraper
+		ExpectedErr string // part of the error, or empty for none
+	}{
+		{"ok", Scraper{Type: "nginx", URLs: []string{"https://example.com/a/", "http://example.com/b"}}, ""},
+		{"no scheme", Scraper{Type: "nginx", URL: "example.com/a/"}, "invalid base url 'example.com/a/'"},
+		{"ftp", Scraper{Type: "nginx", URLs: []string{"https://example.com/a/", "ftp://example.com/b/"}}, "scheme must be http or https"},
+		{"no host", Scraper{Type: "nginx", URL: "https:///a/"}, "missing host"},
+		{"malformed", Scraper{Type: "nginx", URL: "https://example.com/%zz"}, "invalid base url"},
+		{"missing", Scraper{Type: "nginx"}, "requires option BaseURL"},
+		// the credentials (and headers) are also used by downloads, so they're fine for any type
+		{"download option", Scraper{Type: "exec", URL: "https://example.com/", Command: []string{"ls"}, Username: "me"}, ""},
+		{"unsupported", Scraper{Type: "xml-bucket", URL: "https://example.com/", NextPage: "next"}, "doesn't support option NextPage (remove 'next_page')"},
+		{"unsupported trailing slash", Scraper{Type: "xml-bucket", URL: "https://example.com/", TrailingSlash: "add"}, "doesn't support option TrailingSlashMode"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Scraper.Validate()
+			if len(tc.ExpectedErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.ExpectedErr) {
+				t.Errorf("expected an error containing '%s', but got %v", tc.ExpectedErr, err)
+			}
+		})
+	}
+}
+
+func Test_OptionSources(t *testing.T) {
+	// the options that come from the main config, rather than the scraper config
+	fromMain := []string{"CacheDir", "HTTPClient", "StrictParse"}
+	for _, typ := range scraper.ListTypes() {
+		info, _ := scraper.Describe(typ)
+		for _, name := range append(append([]string(nil), info.Required...), info.Optional...) {
+			if slices.Contains(fromMain, name) {
+				continue
+			}
+			if optionKey(name) == name {
+				t.Errorf("%s: option %s can't be set by the scraper config", typ, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package plan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+const GzipSuffix = ".gz"
+
+// MatchesCompressed returns whether the named remote file may be stored gzipped locally, because its
+// file name matches one of the patterns
+func MatchesCompressed(patterns []string, name string) bool {
+	base := path.Base(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UncompressLocals finds each local "<name>.gz" whose name matches one of the patterns, and lists it
+// as name instead, with its uncompressed size, so that it is compared to the remote file of that name.
+// If there is also an uncompressed local copy of the same name (or it isn't a gzip file), then the
+// gzipped one is left alone. The returned list is sorted again.
+func UncompressLocals(dir string, locals []LocalFile, patterns []string) []LocalFile {
+	if len(patterns) == 0 {
+		return locals
+	}
+	names := make(map[string]bool, len(locals))
+	for _, l := range locals {
+		names[l.SortName] = true
+	}
+
+	for i, l := range locals {
+		name, ok := strings.CutSuffix(l.Name, GzipSuffix)
+		if !ok || l.Linked || !MatchesCompressed(patterns, name) || names[scraper.SortName(name)] {
+			continue
+		}
+		size, err := GzipSize(filepath.Join(dir, filepath.FromSlash(l.Name)))
+		if err != nil {
+			continue
+		}
+		locals[i].Name = name
+		locals[i].SortName = scraper.SortName(name)
+		locals[i].Size = size
+		locals[i].Compressed = true
+	}
+
+	sort.Slice(locals, func(i, j int) bool {
+		return locals[i].SortName < locals[j].SortName
+	})
+	return locals
+}
+
+// GzipSize returns the uncompressed size of the gzip file, as recorded in its trailer, without
+// decompressing it. The trailer only holds the size modulo 2^32, so larger files have the wrong size
+// (see LocalFile.SizeMatches).
+func GzipSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var header [2]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil || header != [2]byte{0x1f, 0x8b} {
+		return 0, fmt.Errorf("'%s' is not a gzip file", path)
+	}
+	var trailer [4]byte
+	if _, err := f.Seek(-4, io.SeekEnd); err != nil {
+		return 0, fmt.Errorf("seek '%s': %w", path, err)
+	}
+	if _, err := io.ReadFull(f, trailer[:]); err != nil {
+		return 0, fmt.Errorf("read '%s': %w", path, err)
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:])), nil
+}
+
+// DecompressedLocals finds each local file that may hold the decompressed contents of a remote
+// "<name>.gz" (for decompress), and lists it as the remote file's name instead, marked Decompressed,
+// so that it is compared to that remote file. If there is also a local "<name>.gz", or a remote file
+// of the local file's own name, then the local file is left alone. The returned list is sorted again.
+func DecompressedLocals(locals []LocalFile, remotes []scraper.RemoteFile) []LocalFile {
+	listed := make(map[string]bool, len(remotes))
+	gzipped := make(map[string]bool)
+	for _, r := range remotes {
+		listed[r.SortName] = true
+		if strings.HasSuffix(r.Name, GzipSuffix) {
+			gzipped[r.SortName] = true
+		}
+	}
+	if len(gzipped) == 0 {
+		return locals
+	}
+	names := make(map[string]bool, len(locals))
+	for _, l := range locals {
+		names[l.SortName] = true
+	}
+
+	for i, l := range locals {
+		name := l.Name + GzipSuffix
+		sortName := scraper.SortName(name)
+		if l.Compressed || !gzipped[sortName] || names[sortName] || listed[l.SortName] {
+			continue
+		}
+		locals[i].Name = name
+		locals[i].SortName = sortName
+		locals[i].Decompressed = true
+	}
+
+	sort.Slice(locals, func(i, j int) bool {
+		return locals[i].SortName < locals[j].SortName
+	})
+	return locals
+}
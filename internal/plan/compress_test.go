@@ -0,0 +1,164 @@
+package plan
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func writeGzipFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testContent(t *testing.T, size int) []byte {
+	t.Helper()
+	b := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(b)
+	return b
+}
+
+func Test_UncompressLocals(t *testing.T) {
+	dir := t.TempDir()
+	writeGzipFile(t, filepath.Join(dir, "a.txt.gz"), testContent(t, 1000))
+	writeGzipFile(t, filepath.Join(dir, "b.bin.gz"), testContent(t, 1000))
+	writeGzipFile(t, filepath.Join(dir, "c.txt.gz"), testContent(t, 1000))
+	if err := os.WriteFile(filepath.Join(dir, "c.txt"), testContent(t, 10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "d.txt.gz"), []byte("not gzipped"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	locals, err := ListLocals(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locals = UncompressLocals(dir, locals, []string{"*.txt"})
+
+	expected := []struct {
+		Name       string
+		Size       int64
+		Compressed bool
+	}{
+		{"a.txt", 1000, true},
+		{"b.bin.gz", -1, false},
+		{"c.txt", 10, false},
+		{"c.txt.gz", -1, false},
+		{"d.txt.gz", -1, false},
+	}
+	if len(locals) != len(expected) {
+		t.Fatalf("expected %d locals, but got %d", len(expected), len(locals))
+	}
+	for i, e := range expected {
+		l := locals[i]
+		if l.Name != e.Name || l.Compressed != e.Compressed || (e.Size >= 0 && l.Size != e.Size) {
+			t.Errorf("%d: expected %s (size %d, compressed %v), but got %s (size %d, compressed %v)",
+				i, e.Name, e.Size, e.Compressed, l.Name, l.Size, l.Compressed)
+		}
+	}
+}
+
+func Test_LocalFile_SizeMatches(t *testing.T) {
+	cases := []struct {
+		Size       int64
+		Compressed bool
+		RemoteSize int64
+		Expected   bool
+	}{
+		{100, false, 100, true},
+		{100, false, 101, false},
+		{100, true, 100, true},
+		{100, true, 1<<32 + 100, true},
+		{100, false, 1<<32 + 100, false},
+	}
+	for _, tc := range cases {
+		l := LocalFile{Size: tc.Size, Compressed: tc.Compressed}
+		if actual := l.SizeMatches(tc.RemoteSize); actual != tc.Expected {
+			t.Errorf("for size %d (compressed %v) vs %d, expected %v, but got %v",
+				tc.Size, tc.Compressed, tc.RemoteSize, tc.Expected, actual)
+		}
+	}
+}
+
+func Test_DecompressedLocals(t *testing.T) {
+	locals := []LocalFile{
+		localFile(t, "a.txt", "2020-01-01 00:00", 1000),
+		localFile(t, "b.txt", "2020-01-01 00:00", 1000),
+		localFile(t, "b.txt.gz", "2020-01-01 00:00", 100),
+		localFile(t, "c.txt", "2020-01-01 00:00", 1000),
+		localFile(t, "d.txt", "2020-01-01 00:00", 1000),
+		localFile(t, "e.txt", "2020-01-01 00:00", 1000),
+	}
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "a.txt.gz", "2020-01-01 00:00", 100),
+		remoteFile(t, "b.txt.gz", "2020-01-01 00:00", 100),
+		remoteFile(t, "c.txt", "2020-01-01 00:00", 1000),
+		remoteFile(t, "c.txt.gz", "2020-01-01 00:00", 100),
+		remoteFile(t, "d.txt.zip", "2020-01-01 00:00", 100),
+	}
+	locals = DecompressedLocals(locals, remotes)
+
+	expected := []struct {
+		Name         string
+		Decompressed bool
+	}{
+		{"a.txt.gz", true},
+		{"b.txt", false},
+		{"b.txt.gz", false},
+		{"c.txt", false},
+		{"d.txt", false},
+		{"e.txt", false},
+	}
+	if len(locals) != len(expected) {
+		t.Fatalf("expected %d locals, but got %d", len(expected), len(locals))
+	}
+	for i, e := range expected {
+		l := locals[i]
+		if l.Name != e.Name || l.Decompressed != e.Decompressed {
+			t.Errorf("%d: expected %s (decompressed %v), but got %s (decompressed %v)",
+				i, e.Name, e.Decompressed, l.Name, l.Decompressed)
+		}
+	}
+	if name := locals[0].FileName(); name != "a.txt" {
+		t.Errorf("expected the decompressed file's name to be 'a.txt', but got '%s'", name)
+	}
+}
+
+func Test_DiffFilesFunc_Decompressed(t *testing.T) {
+	locals := []LocalFile{
+		localFile(t, "a.gz", "2020-01-01 00:00", 1000),
+		localFile(t, "b.gz", "2020-01-01 00:00", 0),
+		localFile(t, "c.gz", "2020-01-01 00:00", 1000),
+	}
+	for i := range locals {
+		locals[i].Decompressed = true
+	}
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "a.gz", "2020-01-01 00:00", 100),
+		remoteFile(t, "b.gz", "2020-01-01 00:00", 20),
+		remoteFile(t, "c.gz", "2020-02-01 00:00", 100),
+	}
+	// only the time is compared, since the sizes are of the compressed and decompressed files
+	expected := map[string]DiffKind{"a.gz": DiffUnchanged, "b.gz": DiffUnchanged, "c.gz": DiffChanged}
+	DiffSortedFilesFunc(locals, remotes, func(kind DiffKind, local LocalFile, remote scraper.RemoteFile) {
+		if kind != expected[remote.Name] {
+			t.Errorf("%s: expected kind %d, but got %d", remote.Name, expected[remote.Name], kind)
+		}
+	})
+}
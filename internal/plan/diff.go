@@ -0,0 +1,114 @@
+package plan
+
+import (
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// DiffKind is how a file compares between the local and remote listings
+type DiffKind int
+
+const (
+	DiffUnchanged   DiffKind = iota
+	DiffExtra                // local only
+	DiffMissing              // remote only
+	DiffChanged              // both, but with a different timestamp or size
+	DiffTimeOnly             // both, with the same (known) size, but a different timestamp
+	DiffUnknownSize          // both, and would be unchanged, but the remote size is unknown (or approximate)
+)
+
+// DiffSortedFiles compares two sorted lists of files and returns the differences.
+func DiffSortedFiles(
+	locals []LocalFile,
+	remotes []scraper.RemoteFile,
+) (
+	extra []LocalFile,
+	missing []scraper.RemoteFile,
+	changed []scraper.RemoteFile,
+) {
+	extra = make([]LocalFile, 0, len(locals))
+	missing = make([]scraper.RemoteFile, 0, len(remotes))
+	changed = make([]scraper.RemoteFile, 0, len(remotes))
+	DiffSortedFilesFunc(locals, remotes, func(kind DiffKind, local LocalFile, remote scraper.RemoteFile) {
+		switch kind {
+		case DiffExtra:
+			extra = append(extra, local)
+		case DiffMissing:
+			missing = append(missing, remote)
+		case DiffChanged, DiffTimeOnly:
+			changed = append(changed, remote)
+		}
+	})
+	return extra, missing, changed
+}
+
+// DiffSortedFilesFunc compares two sorted lists of files, and calls fn for each file as soon as it
+// is compared, in sorted order. For DiffExtra, only local is set, and for DiffMissing, only remote is set.
+// Because the input is already sorted, this diff has a linear running time.
+// If the remote file has no timestamp or size, then those fields are ignored, except that an empty
+// local file is changed unless the remote file is known to be empty too (since a crash just after
+// a download is moved into place can leave it empty, with the remote file's timestamp). A file that
+// would be unchanged, but whose remote size is unknown, is DiffUnknownSize. An approximate remote size
+// (see RemoteFile.SizePrecision) only changes a file whose size is further from it than that, and
+// otherwise counts as unknown.
+// Linked local files only compare size, since their target may be shared by other remote files
+// (with other timestamps) in the content-addressed store. A file whose timestamp differs, but whose
+// size is known to match, is DiffTimeOnly (unless it's compressed, since then only the low bits of
+// its size are known).
+func DiffSortedFilesFunc(
+	locals []LocalFile,
+	remotes []scraper.RemoteFile,
+	fn func(kind DiffKind, local LocalFile, remote scraper.RemoteFile),
+) {
+	i, j := 0, 0
+	for i < len(locals) && j < len(remotes) {
+		local := locals[i]
+		remote := remotes[j]
+
+		if local.SortName < remote.SortName {
+			fn(DiffExtra, local, scraper.RemoteFile{})
+			i++
+			continue
+		}
+
+		if local.SortName > remote.SortName {
+			fn(DiffMissing, LocalFile{}, remote)
+			j++
+			continue
+		}
+
+		kind := DiffUnchanged
+		if local.Decompressed {
+			// its size is unrelated to the (compressed) remote file's, so only the time is compared
+			if !local.Linked && !remote.Timestamp.IsZero() && !local.Timestamp.Truncate(remote.TimestampPrecision).Equal(remote.Timestamp) {
+				kind = DiffChanged
+			}
+		} else if local.Size == 0 && remote.Size != 0 {
+			kind = DiffChanged
+		} else if local.Linked {
+			if remote.Size > 0 && !local.SizeNear(remote.Size, remote.SizePrecision) {
+				kind = DiffChanged
+			}
+		} else if !remote.Timestamp.IsZero() && !local.Timestamp.Truncate(remote.TimestampPrecision).Equal(remote.Timestamp) {
+			kind = DiffChanged
+			if remote.ExactSize() > 0 && !local.Compressed && local.SizeMatches(remote.Size) {
+				kind = DiffTimeOnly
+			}
+		} else if remote.Size > 0 && !local.SizeNear(remote.Size, remote.SizePrecision) {
+			kind = DiffChanged
+		} else if remote.ExactSize() < 0 {
+			kind = DiffUnknownSize
+		}
+		fn(kind, local, remote)
+
+		i++
+		j++
+	}
+
+	for ; i < len(locals); i++ {
+		fn(DiffExtra, locals[i], scraper.RemoteFile{})
+	}
+
+	for ; j < len(remotes); j++ {
+		fn(DiffMissing, LocalFile{}, remotes[j])
+	}
+}
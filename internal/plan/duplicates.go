@@ -0,0 +1,130 @@
+package plan
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// DuplicatePolicy decides what happens when more than one remote file would be written to
+// the same local path (compared case-insensitively, via SortName).
+type DuplicatePolicy string
+
+const (
+	dupFirst  DuplicatePolicy = "first"  // keep whichever was scraped first (the default)
+	dupError  DuplicatePolicy = "error"  // stop with an error
+	dupLarger DuplicatePolicy = "larger" // keep the largest (ties go to the first)
+	dupNewer  DuplicatePolicy = "newer"  // keep the most recently modified (ties go to the first)
+	dupRename DuplicatePolicy = "rename" // keep the first, and rename the others to "name (2).ext", etc
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch DuplicatePolicy(s) {
+	case "":
+		return dupFirst, nil
+	case dupFirst, dupError, dupLarger, dupNewer, dupRename:
+		return DuplicatePolicy(s), nil
+	}
+	return "", fmt.Errorf("unrecognized duplicates policy '%s' (expected one of: %s, %s, %s, %s, %s)",
+		s, dupFirst, dupError, dupLarger, dupNewer, dupRename)
+}
+
+// resolveDuplicates ensures that no two remote files share a SortName, according to the given policy.
+// The remotes must already be sorted by SortName, using a stable sort so that files sharing a
+// SortName are still in the order they were scraped. The result is sorted the same way.
+func resolveDuplicates(log frog.Logger, remotes []scraper.RemoteFile, policy DuplicatePolicy) ([]scraper.RemoteFile, error) {
+	resolved := make([]scraper.RemoteFile, 0, len(remotes))
+	var renames []scraper.RemoteFile
+	var collisions []string
+
+	for i := 0; i < len(remotes); {
+		// find the run of remotes that share a SortName
+		j := i + 1
+		for j < len(remotes) && remotes[j].SortName == remotes[i].SortName {
+			j++
+		}
+		group := remotes[i:j]
+		i = j
+
+		if len(group) == 1 {
+			resolved = append(resolved, group[0])
+			continue
+		}
+
+		keep := 0
+		switch policy {
+		case dupError:
+			collisions = append(collisions, group[0].Name)
+			continue
+		case dupLarger:
+			for k := range group {
+				if group[k].Size > group[keep].Size {
+					keep = k
+				}
+			}
+		case dupNewer:
+			for k := range group {
+				if group[k].Timestamp.After(group[keep].Timestamp) {
+					keep = k
+				}
+			}
+		}
+
+		resolved = append(resolved, group[keep])
+		for k := range group {
+			if k == keep {
+				continue
+			}
+			if policy == dupRename {
+				renames = append(renames, group[k])
+				continue
+			}
+			log.Warning("skipping duplicate remote file",
+				frog.String("name", group[k].Name), frog.String("url", group[k].URL),
+				frog.String("kept_url", group[keep].URL),
+			)
+		}
+	}
+
+	if len(collisions) > 0 {
+		return nil, fmt.Errorf("%d local name(s) would be written by more than one remote file: %s",
+			len(collisions), strings.Join(collisions, ", "))
+	}
+
+	if len(renames) == 0 {
+		return resolved, nil
+	}
+
+	taken := make(map[string]bool, len(resolved)+len(renames))
+	for _, r := range resolved {
+		taken[r.SortName] = true
+	}
+	for _, r := range renames {
+		ext := path.Ext(r.Name)
+		base := strings.TrimSuffix(r.Name, ext)
+		for n := 2; ; n++ {
+			name := fmt.Sprintf("%s (%d)%s", base, n, ext)
+			sortName := scraper.SortName(name)
+			if taken[sortName] {
+				continue
+			}
+			taken[sortName] = true
+			log.Warning("renaming duplicate remote file",
+				frog.String("name", r.Name), frog.String("new_name", name), frog.String("url", r.URL),
+			)
+			r.Name = name
+			r.SortName = sortName
+			break
+		}
+		resolved = append(resolved, r)
+	}
+
+	sort.Slice(resolved, func(i, j int) bool {
+		return resolved[i].SortName < resolved[j].SortName
+	})
+	return resolved, nil
+}
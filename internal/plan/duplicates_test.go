@@ -0,0 +1,108 @@
+package plan
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_ResolveDuplicates(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Policy   DuplicatePolicy
+		Remotes  []scraper.RemoteFile
+		Expected []scraper.RemoteFile
+		IsErr    bool
+	}{
+		{
+			"no duplicates",
+			dupError,
+			[]scraper.RemoteFile{remoteFile(t, "a", "2020-01-01 00:00", 1), remoteFile(t, "b", "2020-01-01 00:00", 2)},
+			[]scraper.RemoteFile{remoteFile(t, "a", "2020-01-01 00:00", 1), remoteFile(t, "b", "2020-01-01 00:00", 2)},
+			false,
+		},
+		{
+			"first",
+			dupFirst,
+			[]scraper.RemoteFile{remoteFile(t, "a", "2020-01-01 00:00", 1), remoteFile(t, "A", "2021-01-01 00:00", 2)},
+			[]scraper.RemoteFile{remoteFile(t, "a", "2020-01-01 00:00", 1)},
+			false,
+		},
+		{
+			"error",
+			dupError,
+			[]scraper.RemoteFile{remoteFile(t, "a", "2020-01-01 00:00", 1), remoteFile(t, "A", "2021-01-01 00:00", 2)},
+			nil,
+			true,
+		},
+		{
+			"larger",
+			dupLarger,
+			[]scraper.RemoteFile{
+				remoteFile(t, "a", "2020-01-01 00:00", 1),
+				remoteFile(t, "A", "2019-01-01 00:00", 3),
+				remoteFile(t, "a", "2021-01-01 00:00", 2),
+			},
+			[]scraper.RemoteFile{remoteFile(t, "A", "2019-01-01 00:00", 3)},
+			false,
+		},
+		{
+			"newer",
+			dupNewer,
+			[]scraper.RemoteFile{
+				remoteFile(t, "a", "2020-01-01 00:00", 1),
+				remoteFile(t, "A", "2021-01-01 00:00", 3),
+				remoteFile(t, "a", "2019-01-01 00:00", 2),
+			},
+			[]scraper.RemoteFile{remoteFile(t, "A", "2021-01-01 00:00", 3)},
+			false,
+		},
+		{
+			"rename",
+			dupRename,
+			[]scraper.RemoteFile{
+				remoteFile(t, "a.txt", "2020-01-01 00:00", 1),
+				remoteFile(t, "A.txt", "2021-01-01 00:00", 2),
+				remoteFile(t, "a (2).txt", "2019-01-01 00:00", 3),
+			},
+			[]scraper.RemoteFile{
+				remoteFile(t, "a (2).txt", "2019-01-01 00:00", 3),
+				remoteFile(t, "A (3).txt", "2021-01-01 00:00", 2),
+				remoteFile(t, "a.txt", "2020-01-01 00:00", 1),
+			},
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			// callers must use a stable sort, so that duplicates stay in scraped order
+			sort.SliceStable(tc.Remotes, func(i, j int) bool {
+				return tc.Remotes[i].SortName < tc.Remotes[j].SortName
+			})
+
+			actual, err := resolveDuplicates(&frog.NullLogger{}, tc.Remotes, tc.Policy)
+			if tc.IsErr {
+				if err == nil {
+					t.Fatalf("expected an error, but got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(actual) != len(tc.Expected) {
+				t.Fatalf("expected %d, but got %d\n\texpected: %v\n\tactual: %v", len(tc.Expected), len(actual), tc.Expected, actual)
+			}
+			for i := range actual {
+				if actual[i].Name != tc.Expected[i].Name || actual[i].Size != tc.Expected[i].Size {
+					t.Errorf("entry %d: expected '%s' (%d), but got '%s' (%d)",
+						i, tc.Expected[i].Name, tc.Expected[i].Size, actual[i].Name, actual[i].Size)
+				}
+			}
+		})
+	}
+}
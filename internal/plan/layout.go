@@ -0,0 +1,39 @@
+package plan
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+const DefaultLayoutFallback = "undated"
+
+// partitionRemotes moves each remote file into the folder named by formatting its timestamp (in UTC)
+// with layout, a Go time layout such as "2006/01". Files with no timestamp go in the fallback folder.
+// A folder that isn't a relative path within the download path (such as one starting with "..") is an error.
+// The result is sorted by SortName, using a stable sort so that files that share a SortName are still
+// in the order they were scraped (as resolveDuplicates expects).
+func partitionRemotes(remotes []scraper.RemoteFile, layout, fallback string) ([]scraper.RemoteFile, error) {
+	for i := range remotes {
+		if len(remotes[i].Name) == 0 {
+			// the name (and so the folder) is up to the server
+			continue
+		}
+		dir := fallback
+		if !remotes[i].Timestamp.IsZero() {
+			dir = remotes[i].Timestamp.UTC().Format(layout)
+		}
+		if len(dir) == 0 || path.IsAbs(dir) || !filepath.IsLocal(filepath.FromSlash(dir)) {
+			return nil, fmt.Errorf("'%s' was laid out in folder '%s', which isn't a path within the download path", remotes[i].Name, dir)
+		}
+		remotes[i].Name = path.Join(dir, remotes[i].Name)
+		remotes[i].SortName = scraper.SortName(remotes[i].Name)
+	}
+	sort.SliceStable(remotes, func(i, j int) bool {
+		return remotes[i].SortName < remotes[j].SortName
+	})
+	return remotes, nil
+}
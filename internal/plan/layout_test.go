@@ -0,0 +1,103 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_PartitionRemotes(t *testing.T) {
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "b.zip", "2023-10-05 12:00", 1),
+		remoteFile(t, "a.zip", "2024-01-31 23:59", 1),
+		remoteFile(t, "c.zip", "", 1),
+		remoteFile(t, "a.zip", "2023-10-20 00:00", 1),
+	}
+
+	actual, err := partitionRemotes(remotes, "2006/01", "undated")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"2023/10/a.zip", "2023/10/b.zip", "2024/01/a.zip", "undated/c.zip"}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d, but got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if actual[i].Name != expected[i] {
+			t.Errorf("%d: expected name '%s', but got '%s'", i, expected[i], actual[i].Name)
+		}
+		if actual[i].SortName != scraper.SortName(expected[i]) {
+			t.Errorf("%d: expected sort name '%s', but got '%s'", i, expected[i], actual[i].SortName)
+		}
+	}
+}
+
+func Test_GetSortedLocals_Recursive(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"2023/10/b.zip", "2023/10/A.zip", "undated/c.zip", "top.zip", "2023/10/x.needl.tmp"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	locals, err := ListLocals(dir, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"2023/10/A.zip", "2023/10/b.zip", "top.zip", "undated/c.zip"}
+	if len(locals) != len(expected) {
+		t.Fatalf("expected %d, but got %d: %v", len(expected), len(locals), locals)
+	}
+	for i := range expected {
+		if locals[i].Name != expected[i] {
+			t.Errorf("%d: expected name '%s', but got '%s'", i, expected[i], locals[i].Name)
+		}
+	}
+
+	// the partitioned remotes line up with the local files
+	remotes, err := partitionRemotes([]scraper.RemoteFile{
+		remoteFile(t, "a.zip", "2023-10-05 12:00", 1),
+		remoteFile(t, "c.zip", "", 1),
+	}, "2006/01", "undated")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	extra, missing, _ := DiffSortedFiles(locals, remotes)
+	if len(missing) != 0 {
+		t.Errorf("expected nothing missing, but got %v", missing)
+	}
+	if len(extra) != 2 {
+		t.Errorf("expected 2 extra, but got %v", extra)
+	}
+}
+
+func Test_PartitionRemotes_OutsideDownloadPath(t *testing.T) {
+	cases := []struct {
+		name     string
+		layout   string
+		fallback string
+	}{
+		{"layout", "../x", "undated"},
+		{"layout with a time", "../2006", "undated"},
+		{"fallback", "2006/01", "../x"},
+		{"absolute", "/2006", "undated"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			remotes := []scraper.RemoteFile{
+				remoteFile(t, "a.zip", "2023-10-05 12:00", 1),
+				remoteFile(t, "c.zip", "", 1),
+			}
+			if _, err := partitionRemotes(remotes, tc.layout, tc.fallback); err == nil {
+				t.Errorf("expected an error for layout '%s' with fallback '%s'", tc.layout, tc.fallback)
+			}
+		})
+	}
+}
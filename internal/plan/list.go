@@ -0,0 +1,222 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// ListFiles concurrently lists both the local and remote files, each sorted by SortName.
+// If missingLocalOK is set, then a local path that doesn't exist is treated as empty.
+// It also returns the number of remote listings that failed and were skipped (see
+// config.Scraper.ContinueOnError), and the number that were truncated (see getSortedRemotes).
+func ListFiles(
+	log frog.Logger, cfg config.Config, scfg config.Scraper, missingLocalOK bool,
+) (locals []LocalFile, remotes []scraper.RemoteFile, failed, truncated int, err error) {
+	var errLocal error
+	var errRemote error
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		log.Info("Listing local files...", frog.Path(cfg.LocalPath))
+		// a layout or name transform can put files in folders, so then they're listed by their paths
+		locals, errLocal = ListLocals(cfg.LocalPath, len(cfg.Layout) > 0 || cfg.NameTransform != nil)
+		if errLocal == nil {
+			locals = UncompressLocals(cfg.LocalPath, locals, cfg.Compressed)
+		}
+		if missingLocalOK && errors.Is(errLocal, fs.ErrNotExist) {
+			log.Info("Local path does not exist", frog.PathAbs(cfg.LocalPath))
+			locals, errLocal = nil, nil
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		var opts []scraper.Option
+		opts, errRemote = ScraperOptions(cfg, scfg)
+		if errRemote != nil {
+			return
+		}
+		remotes, failed, truncated, errRemote = getSortedRemotes(log, scfg, cfg.ScrapeThreads, cfg.StrictScrape, opts...)
+	}()
+
+	wg.Wait()
+
+	if errLocal != nil {
+		return nil, nil, failed, truncated, &Error{Code: 20, Msg: "list local files", Err: errLocal,
+			Fields: []frog.Fielder{frog.PathAbs(cfg.LocalPath)},
+		}
+	}
+
+	if errRemote != nil {
+		return nil, nil, failed, truncated, &Error{Code: 30, Msg: "list remote files", Err: errRemote,
+			Fields: []frog.Fielder{frog.String("urls", strings.Join(scfg.BaseURLs(), " "))},
+		}
+	}
+
+	if failed > 0 {
+		log.Warning("Some remote listings failed and were skipped", frog.Int("failed", failed))
+	}
+
+	return locals, remotes, failed, truncated, nil
+}
+
+// strictScrapeRatio is how many unparsed lines (that look like they list a file) a listing may have, for
+// each line that was parsed, before --strict-scrape fails it
+const strictScrapeRatio = 0.05
+
+// ScraperOptions returns the scraper options that come from the config (other than the base URL)
+func ScraperOptions(cfg config.Config, scfg config.Scraper) ([]scraper.Option, error) {
+	var opts []scraper.Option
+	if len(cfg.ScrapeCache) > 0 {
+		opts = append(opts, scraper.CacheDir(cfg.ScrapeCache))
+	}
+	scfgOpts, err := scfg.Options()
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+	opts = append(opts, scfgOpts...)
+	if client := ScraperClient(scfg); client != nil {
+		opts = append(opts, scraper.HTTPClient(client))
+	}
+	if cfg.StrictScrape {
+		opts = append(opts, scraper.StrictParse(strictScrapeRatio))
+	}
+	return opts, nil
+}
+
+// getSortedRemotes scrapes each of the scraper's base URLs and returns the combined list of remote files.
+// The sort is stable, so any files that share a name are left in the order they were scraped.
+// If scfg.ContinueOnError is set, then base URLs that fail to scrape are logged and skipped, and the
+// number skipped is returned. An error is only returned in that case if every base URL failed.
+// If scfg.RefreshUnknownSizes is set, then the unknown sizes are looked up using up to statThreads
+// concurrent requests (see refreshUnknownSizes). A listing with too many files or pages (see
+// scraper.MaxFiles and scraper.MaxPages) fails if strict is set, or else only the files listed before
+// the limit are used, and the number of such listings is returned.
+func getSortedRemotes(
+	log frog.Logger, scfg config.Scraper, statThreads int, strict bool, opts ...scraper.Option,
+) (remotes []scraper.RemoteFile, failed, truncated int, err error) {
+	urls := scfg.BaseURLs()
+	if len(urls) == 0 {
+		return nil, 0, 0, fmt.Errorf("no url specified")
+	}
+
+	if !scfg.RefreshUnknownSizes {
+		statThreads = 0
+	}
+
+	var lastErr error
+	for i, u := range urls {
+		if i > 0 && scfg.ScrapeDelay > 0 {
+			log.Verbose("waiting before next listing", frog.Dur("scrape_delay", scfg.ScrapeDelay))
+			time.Sleep(scfg.ScrapeDelay)
+		}
+		log.Info("Listing remote files...", frog.String("url", u))
+		r, partial, err := scrapeBaseURL(log, scfg.Type, u, statThreads, strict, opts...)
+		if err != nil {
+			if !scfg.ContinueOnError {
+				return nil, 0, 0, err
+			}
+			log.Warning("skipping failed remote listing", frog.String("url", u), frog.Err(err))
+			failed++
+			lastErr = err
+			continue
+		}
+		if partial {
+			truncated++
+		}
+		remotes = append(remotes, r...)
+	}
+	if failed == len(urls) {
+		return nil, failed, 0, fmt.Errorf("all %d remote listings failed, last error: %w", failed, lastErr)
+	}
+
+	if err := RewriteURLs(log, remotes, scfg.URLRewrite); err != nil {
+		return nil, failed, truncated, err
+	}
+	StripNames(remotes, scfg.StripPrefix, scfg.StripSuffix)
+
+	sort.SliceStable(remotes, func(i, j int) bool {
+		return remotes[i].SortName < remotes[j].SortName
+	})
+
+	return remotes, failed, truncated, nil
+}
+
+// RewriteURLs applies each rewrite rule, in order, to the URL of every remote file.
+func RewriteURLs(log frog.Logger, remotes []scraper.RemoteFile, rules []config.URLRewrite) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	res := make([]*regexp.Regexp, len(rules))
+	for i, rw := range rules {
+		var err error
+		if res[i], err = regexp.Compile(rw.From); err != nil {
+			return fmt.Errorf("config error in url_rewrite %d: %w", i, err)
+		}
+	}
+	for i := range remotes {
+		orig := remotes[i].URL
+		for j, re := range res {
+			remotes[i].URL = re.ReplaceAllString(remotes[i].URL, rules[j].To)
+		}
+		if remotes[i].URL != orig {
+			log.Verbose("rewrote url", frog.String("name", remotes[i].Name),
+				frog.String("from", orig), frog.String("to", remotes[i].URL),
+			)
+		}
+	}
+	return nil
+}
+
+// scrapeBaseURL lists the remote files at baseURL. If statThreads is non-zero, and the scraper is a
+// scraper.RemoteStatter, then any unknown sizes are looked up using that many concurrent requests.
+// Unless strict is set, a listing that stopped at its file (or page) limit is logged, and used as far as it got
+// (and truncated is set).
+func scrapeBaseURL(
+	log frog.Logger, typ, baseURL string, statThreads int, strict bool, opts ...scraper.Option,
+) (remotes []scraper.RemoteFile, truncated bool, err error) {
+	s, err := scraper.Create(typ, append([]scraper.Option{scraper.BaseURL(baseURL)}, opts...)...)
+	if err != nil {
+		return nil, false, fmt.Errorf("config error creating scraper of type '%s': %w", typ, err)
+	}
+
+	remotes, err = s.ScrapeRemotes()
+	if errors.Is(err, scraper.ErrTooManyFiles) && !strict {
+		log.Warning("Listing has too many files, so only some of them were listed",
+			frog.String("url", baseURL), frog.Int("files", len(remotes)), frog.Err(err),
+		)
+		truncated = true
+	} else if errors.Is(err, scraper.ErrTooManyPages) && !strict {
+		log.Warning("Listing has too many pages, so only some of them were listed",
+			frog.String("url", baseURL), frog.Int("files", len(remotes)), frog.Err(err),
+		)
+		truncated = true
+	} else if err != nil {
+		return nil, false, fmt.Errorf("error while scraping '%s': %w", baseURL, err)
+	}
+
+	if statThreads > 0 {
+		if st, ok := s.(scraper.RemoteStatter); ok {
+			if n := refreshUnknownSizes(log, st, remotes, statThreads); n > 0 {
+				log.Warning("Some sizes are still unknown", frog.String("url", baseURL), frog.Int("failed", n))
+			}
+		} else {
+			log.Warning("scraper type can't look up unknown sizes", frog.String("type", typ))
+		}
+	}
+
+	return remotes, truncated, nil
+}
@@ -0,0 +1,53 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_RewriteURLs(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Rules    []config.URLRewrite
+		URL      string
+		Expected string
+	}{
+		{"no rules", nil, "https://archive.org/download/a/b.zip", "https://archive.org/download/a/b.zip"},
+		{
+			"host swap",
+			[]config.URLRewrite{{From: `^https://archive\.org/`, To: "https://mirror.example/"}},
+			"https://archive.org/download/a/b.zip",
+			"https://mirror.example/download/a/b.zip",
+		},
+		{
+			"capture groups, applied in order",
+			[]config.URLRewrite{
+				{From: `^https://([^/]+)/download/`, To: "https://cdn.example/$1/"},
+				{From: `\.zip$`, To: ".zip?dl=1"},
+			},
+			"https://archive.org/download/a/b.zip",
+			"https://cdn.example/archive.org/a/b.zip?dl=1",
+		},
+		{
+			"no match",
+			[]config.URLRewrite{{From: `^https://other\.example/`, To: "https://mirror.example/"}},
+			"https://archive.org/download/a/b.zip",
+			"https://archive.org/download/a/b.zip",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			remotes := []scraper.RemoteFile{{Name: "b.zip", URL: tc.URL}}
+			if err := RewriteURLs(&frog.NullLogger{}, remotes, tc.Rules); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if remotes[0].URL != tc.Expected {
+				t.Errorf("expected '%s', but got '%s'", tc.Expected, remotes[0].URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package plan
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// The suffixes of the files that needl writes beside each download while it's in progress, which
+// aren't local files (yet), so they aren't listed.
+const (
+	TempFileSuffix      = ".needl.tmp"
+	ResumeSidecarSuffix = ".needl.json"
+	LockFileSuffix      = ".needl.lock"
+
+	// Aria2cControlSuffix is added to the name of a file that aria2c is downloading, for the file that
+	// tracks its progress (so that it can be resumed)
+	Aria2cControlSuffix = ".aria2"
+)
+
+// IsTempFileName returns true for the names of temp files (and their sidecars and lock files) that needl writes while downloading
+func IsTempFileName(name string) bool {
+	return strings.HasSuffix(name, TempFileSuffix) || strings.HasSuffix(name, ResumeSidecarSuffix) ||
+		strings.HasSuffix(name, LockFileSuffix) || strings.HasSuffix(name, TempFileSuffix+Aria2cControlSuffix)
+}
+
+// LocalFile is a file in the download path.
+type LocalFile struct {
+	Name      string
+	SortName  string
+	Timestamp time.Time
+	Size      int64
+	Linked    bool // a symlink (such as into the content-addressed store); Timestamp and Size are of its target
+
+	// Compressed is set for a local "<Name>.gz" that holds the remote file Name, and then Size is its
+	// uncompressed size (modulo 2^32, from the gzip trailer).
+	Compressed bool
+
+	// Decompressed is set for a local file that holds the decompressed contents of the remote file
+	// Name (which ends in ".gz"), for decompress. Its Size can't be compared to the remote file's.
+	Decompressed bool
+}
+
+// FileName returns the name of the local file, which has a ".gz" suffix if it is Compressed, and
+// doesn't if it is Decompressed.
+func (l LocalFile) FileName() string {
+	if l.Compressed {
+		return l.Name + GzipSuffix
+	}
+	if l.Decompressed {
+		return strings.TrimSuffix(l.Name, GzipSuffix)
+	}
+	return l.Name
+}
+
+// SizeMatches returns whether the local file is the given (uncompressed) size. A Compressed file only
+// knows its size modulo 2^32, so only that much is compared.
+func (l LocalFile) SizeMatches(size int64) bool {
+	if l.Compressed {
+		return uint32(l.Size) == uint32(size)
+	}
+	return l.Size == size
+}
+
+// SizeNear returns whether the local file is within precision of the given (uncompressed) size, which
+// is the same as SizeMatches if precision is zero. The size of a Compressed file is only known modulo
+// 2^32, so it's always near an approximate size.
+func (l LocalFile) SizeNear(size, precision int64) bool {
+	if precision == 0 {
+		return l.SizeMatches(size)
+	}
+	if l.Compressed {
+		return true
+	}
+	return l.Size >= size-precision && l.Size <= size+precision
+}
+
+// ListLocals lists the files in path. If recursive is set, then the files in sub-folders are also
+// listed (named by their slash separated path within path), and the sub-folders themselves are not.
+func ListLocals(path string, recursive bool) ([]LocalFile, error) {
+	locals := make([]LocalFile, 0, 256)
+
+	addLocal := func(name string, e fs.DirEntry) error {
+		if IsTempFileName(e.Name()) {
+			// in-progress (or interrupted) downloads aren't local files yet
+			return nil
+		}
+		var i fs.FileInfo
+		var err error
+		linked := e.Type()&fs.ModeSymlink != 0
+		if linked {
+			i, err = os.Stat(filepath.Join(path, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				// a dangling link doesn't count as having the file
+				return nil
+			}
+		} else {
+			i, err = e.Info()
+		}
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		locals = append(locals, LocalFile{
+			Name:      name,
+			SortName:  scraper.SortName(name),
+			Timestamp: i.ModTime().UTC(),
+			Size:      i.Size(),
+			Linked:    linked,
+		})
+		return nil
+	}
+
+	if recursive {
+		err := filepath.WalkDir(path, func(p string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if e.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(path, p)
+			if err != nil {
+				return err
+			}
+			return addLocal(rel, e)
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if err := addLocal(e.Name(), e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Slice(locals, func(i, j int) bool {
+		return locals[i].SortName < locals[j].SortName
+	})
+
+	return locals, nil
+}
@@ -0,0 +1,106 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// DefaultMaxNameLength is the longest file name (in bytes) allowed by most filesystems
+const DefaultMaxNameLength = 255
+
+// LongNamePolicy decides what happens to remote files whose local name would be too long.
+type LongNamePolicy string
+
+const (
+	longNameSkip     LongNamePolicy = "skip"     // log and skip each file with a long name (the default)
+	longNameError    LongNamePolicy = "error"    // stop with an error that lists every long name
+	longNameTruncate LongNamePolicy = "truncate" // shorten the name, keeping the extension and adding a hash of the full name
+)
+
+func ParseLongNamePolicy(s string) (LongNamePolicy, error) {
+	switch LongNamePolicy(s) {
+	case "":
+		return longNameSkip, nil
+	case longNameSkip, longNameError, longNameTruncate:
+		return LongNamePolicy(s), nil
+	}
+	return "", fmt.Errorf("unrecognized long_names policy '%s' (expected one of: %s, %s, %s)",
+		s, longNameSkip, longNameError, longNameTruncate)
+}
+
+// resolveLongNames ensures that no remote file's name is longer than maxLen bytes, according to the given policy.
+// Only the last element of a name is checked (and truncated), so that any folders (from the layout) are kept.
+// The remotes must already be sorted by SortName, and the result is sorted the same way.
+func resolveLongNames(
+	log frog.Logger, remotes []scraper.RemoteFile, policy LongNamePolicy, maxLen int,
+) ([]scraper.RemoteFile, error) {
+	var long []string
+	renamed := false
+	kept := remotes[:0]
+	for i := range remotes {
+		dir, base := path.Split(remotes[i].Name)
+		if len(base) <= maxLen {
+			kept = append(kept, remotes[i])
+			continue
+		}
+		switch policy {
+		case longNameError:
+			long = append(long, remotes[i].Name)
+			continue
+		case longNameSkip:
+			log.Warning("skipping remote file with a long name",
+				frog.String("name", remotes[i].Name), frog.Int("length", len(base)), frog.String("url", remotes[i].URL),
+			)
+			continue
+		}
+		name := dir + truncateName(base, maxLen)
+		log.Warning("truncating long remote file name",
+			frog.String("name", remotes[i].Name), frog.String("new_name", name), frog.String("url", remotes[i].URL),
+		)
+		remotes[i].Name = name
+		remotes[i].SortName = scraper.SortName(name)
+		kept = append(kept, remotes[i])
+		renamed = true
+	}
+	remotes = kept
+
+	if len(long) > 0 {
+		return nil, fmt.Errorf("%d name(s) are longer than %d bytes: %s", len(long), maxLen, strings.Join(long, ", "))
+	}
+
+	if renamed {
+		sort.Slice(remotes, func(i, j int) bool {
+			return remotes[i].SortName < remotes[j].SortName
+		})
+	}
+	return remotes, nil
+}
+
+// truncateName shortens name to at most maxLen bytes, as "<start of name>~<hash>.<ext>".
+// The hash is of the full name, so that names that only differ after the cut stay unique,
+// and so that the same name is always truncated the same way.
+func truncateName(name string, maxLen int) string {
+	sum := sha256.Sum256([]byte(name))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+
+	ext := path.Ext(name)
+	if len(ext)+len(suffix) > maxLen/2 {
+		// an unreasonably long extension is treated as part of the name
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	n := max(maxLen-len(suffix)-len(ext), 0)
+	// don't cut a multi-byte character in half
+	for n > 0 && n < len(stem) && !utf8.RuneStart(stem[n]) {
+		n--
+	}
+	return stem[:min(n, len(stem))] + suffix + ext
+}
@@ -0,0 +1,89 @@
+package plan
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_TruncateName(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Input  string
+		MaxLen int
+		Ext    string
+	}{
+		{"keeps extension", strings.Repeat("a", 300) + ".tar.gz", 255, ".gz"},
+		{"long extension", "a." + strings.Repeat("b", 300), 255, ""},
+		{"multi-byte runes", strings.Repeat("é", 200) + ".txt", 100, ".txt"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual := truncateName(tc.Input, tc.MaxLen)
+			if len(actual) > tc.MaxLen {
+				t.Errorf("expected at most %d bytes, but got %d", tc.MaxLen, len(actual))
+			}
+			if !strings.HasSuffix(actual, tc.Ext) {
+				t.Errorf("expected '%s' to end with '%s'", actual, tc.Ext)
+			}
+			if !strings.ContainsRune(actual, '~') {
+				t.Errorf("expected '%s' to include a hash", actual)
+			}
+			if !utf8.ValidString(actual) {
+				t.Errorf("expected '%s' to be valid utf-8", actual)
+			}
+			if again := truncateName(tc.Input, tc.MaxLen); again != actual {
+				t.Errorf("expected truncation to be stable, but got '%s' then '%s'", actual, again)
+			}
+		})
+	}
+}
+
+func Test_ResolveLongNames(t *testing.T) {
+	// these only differ after the point where they get cut
+	long1 := strings.Repeat("x", 300) + "1.bin"
+	long2 := strings.Repeat("x", 300) + "2.bin"
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "short.bin", "2020-01-01 00:00", 1),
+		remoteFile(t, long1, "2020-01-01 00:00", 2),
+		remoteFile(t, long2, "2020-01-01 00:00", 3),
+	}
+
+	_, err := resolveLongNames(&frog.NullLogger{}, append([]scraper.RemoteFile(nil), remotes...), longNameError, 255)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	skipped, err := resolveLongNames(&frog.NullLogger{}, append([]scraper.RemoteFile(nil), remotes...), longNameSkip, 255)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(skipped) != 1 || skipped[0].Name != "short.bin" {
+		t.Errorf("expected only short.bin to be kept, but got %v", skipped)
+	}
+
+	actual, err := resolveLongNames(&frog.NullLogger{}, remotes, longNameTruncate, 255)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(actual) != 3 {
+		t.Fatalf("expected 3 remotes, but got %d", len(actual))
+	}
+	seen := map[string]bool{}
+	for i, r := range actual {
+		if len(r.Name) > 255 {
+			t.Errorf("%d: expected at most 255 bytes, but got %d", i, len(r.Name))
+		}
+		if seen[r.SortName] {
+			t.Errorf("%d: duplicate name '%s'", i, r.Name)
+		}
+		seen[r.SortName] = true
+		if i > 0 && actual[i-1].SortName > r.SortName {
+			t.Errorf("%d: expected remotes to be sorted", i)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package plan
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// transformNames replaces the name of each remote file with what transform returns for it, which must
+// be a relative path within the download path. Files without a name are left alone, since their name
+// is up to the server. The result is sorted by SortName, using a stable sort so that files that share
+// a SortName are still in the order they were scraped (as resolveDuplicates expects).
+func transformNames(remotes []scraper.RemoteFile, transform func(scraper.RemoteFile) string) ([]scraper.RemoteFile, error) {
+	for i := range remotes {
+		if len(remotes[i].Name) == 0 {
+			continue
+		}
+		name := transform(remotes[i])
+		if len(name) == 0 || path.IsAbs(name) || !filepath.IsLocal(filepath.FromSlash(name)) {
+			return nil, fmt.Errorf("'%s' was transformed to '%s', which isn't a path within the download path", remotes[i].Name, name)
+		}
+		remotes[i].Name = path.Clean(name)
+		remotes[i].SortName = scraper.SortName(remotes[i].Name)
+	}
+	sort.SliceStable(remotes, func(i, j int) bool {
+		return remotes[i].SortName < remotes[j].SortName
+	})
+	return remotes, nil
+}
@@ -0,0 +1,55 @@
+package plan
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_TransformNames(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Transform func(scraper.RemoteFile) string
+		Input     []string
+		Expected  []string
+		Err       bool
+	}{
+		{"lowercase", func(r scraper.RemoteFile) string { return strings.ToLower(r.Name) }, []string{"B.ZIP", "a.zip"}, []string{"a.zip", "b.zip"}, false},
+		{"prefix", func(r scraper.RemoteFile) string { return "x-" + r.Name }, []string{"a", "b"}, []string{"x-a", "x-b"}, false},
+		{"into folders", func(r scraper.RemoteFile) string { return path.Join(r.Name[:1], r.Name) }, []string{"bb", "ab"}, []string{"a/ab", "b/bb"}, false},
+		{"cleaned", func(r scraper.RemoteFile) string { return "./d//" + r.Name }, []string{"a"}, []string{"d/a"}, false},
+		{"no name is left alone", func(r scraper.RemoteFile) string { return "x" }, []string{""}, []string{""}, false},
+		{"empty", func(r scraper.RemoteFile) string { return "" }, []string{"a"}, nil, true},
+		{"absolute", func(r scraper.RemoteFile) string { return "/" + r.Name }, []string{"a"}, nil, true},
+		{"escapes", func(r scraper.RemoteFile) string { return "../" + r.Name }, []string{"a"}, nil, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var remotes []scraper.RemoteFile
+			for _, name := range tc.Input {
+				remotes = append(remotes, scraper.RemoteFile{Name: name, SortName: scraper.SortName(name)})
+			}
+			actual, err := transformNames(remotes, tc.Transform)
+			if tc.Err {
+				if err == nil {
+					t.Errorf("expected an error, but got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(actual) != len(tc.Expected) {
+				t.Fatalf("expected %v, but got %v", tc.Expected, actual)
+			}
+			for i := range actual {
+				if actual[i].Name != tc.Expected[i] || actual[i].SortName != scraper.SortName(tc.Expected[i]) {
+					t.Errorf("expected %s, but got %s (sorted as %s)", tc.Expected[i], actual[i].Name, actual[i].SortName)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package plan
+
+import (
+	"fmt"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// DefaultThreadCount is how many files are listed (or downloaded) at once, if the config doesn't say.
+const DefaultThreadCount = 4
+
+// Error is an error from one step of planning a run, along with what needl logs for it (Msg and
+// Fields), and the exit code it returns.
+type Error struct {
+	Code   int
+	Msg    string
+	Fields []frog.Fielder
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Msg, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Plan lists the local and remote files, and returns how they differ: the local files that aren't in
+// the remote listing, and the remote files that are missing or changed locally. Unlike a run (even
+// with --audit), nothing is created, downloaded, or logged (so the scrape cache is ignored, rather
+// than read and then saved), and a local path that doesn't exist yet is treated as empty. The config
+// is used as loaded, other than its defaults being filled in (including the scraper's, from the
+// config's Defaults). Set the config's NameTransform to control the local path each remote file is
+// compared with (and would be downloaded to).
+func Plan(cfg config.Config, scfg config.Scraper) (extra []LocalFile, missing, changed []scraper.RemoteFile, err error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, nil, &Error{Code: 5, Msg: "config error", Err: err}
+	}
+	scfg = cfg.Defaults.Apply(scfg)
+	if err := scfg.Validate(); err != nil {
+		return nil, nil, nil, &Error{Code: 5, Msg: "scraper config error", Err: err}
+	}
+	dups, err := ParseDuplicatePolicy(cfg.Duplicates)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	longNames, err := ParseLongNamePolicy(cfg.LongNames)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cfg.ScrapeCache = ""
+	if cfg.Threads == 0 {
+		cfg.Threads = DefaultThreadCount
+	}
+	if cfg.ScrapeThreads == 0 {
+		cfg.ScrapeThreads = cfg.Threads
+	}
+	if cfg.MaxNameLength <= 0 {
+		cfg.MaxNameLength = DefaultMaxNameLength
+	}
+	if len(cfg.LayoutFallback) == 0 {
+		cfg.LayoutFallback = DefaultLayoutFallback
+	}
+
+	log := &frog.NullLogger{}
+	locals, remotes, _, _, err := ListFiles(log, cfg, scfg, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	remotes, err = ResolveRemotes(log, cfg, remotes, dups, longNames)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if scfg.Decompress {
+		locals = DecompressedLocals(locals, remotes)
+	}
+	extra, missing, changed = DiffSortedFiles(locals, remotes)
+	return extra, missing, changed, nil
+}
+
+// ResolveRemotes gives each remote file the local path it will be written to: from the name transform
+// (if any), in its folder from the layout (if any), and then renamed (or rejected) as needed so that no two files share a path, and no
+// name is too long. The remotes must already be sorted by SortName, and the result is sorted the same way.
+// Each remote's OrigName is set to its name from the listing, so that its checksum can still be found.
+func ResolveRemotes(
+	log frog.Logger, cfg config.Config, remotes []scraper.RemoteFile, dups DuplicatePolicy, longNames LongNamePolicy,
+) ([]scraper.RemoteFile, error) {
+	for i := range remotes {
+		if len(remotes[i].OrigName) == 0 {
+			remotes[i].OrigName = remotes[i].Name
+		}
+	}
+
+	if cfg.NameTransform != nil {
+		var err error
+		remotes, err = transformNames(remotes, cfg.NameTransform)
+		if err != nil {
+			return nil, &Error{Code: 36, Msg: "transform remote file names", Err: err}
+		}
+	}
+
+	// do the layout before checking for duplicates, since files with the same name in different
+	// folders don't collide
+	if len(cfg.Layout) > 0 {
+		var err error
+		remotes, err = partitionRemotes(remotes, cfg.Layout, cfg.LayoutFallback)
+		if err != nil {
+			return nil, &Error{Code: 36, Msg: "lay out remote files", Err: err}
+		}
+	}
+
+	remotes, err := resolveDuplicates(log, remotes, dups)
+	if err != nil {
+		return nil, &Error{Code: 31, Msg: "duplicate remote files", Err: err}
+	}
+
+	remotes, err = resolveLongNames(log, remotes, longNames, cfg.MaxNameLength)
+	if err != nil {
+		return nil, &Error{Code: 32, Msg: "long remote file names", Err: err}
+	}
+	return remotes, nil
+}
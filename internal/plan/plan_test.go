@@ -0,0 +1,182 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/config"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_Plan(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<html><head><title>Index of /</title></head><body><h1>Index of /</h1><hr><pre><a href="../">../</a>`)
+		for _, f := range []struct {
+			Name string
+			Size int
+		}{{"a.zip", 100}, {"b.zip", 200}, {"d.zip", 400}} {
+			fmt.Fprintf(w, "<a href=\"%s\">%s</a>%45s%s%20d\n", f.Name, f.Name, " ", modTime.Format("02-Jan-2006 15:04"), f.Size)
+		}
+		fmt.Fprintln(w, `</pre><hr></body></html>`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	for name, size := range map[string]int{"a.zip": 100, "c.zip": 300, "d.zip": 399} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scfg := config.Scraper{Type: "nginx", URL: srv.URL + "/"}
+
+	extra, missing, changed, err := Plan(config.Config{LocalPath: dir}, scfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(extra) != 1 || extra[0].Name != "c.zip" {
+		t.Errorf("expected extra c.zip, but got %v", extra)
+	}
+	if len(missing) != 1 || missing[0].Name != "b.zip" {
+		t.Errorf("expected missing b.zip, but got %v", missing)
+	}
+	if len(changed) != 1 || changed[0].Name != "d.zip" {
+		t.Errorf("expected changed d.zip, but got %v", changed)
+	}
+
+	// a download path that doesn't exist yet is left alone, and everything is missing
+	newDir := filepath.Join(dir, "new")
+	_, missing, _, err = Plan(config.Config{LocalPath: newDir}, scfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 3 {
+		t.Errorf("expected 3 missing, but got %v", missing)
+	}
+	if _, err := os.Stat(newDir); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to be created, but got %v", newDir, err)
+	}
+
+	// with a name transform, the local files are compared by their transformed paths
+	if err := os.MkdirAll(filepath.Join(dir, "zips"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "a.zip"), filepath.Join(dir, "zips", "A.ZIP")); err != nil {
+		t.Fatal(err)
+	}
+	transform := func(r scraper.RemoteFile) string { return "zips/" + strings.ToUpper(r.Name) }
+	extra, missing, _, err = Plan(config.Config{LocalPath: dir, NameTransform: transform}, scfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(extra) != 2 {
+		t.Errorf("expected extra c.zip and d.zip, but got %v", extra)
+	}
+	if len(missing) != 2 || missing[0].Name != "zips/B.ZIP" || missing[1].Name != "zips/D.ZIP" {
+		t.Errorf("expected missing zips/B.ZIP and zips/D.ZIP, but got %v", missing)
+	}
+
+	if _, _, _, err := Plan(config.Config{LocalPath: dir, Duplicates: "bogus"}, scfg); err == nil {
+		t.Errorf("expected an error for a bad duplicates policy")
+	}
+
+	// a bad base url is a config error, even if listing errors are skipped
+	bad := config.Scraper{Type: "nginx", URLs: []string{srv.URL + "/", "ftp://example.com/"}, ContinueOnError: true}
+	var pe *Error
+	if _, _, _, err := Plan(config.Config{LocalPath: dir}, bad); !errors.As(err, &pe) || pe.Code != 5 {
+		t.Errorf("expected a config error (status 5) for an ftp url, but got %v", err)
+	}
+}
+
+func Test_Plan_ScrapeCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("If-None-Match")) > 0 {
+			t.Errorf("expected an unconditional request, but got If-None-Match '%s'", r.Header.Get("If-None-Match"))
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintln(w, `<html><head><title>Index of /</title></head><body><h1>Index of /</h1><hr><pre><a href="../">../</a>`)
+		fmt.Fprintf(w, "<a href=\"a.zip\">a.zip</a>%45s02-Jan-2020 03:04%20d\n", " ", 100)
+		fmt.Fprintln(w, `</pre><hr></body></html>`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	cfg := config.Config{LocalPath: filepath.Join(dir, "files"), ScrapeCache: cacheDir}
+	_, missing, _, err := Plan(cfg, config.Scraper{Type: "archive.org", URL: srv.URL + "/download/x"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 1 || missing[0].Name != "a.zip" {
+		t.Errorf("expected missing a.zip, but got %v", missing)
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to be created, but got %v", cacheDir, err)
+	}
+}
+
+func Test_Plan_ConfigError(t *testing.T) {
+	// the config is checked as --check-config does, before anything is listed
+	cases := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{"negative threads", config.Config{Threads: -1}},
+		{"layout outside the download path", config.Config{Layout: "../x"}},
+		{"compress_downloads with store", config.Config{CompressDownloads: true, Compressed: []string{"*.gz"}, Store: "store"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.LocalPath = t.TempDir()
+			_, _, _, err := Plan(tc.cfg, config.Scraper{Type: "nginx", URL: "http://127.0.0.1:1/"})
+			var planErr *Error
+			if !errors.As(err, &planErr) || planErr.Code != 5 {
+				t.Errorf("expected an error with code 5, but got %v", err)
+			}
+		})
+	}
+}
+
+func Test_ResolveRemotes_ListedName(t *testing.T) {
+	long := strings.Repeat("x", 300) + ".bin"
+	aa, bb := strings.Repeat("a", 32), strings.Repeat("b", 32)
+	sums, err := scraper.ParseSumsFile(strings.NewReader(aa + "  a.zip\n" + bb + "  " + long + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dup := remoteFile(t, "a.zip", "2020-01-01 00:00", 2)
+	dup.URL = "mirror/a.zip"
+	remotes := []scraper.RemoteFile{
+		remoteFile(t, "a.zip", "2020-01-01 00:00", 1),
+		dup,
+		remoteFile(t, long, "2020-01-01 00:00", 3),
+	}
+
+	// the renamed duplicate and the truncated name are still found under the names they were listed as
+	actual, err := ResolveRemotes(&frog.NullLogger{}, config.Config{MaxNameLength: 255}, remotes, dupRename, longNameTruncate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(actual) != 3 {
+		t.Fatalf("expected 3 remotes, but got %v", actual)
+	}
+	expected := map[string]string{"a.zip": aa, "a (2).zip": aa, truncateName(long, 255): bb}
+	for _, r := range actual {
+		_, hex, ok := sums.ChecksumFor(r.ListedName())
+		if !ok || hex != expected[r.Name] {
+			t.Errorf("%s: expected checksum '%s', but got '%s' (found: %t)", r.Name, expected[r.Name], hex, ok)
+		}
+	}
+}
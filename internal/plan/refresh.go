@@ -0,0 +1,64 @@
+package plan
+
+import (
+	"sync"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// refreshUnknownSizes looks up the size of each remote file whose size is unknown (or approximate), using
+// up to threads concurrent StatRemote calls (which are HEAD requests, for the built-in scrapers). Each size
+// is written back into remotes at the file's own index, so the result doesn't depend on which lookup
+// finishes first. Files that fail to stat are logged, and their size is left as it was. The number that
+// failed is returned.
+func refreshUnknownSizes(log frog.Logger, st scraper.RemoteStatter, remotes []scraper.RemoteFile, threads int) int {
+	var unknown []int
+	for i, r := range remotes {
+		if r.ExactSize() < 0 {
+			unknown = append(unknown, i)
+		}
+	}
+	if len(unknown) == 0 {
+		return 0
+	}
+	threads = max(1, min(threads, len(unknown)))
+	log.Info("Looking up unknown sizes...", frog.Int("files", len(unknown)), frog.Int("threads", threads))
+
+	indexes := make(chan int)
+	sizes := make([]int64, len(remotes))
+	failed := make([]bool, len(remotes))
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for t := 0; t < threads; t++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				stat, err := st.StatRemote(remotes[i].Name)
+				if err != nil {
+					log.Warning("failed to look up size", frog.String("name", remotes[i].Name), frog.Err(err))
+					failed[i] = true
+					continue
+				}
+				sizes[i] = stat.Size
+			}
+		}()
+	}
+	for _, i := range unknown {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var n int
+	for _, i := range unknown {
+		if failed[i] {
+			n++
+			continue
+		}
+		if sizes[i] >= 0 {
+			remotes[i].Size, remotes[i].SizePrecision = sizes[i], 0
+		}
+	}
+	return n
+}
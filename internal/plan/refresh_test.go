@@ -0,0 +1,44 @@
+package plan
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/danbrakeley/frog"
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+type sizeStatter map[string]int64
+
+func (s sizeStatter) StatRemote(name string) (scraper.RemoteFile, error) {
+	size, ok := s[name]
+	if !ok {
+		return scraper.RemoteFile{}, fmt.Errorf("%w: %s", scraper.ErrRemoteNotFound, name)
+	}
+	return scraper.RemoteFile{Name: name, Size: size}, nil
+}
+
+func Test_RefreshUnknownSizes(t *testing.T) {
+	st := sizeStatter{"a.zip": 100, "b.zip": 200, "c.zip": 300, "e.zip": -1}
+	for _, threads := range []int{1, 2, 8} {
+		t.Run(fmt.Sprintf("threads=%d", threads), func(t *testing.T) {
+			remotes := []scraper.RemoteFile{
+				remoteFile(t, "a.zip", "", -1),
+				remoteFile(t, "b.zip", "", 5), // known sizes aren't looked up
+				remoteFile(t, "c.zip", "", -1),
+				remoteFile(t, "d.zip", "", -1), // fails
+				remoteFile(t, "e.zip", "", -1), // still unknown
+			}
+			failed := refreshUnknownSizes(&frog.NullLogger{}, st, remotes, threads)
+			if failed != 1 {
+				t.Errorf("expected 1 failure, but got %d", failed)
+			}
+			expected := []int64{100, 5, 300, -1, -1}
+			for i, r := range remotes {
+				if r.Size != expected[i] {
+					t.Errorf("expected '%s' to have size %d, but got %d", r.Name, expected[i], r.Size)
+				}
+			}
+		})
+	}
+}
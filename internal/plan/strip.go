@@ -0,0 +1,26 @@
+package plan
+
+import (
+	"path"
+	"strings"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+// StripNames removes the prefix and suffix (if set, and present) from the file name of each remote
+// file (its last element, so that any folders are kept), so that it's saved under the shorter name.
+// A name that would be left empty is kept as is.
+func StripNames(remotes []scraper.RemoteFile, prefix, suffix string) {
+	if len(prefix) == 0 && len(suffix) == 0 {
+		return
+	}
+	for i := range remotes {
+		dir, base := path.Split(remotes[i].Name)
+		stripped := strings.TrimSuffix(strings.TrimPrefix(base, prefix), suffix)
+		if len(stripped) == 0 || stripped == base {
+			continue
+		}
+		remotes[i].Name = dir + stripped
+		remotes[i].SortName = scraper.SortName(remotes[i].Name)
+	}
+}
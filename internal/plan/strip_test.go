@@ -0,0 +1,36 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/danbrakeley/needl/internal/scraper"
+)
+
+func Test_StripNames(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Prefix   string
+		Suffix   string
+		Expected string
+	}{
+		{"item_a.zip", "item_", "", "a.zip"},
+		{"other_a.zip", "item_", "", "other_a.zip"},
+		{"a_orig.zip", "", "_orig.zip", "a"},
+		{"item_a_orig.zip", "item_", "_orig.zip", "a"},
+		{"dir/item_a.zip", "item_", "", "dir/a.zip"},
+		{"item_", "item_", "", "item_"},
+		{"Item_A.zip", "item_", "", "Item_A.zip"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			remotes := []scraper.RemoteFile{remoteFile(t, tc.Name, "", -1)}
+			StripNames(remotes, tc.Prefix, tc.Suffix)
+			if remotes[0].Name != tc.Expected {
+				t.Errorf("expected name '%s', but got '%s'", tc.Expected, remotes[0].Name)
+			}
+			if remotes[0].SortName != scraper.SortName(tc.Expected) {
+				t.Errorf("expected sort name '%s', but got '%s'", scraper.SortName(tc.Expected), remotes[0].SortName)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package plan
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/danbrakeley/needl/internal/config"
+)
+
+// ScraperClient returns the client that the scraper's listing and download requests are made with,
+// which keeps the session's cookies (if there are any), and connects over the scraper's Unix socket
+// (if it has one). It returns nil if neither is set, so that the default client is used.
+func ScraperClient(scfg config.Scraper) *http.Client {
+	if scfg.Jar == nil && len(scfg.UnixSocket) == 0 {
+		return nil
+	}
+	return &http.Client{Jar: scfg.Jar, Transport: ScraperTransport(scfg)}
+}
+
+// ScraperTransport returns the transport that sends each request over the scraper's Unix socket,
+// or nil (for the default transport) if it doesn't have one.
+func ScraperTransport(scfg config.Scraper) http.RoundTripper {
+	if len(scfg.UnixSocket) == 0 {
+		return nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// the socket is the only place to connect to, whatever host the URL names
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", scfg.UnixSocket)
+	}
+	return t
+}
@@ -2,6 +2,7 @@ package scraper
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,31 +12,102 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/dustin/go-humanize"
 )
 
 type ArchiveDotOrg struct {
 	BaseURL   string
 	UserAgent string
+	Username  string
+	Password  string
+
+	// CacheDir, if set, is where the listing is cached between runs. When a cached listing
+	// exists, the listing is requested conditionally, and the cached copy is reused if the
+	// server responds that it's not modified.
+	CacheDir string
+
+	// Retries is how many times a request is retried after an error that may be temporary.
+	Retries int
+
+	// Header holds any extra headers sent with each request.
+	Header http.Header
+
+	// TimeZone is the time zone of the listing's times (or nil for UTC).
+	TimeZone *time.Location
+
+	// Client makes the requests (or a default client, if nil).
+	Client *http.Client
+
+	// Torrent, if set, lists the files in the item's .torrent, instead of its download listing.
+	// The torrent has the exact size of every file (which the full listing doesn't), but no times.
+	Torrent bool
+
+	// StrictRatio, if non-zero, fails the parse of a simple listing if there are more than this many
+	// unparsed lines that look like they list a file, for each line that was parsed (see StrictParse).
+	StrictRatio float64
+
+	// MaxFiles caps how many files the listing may have (or zero for DefaultMaxFiles).
+	MaxFiles int
+
+	// NextPage, if set, finds the link to the next page of a paginated download listing (see the
+	// NextPage option). A paginated listing isn't cached.
+	NextPage *regexp.Regexp
+
+	// MaxPages caps how many pages a paginated listing may have (or zero for DefaultMaxPages).
+	MaxPages int
+
+	// Delay is how long to wait between requests for the pages of a paginated listing.
+	Delay time.Duration
 }
 
 func init() {
-	Register("archive.org", func(name string, opts ...Option) (Scraper, error) {
-		var baseURL string
-		for _, o := range opts {
-			switch ot := o.(type) {
-			case optBaseURL:
-				baseURL = ot.v
-			}
-		}
-		if len(baseURL) == 0 {
-			return nil, fmt.Errorf("missing required option: BaseURL")
-		}
-		return &ArchiveDotOrg{
-			BaseURL: baseURL,
-		}, nil
+	Register("archive.org", newArchiveDotOrg, Info{
+		Description: "an archive.org item's download listing",
+		Required:    []string{"BaseURL"},
+		Optional: []string{
+			"CacheDir", "TrailingSlashMode", "BasicAuth", "UserAgent", "Header", "Timeout", "HTTPClient", "Retries", "TimeZone", "StrictParse", "MaxFiles",
+			"NextPage", "MaxPages", "Delay",
+		},
+	})
+	Register("archive.org-torrent", newArchiveDotOrg, Info{
+		Description: "an archive.org item's .torrent file (for exact sizes, but no times)",
+		Required:    []string{"BaseURL"},
+		Optional: []string{
+			"CacheDir", "TrailingSlashMode", "BasicAuth", "UserAgent", "Header", "Timeout", "HTTPClient", "Retries", "MaxFiles",
+		},
 	})
 }
 
+func newArchiveDotOrg(typ string, opts ...Option) (Scraper, error) {
+	p := parseOptions(opts)
+	if len(p.baseURL) == 0 {
+		return nil, fmt.Errorf("missing required option: BaseURL")
+	}
+	baseURL, err := NormalizeBaseURL(p.baseURL, p.trailingSlash)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveDotOrg{
+		BaseURL:   baseURL,
+		UserAgent: p.userAgent,
+		Username:  p.auth.username,
+		Password:  p.auth.password,
+		CacheDir:  p.cacheDir,
+		Retries:   p.retries,
+		Header:    p.header,
+		TimeZone:  p.timeZone,
+		Client:    clientWithTimeout(p.client, p.timeout),
+		Torrent:   typ == "archive.org-torrent",
+
+		StrictRatio: p.strictRatio,
+		MaxFiles:    p.maxFiles,
+		NextPage:    p.nextPage,
+		MaxPages:    p.maxPages,
+		Delay:       p.delay,
+	}, nil
+}
+
 // (2023-10-07) archive.org seems to have two different responses, sometimes depending on
 // if the URL ends in a '/'.
 //
@@ -61,50 +133,157 @@ const (
 func (n ArchiveDotOrg) ScrapeRemotes() ([]RemoteFile, error) {
 	remotes := make([]RemoteFile, 0, 256)
 
-	req, err := http.NewRequest("GET", n.BaseURL, nil)
+	if n.NextPage != nil && !n.Torrent {
+		p := pages{NextPage: n.NextPage, MaxPages: n.MaxPages, Delay: n.Delay}
+		return p.scrape(n.BaseURL, n.getPage, n.ScrapeFromReader, remotes)
+	}
+
+	listURL := n.BaseURL
+	scrape := n.ScrapeFromReader
+	if n.Torrent {
+		torrentURL, err := archiveTorrentURL(n.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		listURL = torrentURL
+		scrape = n.ScrapeTorrentFromReader
+	}
+
+	req, err := http.NewRequest("GET", listURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make new GET request: %w", err)
 	}
 	if len(n.UserAgent) > 0 {
 		req.Header.Set("User-Agent", n.UserAgent)
 	}
+	addHeaders(req, n.Header)
+	if len(n.Username) > 0 || len(n.Password) > 0 {
+		req.SetBasicAuth(n.Username, n.Password)
+	}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	var cached cachedListing
+	var hasCache bool
+	if len(n.CacheDir) > 0 {
+		cached, hasCache = loadCachedListing(n.CacheDir, listURL)
+		if hasCache {
+			cached.setConditionalHeaders(req)
+		}
+	}
+
+	resp, err := doWithRetry(clientFor(n.Client, n.Header), req, n.Retries)
 	if err != nil {
 		return nil, fmt.Errorf("failed to do request: %w", err)
 	}
 	defer resp.Body.Close()
+	if hasCache && resp.StatusCode == http.StatusNotModified {
+		return append(remotes, cached.Remotes...), nil
+	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("unexpected request status %d", resp.StatusCode)
 	}
 
-	return n.ScrapeFromReader(resp.Body, remotes)
+	remotes, err = scrape(resp.Body, remotes)
+	if err != nil {
+		// remotes is nil, unless the listing stopped at MaxFiles (which isn't cached)
+		return remotes, err
+	}
+
+	if len(n.CacheDir) > 0 {
+		if err := saveCachedListing(n.CacheDir, listURL, resp.Header, remotes); err != nil {
+			return nil, fmt.Errorf("failed to cache listing: %w", err)
+		}
+	}
+
+	return remotes, nil
+}
+
+// getPage requests a page of a paginated listing, and returns its body
+func (n ArchiveDotOrg) getPage(pageURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make new GET request: %w", err)
+	}
+	if len(n.UserAgent) > 0 {
+		req.Header.Set("User-Agent", n.UserAgent)
+	}
+	addHeaders(req, n.Header)
+	if len(n.Username) > 0 || len(n.Password) > 0 {
+		req.SetBasicAuth(n.Username, n.Password)
+	}
+
+	resp, err := doWithRetry(clientFor(n.Client, n.Header), req, n.Retries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected request status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// StatRemote looks up a single file under the BaseURL, using a HEAD request.
+func (n ArchiveDotOrg) StatRemote(name string) (RemoteFile, error) {
+	fileURL, err := url.Parse(n.BaseURL)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to parse base url '%s': %w", n.BaseURL, err)
+	}
+	req, err := http.NewRequest("HEAD", fileURL.JoinPath(name).String(), nil)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to make new HEAD request: %w", err)
+	}
+	if len(n.UserAgent) > 0 {
+		req.Header.Set("User-Agent", n.UserAgent)
+	}
+	addHeaders(req, n.Header)
+	if len(n.Username) > 0 || len(n.Password) > 0 {
+		req.SetBasicAuth(n.Username, n.Password)
+	}
+	// the listings only include hours and minutes
+	return statWithHead(clientFor(n.Client, n.Header), req, n.Retries, name, time.Minute)
 }
 
 func (n ArchiveDotOrg) ScrapeFromReader(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error) {
+	err := n.ScrapeFromReaderFunc(r, func(rf RemoteFile) error {
+		if err := checkFileLimit(len(remotes), n.MaxFiles); err != nil {
+			return err
+		}
+		remotes = append(remotes, rf)
+		return nil
+	})
+	if errors.Is(err, ErrTooManyFiles) {
+		return remotes, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return remotes, nil
+}
+
+// ScrapeFromReaderFunc parses the listing, and calls fn with each remote file as it is parsed,
+// so that enormous listings can be processed without holding every file in memory at once.
+// If fn returns an error, parsing stops, and that error is returned.
+func (n ArchiveDotOrg) ScrapeFromReaderFunc(r io.Reader, fn func(RemoteFile) error) error {
 	scanner := bufio.NewScanner(r)
 	adoType, err := n.readType(scanner)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing response body: %w", err)
+		return fmt.Errorf("error parsing response body: %w", err)
 	}
 
 	switch adoType {
 	case adostSimple:
-		remotes, err = n.parseSimple(scanner, remotes)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing as 'simple': %w", err)
+		if err := n.parseSimple(scanner, fn); err != nil {
+			return fmt.Errorf("error parsing as 'simple': %w", err)
 		}
 	case adostFull:
-		remotes, err = n.parseFull(scanner, remotes)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing as 'full': %w", err)
+		if err := n.parseFull(scanner, fn); err != nil {
+			return fmt.Errorf("error parsing as 'full': %w", err)
 		}
 	default:
-		return nil, fmt.Errorf("unrecognized adoType %d", adoType)
+		return fmt.Errorf("unrecognized adoType %d", adoType)
 	}
 
-	return remotes, nil
+	return nil
 }
 
 func (n ArchiveDotOrg) readType(s *bufio.Scanner) (adoSourceType, error) {
@@ -126,13 +305,16 @@ func (n ArchiveDotOrg) readType(s *bufio.Scanner) (adoSourceType, error) {
 
 var adoSimpleFileLineRE = regexp.MustCompile(`^<a href="([^"]+)">(.[^<]+)<\/a>\s*([0-9]+\-[a-zA-Z]+\-[0-9]+ [0-9]+:[0-9]+)\s+([0-9]+)$`)
 
-func (n ArchiveDotOrg) parseSimple(scanner *bufio.Scanner, remotes []RemoteFile) ([]RemoteFile, error) {
+func (n ArchiveDotOrg) parseSimple(scanner *bufio.Scanner, fn func(RemoteFile) error) error {
+	check := lineCheck{maxRatio: n.StrictRatio}
 	for scanner.Scan() {
 		line := scanner.Text()
 		matches := adoSimpleFileLineRE.FindStringSubmatch(line)
 		if matches == nil {
+			check.miss(line)
 			continue
 		}
+		check.match()
 		urlStr := matches[1]
 		// fileName := matches[2] // CANNOT TRUST THIS: it may be cut short and end in a '..>'
 		timeStr := matches[3]
@@ -140,51 +322,84 @@ func (n ArchiveDotOrg) parseSimple(scanner *bufio.Scanner, remotes []RemoteFile)
 
 		fileURL, err := url.Parse(urlStr)
 		if err != nil {
-			return remotes, fmt.Errorf("failed to parse url '%s': %w", urlStr, err)
+			return fmt.Errorf("failed to parse url '%s': %w", urlStr, err)
 		}
 
 		if !fileURL.IsAbs() {
 			fileURL, err = url.Parse(n.BaseURL)
 			if err != nil {
-				return remotes, fmt.Errorf("failed to parse base url '%s': %w", n.BaseURL, err)
+				return fmt.Errorf("failed to parse base url '%s': %w", n.BaseURL, err)
 			}
 			fileURL = fileURL.JoinPath(urlStr)
 		}
 
 		fileName := path.Base(fileURL.Path)
 
-		lastModified, err := time.Parse("02-Jan-2006 15:04", timeStr)
+		lastModified, err := parseListingTime("02-Jan-2006 15:04", timeStr, n.TimeZone)
 		if err != nil {
-			return remotes, fmt.Errorf("failed to parse time '%s': %w", timeStr, err)
+			return fmt.Errorf("failed to parse time '%s': %w", timeStr, err)
 		}
 
 		size, err := strconv.ParseInt(sizeStr, 10, 64)
 		if err != nil {
-			return remotes, fmt.Errorf("failed to parse size '%s': %w", sizeStr, err)
+			return fmt.Errorf("failed to parse size '%s': %w", sizeStr, err)
 		}
 
-		remotes = append(remotes, RemoteFile{
+		err = fn(RemoteFile{
 			Name:      fileName,
-			SortName:  strings.ToLower(fileName),
+			SortName:  SortName(fileName),
 			URL:       fileURL.String(),
 			Timestamp: lastModified,
 			Size:      size,
+			// the listing only includes hours and minutes
+			TimestampPrecision: time.Minute,
 		})
+		if err != nil {
+			return err
+		}
 	}
 	if err := scanner.Err(); err != nil {
-		return remotes, fmt.Errorf("failed to scan response body: %w", err)
+		return fmt.Errorf("failed to scan response body: %w", err)
 	}
 
-	return remotes, nil
+	return check.err()
 }
 
 var (
 	adoFullFileNameHeader = regexp.MustCompile(`^\s+<td><a href="[^"]+"><span class="iconochive-Uplevel" title="Parent Directory" aria-hidden="true"><\/span> Go to parent directory<\/a><\/td>$`)
 	adoFullFileNameLineRE = regexp.MustCompile(`^\s+<td><a href="([^"]+)">([^<]+)<\/a>.*<\/td>$`)
 	adoFullLastModifiedRE = regexp.MustCompile(`^\s+<td>([0-9]+\-[a-zA-Z]+\-[0-9]+ [0-9]+:[0-9]+)<\/td>$`)
+	adoFullSizeRE         = regexp.MustCompile(`^\s+<td>([0-9]+(?:\.([0-9]+))?)([BKMGTP])<\/td>$`)
 )
 
-func (n ArchiveDotOrg) parseFull(scanner *bufio.Scanner, remotes []RemoteFile) ([]RemoteFile, error) {
+// parseHumanizedSize parses a size as the full listing shows it (such as "135.3K", in binary units),
+// and returns the approximate size in bytes, and how far the exact size may be from it (which is zero
+// for a size in bytes, since those are exact).
+func parseHumanizedSize(num, decimals, unit string) (size, precision int64, err error) {
+	if unit == "B" {
+		n, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return -1, 0, err
+		}
+		return int64(n), 0, nil
+	}
+	n, err := humanize.ParseBytes(num + unit + "iB")
+	if err != nil {
+		return -1, 0, err
+	}
+	scale, err := humanize.ParseBytes("1" + unit + "iB")
+	if err != nil {
+		return -1, 0, err
+	}
+	// the listing rounds to its last digit, so allow for a whole one
+	precision = int64(scale)
+	for i := 0; i < len(decimals); i++ {
+		precision = (precision + 9) / 10
+	}
+	return int64(n), precision, nil
+}
+
+func (n ArchiveDotOrg) parseFull(scanner *bufio.Scanner, fn func(RemoteFile) error) error {
 	// scan down to the top of the file list
 	foundFileList := false
 	for scanner.Scan() {
@@ -195,7 +410,7 @@ func (n ArchiveDotOrg) parseFull(scanner *bufio.Scanner, remotes []RemoteFile) (
 		}
 	}
 	if !foundFileList {
-		return remotes, fmt.Errorf("failed to find file list")
+		return fmt.Errorf("failed to find file list")
 	}
 
 	// start looking for files
@@ -210,12 +425,12 @@ func (n ArchiveDotOrg) parseFull(scanner *bufio.Scanner, remotes []RemoteFile) (
 
 		fileURL, err := url.Parse(urlStr)
 		if err != nil {
-			return remotes, fmt.Errorf("failed to parse url '%s': %w", urlStr, err)
+			return fmt.Errorf("failed to parse url '%s': %w", urlStr, err)
 		}
 		if !fileURL.IsAbs() {
 			fileURL, err = url.Parse(n.BaseURL)
 			if err != nil {
-				return remotes, fmt.Errorf("failed to parse base url '%s': %w", n.BaseURL, err)
+				return fmt.Errorf("failed to parse base url '%s': %w", n.BaseURL, err)
 			}
 			fileURL = fileURL.JoinPath(urlStr)
 		}
@@ -228,26 +443,111 @@ func (n ArchiveDotOrg) parseFull(scanner *bufio.Scanner, remotes []RemoteFile) (
 			matches = adoFullLastModifiedRE.FindStringSubmatch(line)
 		}
 		if matches == nil {
-			return remotes, fmt.Errorf("failed to find last modified time for '%s'", fileName)
+			return fmt.Errorf("failed to find last modified time for '%s'", fileName)
 		}
 
 		timeStr := matches[1]
-		lastModified, err := time.Parse("02-Jan-2006 15:04", timeStr)
+		lastModified, err := parseListingTime("02-Jan-2006 15:04", timeStr, n.TimeZone)
 		if err != nil {
-			return remotes, fmt.Errorf("failed to parse time '%s': %w", timeStr, err)
+			return fmt.Errorf("failed to parse time '%s': %w", timeStr, err)
 		}
 
-		remotes = append(remotes, RemoteFile{
+		// the (humanized) size, if any, should be on the line after that
+		size, sizePrecision := int64(-1), int64(0)
+		if scanner.Scan() {
+			if matches = adoFullSizeRE.FindStringSubmatch(scanner.Text()); matches != nil {
+				size, sizePrecision, err = parseHumanizedSize(matches[1], matches[2], matches[3])
+				if err != nil {
+					return fmt.Errorf("failed to parse size '%s%s': %w", matches[1], matches[3], err)
+				}
+			}
+		}
+
+		err = fn(RemoteFile{
 			Name:      fileName,
-			SortName:  strings.ToLower(fileName),
+			SortName:  SortName(fileName),
 			URL:       fileURL.String(),
 			Timestamp: lastModified,
-			Size:      -1,
+			Size:      size,
+			// the listing only includes hours and minutes, and sizes to a few digits
+			TimestampPrecision: time.Minute,
+			SizePrecision:      sizePrecision,
 		})
+		if err != nil {
+			return err
+		}
 	}
 	if err := scanner.Err(); err != nil {
-		return remotes, fmt.Errorf("error while scanning: %w", err)
+		return fmt.Errorf("error while scanning: %w", err)
 	}
 
-	return remotes, nil
+	return nil
+}
+
+// ScrapeChecksums fetches the checksums of the item's files from archive.org's metadata API.
+// For example, a BaseURL of "https://archive.org/download/images/tv" uses the metadata at
+// "https://archive.org/metadata/images", and only includes the files under "tv/".
+func (n ArchiveDotOrg) ScrapeChecksums() (ChecksumProvider, error) {
+	metaURL, prefix, err := archiveMetadataURL(n.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", metaURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make new GET request: %w", err)
+	}
+	if len(n.UserAgent) > 0 {
+		req.Header.Set("User-Agent", n.UserAgent)
+	}
+	addHeaders(req, n.Header)
+	if len(n.Username) > 0 || len(n.Password) > 0 {
+		req.SetBasicAuth(n.Username, n.Password)
+	}
+
+	resp, err := doWithRetry(clientFor(n.Client, n.Header), req, n.Retries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected request status %d", resp.StatusCode)
+	}
+
+	return ParseJSONManifest(resp.Body, prefix)
+}
+
+// archiveMetadataURL returns the metadata API URL for the item in the given download URL,
+// and the prefix of the names (within that item) that are listed by the download URL.
+func archiveMetadataURL(baseURL string) (string, string, error) {
+	u, item, prefix, err := parseArchiveDownloadURL(baseURL)
+	if err != nil {
+		return "", "", err
+	}
+	u.Path = "/metadata/" + item
+	return u.String(), prefix, nil
+}
+
+// parseArchiveDownloadURL splits an archive.org download URL into the item identifier, and the
+// prefix of the names (within that item) that it lists. The returned URL has no path or query.
+func parseArchiveDownloadURL(baseURL string) (*url.URL, string, string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to parse base url '%s': %w", baseURL, err)
+	}
+	rest, ok := strings.CutPrefix(u.Path, "/download/")
+	if !ok {
+		return nil, "", "", fmt.Errorf("base url '%s' is not an archive.org download url", baseURL)
+	}
+	item, prefix, _ := strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	if len(item) == 0 {
+		return nil, "", "", fmt.Errorf("base url '%s' is missing the item identifier", baseURL)
+	}
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	u.Path = ""
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u, item, prefix, nil
 }
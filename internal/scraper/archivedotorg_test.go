@@ -1,10 +1,16 @@
 package scraper
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"testing"
+	"time"
 )
 
 func TestArchiveDotOrg_ScrapedCount(t *testing.T) {
@@ -39,6 +45,119 @@ func TestArchiveDotOrg_ScrapedCount(t *testing.T) {
 	}
 }
 
+func TestArchiveDotOrg_ScrapeFromReaderFunc(t *testing.T) {
+	errStop := errors.New("stop")
+	cases := []struct {
+		Name          string
+		StopAfter     int
+		ExpectedCount int
+	}{
+		{"images.tv.simple", 0, 140},
+		{"images.tv.full", 0, 140},
+		{"images.tv.simple", 10, 10},
+		{"images.tv.full", 10, 10},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s/%d", tc.Name, tc.StopAfter), func(t *testing.T) {
+			var s ArchiveDotOrg
+			f, err := os.Open("testdata/" + tc.Name)
+			if err != nil {
+				t.Fatalf("error opening '%s': %v", tc.Name, err)
+			}
+			defer f.Close()
+
+			count := 0
+			err = s.ScrapeFromReaderFunc(f, func(rf RemoteFile) error {
+				count++
+				if len(rf.Name) == 0 {
+					t.Errorf("file %d has no name", count)
+				}
+				if count == tc.StopAfter {
+					return errStop
+				}
+				return nil
+			})
+			if tc.StopAfter > 0 {
+				if !errors.Is(err, errStop) {
+					t.Errorf("expected the callback's error, but got: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error in ScrapeFromReaderFunc: %v", err)
+			}
+
+			if count != tc.ExpectedCount {
+				t.Errorf("expected %d, but found %d", tc.ExpectedCount, count)
+			}
+		})
+	}
+}
+
+func TestArchiveDotOrg_ParseHumanizedSize(t *testing.T) {
+	cases := []struct {
+		Input             string
+		ExpectedSize      int64
+		ExpectedPrecision int64
+	}{
+		{"43.0B", 43, 0},
+		{"135.3K", 138547, 103},
+		{"27.7M", 29045555, 104858},
+		{"1.7G", 1825361100, 107374183},
+		{"2K", 2048, 1024},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Input, func(t *testing.T) {
+			m := adoFullSizeRE.FindStringSubmatch("  <td>" + tc.Input + "</td>")
+			if m == nil {
+				t.Fatalf("expected '%s' to match", tc.Input)
+			}
+			size, precision, err := parseHumanizedSize(m[1], m[2], m[3])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if size != tc.ExpectedSize || precision != tc.ExpectedPrecision {
+				t.Errorf("expected %d (within %d), but got %d (within %d)", tc.ExpectedSize, tc.ExpectedPrecision, size, precision)
+			}
+		})
+	}
+}
+
+func TestArchiveDotOrg_FullSizes(t *testing.T) {
+	simple, err := os.Open("testdata/images.tv.simple")
+	if err != nil {
+		t.Fatalf("error opening simple listing: %v", err)
+	}
+	defer simple.Close()
+	full, err := os.Open("testdata/images.tv.full")
+	if err != nil {
+		t.Fatalf("error opening full listing: %v", err)
+	}
+	defer full.Close()
+
+	var s ArchiveDotOrg
+	exact, err := s.ScrapeFromReader(simple, nil)
+	if err != nil {
+		t.Fatalf("unexpected error scraping simple listing: %v", err)
+	}
+	approx, err := s.ScrapeFromReader(full, nil)
+	if err != nil {
+		t.Fatalf("unexpected error scraping full listing: %v", err)
+	}
+	if len(exact) != len(approx) {
+		t.Fatalf("expected %d, but found %d", len(exact), len(approx))
+	}
+	for i := range exact {
+		a := approx[i]
+		if a.Size < 0 || a.SizePrecision <= 0 || a.ExactSize() != -1 {
+			t.Errorf("%s: expected an approximate size, but got %d (within %d)", a.Name, a.Size, a.SizePrecision)
+			continue
+		}
+		if d := a.Size - exact[i].Size; d < -a.SizePrecision || d > a.SizePrecision {
+			t.Errorf("%s: expected %d to be within %d of %d", a.Name, a.Size, a.SizePrecision, exact[i].Size)
+		}
+	}
+}
+
 func TestArchiveDotOrg_ScrapedContents(t *testing.T) {
 	cases := []struct {
 		FileA string
@@ -118,3 +237,146 @@ func TestArchiveDotOrg_SimpleLongNameTruncation(t *testing.T) {
 		})
 	}
 }
+
+func TestArchiveDotOrg_ConditionalScrape(t *testing.T) {
+	// a weak ETag is sent back as-is (with its W/ prefix), since If-None-Match uses weak comparison
+	for _, etag := range []string{`"v1"`, `W/"v1"`} {
+		t.Run(etag, func(t *testing.T) {
+			testArchiveDotOrgConditionalScrape(t, etag)
+		})
+	}
+}
+
+func testArchiveDotOrgConditionalScrape(t *testing.T, etag string) {
+	body, err := os.ReadFile("testdata/images.tv.simple")
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+
+	var fullResponses, notModifiedResponses int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			notModifiedResponses++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullResponses++
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	s := ArchiveDotOrg{BaseURL: srv.URL, CacheDir: t.TempDir()}
+	first, err := s.ScrapeRemotes()
+	if err != nil {
+		t.Fatalf("unexpected error on first scrape: %v", err)
+	}
+	second, err := s.ScrapeRemotes()
+	if err != nil {
+		t.Fatalf("unexpected error on second scrape: %v", err)
+	}
+
+	if fullResponses != 1 || notModifiedResponses != 1 {
+		t.Errorf("expected 1 full and 1 not modified response, but got %d and %d", fullResponses, notModifiedResponses)
+	}
+	if len(first) != 140 || len(second) != len(first) {
+		t.Fatalf("expected 140 entries from both scrapes, but got %d and %d", len(first), len(second))
+	}
+	for i := range first {
+		if first[i].Name != second[i].Name || first[i].URL != second[i].URL ||
+			!first[i].Timestamp.Equal(second[i].Timestamp) || first[i].Size != second[i].Size {
+			t.Errorf("mismatch in entry %d: first=%v, second=%v", i, first[i], second[i])
+		}
+	}
+}
+
+func TestArchiveDotOrg_NextPage(t *testing.T) {
+	pageOne, err := os.ReadFile("testdata/images.tv.simple")
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+	pageOne = append(pageOne, []byte(`<a class="next" href="?page=2">Next</a>`+"\n")...)
+	pageTwo, err := os.ReadFile("testdata/longnames.simple")
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("page") == "2" {
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write(pageTwo)
+			return
+		}
+		if r.Header.Get("If-None-Match") != "" {
+			t.Errorf("expected a paginated listing to not be requested conditionally")
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write(pageOne)
+	}))
+	defer srv.Close()
+
+	s := ArchiveDotOrg{
+		BaseURL:  srv.URL + "/download/images/tv",
+		CacheDir: t.TempDir(),
+		NextPage: regexp.MustCompile(`<a class="next" href="([^"]+)"`),
+	}
+	for i := 0; i < 2; i++ {
+		remotes, err := s.ScrapeRemotes()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(remotes) != 145 {
+			t.Errorf("expected 145 files from both pages, but got %d", len(remotes))
+		}
+	}
+	if requests != 4 {
+		t.Errorf("expected 2 requests per scrape, but got %d in all", requests)
+	}
+}
+
+func TestArchiveDotOrg_RequestOptions(t *testing.T) {
+	body, err := os.ReadFile("testdata/images.tv.simple")
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+
+	var gotHeader http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Clone()
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	s, err := Create("archive.org",
+		BaseURL(srv.URL),
+		UserAgent("needl-test"),
+		Header("X-Token", "abc"),
+		Header("X-Token", "def"),
+		HTTPClient(srv.Client()),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.ScrapeRemotes(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ua := gotHeader.Get("User-Agent"); ua != "needl-test" {
+		t.Errorf("expected user agent 'needl-test', but got '%s'", ua)
+	}
+	if tokens := gotHeader.Values("X-Token"); fmt.Sprint(tokens) != "[abc def]" {
+		t.Errorf("expected X-Token headers [abc def], but got %v", tokens)
+	}
+
+	s, err = Create("archive.org", BaseURL(srv.URL+"/slow"), Timeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.ScrapeRemotes(); err == nil {
+		t.Errorf("expected a timeout error")
+	}
+}
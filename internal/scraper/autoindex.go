@@ -0,0 +1,253 @@
+package scraper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AutoIndex scrapes the directory listings that web servers generate for themselves, such as
+// from nginx's autoindex module ("nginx"), or Apache's mod_autoindex ("apache").
+// Sub-folders are skipped, and both only list times to the minute.
+// nginx lists exact sizes (unless autoindex_exact_size is off), but Apache only lists humanized
+// sizes (like "1.2K"), so Apache listings have unknown sizes.
+// nginx lists times in UTC (unless autoindex_localtime is on), but Apache uses the server's
+// time zone, so for Apache (or nginx with autoindex_localtime) TimeZone should be the server's.
+type AutoIndex struct {
+	Server    string // "nginx" or "apache"
+	BaseURL   string
+	UserAgent string
+	Username  string
+	Password  string
+	Retries   int
+
+	// Header holds any extra headers sent with each request.
+	Header http.Header
+
+	// TimeZone is the time zone of the listing's times (or nil for UTC).
+	TimeZone *time.Location
+
+	// Client makes the requests (or a default client, if nil).
+	Client *http.Client
+
+	// StrictRatio, if non-zero, fails the parse if there are more than this many unparsed lines
+	// that look like they list a file, for each line that was parsed (see StrictParse).
+	StrictRatio float64
+
+	// MaxFiles caps how many files the listing may have (or zero for DefaultMaxFiles).
+	MaxFiles int
+
+	// NextPage, if set, finds the link to the next page of a paginated listing (see the NextPage
+	// option). The files on every page are taken to be in the BaseURL's folder.
+	NextPage *regexp.Regexp
+
+	// MaxPages caps how many pages a paginated listing may have (or zero for DefaultMaxPages).
+	MaxPages int
+
+	// Delay is how long to wait between requests for the pages of a paginated listing.
+	Delay time.Duration
+}
+
+func init() {
+	descriptions := map[string]string{
+		"nginx":  "a folder listing from nginx's autoindex module",
+		"apache": "a folder listing from Apache's mod_autoindex (sizes are not exact)",
+	}
+	for server, description := range descriptions {
+		server := server
+		Register(server, func(name string, opts ...Option) (Scraper, error) {
+			p := parseOptions(opts)
+			if len(p.baseURL) == 0 {
+				return nil, fmt.Errorf("missing required option: BaseURL")
+			}
+			baseURL, err := NormalizeBaseURL(p.baseURL, p.trailingSlash)
+			if err != nil {
+				return nil, err
+			}
+			return &AutoIndex{
+				Server:    server,
+				BaseURL:   baseURL,
+				UserAgent: p.userAgent,
+				Username:  p.auth.username,
+				Password:  p.auth.password,
+				Retries:   p.retries,
+				Header:    p.header,
+				TimeZone:  p.timeZone,
+				Client:    clientWithTimeout(p.client, p.timeout),
+
+				StrictRatio: p.strictRatio,
+				MaxFiles:    p.maxFiles,
+				NextPage:    p.nextPage,
+				MaxPages:    p.maxPages,
+				Delay:       p.delay,
+			}, nil
+		}, Info{
+			Description: description,
+			Required:    []string{"BaseURL"},
+			Optional: []string{
+				"TrailingSlashMode", "BasicAuth", "UserAgent", "Header", "Timeout", "HTTPClient", "Retries", "TimeZone", "StrictParse", "MaxFiles",
+				"NextPage", "MaxPages", "Delay",
+			},
+		})
+	}
+}
+
+func (a AutoIndex) ScrapeRemotes() ([]RemoteFile, error) {
+	p := pages{NextPage: a.NextPage, MaxPages: a.MaxPages, Delay: a.Delay}
+	return p.scrape(a.BaseURL, a.getPage, a.ScrapeFromReader, make([]RemoteFile, 0, 256))
+}
+
+// getPage requests a page of the listing, and returns its body
+func (a AutoIndex) getPage(pageURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make new GET request: %w", err)
+	}
+	if len(a.UserAgent) > 0 {
+		req.Header.Set("User-Agent", a.UserAgent)
+	}
+	addHeaders(req, a.Header)
+	if len(a.Username) > 0 || len(a.Password) > 0 {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+
+	resp, err := doWithRetry(clientFor(a.Client, a.Header), req, a.Retries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected request status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// StatRemote looks up a single file in the folder, using a HEAD request.
+func (a AutoIndex) StatRemote(name string) (RemoteFile, error) {
+	dirURL, err := a.dirURL()
+	if err != nil {
+		return RemoteFile{}, err
+	}
+	req, err := http.NewRequest("HEAD", dirURL.JoinPath(name).String(), nil)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to make new HEAD request: %w", err)
+	}
+	if len(a.UserAgent) > 0 {
+		req.Header.Set("User-Agent", a.UserAgent)
+	}
+	addHeaders(req, a.Header)
+	if len(a.Username) > 0 || len(a.Password) > 0 {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+	// the listings only include hours and minutes
+	return statWithHead(clientFor(a.Client, a.Header), req, a.Retries, name, time.Minute)
+}
+
+// dirURL returns the BaseURL, with a trailing '/', since hrefs are relative to the folder
+func (a AutoIndex) dirURL() (*url.URL, error) {
+	dirURL, err := url.Parse(a.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base url '%s': %w", a.BaseURL, err)
+	}
+	if !strings.HasSuffix(dirURL.Path, "/") {
+		dirURL.Path += "/"
+		dirURL.RawPath = ""
+	}
+	return dirURL, nil
+}
+
+var (
+	// nginx, as in:
+	//   <a href="file.bin">file.bin</a>                       02-Jan-2006 15:04             1234
+	autoIndexNginxLineRE = regexp.MustCompile(`<a href="([^"]+)">[^<]*</a>\s+([0-9]{2}-[A-Za-z]{3}-[0-9]{4} [0-9]{2}:[0-9]{2})\s+(\S+)\s*$`)
+
+	// Apache, either as a table row, or (without HTMLTable) as a line of a <pre>, as in:
+	//   <td><a href="file.bin">file.bin</a></td><td align="right">2006-01-02 15:04  </td><td align="right">1.2K</td>
+	//   <a href="file.bin">file.bin</a>               2006-01-02 15:04  1.2K
+	autoIndexApacheLineRE = regexp.MustCompile(`<a href="([^"]+)">[^<]*</a>(?:</td><td[^>]*>|\s+)([0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2})`)
+)
+
+// ScrapeFromReader parses a directory listing page.
+func (a AutoIndex) ScrapeFromReader(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error) {
+	// hrefs are relative to the folder, so resolve them as if the base URL ends in a '/'
+	dirURL, err := a.dirURL()
+	if err != nil {
+		return nil, err
+	}
+
+	var lineRE *regexp.Regexp
+	var timeLayout string
+	switch a.Server {
+	case "nginx":
+		lineRE, timeLayout = autoIndexNginxLineRE, "02-Jan-2006 15:04"
+	case "apache":
+		lineRE, timeLayout = autoIndexApacheLineRE, "2006-01-02 15:04"
+	default:
+		return nil, fmt.Errorf("unrecognized server '%s'", a.Server)
+	}
+
+	check := lineCheck{maxRatio: a.StrictRatio}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		matches := lineRE.FindStringSubmatch(scanner.Text())
+		if matches == nil {
+			check.miss(scanner.Text())
+			continue
+		}
+		check.match()
+		href := matches[1]
+		if strings.HasSuffix(href, "/") || strings.HasPrefix(href, "?") {
+			// sub-folders, the parent folder, and sorting links
+			continue
+		}
+
+		ref, err := url.Parse(href)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse url '%s': %w", href, err)
+		}
+		fileURL := dirURL.ResolveReference(ref)
+		fileName := path.Base(fileURL.Path)
+
+		lastModified, err := parseListingTime(timeLayout, matches[2], a.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse time '%s': %w", matches[2], err)
+		}
+
+		if err := checkFileLimit(len(remotes), a.MaxFiles); err != nil {
+			return remotes, err
+		}
+
+		size := int64(-1)
+		if len(matches) > 3 {
+			// nginx shows "-" for folders, or a humanized size if autoindex_exact_size is off
+			if n, err := strconv.ParseInt(matches[3], 10, 64); err == nil {
+				size = n
+			}
+		}
+
+		remotes = append(remotes, RemoteFile{
+			Name:      fileName,
+			SortName:  SortName(fileName),
+			URL:       fileURL.String(),
+			Timestamp: lastModified,
+			Size:      size,
+			// the listing only includes hours and minutes
+			TimestampPrecision: time.Minute,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan response body: %w", err)
+	}
+	if err := check.err(); err != nil {
+		return nil, err
+	}
+
+	return remotes, nil
+}
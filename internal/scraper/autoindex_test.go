@@ -0,0 +1,62 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoIndex_Fixtures(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Server        string
+		Fixture       string
+		ExpectedCount int
+		Entries       []fixtureEntry
+	}{
+		{
+			"nginx", "nginx", "mirror.nginx", 5,
+			[]fixtureEntry{
+				{"debian-12.5.0-amd64-netinst.iso", "https://mirror.example.com/mirror/debian-12.5.0-amd64-netinst.iso", 659554304, "2024-02-10 11:06"},
+				{"file with spaces.txt", "https://mirror.example.com/mirror/file%20with%20spaces.txt", 12, "2023-03-01 08:00"},
+				// the link text is truncated, but the href is not
+				{"kvm-qemu-a-really-long-file-name-that-nginx-will-truncate.qcow2", "", 2147483648, "2023-12-29 23:59"},
+				{"README", "", 2048, "2021-05-05 04:03"},
+			},
+		},
+		{
+			"apache table", "apache", "mirror.apache", 4,
+			[]fixtureEntry{
+				{"debian-12.5.0-amd64-netinst.iso", "https://mirror.example.com/mirror/debian-12.5.0-amd64-netinst.iso", -1, "2024-02-10 11:06"},
+				{"file with spaces.txt", "https://mirror.example.com/mirror/file%20with%20spaces.txt", -1, "2023-03-01 08:00"},
+				{"SHA256SUMS", "", -1, "2021-05-05 04:03"},
+			},
+		},
+		{
+			"apache pre", "apache", "mirror.apache.pre", 4,
+			[]fixtureEntry{
+				{"debian-12.5.0-amd64-netinst.iso", "https://mirror.example.com/mirror/debian-12.5.0-amd64-netinst.iso", -1, "2024-02-10 11:06"},
+				{"checksums.sha256", "", -1, "2024-01-03 17:45"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			// no trailing slash, to check that hrefs are still resolved within the folder
+			s := AutoIndex{Server: tc.Server, BaseURL: "https://mirror.example.com/mirror"}
+			checkFixture(t, s, tc.Fixture, tc.ExpectedCount, tc.Entries...)
+		})
+	}
+}
+
+func TestAutoIndex_TimeZone(t *testing.T) {
+	// the listing says 11:06 in the server's zone, which is 16:06 UTC
+	s := AutoIndex{
+		Server:   "apache",
+		BaseURL:  "https://mirror.example.com/mirror/",
+		TimeZone: time.FixedZone("EST", -5*60*60),
+	}
+	checkFixture(t, s, "mirror.apache", 4,
+		fixtureEntry{"debian-12.5.0-amd64-netinst.iso", "", -1, "2024-02-10 16:06"},
+	)
+}
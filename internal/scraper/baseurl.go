@@ -0,0 +1,60 @@
+package scraper
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TrailingSlash controls what is done with a trailing '/' on a scraper's base URL.
+// This matters because some servers (archive.org in particular) respond with a different
+// listing format depending on whether or not the URL ends in a '/'.
+type TrailingSlash string
+
+const (
+	TrailingSlashKeep   TrailingSlash = "keep"   // leave the URL as is (the default)
+	TrailingSlashAdd    TrailingSlash = "add"    // ensure the URL path ends in a '/'
+	TrailingSlashRemove TrailingSlash = "remove" // ensure the URL path does not end in a '/'
+)
+
+// ParseTrailingSlash converts a config string to a TrailingSlash, where empty means TrailingSlashKeep.
+func ParseTrailingSlash(s string) (TrailingSlash, error) {
+	switch TrailingSlash(s) {
+	case "", TrailingSlashKeep:
+		return TrailingSlashKeep, nil
+	case TrailingSlashAdd, TrailingSlashRemove:
+		return TrailingSlash(s), nil
+	}
+	return "", fmt.Errorf("unrecognized trailing slash mode '%s' (expected '%s', '%s', or '%s')",
+		s, TrailingSlashKeep, TrailingSlashAdd, TrailingSlashRemove)
+}
+
+// NormalizeBaseURL verifies that raw is an absolute http or https URL, and then applies the
+// given trailing slash mode to its path.
+func NormalizeBaseURL(raw string, ts TrailingSlash) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("invalid base url '%s': %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid base url '%s': scheme must be http or https", raw)
+	}
+	if len(u.Host) == 0 {
+		return "", fmt.Errorf("invalid base url '%s': missing host", raw)
+	}
+
+	switch ts {
+	case "", TrailingSlashKeep:
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+	case TrailingSlashRemove:
+		u.Path = strings.TrimRight(u.Path, "/")
+	default:
+		return "", fmt.Errorf("unrecognized trailing slash mode '%s'", ts)
+	}
+	u.RawPath = ""
+
+	return u.String(), nil
+}
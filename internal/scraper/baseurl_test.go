@@ -0,0 +1,42 @@
+package scraper
+
+import "testing"
+
+func TestNormalizeBaseURL(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Raw      string
+		Mode     TrailingSlash
+		Expected string
+		IsErr    bool
+	}{
+		{"keep without slash", "https://archive.org/download/images/tv", TrailingSlashKeep, "https://archive.org/download/images/tv", false},
+		{"keep with slash", "https://archive.org/download/images/tv/", TrailingSlashKeep, "https://archive.org/download/images/tv/", false},
+		{"add", "https://archive.org/download/images/tv", TrailingSlashAdd, "https://archive.org/download/images/tv/", false},
+		{"add already there", "https://archive.org/download/images/tv/", TrailingSlashAdd, "https://archive.org/download/images/tv/", false},
+		{"remove", "https://archive.org/download/images/tv//", TrailingSlashRemove, "https://archive.org/download/images/tv", false},
+		{"trims whitespace", "  http://example.com/files ", TrailingSlashKeep, "http://example.com/files", false},
+		{"relative", "/download/images/tv", TrailingSlashKeep, "", true},
+		{"no host", "https:///tv", TrailingSlashKeep, "", true},
+		{"bad scheme", "ftp://example.com/files", TrailingSlashKeep, "", true},
+		{"unparseable", "http://example.com/%zz", TrailingSlashKeep, "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual, err := NormalizeBaseURL(tc.Raw, tc.Mode)
+			if tc.IsErr {
+				if err == nil {
+					t.Fatalf("expected error, but got '%s'", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != tc.Expected {
+				t.Errorf("expected '%s', but got '%s'", tc.Expected, actual)
+			}
+		})
+	}
+}
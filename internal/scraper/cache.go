@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/natefinch/atomic"
+)
+
+// cachedListing is the result of a previous scrape of a single listing URL, along with the
+// validators needed to make a conditional request for that same listing in a later run.
+// The ETag may be weak (starting with W/), since it is only ever sent back in If-None-Match,
+// which compares weakly. It only says whether the listing changed, so it is never used to verify
+// the listing's (or any file's) contents.
+type cachedListing struct {
+	URL          string       `json:"url"`
+	ETag         string       `json:"etag,omitempty"`
+	LastModified string       `json:"last_modified,omitempty"`
+	Remotes      []RemoteFile `json:"remotes"`
+}
+
+// hasValidators returns true if a conditional request can be made for this listing.
+func (c cachedListing) hasValidators() bool {
+	return len(c.ETag) > 0 || len(c.LastModified) > 0
+}
+
+// setConditionalHeaders adds If-None-Match and/or If-Modified-Since headers to the request.
+func (c cachedListing) setConditionalHeaders(req *http.Request) {
+	if len(c.ETag) > 0 {
+		req.Header.Set("If-None-Match", c.ETag)
+	}
+	if len(c.LastModified) > 0 {
+		req.Header.Set("If-Modified-Since", c.LastModified)
+	}
+}
+
+func cachePath(dir, listingURL string) string {
+	sum := sha256.Sum256([]byte(listingURL))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// loadCachedListing returns the cached listing for the given URL, if there is one.
+// A missing or unreadable cache entry is not an error, it just means there's no cache.
+func loadCachedListing(dir, listingURL string) (cachedListing, bool) {
+	b, err := os.ReadFile(cachePath(dir, listingURL))
+	if err != nil {
+		return cachedListing{}, false
+	}
+	var c cachedListing
+	if err := json.Unmarshal(b, &c); err != nil || c.URL != listingURL {
+		return cachedListing{}, false
+	}
+	return c, true
+}
+
+// saveCachedListing stores the listing and its validators from the response headers.
+// If the response has no validators, then any existing cache entry is removed instead,
+// as it could never be used for a conditional request.
+func saveCachedListing(dir, listingURL string, h http.Header, remotes []RemoteFile) error {
+	c := cachedListing{
+		URL:          listingURL,
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+		Remotes:      remotes,
+	}
+	path := cachePath(dir, listingURL)
+	if !c.hasValidators() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove stale cache '%s': %w", path, err)
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode cache: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create cache dir '%s': %w", dir, err)
+	}
+	if err := atomic.WriteFile(path, bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("write cache '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package scraper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ChecksumProvider is implemented by anything that knows the expected checksums of remote files,
+// such as a scraper's metadata, a sums file, or a JSON manifest.
+type ChecksumProvider interface {
+	// ChecksumFor returns the checksum of the named remote file, with algo being one of
+	// "md5", "sha1", "sha256", or "sha512", and hex being the lowercase hex encoded digest.
+	ChecksumFor(name string) (algo, hex string, ok bool)
+}
+
+// ChecksumSource is implemented by scrapers that can also provide the checksums of the files they list.
+type ChecksumSource interface {
+	ScrapeChecksums() (ChecksumProvider, error)
+}
+
+// Checksums is a ChecksumProvider backed by a map of file name to checksum.
+type Checksums map[string]FileChecksum
+
+type FileChecksum struct {
+	Algo string
+	Hex  string
+}
+
+func (c Checksums) ChecksumFor(name string) (string, string, bool) {
+	fc, ok := c[name]
+	return fc.Algo, fc.Hex, ok
+}
+
+// MultiChecksums is a ChecksumProvider that asks each provider in turn, and returns the first checksum found.
+type MultiChecksums []ChecksumProvider
+
+func (m MultiChecksums) ChecksumFor(name string) (string, string, bool) {
+	for _, p := range m {
+		if algo, hex, ok := p.ChecksumFor(name); ok {
+			return algo, hex, true
+		}
+	}
+	return "", "", false
+}
+
+// algoStrength orders the supported algorithms from weakest to strongest
+var algoStrength = map[string]int{"md5": 1, "sha1": 2, "sha256": 3, "sha512": 4}
+
+// algoForHexLen guesses the algorithm from the length of its hex digest
+var algoForHexLen = map[int]string{32: "md5", 40: "sha1", 64: "sha256", 128: "sha512"}
+
+var (
+	reHexDigest = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	// BSD style, as in "SHA256 (name) = hex"
+	reBSDSum = regexp.MustCompile(`^([A-Za-z0-9]+) \((.*)\) = ([0-9a-fA-F]+)$`)
+)
+
+// ParseSumsFile parses the output of tools like sha256sum or md5sum, where each line is a hex digest,
+// whitespace, and a file name (optionally prefixed by '*'). BSD style lines ("SHA256 (name) = hex")
+// are also accepted. For the former, the algorithm is guessed from the length of the digest.
+func ParseSumsFile(r io.Reader) (Checksums, error) {
+	sums := make(Checksums)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var algo, digest, name string
+		if m := reBSDSum.FindStringSubmatch(line); m != nil {
+			algo, name, digest = strings.ToLower(m[1]), m[2], m[3]
+		} else {
+			var ok bool
+			digest, name, ok = strings.Cut(line, " ")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected a digest and a name", lineNum)
+			}
+			name = strings.TrimPrefix(strings.TrimLeft(name, " \t"), "*")
+			algo = algoForHexLen[len(digest)]
+		}
+		if _, ok := algoStrength[algo]; !ok || !reHexDigest.MatchString(digest) {
+			return nil, fmt.Errorf("line %d: unrecognized digest '%s'", lineNum, digest)
+		}
+		sums.add(name, algo, digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// manifestFile is one entry in a JSON manifest. archive.org's metadata API uses the same shape.
+type manifestFile struct {
+	Name   string `json:"name"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
+// ParseJSONManifest parses a JSON object with a "files" array, where each file has a "name", and
+// any of "md5", "sha1", "sha256", or "sha512". When a file has more than one, the strongest is used.
+// Only names that start with prefix are included (with the prefix removed).
+func ParseJSONManifest(r io.Reader, prefix string) (Checksums, error) {
+	var manifest struct {
+		Files []manifestFile `json:"files"`
+	}
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	sums := make(Checksums)
+	for _, f := range manifest.Files {
+		name, ok := strings.CutPrefix(f.Name, prefix)
+		if !ok || len(name) == 0 {
+			continue
+		}
+		for algo, digest := range map[string]string{"md5": f.MD5, "sha1": f.SHA1, "sha256": f.SHA256, "sha512": f.SHA512} {
+			if len(digest) > 0 && reHexDigest.MatchString(digest) {
+				sums.add(name, algo, digest)
+			}
+		}
+	}
+	return sums, nil
+}
+
+// add stores the checksum, unless the file already has a checksum from a stronger algorithm
+func (c Checksums) add(name, algo, digest string) {
+	if prev, ok := c[name]; ok && algoStrength[prev.Algo] >= algoStrength[algo] {
+		return
+	}
+	c[name] = FileChecksum{Algo: algo, Hex: strings.ToLower(digest)}
+}
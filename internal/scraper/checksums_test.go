@@ -0,0 +1,124 @@
+package scraper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSumsFile(t *testing.T) {
+	input := strings.Join([]string{
+		"# comment",
+		"d41d8cd98f00b204e9800998ecf8427e  empty.txt",
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 *binary.bin",
+		"SHA1 (bsd style.txt) = DA39A3EE5E6B4B0D3255BFEF95601890AFD80709",
+		"",
+	}, "\n")
+
+	sums, err := ParseSumsFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		Name string
+		Algo string
+		Hex  string
+	}{
+		{"empty.txt", "md5", "d41d8cd98f00b204e9800998ecf8427e"},
+		{"binary.bin", "sha256", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"bsd style.txt", "sha1", "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+	}
+	if len(sums) != len(cases) {
+		t.Errorf("expected %d checksums, but got %d", len(cases), len(sums))
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			algo, hex, ok := sums.ChecksumFor(tc.Name)
+			if !ok {
+				t.Fatalf("expected a checksum")
+			}
+			if algo != tc.Algo || hex != tc.Hex {
+				t.Errorf("expected %s:%s, but got %s:%s", tc.Algo, tc.Hex, algo, hex)
+			}
+		})
+	}
+}
+
+func TestParseSumsFile_Invalid(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Input string
+	}{
+		{"no name", "d41d8cd98f00b204e9800998ecf8427e"},
+		{"bad length", "d41d8cd98f  empty.txt"},
+		{"not hex", "z41d8cd98f00b204e9800998ecf8427e  empty.txt"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := ParseSumsFile(strings.NewReader(tc.Input)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseJSONManifest(t *testing.T) {
+	// the same shape as archive.org's metadata API
+	input := `{"files": [
+		{"name": "tv/a.jpg", "md5": "d41d8cd98f00b204e9800998ecf8427e", "sha1": "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+		{"name": "tv/b.jpg", "md5": "D41D8CD98F00B204E9800998ECF8427E"},
+		{"name": "other/c.jpg", "md5": "d41d8cd98f00b204e9800998ecf8427e"},
+		{"name": "tv/no-sums.jpg"}
+	]}`
+
+	sums, err := ParseJSONManifest(strings.NewReader(input), "tv/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sums) != 2 {
+		t.Errorf("expected 2 checksums, but got %d", len(sums))
+	}
+	if algo, _, _ := sums.ChecksumFor("a.jpg"); algo != "sha1" {
+		t.Errorf("expected the strongest algorithm (sha1), but got '%s'", algo)
+	}
+	if _, hex, _ := sums.ChecksumFor("b.jpg"); hex != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("expected lowercase hex, but got '%s'", hex)
+	}
+	if _, _, ok := sums.ChecksumFor("c.jpg"); ok {
+		t.Errorf("expected names outside the prefix to be skipped")
+	}
+}
+
+func TestArchiveMetadataURL(t *testing.T) {
+	cases := []struct {
+		BaseURL        string
+		ExpectedURL    string
+		ExpectedPrefix string
+		IsErr          bool
+	}{
+		{"https://archive.org/download/images/tv", "https://archive.org/metadata/images", "tv/", false},
+		{"https://archive.org/download/images/tv/", "https://archive.org/metadata/images", "tv/", false},
+		{"https://archive.org/download/images", "https://archive.org/metadata/images", "", false},
+		{"https://archive.org/details/images", "", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.BaseURL, func(t *testing.T) {
+			u, prefix, err := archiveMetadataURL(tc.BaseURL)
+			if tc.IsErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if u != tc.ExpectedURL {
+				t.Errorf("expected url '%s', but got '%s'", tc.ExpectedURL, u)
+			}
+			if prefix != tc.ExpectedPrefix {
+				t.Errorf("expected prefix '%s', but got '%s'", tc.ExpectedPrefix, prefix)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exec lists the files by running an external program, for sources that none of the built-in types
+// can list. The program is run with Command, plus the BaseURL as its last argument, and prints the
+// listing to stdout, either as a JSON array of objects (with "name", and optionally "size", "time",
+// and "url"), or as lines of tab separated values (name, then optionally size, time, and url). A size
+// that is missing (or "-" in TSV) is unknown. A time is either RFC 3339 or "2006-01-02 15:04:05" (in
+// the TimeZone). A url may be relative to the BaseURL, and a missing one is the name, relative to the
+// BaseURL. In TSV, blank lines and lines that start with '#' are skipped.
+type Exec struct {
+	BaseURL  string
+	Command  []string
+	Timeout  time.Duration  // for the program to finish (or zero for no limit)
+	Location *time.Location // of times that don't say which zone they are in
+
+	// MaxFiles caps how many files the listing may have (or zero for DefaultMaxFiles).
+	MaxFiles int
+}
+
+func init() {
+	Register("exec", func(name string, opts ...Option) (Scraper, error) {
+		p := parseOptions(opts)
+		if len(p.command) == 0 || len(p.command[0]) == 0 {
+			return nil, fmt.Errorf("missing required option: Command")
+		}
+		loc := p.timeZone
+		if loc == nil {
+			loc = time.UTC
+		}
+		return &Exec{
+			BaseURL:  p.baseURL,
+			Command:  p.command,
+			Timeout:  p.timeout,
+			Location: loc,
+			MaxFiles: p.maxFiles,
+		}, nil
+	}, Info{
+		Description: "the output of an external program (which is passed the base url), as JSON or TSV",
+		Required:    []string{"BaseURL", "Command"},
+		Optional:    []string{"Timeout", "TimeZone", "MaxFiles"},
+	})
+}
+
+// execWaitDelay is how long to wait for the output of a program that was killed to be closed
+const execWaitDelay = time.Second
+
+func (e Exec) ScrapeRemotes() ([]RemoteFile, error) {
+	ctx := context.Background()
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command[0], append(e.Command[1:len(e.Command):len(e.Command)], e.BaseURL)...)
+	cmd.Stderr = &stderr
+	// a program that is killed (at the timeout) may have left children that still hold its output open
+	cmd.WaitDelay = execWaitDelay
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command '%s' took longer than %v", e.Command[0], e.Timeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("command '%s' exited with status %d: %s",
+				e.Command[0], exitErr.ExitCode(), strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("command '%s': %w", e.Command[0], err)
+	}
+	return e.ScrapeFromReader(bytes.NewReader(out), make([]RemoteFile, 0, 256))
+}
+
+// ScrapeFromReader parses the program's output (see Exec).
+func (e Exec) ScrapeFromReader(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error) {
+	br := bufio.NewReader(r)
+	first, err := firstNonSpace(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read listing: %w", err)
+	}
+	if first == '[' {
+		return e.scrapeJSON(br, remotes)
+	}
+	return e.scrapeTSV(br, remotes)
+}
+
+// firstNonSpace returns the first byte of r that isn't white space (without consuming it), or zero if
+// there isn't one.
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+type execFile struct {
+	Name string `json:"name"`
+	Size *int64 `json:"size"`
+	Time string `json:"time"`
+	URL  string `json:"url"`
+}
+
+func (e Exec) scrapeJSON(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error) {
+	var files []execFile
+	if err := json.NewDecoder(r).Decode(&files); err != nil {
+		return nil, fmt.Errorf("error parsing json listing: %w", err)
+	}
+	for i, f := range files {
+		if err := checkFileLimit(len(remotes), e.MaxFiles); err != nil {
+			return remotes, err
+		}
+		size := int64(-1)
+		if f.Size != nil {
+			size = *f.Size
+		}
+		rf, err := e.remoteFile(f.Name, size, f.Time, f.URL)
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %w", i, err)
+		}
+		remotes = append(remotes, rf)
+	}
+	return remotes, nil
+}
+
+func (e Exec) scrapeTSV(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error) {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(text)) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := checkFileLimit(len(remotes), e.MaxFiles); err != nil {
+			return remotes, err
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected at most 4 fields, but got %d", line, len(fields))
+		}
+		fields = append(fields, make([]string, 4-len(fields))...)
+		size := int64(-1)
+		if s := strings.TrimSpace(fields[1]); len(s) > 0 && s != "-" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid size '%s'", line, s)
+			}
+			size = n
+		}
+		rf, err := e.remoteFile(fields[0], size, strings.TrimSpace(fields[2]), strings.TrimSpace(fields[3]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		remotes = append(remotes, rf)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan listing: %w", err)
+	}
+	return remotes, nil
+}
+
+// remoteFile checks the fields of one listed file, and fills in its url (if it's missing or relative)
+func (e Exec) remoteFile(name string, size int64, timeStr, fileURL string) (RemoteFile, error) {
+	switch {
+	case len(name) == 0:
+		return RemoteFile{}, fmt.Errorf("missing name")
+	case name == "." || name == ".." || strings.ContainsAny(name, "/\\"):
+		// the name is used as-is for the local file, so it can't leave the download folder
+		return RemoteFile{}, fmt.Errorf("invalid name '%s'", name)
+	case size < -1:
+		return RemoteFile{}, fmt.Errorf("invalid size %d for '%s'", size, name)
+	}
+
+	var ts time.Time
+	if len(timeStr) > 0 {
+		var err error
+		if ts, err = time.Parse(time.RFC3339, timeStr); err != nil {
+			if ts, err = time.ParseInLocation("2006-01-02 15:04:05", timeStr, e.Location); err != nil {
+				return RemoteFile{}, fmt.Errorf("invalid time '%s' for '%s'", timeStr, name)
+			}
+		}
+	}
+
+	base, err := url.Parse(e.BaseURL)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to parse base url '%s': %w", e.BaseURL, err)
+	}
+	ref := &url.URL{Path: name}
+	if len(fileURL) > 0 {
+		if ref, err = url.Parse(fileURL); err != nil {
+			return RemoteFile{}, fmt.Errorf("invalid url '%s' for '%s': %w", fileURL, name, err)
+		}
+	}
+
+	return RemoteFile{
+		Name:      name,
+		SortName:  SortName(name),
+		URL:       base.ResolveReference(ref).String(),
+		Timestamp: ts,
+		Size:      size,
+	}, nil
+}
@@ -0,0 +1,154 @@
+package scraper
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec_ScrapeFromReader(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Exec{BaseURL: "https://example.com/files/", Command: []string{"list"}, Location: newYork}
+
+	type file struct {
+		Name string
+		Size int64
+		Time string // RFC 3339, or empty for none
+		URL  string
+	}
+	cases := []struct {
+		Name     string
+		Input    string
+		Expected []file
+	}{
+		{"json", `
+			[
+				{"name": "a.iso", "size": 1024, "time": "2024-03-01T12:00:00Z"},
+				{"name": "b c.txt", "url": "https://cdn.example.com/b?sig=1"},
+				{"name": "d.bin", "size": 0, "time": "2024-03-01 07:00:00", "url": "sub/d.bin"}
+			]`,
+			[]file{
+				{"a.iso", 1024, "2024-03-01T12:00:00Z", "https://example.com/files/a.iso"},
+				{"b c.txt", -1, "", "https://cdn.example.com/b?sig=1"},
+				{"d.bin", 0, "2024-03-01T12:00:00Z", "https://example.com/files/sub/d.bin"},
+			},
+		},
+		{"tsv", "# name\tsize\ttime\turl\na.iso\t1024\t2024-03-01T12:00:00Z\n\nb c.txt\t-\t\thttps://cdn.example.com/b?sig=1\r\nd.bin\t0\t2024-03-01 07:00:00\tsub/d.bin\ne#1.txt\n",
+			[]file{
+				{"a.iso", 1024, "2024-03-01T12:00:00Z", "https://example.com/files/a.iso"},
+				{"b c.txt", -1, "", "https://cdn.example.com/b?sig=1"},
+				{"d.bin", 0, "2024-03-01T12:00:00Z", "https://example.com/files/sub/d.bin"},
+				{"e#1.txt", -1, "", "https://example.com/files/e%231.txt"},
+			},
+		},
+		{"empty", "\n", nil},
+		{"empty json", "[]", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			remotes, err := e.ScrapeFromReader(strings.NewReader(tc.Input), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(remotes) != len(tc.Expected) {
+				t.Fatalf("expected %d files, but got %d", len(tc.Expected), len(remotes))
+			}
+			for i, ex := range tc.Expected {
+				r := remotes[i]
+				var ts time.Time
+				if len(ex.Time) > 0 {
+					ts, _ = time.Parse(time.RFC3339, ex.Time)
+				}
+				if r.Name != ex.Name || r.SortName != SortName(ex.Name) || r.Size != ex.Size || r.URL != ex.URL || !r.Timestamp.Equal(ts) {
+					t.Errorf("%d: expected %v, but got %s %d %s %s", i, ex, r.Name, r.Size, r.Timestamp.Format(time.RFC3339), r.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestExec_ScrapeFromReader_Errors(t *testing.T) {
+	e := Exec{BaseURL: "https://example.com/files/", Command: []string{"list"}, Location: time.UTC}
+	cases := []struct {
+		Name     string
+		Input    string
+		Expected string // part of the error
+	}{
+		{"bad json", `[{"name": "a", "size": "big"}]`, "error parsing json listing"},
+		{"missing name", `[{"size": 1}]`, "file 0: missing name"},
+		{"sub-folder", "a/b.txt\t1\n", "line 1: invalid name 'a/b.txt'"},
+		{"parent", `[{"name": ".."}]`, "invalid name '..'"},
+		{"bad size", "a.txt\t12K\n", "line 1: invalid size '12K'"},
+		{"negative size", `[{"name": "a.txt", "size": -5}]`, "invalid size -5"},
+		{"bad time", "# header\na.txt\t1\tyesterday\n", "line 2: invalid time 'yesterday'"},
+		{"too many fields", "a.txt\t1\t\t\textra\n", "expected at most 4 fields"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := e.ScrapeFromReader(strings.NewReader(tc.Input), nil)
+			if err == nil || !strings.Contains(err.Error(), tc.Expected) {
+				t.Errorf("expected an error containing '%s', but got %v", tc.Expected, err)
+			}
+		})
+	}
+}
+
+func TestExec_MaxFiles(t *testing.T) {
+	e := Exec{BaseURL: "https://example.com/", Command: []string{"list"}, Location: time.UTC, MaxFiles: 2}
+	remotes, err := e.ScrapeFromReader(strings.NewReader("a\nb\nc\n"), nil)
+	if !errors.Is(err, ErrTooManyFiles) {
+		t.Fatalf("expected ErrTooManyFiles, but got %v", err)
+	}
+	if len(remotes) != 2 {
+		t.Errorf("expected 2 files, but got %d", len(remotes))
+	}
+}
+
+func TestExec_ScrapeRemotes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	cases := []struct {
+		Name        string
+		Command     []string
+		Timeout     time.Duration
+		ExpectedErr string // part of the error, or empty for none
+	}{
+		// the base url is the last argument, which is $0 of the script
+		{"lists", []string{"sh", "-c", `printf 'f.bin\t10\t\t%sother.bin\n' "$0"`}, 0, ""},
+		{"fails", []string{"sh", "-c", `echo 'no such bucket' >&2; exit 3`}, 0, "exited with status 3: no such bucket"},
+		{"too slow", []string{"sh", "-c", `exec sleep 5`}, 50 * time.Millisecond, "took longer than 50ms"},
+		{"not found", []string{"needl-no-such-program"}, 0, "command 'needl-no-such-program'"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s, err := Create("exec", BaseURL("https://example.com/files/"), Command(tc.Command...), Timeout(tc.Timeout))
+			if err != nil {
+				t.Fatalf("unexpected error creating scraper: %v", err)
+			}
+			remotes, err := s.ScrapeRemotes()
+			if len(tc.ExpectedErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.ExpectedErr) {
+					t.Fatalf("expected an error containing '%s', but got %v", tc.ExpectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(remotes) != 1 || remotes[0].Name != "f.bin" || remotes[0].Size != 10 ||
+				remotes[0].URL != "https://example.com/files/other.bin" {
+				t.Errorf("expected f.bin from https://example.com/files/other.bin, but got %v", remotes)
+			}
+		})
+	}
+
+	if _, err := Create("exec", BaseURL("https://example.com/")); err == nil {
+		t.Errorf("expected an error creating an exec scraper without a command")
+	}
+}
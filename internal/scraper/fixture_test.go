@@ -0,0 +1,68 @@
+package scraper
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// readerScraper is implemented by scrapers that can parse a saved listing
+type readerScraper interface {
+	ScrapeFromReader(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error)
+}
+
+// fixtureEntry is a remote file that is expected to be parsed from a fixture
+type fixtureEntry struct {
+	Name string
+	URL  string
+	Size int64
+	Time string // "2006-01-02 15:04" in UTC, or empty if unknown
+}
+
+// checkFixture parses testdata/<fixture> with the given scraper, and verifies the number of
+// files found, and the details of each of the given entries.
+func checkFixture(t *testing.T, s readerScraper, fixture string, expectedCount int, entries ...fixtureEntry) {
+	t.Helper()
+	f, err := os.Open("testdata/" + fixture)
+	if err != nil {
+		t.Fatalf("error opening '%s': %v", fixture, err)
+	}
+	defer f.Close()
+
+	remotes, err := s.ScrapeFromReader(f, nil)
+	if err != nil {
+		t.Fatalf("unexpected error in ScrapeFromReader: %v", err)
+	}
+	if len(remotes) != expectedCount {
+		t.Errorf("expected %d, but found %d", expectedCount, len(remotes))
+	}
+
+	byName := make(map[string]RemoteFile, len(remotes))
+	for _, r := range remotes {
+		byName[r.Name] = r
+	}
+	for _, e := range entries {
+		r, ok := byName[e.Name]
+		if !ok {
+			t.Errorf("expected to find '%s'", e.Name)
+			continue
+		}
+		if len(e.URL) > 0 && r.URL != e.URL {
+			t.Errorf("%s: expected url '%s', but got '%s'", e.Name, e.URL, r.URL)
+		}
+		if r.Size != e.Size {
+			t.Errorf("%s: expected size %d, but got %d", e.Name, e.Size, r.Size)
+		}
+		var expectedTime time.Time
+		if len(e.Time) > 0 {
+			expectedTime, err = time.Parse("2006-01-02 15:04", e.Time)
+			if err != nil {
+				t.Fatalf("error parsing time: %v", err)
+			}
+		}
+		if !r.Timestamp.Equal(expectedTime) {
+			t.Errorf("%s: expected time %v, but got %v", e.Name, expectedTime, r.Timestamp)
+		}
+	}
+}
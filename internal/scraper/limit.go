@@ -0,0 +1,34 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxFiles is how many files a listing may have, unless MaxFiles says otherwise. It's generous,
+// so that it only stops listings that are endless, or much larger than any real folder.
+const DefaultMaxFiles = 1_000_000
+
+// ErrTooManyFiles is returned when a listing has more than MaxFiles files. Parsing stops there, and
+// the files that were parsed until then are returned along with the error.
+var ErrTooManyFiles = errors.New("too many files in listing")
+
+// checkFileLimit returns ErrTooManyFiles if a listing that already has n files can't have another,
+// given its limit (where zero means DefaultMaxFiles).
+func checkFileLimit(n, maxFiles int) error {
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+	if n < maxFiles {
+		return nil
+	}
+	return fmt.Errorf("%w (more than %d)", ErrTooManyFiles, maxFiles)
+}
+
+// DefaultMaxPages is how many pages a paginated listing (see NextPage) may have, unless MaxPages says
+// otherwise.
+const DefaultMaxPages = 100
+
+// ErrTooManyPages is returned when a paginated listing has more than MaxPages pages. The files on the
+// pages that were parsed are returned along with the error.
+var ErrTooManyPages = errors.New("too many pages in listing")
@@ -0,0 +1,43 @@
+package scraper
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestMaxFiles(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Scraper       readerScraper
+		Fixture       string
+		ExpectedCount int
+		ExpectedErr   bool
+	}{
+		{"archive.org simple", ArchiveDotOrg{MaxFiles: 10}, "images.tv.simple", 10, true},
+		{"archive.org full", ArchiveDotOrg{MaxFiles: 10}, "images.tv.full", 10, true},
+		{"archive.org at limit", ArchiveDotOrg{MaxFiles: 140}, "images.tv.simple", 140, false},
+		{"nginx", AutoIndex{Server: "nginx", BaseURL: "https://mirror.example.com/mirror", MaxFiles: 2}, "mirror.nginx", 2, true},
+		{"nginx default", AutoIndex{Server: "nginx", BaseURL: "https://mirror.example.com/mirror"}, "mirror.nginx", 5, false},
+		{"xml-bucket", XMLBucket{BaseURL: "https://bucket.example.com", Prefix: "images/tv/", MaxFiles: 1}, "bucket.s3.xml", 1, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			f, err := os.Open("testdata/" + tc.Fixture)
+			if err != nil {
+				t.Fatalf("error opening '%s': %v", tc.Fixture, err)
+			}
+			defer f.Close()
+
+			remotes, err := tc.Scraper.ScrapeFromReader(f, nil)
+			if errors.Is(err, ErrTooManyFiles) != tc.ExpectedErr {
+				t.Errorf("expected ErrTooManyFiles %v, but got %v", tc.ExpectedErr, err)
+			}
+			// the files parsed before the limit was reached are still returned
+			if len(remotes) != tc.ExpectedCount {
+				t.Errorf("expected %d, but found %d", tc.ExpectedCount, len(remotes))
+			}
+		})
+	}
+}
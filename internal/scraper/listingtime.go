@@ -0,0 +1,16 @@
+package scraper
+
+import "time"
+
+// parseListingTime parses a time from a listing that doesn't include its time zone, as being in loc
+// (or in UTC, if loc is nil), and returns it in UTC, to compare with the local files' times.
+func parseListingTime(layout, value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
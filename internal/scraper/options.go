@@ -1,5 +1,11 @@
 package scraper
 
+import (
+	"net/http"
+	"regexp"
+	"time"
+)
+
 type Option interface {
 	isScraperOption()
 	String() string
@@ -17,3 +23,308 @@ type optBaseURL struct {
 
 func (_ optBaseURL) isScraperOption() {}
 func (_ optBaseURL) String() string   { return "BaseURL" }
+
+// CacheDir
+
+// CacheDir enables caching of scraped listings in the given directory, so that later
+// scrapes of the same listing can make conditional requests.
+func CacheDir(v string) Option {
+	return optCacheDir{v: v}
+}
+
+type optCacheDir struct {
+	v string
+}
+
+func (_ optCacheDir) isScraperOption() {}
+func (_ optCacheDir) String() string   { return "CacheDir" }
+
+// TrailingSlashMode
+
+// TrailingSlashMode sets how a trailing '/' on the BaseURL is treated.
+func TrailingSlashMode(v TrailingSlash) Option {
+	return optTrailingSlash{v: v}
+}
+
+type optTrailingSlash struct {
+	v TrailingSlash
+}
+
+func (_ optTrailingSlash) isScraperOption() {}
+func (_ optTrailingSlash) String() string   { return "TrailingSlashMode" }
+
+// BasicAuth
+
+// BasicAuth sets the username and password to send using HTTP basic auth.
+func BasicAuth(username, password string) Option {
+	return optBasicAuth{username: username, password: password}
+}
+
+type optBasicAuth struct {
+	username string
+	password string
+}
+
+func (_ optBasicAuth) isScraperOption() {}
+func (_ optBasicAuth) String() string   { return "BasicAuth" }
+
+// Prefix
+
+// Prefix limits a listing to the files whose names start with the given prefix.
+func Prefix(v string) Option {
+	return optPrefix{v: v}
+}
+
+type optPrefix struct {
+	v string
+}
+
+func (_ optPrefix) isScraperOption() {}
+func (_ optPrefix) String() string   { return "Prefix" }
+
+// Delay
+
+// Delay sets how long to wait between successive requests made while scraping a single listing.
+func Delay(v time.Duration) Option {
+	return optDelay{v: v}
+}
+
+type optDelay struct {
+	v time.Duration
+}
+
+func (_ optDelay) isScraperOption() {}
+func (_ optDelay) String() string   { return "Delay" }
+
+// UserAgent
+
+// UserAgent sets the User-Agent header sent with each listing request.
+func UserAgent(v string) Option {
+	return optUserAgent{v: v}
+}
+
+type optUserAgent struct {
+	v string
+}
+
+func (_ optUserAgent) isScraperOption() {}
+func (_ optUserAgent) String() string   { return "UserAgent" }
+
+// Header
+
+// Header adds a header to send with each listing request. It may be given more than once.
+func Header(key, value string) Option {
+	return optHeader{key: key, value: value}
+}
+
+type optHeader struct {
+	key   string
+	value string
+}
+
+func (_ optHeader) isScraperOption() {}
+func (_ optHeader) String() string   { return "Header" }
+
+// Timeout
+
+// Timeout limits how long each listing request may take, including reading the response.
+func Timeout(v time.Duration) Option {
+	return optTimeout{v: v}
+}
+
+type optTimeout struct {
+	v time.Duration
+}
+
+func (_ optTimeout) isScraperOption() {}
+func (_ optTimeout) String() string   { return "Timeout" }
+
+// TimeZone
+
+// TimeZone sets the time zone of the times in a listing that doesn't say which zone its times are in
+// (the default is UTC).
+func TimeZone(v *time.Location) Option {
+	return optTimeZone{v: v}
+}
+
+type optTimeZone struct {
+	v *time.Location
+}
+
+func (_ optTimeZone) isScraperOption() {}
+func (_ optTimeZone) String() string   { return "TimeZone" }
+
+// HTTPClient
+
+// HTTPClient sets the client that listing requests are made with, instead of a default client.
+func HTTPClient(v *http.Client) Option {
+	return optHTTPClient{v: v}
+}
+
+type optHTTPClient struct {
+	v *http.Client
+}
+
+func (_ optHTTPClient) isScraperOption() {}
+func (_ optHTTPClient) String() string   { return "HTTPClient" }
+
+// Retries
+
+// Retries sets how many times a listing request is retried after an error that may be temporary.
+func Retries(v int) Option {
+	return optRetries{v: v}
+}
+
+type optRetries struct {
+	v int
+}
+
+func (_ optRetries) isScraperOption() {}
+func (_ optRetries) String() string   { return "Retries" }
+
+// StrictParse
+
+// StrictParse makes a listing fail to parse if more than maxRatio lines (per line that was parsed)
+// look like they list a file, but couldn't be parsed, which may mean that the listing's format has
+// changed. Zero (the default) means that such lines are skipped.
+func StrictParse(maxRatio float64) Option {
+	return optStrictParse{v: maxRatio}
+}
+
+type optStrictParse struct {
+	v float64
+}
+
+func (_ optStrictParse) isScraperOption() {}
+func (_ optStrictParse) String() string   { return "StrictParse" }
+
+// MaxFiles
+
+// MaxFiles caps how many files a listing may have, so that a broken (or malicious) server can't make
+// a listing grow without bound. A listing with more fails with ErrTooManyFiles. Zero (the default)
+// means DefaultMaxFiles.
+func MaxFiles(v int) Option {
+	return optMaxFiles{v: v}
+}
+
+type optMaxFiles struct {
+	v int
+}
+
+func (_ optMaxFiles) isScraperOption() {}
+func (_ optMaxFiles) String() string   { return "MaxFiles" }
+
+// NextPage
+
+// NextPage follows the "next page" links of a paginated HTML listing: re is matched against each page,
+// and its first capture group (or the whole match, if it has none) is the URL of the next page, which
+// may be relative to the page. The listing ends at the first page that has no match.
+func NextPage(re *regexp.Regexp) Option {
+	return optNextPage{v: re}
+}
+
+type optNextPage struct {
+	v *regexp.Regexp
+}
+
+func (_ optNextPage) isScraperOption() {}
+func (_ optNextPage) String() string   { return "NextPage" }
+
+// MaxPages
+
+// MaxPages caps how many pages a paginated listing (see NextPage) may have. A listing with more fails
+// with ErrTooManyPages. Zero (the default) means DefaultMaxPages.
+func MaxPages(v int) Option {
+	return optMaxPages{v: v}
+}
+
+type optMaxPages struct {
+	v int
+}
+
+func (_ optMaxPages) isScraperOption() {}
+func (_ optMaxPages) String() string   { return "MaxPages" }
+
+// Command
+
+// Command sets the program (and its arguments) that lists the files (for the exec type).
+func Command(v ...string) Option {
+	return optCommand{v: v}
+}
+
+type optCommand struct {
+	v []string
+}
+
+func (_ optCommand) isScraperOption() {}
+func (_ optCommand) String() string   { return "Command" }
+
+// parsedOptions holds the value of each option, as collected by parseOptions
+type parsedOptions struct {
+	baseURL       string
+	cacheDir      string
+	trailingSlash TrailingSlash
+	auth          optBasicAuth
+	prefix        string
+	delay         time.Duration
+	userAgent     string
+	header        http.Header
+	timeout       time.Duration
+	timeZone      *time.Location
+	client        *http.Client
+	retries       int
+	strictRatio   float64
+	maxFiles      int
+	nextPage      *regexp.Regexp
+	maxPages      int
+	command       []string
+}
+
+// parseOptions collects the options for a scraper's factory. If an option is given more than once,
+// then the last one wins (except for Header, which adds each one). The factory decides which of them
+// it uses, according to its Info.
+func parseOptions(opts []Option) parsedOptions {
+	p := parsedOptions{trailingSlash: TrailingSlashKeep}
+	for _, o := range opts {
+		switch ot := o.(type) {
+		case optBaseURL:
+			p.baseURL = ot.v
+		case optCacheDir:
+			p.cacheDir = ot.v
+		case optTrailingSlash:
+			p.trailingSlash = ot.v
+		case optBasicAuth:
+			p.auth = ot
+		case optPrefix:
+			p.prefix = ot.v
+		case optDelay:
+			p.delay = ot.v
+		case optUserAgent:
+			p.userAgent = ot.v
+		case optHeader:
+			if p.header == nil {
+				p.header = http.Header{}
+			}
+			p.header.Add(ot.key, ot.value)
+		case optTimeout:
+			p.timeout = ot.v
+		case optTimeZone:
+			p.timeZone = ot.v
+		case optHTTPClient:
+			p.client = ot.v
+		case optRetries:
+			p.retries = ot.v
+		case optStrictParse:
+			p.strictRatio = ot.v
+		case optMaxFiles:
+			p.maxFiles = ot.v
+		case optNextPage:
+			p.nextPage = ot.v
+		case optMaxPages:
+			p.maxPages = ot.v
+		case optCommand:
+			p.command = ot.v
+		}
+	}
+	return p
+}
@@ -0,0 +1,105 @@
+package scraper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// pages follows the "next page" links of a paginated HTML listing (see NextPage). With a nil NextPage,
+// a listing has a single page.
+type pages struct {
+	NextPage *regexp.Regexp
+	MaxPages int           // or zero for DefaultMaxPages
+	Delay    time.Duration // between page requests
+}
+
+// scrape requests the page at firstURL (using get, which returns the page's body, ready to read),
+// parses it (using parse), and then does the same for each next page, until a page has no next page
+// link, or links to a page that was already parsed (as the last page of some listings does).
+func (p pages) scrape(
+	firstURL string,
+	get func(pageURL string) (io.ReadCloser, error),
+	parse func(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error),
+	remotes []RemoteFile,
+) ([]RemoteFile, error) {
+	maxPages := p.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
+
+	pageURL := firstURL
+	seen := map[string]bool{}
+	for page := 1; ; page++ {
+		seen[pageURL] = true
+		body, err := get(pageURL)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		r := io.Reader(body)
+		if p.NextPage != nil {
+			// keep a copy of the page, to look for its next page link once it's parsed
+			r = io.TeeReader(body, &buf)
+		}
+		remotes, err = parse(r, remotes)
+		if err == nil && p.NextPage != nil {
+			// the parse may not have needed to read to the end
+			_, err = io.Copy(io.Discard, r)
+		}
+		body.Close()
+		if errors.Is(err, ErrTooManyFiles) {
+			return remotes, err
+		}
+		if err != nil {
+			if page > 1 {
+				return nil, fmt.Errorf("page %d '%s': %w", page, pageURL, err)
+			}
+			return nil, err
+		}
+		if p.NextPage == nil {
+			return remotes, nil
+		}
+
+		next, ok, err := nextPageURL(p.NextPage, buf.Bytes(), pageURL)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || seen[next] {
+			return remotes, nil
+		}
+		if page >= maxPages {
+			return remotes, fmt.Errorf("%w (more than %d)", ErrTooManyPages, maxPages)
+		}
+		pageURL = next
+		time.Sleep(p.Delay)
+	}
+}
+
+// nextPageURL finds the first match of re in the page, and returns the URL it links to, resolved
+// against pageURL (the URL of the page). ok is false if there's no match.
+func nextPageURL(re *regexp.Regexp, page []byte, pageURL string) (next string, ok bool, err error) {
+	m := re.FindSubmatch(page)
+	if m == nil {
+		return "", false, nil
+	}
+	href := m[0]
+	if len(m) > 1 {
+		href = m[1]
+	}
+	// the link is an attribute of the page's HTML, so it may have entities in it, like "&amp;"
+	ref, err := url.Parse(html.UnescapeString(string(href)))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse next page url '%s': %w", href, err)
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse page url '%s': %w", pageURL, err)
+	}
+	return base.ResolveReference(ref).String(), true, nil
+}
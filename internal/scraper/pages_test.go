@@ -0,0 +1,155 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestNextPageURL(t *testing.T) {
+	cases := []struct {
+		Name     string
+		RE       string
+		Page     string
+		Expected string // empty if there is no next page
+	}{
+		{"query", `<a class="next" href="([^"]+)"`, `<a class="next" href="?page=2&amp;sort=name">Next</a>`,
+			"https://example.com/mirror/?page=2&sort=name"},
+		{"relative path", `href="([^"]+)">Next`, `<a href="page/3/">Next</a>`, "https://example.com/mirror/page/3/"},
+		{"absolute", `href="([^"]+)">Next`, `<a href="https://cdn.example.com/list?p=2">Next</a>`,
+			"https://cdn.example.com/list?p=2"},
+		{"whole match", `\?page=[0-9]+`, `<a href="?page=4">Next</a>`, "https://example.com/mirror/?page=4"},
+		{"first match", `href="([^"]+)">Next`, `<a href="?p=2">Next</a> <a href="?p=9">Next</a>`,
+			"https://example.com/mirror/?p=2"},
+		{"last page", `<a class="next" href="([^"]+)"`, `<a class="prev" href="?page=1">Prev</a>`, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			next, ok, err := nextPageURL(regexp.MustCompile(tc.RE), []byte(tc.Page), "https://example.com/mirror/")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != (len(tc.Expected) > 0) || next != tc.Expected {
+				t.Errorf("expected '%s', but got '%s' (ok %v)", tc.Expected, next, ok)
+			}
+		})
+	}
+}
+
+func TestAutoIndex_NextPage(t *testing.T) {
+	pageOne, err := os.ReadFile("testdata/paged.nginx.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageTwo, err := os.ReadFile("testdata/paged.nginx.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/mirror/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "", "1":
+			_, _ = w.Write(pageOne)
+		case "2":
+			if r.URL.Query().Get("sort") != "name" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write(pageTwo)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	next := NextPage(regexp.MustCompile(`<a class="next" href="([^"]+)"`))
+	s, err := Create("nginx", BaseURL(srv.URL+"/mirror/"), next)
+	if err != nil {
+		t.Fatalf("unexpected error creating scraper: %v", err)
+	}
+	remotes, err := s.ScrapeRemotes()
+	if err != nil {
+		t.Fatalf("unexpected error scraping: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, but got %d", requests)
+	}
+	expected := []struct {
+		Name string
+		Size int64
+	}{
+		{"checksums.sha256", 541},
+		{"debian-12.5.0-amd64-netinst.iso", 659554304},
+		{"file with spaces.txt", 12},
+		{"README", 2048},
+	}
+	if len(remotes) != len(expected) {
+		t.Fatalf("expected %d files, but got %d", len(expected), len(remotes))
+	}
+	for i, e := range expected {
+		if remotes[i].Name != e.Name || remotes[i].Size != e.Size {
+			t.Errorf("%d: expected %s (%d bytes), but got %s (%d bytes)", i, e.Name, e.Size, remotes[i].Name, remotes[i].Size)
+		}
+	}
+	// the files on the second page are still in the folder
+	if u := remotes[3].URL; u != srv.URL+"/mirror/README" {
+		t.Errorf("expected url '%s', but got '%s'", srv.URL+"/mirror/README", u)
+	}
+
+	// without NextPage, only the first page is listed
+	s, err = Create("nginx", BaseURL(srv.URL+"/mirror/"))
+	if err != nil {
+		t.Fatalf("unexpected error creating scraper: %v", err)
+	}
+	if remotes, err = s.ScrapeRemotes(); err != nil || len(remotes) != 2 {
+		t.Errorf("expected the 2 files on the first page, but got %d (err %v)", len(remotes), err)
+	}
+}
+
+func TestAutoIndex_MaxPages(t *testing.T) {
+	// every page links to the one after it, and the last page links to the first
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		next := (page + 1) % 5
+		fmt.Fprintf(w, "<pre><a href=\"f%d.bin\">f%d.bin</a>   02-Jan-2020 03:04   100\n</pre>\n", page, page)
+		fmt.Fprintf(w, "<a class=\"next\" href=\"?page=%d\">Next</a>\n", next)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name          string
+		MaxPages      int
+		ExpectedCount int
+		ExpectedErr   error
+	}{
+		{"loops back", 0, 5, nil},
+		{"limited", 3, 3, ErrTooManyPages},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s, err := Create("nginx", BaseURL(srv.URL+"/?page=0"), MaxPages(tc.MaxPages),
+				NextPage(regexp.MustCompile(`<a class="next" href="([^"]+)"`)),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error creating scraper: %v", err)
+			}
+			remotes, err := s.ScrapeRemotes()
+			if !errors.Is(err, tc.ExpectedErr) {
+				t.Fatalf("expected error %v, but got %v", tc.ExpectedErr, err)
+			}
+			if len(remotes) != tc.ExpectedCount {
+				t.Errorf("expected %d files, but got %d", tc.ExpectedCount, len(remotes))
+			}
+		})
+	}
+}
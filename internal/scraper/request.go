@@ -0,0 +1,61 @@
+package scraper
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// addHeaders adds each of the given headers to the request, after any it already has.
+func addHeaders(req *http.Request, h http.Header) {
+	for key, values := range h {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+}
+
+// clientFor returns the client to send a request with the given extra headers: the given client (or a new
+// one if nil), except that the headers are removed from a redirect to another host, as they may hold
+// credentials meant for the first host. (The client already does this for Authorization and Cookie.)
+// The given client is not modified.
+func clientFor(client *http.Client, h http.Header) *http.Client {
+	if client == nil {
+		client = &http.Client{}
+	}
+	if len(h) == 0 {
+		return client
+	}
+	c := *client
+	checkRedirect := client.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != via[0].URL.Host {
+			for key := range h {
+				req.Header.Del(key)
+			}
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		// the same limit as the client's default policy
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &c
+}
+
+// clientWithTimeout returns the client to make requests with: the given client (or a new one if nil),
+// with its Timeout replaced by timeout, if non-zero. The given client is not modified.
+func clientWithTimeout(client *http.Client, timeout time.Duration) *http.Client {
+	if client == nil {
+		client = &http.Client{}
+	}
+	if timeout > 0 {
+		c := *client
+		c.Timeout = timeout
+		client = &c
+	}
+	return client
+}
@@ -0,0 +1,70 @@
+package scraper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ClientFor_Redirects(t *testing.T) {
+	var other string
+	otherSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other = r.Header.Get("X-Api-Key")
+	}))
+	defer otherSrv.Close()
+
+	var same string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/same", http.StatusFound)
+		case "/same":
+			same = r.Header.Get("X-Api-Key")
+			http.Redirect(w, r, otherSrv.URL+"/other", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	h := http.Header{}
+	h.Set("X-Api-Key", "secret")
+	req, err := http.NewRequest("GET", srv.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addHeaders(req, h)
+	resp, err := clientFor(nil, h).Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if same != "secret" {
+		t.Errorf("expected the header on a redirect to the same host, but got '%s'", same)
+	}
+	if other != "" {
+		t.Errorf("expected no header on a redirect to another host, but got '%s'", other)
+	}
+}
+
+func Test_ClientFor_KeepsCheckRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/next", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	h := http.Header{}
+	h.Set("X-Api-Key", "secret")
+	resp, err := clientFor(client, h).Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("expected the client's redirect policy to stop at the redirect, but got status %d", resp.StatusCode)
+	}
+}
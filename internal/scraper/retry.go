@@ -0,0 +1,61 @@
+package scraper
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// maxRetryDelay caps both the backoff and any Retry-After header from the server
+const maxRetryDelay = time.Minute
+
+// retryDelay is how long to wait before the given retry (starting at 1), and is a variable so
+// that tests don't have to wait
+var retryDelay = func(retry int) time.Duration {
+	return min(time.Second<<min(retry-1, 6), maxRetryDelay)
+}
+
+// doWithRetry does the request, and retries up to retries times (with a growing delay) after
+// errors that may be temporary: network errors, 5xx statuses, and 429 Too Many Requests.
+// Any other response (including other 4xx statuses) is returned right away.
+// Once out of retries, the last response or error is returned. The request must not have a body.
+func doWithRetry(client *http.Client, req *http.Request, retries int) (*http.Response, error) {
+	for retry := 1; ; retry++ {
+		resp, err := client.Do(req)
+		if retry > retries || !isRetryable(resp, err) {
+			return resp, err
+		}
+
+		d := retryDelay(retry)
+		if resp != nil {
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+				d = min(time.Duration(s)*time.Second, maxRetryDelay)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(d):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// network errors, and connections that closed early, but not mistakes like a bad scheme
+		// (*url.Error is itself a net.Error, so look at what it wraps)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
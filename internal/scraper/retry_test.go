@@ -0,0 +1,74 @@
+package scraper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_DoWithRetry(t *testing.T) {
+	defer func(prev func(int) time.Duration) { retryDelay = prev }(retryDelay)
+	retryDelay = func(int) time.Duration { return 0 }
+
+	cases := []struct {
+		Name             string
+		Statuses         []int // the status of each request, with the last repeated forever
+		Retries          int
+		ExpectedStatus   int
+		ExpectedRequests int32
+	}{
+		{"ok", []int{200}, 3, 200, 1},
+		{"503 then ok", []int{503, 503, 200}, 3, 200, 3},
+		{"429 then ok", []int{429, 200}, 3, 200, 2},
+		{"out of retries", []int{503}, 2, 503, 3},
+		{"no retries", []int{503, 200}, 0, 503, 1},
+		{"404 fails fast", []int{404, 200}, 3, 404, 1},
+		{"403 fails fast", []int{403, 200}, 3, 403, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+				w.WriteHeader(tc.Statuses[min(n, len(tc.Statuses))-1])
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := doWithRetry(&http.Client{}, req, tc.Retries)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.ExpectedStatus {
+				t.Errorf("expected status %d, but got %d", tc.ExpectedStatus, resp.StatusCode)
+			}
+			if n := requests.Load(); n != tc.ExpectedRequests {
+				t.Errorf("expected %d requests, but got %d", tc.ExpectedRequests, n)
+			}
+		})
+	}
+}
+
+func Test_DoWithRetry_BadScheme(t *testing.T) {
+	defer func(prev func(int) time.Duration) { retryDelay = prev }(retryDelay)
+	delays := 0
+	retryDelay = func(int) time.Duration { delays++; return 0 }
+
+	req, err := http.NewRequest("GET", "ftp://example.com/listing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doWithRetry(&http.Client{}, req, 3); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if delays != 0 {
+		t.Errorf("expected no retries, but got %d", delays)
+	}
+}
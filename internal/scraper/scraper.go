@@ -2,15 +2,56 @@ package scraper
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type RemoteFile struct {
 	Name      string
-	SortName  string
+	SortName  string // Name, as folded by SortName
 	URL       string
 	Timestamp time.Time // zero if unknown
 	Size      int64     // -1 if unknown
+
+	// TimestampPrecision is how precise Timestamp is, eg time.Minute if the source only lists
+	// hours and minutes (in which case Timestamp is truncated to the minute). Zero means exact.
+	TimestampPrecision time.Duration
+
+	// SizePrecision is how precise Size is, eg 103 if the source only lists a humanized size like
+	// "135.3K" (in which case the exact size is within 103 bytes of Size). Zero means exact.
+	SizePrecision int64
+
+	// OrigName, if set, is the name the file was listed under, before Name was changed to give it a
+	// local path (such as by a name transform, or to rename a duplicate). See ListedName.
+	OrigName string
+}
+
+// ListedName returns the name the file was listed under, which is the name its checksum is listed under.
+func (r RemoteFile) ListedName() string {
+	if len(r.OrigName) > 0 {
+		return r.OrigName
+	}
+	return r.Name
+}
+
+// ExactSize returns the file's Size, or -1 if its size is unknown or only approximate.
+func (r RemoteFile) ExactSize() int64 {
+	if r.SizePrecision != 0 {
+		return -1
+	}
+	return r.Size
+}
+
+// SortName folds the case of name, so that names that only differ by case compare (and sort) the same.
+// The fold doesn't depend on the locale, and maps each letter to the lower case of its upper case,
+// so that, for example, the Turkish dotless 'ı' and the ASCII 'I' both fold to 'i' (as does 'İ').
+// Local and remote names must be folded the same way, or their diff is wrong.
+func SortName(name string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(unicode.ToUpper(r))
+	}, name)
 }
 
 type Scraper interface {
@@ -19,9 +60,29 @@ type Scraper interface {
 
 var scraperFactory = map[string]func(string, ...Option) (Scraper, error){}
 
-// Register adds the given scraper type and that type's creation method.
-func Register(typ string, createFn func(string, ...Option) (Scraper, error)) {
+var scraperInfo = map[string]Info{}
+
+// Info describes a scraper type, for help text and config validation.
+type Info struct {
+	Description string   // one line, for listing the available types
+	Required    []string // names of the options (as in Option.String) that the type can't be created without
+	Optional    []string // names of the other options that the type makes use of
+}
+
+// Register adds the given scraper type and that type's creation method, along with an optional Info.
+func Register(typ string, createFn func(string, ...Option) (Scraper, error), info ...Info) {
 	scraperFactory[typ] = createFn
+	if len(info) > 0 {
+		scraperInfo[typ] = info[0]
+	}
+}
+
+// Describe returns the Info the given scraper type was registered with, or false if the type is not found.
+func Describe(typ string) (Info, bool) {
+	if _, ok := scraperFactory[typ]; !ok {
+		return Info{}, false
+	}
+	return scraperInfo[typ], true
 }
 
 // Create looks up the given scraper type and returns a new instance of it.
@@ -30,6 +91,11 @@ func Create(typ string, opts ...Option) (Scraper, error) {
 	if !ok {
 		return nil, fmt.Errorf("type not found")
 	}
+	for _, required := range scraperInfo[typ].Required {
+		if !slices.ContainsFunc(opts, func(o Option) bool { return o.String() == required }) {
+			return nil, fmt.Errorf("missing required option: %s", required)
+		}
+	}
 	return factory(typ, opts...)
 }
 
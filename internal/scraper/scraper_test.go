@@ -0,0 +1,101 @@
+package scraper
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_EveryTypeHasDescription(t *testing.T) {
+	for _, typ := range ListTypes() {
+		info, ok := Describe(typ)
+		if !ok {
+			t.Errorf("scraper type '%s' is listed, but not found", typ)
+		}
+		if len(info.Description) == 0 {
+			t.Errorf("scraper type '%s' has no description", typ)
+		}
+	}
+	if _, ok := Describe("not-a-type"); ok {
+		t.Errorf("expected unregistered type to not be found")
+	}
+}
+
+func Test_Create_RequiredOptions(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Opts        []Option
+		ExpectedErr string
+	}{
+		{"no options", nil, "missing required option: BaseURL"},
+		{"other options only", []Option{Prefix("a/")}, "missing required option: BaseURL"},
+		{"required option", []Option{BaseURL("https://example.com/bucket")}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := Create("xml-bucket", tc.Opts...)
+			if len(tc.ExpectedErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.ExpectedErr) {
+				t.Errorf("expected error '%s', but got '%v'", tc.ExpectedErr, err)
+			}
+		})
+	}
+}
+
+func Test_Create_UserAgent(t *testing.T) {
+	for _, typ := range ListTypes() {
+		// only the types that make requests send a user agent
+		if info, _ := Describe(typ); !slices.Contains(info.Optional, "UserAgent") {
+			continue
+		}
+		t.Run(typ, func(t *testing.T) {
+			s, err := Create(typ, BaseURL("https://example.com/files"), UserAgent("needl-test"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual string
+			switch st := s.(type) {
+			case *ArchiveDotOrg:
+				actual = st.UserAgent
+			case *AutoIndex:
+				actual = st.UserAgent
+			case *XMLBucket:
+				actual = st.UserAgent
+			default:
+				t.Fatalf("unexpected scraper %T", s)
+			}
+			if actual != "needl-test" {
+				t.Errorf("expected user agent 'needl-test', but got '%s'", actual)
+			}
+		})
+	}
+}
+
+func Test_SortName(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Expected string
+	}{
+		{"Hello.ZIP", "hello.zip"},
+		{"already-lower_1.txt", "already-lower_1.txt"},
+		{"DIYARBAKIR.zip", "diyarbakir.zip"},
+		{"dıyarbakır.zip", "diyarbakir.zip"},
+		{"İstanbul.zip", "istanbul.zip"},
+		{"ΟΔΥΣΣΕΥΣ", "οδυσσευσ"},
+		{"οδυσσευς", "οδυσσευσ"},
+		{"K.txt", "k.txt"}, // Kelvin sign
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual := SortName(tc.Name)
+			if actual != tc.Expected {
+				t.Errorf("expected '%s', but got '%s'", tc.Expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// RemoteStatter is implemented by scrapers that can look up a single remote file by name, without
+// scraping the whole listing.
+type RemoteStatter interface {
+	StatRemote(name string) (RemoteFile, error)
+}
+
+// ErrRemoteNotFound is returned by StatRemote if there is no remote file with the given name.
+var ErrRemoteNotFound = errors.New("remote file not found")
+
+// statWithHead sends the HEAD request, and returns the file it describes as a RemoteFile with the given
+// name. The size and time come from the Content-Length and Last-Modified headers (if any), and the time is
+// truncated to precision, to match the times in the scraper's listings.
+func statWithHead(client *http.Client, req *http.Request, retries int, name string, precision time.Duration) (RemoteFile, error) {
+	resp, err := doWithRetry(client, req, retries)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to do request: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return RemoteFile{}, fmt.Errorf("%w: %s", ErrRemoteNotFound, name)
+	case resp.StatusCode != 200:
+		return RemoteFile{}, fmt.Errorf("unexpected request status %d", resp.StatusCode)
+	}
+
+	var timestamp time.Time
+	if raw := resp.Header.Get("Last-Modified"); len(raw) > 0 {
+		t, err := http.ParseTime(raw)
+		if err != nil {
+			return RemoteFile{}, fmt.Errorf("failed to parse Last-Modified '%s': %w", raw, err)
+		}
+		if precision > 0 {
+			t = t.Truncate(precision)
+		}
+		timestamp = t.UTC()
+	}
+
+	return RemoteFile{
+		Name:               name,
+		SortName:           SortName(name),
+		URL:                req.URL.String(),
+		Timestamp:          timestamp,
+		Size:               resp.ContentLength,
+		TimestampPrecision: precision,
+	}, nil
+}
@@ -0,0 +1,61 @@
+package scraper
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_StatRemote(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Errorf("expected a HEAD request, but got %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/files/a%20b.zip", "/files/a b.zip", "/bucket/tv/a b.zip":
+			w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "1234")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name         string
+		Scraper      RemoteStatter
+		ExpectedURL  string
+		ExpectedTime time.Time
+	}{
+		{"archive.org", ArchiveDotOrg{BaseURL: srv.URL + "/files"}, srv.URL + "/files/a%20b.zip", modTime.Truncate(time.Minute)},
+		{"nginx", AutoIndex{Server: "nginx", BaseURL: srv.URL + "/files"}, srv.URL + "/files/a%20b.zip", modTime.Truncate(time.Minute)},
+		{"xml-bucket", XMLBucket{BaseURL: srv.URL + "/bucket", Prefix: "tv/"}, srv.URL + "/bucket/tv/a%20b.zip", modTime},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r, err := tc.Scraper.StatRemote("a b.zip")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.Name != "a b.zip" || r.SortName != "a b.zip" {
+				t.Errorf("expected name 'a b.zip', but got '%s' (sorted as '%s')", r.Name, r.SortName)
+			}
+			if r.URL != tc.ExpectedURL {
+				t.Errorf("expected url '%s', but got '%s'", tc.ExpectedURL, r.URL)
+			}
+			if r.Size != 1234 {
+				t.Errorf("expected size 1234, but got %d", r.Size)
+			}
+			if !r.Timestamp.Equal(tc.ExpectedTime) {
+				t.Errorf("expected time %v, but got %v", tc.ExpectedTime, r.Timestamp)
+			}
+
+			if _, err := tc.Scraper.StatRemote("missing.zip"); !errors.Is(err, ErrRemoteNotFound) {
+				t.Errorf("expected ErrRemoteNotFound, but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrUnmatchedLines is returned (with StrictParse) when too many of a listing's lines couldn't be parsed.
+var ErrUnmatchedLines = errors.New("too many unparsed lines in listing")
+
+// maxUnmatchedExamples is how many of the unparsed lines are included in the error
+const maxUnmatchedExamples = 3
+
+// linkRE finds the href of a link in a line of a listing
+var linkRE = regexp.MustCompile(`<a href="([^"]*)"`)
+
+// lineCheck counts the lines of a listing that were parsed, and those that look like they list a file
+// (since they link to something other than a folder or a sorting option), but weren't, for StrictParse.
+type lineCheck struct {
+	maxRatio  float64 // zero to not check
+	matched   int
+	unmatched int
+	examples  []string
+}
+
+// match counts a line that was parsed
+func (c *lineCheck) match() {
+	c.matched++
+}
+
+// miss counts a line that wasn't parsed, if it looks like it lists a file
+func (c *lineCheck) miss(line string) {
+	if c.maxRatio <= 0 {
+		return
+	}
+	m := linkRE.FindStringSubmatch(line)
+	if m == nil || len(m[1]) == 0 || strings.HasSuffix(m[1], "/") || strings.HasPrefix(m[1], "?") || strings.HasPrefix(m[1], "#") {
+		return
+	}
+	c.unmatched++
+	if len(c.examples) < maxUnmatchedExamples {
+		c.examples = append(c.examples, strings.TrimSpace(line))
+	}
+}
+
+// err returns ErrUnmatchedLines (along with some of the lines) if there were too many unparsed lines
+func (c *lineCheck) err() error {
+	if c.maxRatio <= 0 || c.unmatched == 0 {
+		return nil
+	}
+	if float64(c.unmatched) <= c.maxRatio*float64(c.matched) {
+		return nil
+	}
+	return fmt.Errorf("%w: %d unparsed, %d parsed, such as: %s",
+		ErrUnmatchedLines, c.unmatched, c.matched, strings.Join(c.examples, " | "))
+}
@@ -0,0 +1,64 @@
+package scraper
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_StrictParse(t *testing.T) {
+	// a listing where most lines have a date format that the nginx scraper doesn't understand
+	drifted := strings.Join([]string{
+		`<html><head><title>Index of /</title></head><body><h1>Index of /</h1><hr><pre><a href="../">../</a>`,
+		`<a href="sub/">sub/</a>                                               03-Jan-2024 17:45                   -`,
+		`<a href="a.zip">a.zip</a>                                             03-Jan-2024 17:45                 541`,
+		`<a href="b.zip">b.zip</a>                                             2024-01-03T17:45Z                 541`,
+		`<a href="c.zip">c.zip</a>                                             2024-01-03T17:45Z                 541`,
+		`</pre><hr></body></html>`,
+	}, "\n")
+
+	cases := []struct {
+		Name          string
+		Scraper       readerScraper
+		Fixture       string
+		Listing       string
+		ExpectedError bool
+	}{
+		{"nginx", AutoIndex{Server: "nginx", StrictRatio: 0.01}, "mirror.nginx", "", false},
+		{"apache table", AutoIndex{Server: "apache", StrictRatio: 0.01}, "mirror.apache", "", false},
+		{"apache pre", AutoIndex{Server: "apache", StrictRatio: 0.01}, "mirror.apache.pre", "", false},
+		{"archive.org simple", ArchiveDotOrg{StrictRatio: 0.01}, "images.tv.simple", "", false},
+		{"archive.org long names", ArchiveDotOrg{StrictRatio: 0.01}, "longnames.simple", "", false},
+		{"drifted", AutoIndex{Server: "nginx", StrictRatio: 0.5}, "", drifted, true},
+		{"drifted within ratio", AutoIndex{Server: "nginx", StrictRatio: 1}, "", drifted, false},
+		{"drifted but not strict", AutoIndex{Server: "nginx"}, "", drifted, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var err error
+			if len(tc.Fixture) > 0 {
+				f, ferr := os.Open("testdata/" + tc.Fixture)
+				if ferr != nil {
+					t.Fatalf("error opening '%s': %v", tc.Fixture, ferr)
+				}
+				defer f.Close()
+				_, err = tc.Scraper.ScrapeFromReader(f, nil)
+			} else {
+				_, err = tc.Scraper.ScrapeFromReader(strings.NewReader(tc.Listing), nil)
+			}
+			if !tc.ExpectedError {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnmatchedLines) {
+				t.Fatalf("expected ErrUnmatchedLines, but got %v", err)
+			}
+			if !strings.Contains(err.Error(), `<a href="b.zip">`) {
+				t.Errorf("expected the error to include an unparsed line, but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package scraper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ScrapeTorrentFromReader parses an archive.org item's .torrent, and appends a remote file for each
+// file in the torrent that's directly under the BaseURL (which may be a folder within the item).
+// Torrents don't include times, so each file's Timestamp is zero.
+func (n ArchiveDotOrg) ScrapeTorrentFromReader(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error) {
+	itemURL, prefix, err := archiveItemURL(n.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	files, err := parseTorrentFiles(r)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing torrent: %w", err)
+	}
+	for _, f := range files {
+		name, ok := strings.CutPrefix(f.path, prefix)
+		// skip anything that would land in a sub-folder
+		if !ok || len(name) == 0 || strings.Contains(name, "/") {
+			continue
+		}
+		if err := checkFileLimit(len(remotes), n.MaxFiles); err != nil {
+			return remotes, err
+		}
+		parts := strings.Split(f.path, "/")
+		for i := range parts {
+			parts[i] = url.PathEscape(parts[i])
+		}
+		remotes = append(remotes, RemoteFile{
+			Name:     name,
+			SortName: SortName(name),
+			URL:      itemURL + strings.Join(parts, "/"),
+			Size:     f.length,
+		})
+	}
+	return remotes, nil
+}
+
+// archiveItemURL returns the download URL of the item (ending in a '/') in the given download URL,
+// and the prefix of the names (within that item) that are listed by the download URL.
+func archiveItemURL(baseURL string) (string, string, error) {
+	u, item, prefix, err := parseArchiveDownloadURL(baseURL)
+	if err != nil {
+		return "", "", err
+	}
+	u.Path = "/download/" + item + "/"
+	return u.String(), prefix, nil
+}
+
+// archiveTorrentURL returns the URL of the .torrent for the item in the given download URL.
+// For example, the torrent for "https://archive.org/download/images/tv" is
+// "https://archive.org/download/images/images_archive.torrent".
+func archiveTorrentURL(baseURL string) (string, error) {
+	u, item, _, err := parseArchiveDownloadURL(baseURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = "/download/" + item + "/" + item + "_archive.torrent"
+	return u.String(), nil
+}
+
+// torrentFile is a file listed in a torrent's info dictionary
+type torrentFile struct {
+	path   string // '/' separated, relative to the torrent's root
+	length int64
+}
+
+// parseTorrentFiles returns the files in a bencoded .torrent (BEP 3). In a single file torrent,
+// the file's path is the torrent's name, and in a multi-file torrent (such as archive.org's), each
+// path is relative to the torrent's name (which is the item's folder). Padding files are skipped.
+func parseTorrentFiles(r io.Reader) ([]torrentFile, error) {
+	v, err := decodeBencode(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	root, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a dictionary")
+	}
+	info, ok := root["info"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("missing info dictionary")
+	}
+
+	files, ok := info["files"].([]any)
+	if !ok {
+		name, _ := info["name"].(string)
+		length, ok := info["length"].(int64)
+		if len(name) == 0 || !ok {
+			return nil, fmt.Errorf("info dictionary has neither files, nor a name and length")
+		}
+		return []torrentFile{{path: name, length: length}}, nil
+	}
+
+	out := make([]torrentFile, 0, len(files))
+	for i, fv := range files {
+		f, ok := fv.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("file %d is not a dictionary", i)
+		}
+		length, ok := f["length"].(int64)
+		if !ok {
+			return nil, fmt.Errorf("file %d is missing its length", i)
+		}
+		pathParts, _ := f["path"].([]any)
+		parts := make([]string, 0, len(pathParts))
+		for _, p := range pathParts {
+			s, ok := p.(string)
+			if !ok || len(s) == 0 || s == "." || s == ".." || strings.Contains(s, "/") {
+				return nil, fmt.Errorf("file %d has an invalid path", i)
+			}
+			parts = append(parts, s)
+		}
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("file %d is missing its path", i)
+		}
+		if attr, _ := f["attr"].(string); strings.Contains(attr, "p") {
+			continue
+		}
+		out = append(out, torrentFile{path: strings.Join(parts, "/"), length: length})
+	}
+	return out, nil
+}
+
+// decodeBencode decodes the next bencoded value, which is one of int64, string, []any, or map[string]any.
+func decodeBencode(r *bufio.Reader) (any, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	switch {
+	case c == 'i':
+		s, err := r.ReadString('e')
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer '%s'", s[:len(s)-1])
+		}
+		return n, nil
+	case c == 'l':
+		var list []any
+		for {
+			if next, err := r.Peek(1); err == nil && next[0] == 'e' {
+				_, _ = r.ReadByte()
+				return list, nil
+			}
+			v, err := decodeBencode(r)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		dict := map[string]any{}
+		for {
+			if next, err := r.Peek(1); err == nil && next[0] == 'e' {
+				_, _ = r.ReadByte()
+				return dict, nil
+			}
+			k, err := decodeBencode(r)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("dictionary key is not a string")
+			}
+			v, err := decodeBencode(r)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+		}
+	case c >= '0' && c <= '9':
+		_ = r.UnreadByte()
+		s, err := r.ReadString(':')
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid string length '%s'", s[:len(s)-1])
+		}
+		var b strings.Builder
+		if _, err := io.CopyN(&b, r, n); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return b.String(), nil
+	}
+	return nil, fmt.Errorf("unexpected byte '%c'", c)
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, since a bencoded value was cut short
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
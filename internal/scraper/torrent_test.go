@@ -0,0 +1,89 @@
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// a multi-file torrent, as archive.org writes them (with a shortened pieces string)
+const testTorrent = "d8:announce20:http://example.com/a4:infod5:filesl" +
+	"d6:lengthi138502e4:pathl2:tv16:20010911_AZT.jpgee" +
+	"d6:lengthi132614e4:pathl2:tv20:20010911_BBC one.jpgee" +
+	"d4:attr1:p6:lengthi10e4:pathl4:.pad2:10ee" +
+	"d6:lengthi1234e4:pathl15:images_meta.xmlee" +
+	"d6:lengthi99e4:pathl2:tv3:old7:old.jpgee" +
+	"e4:name6:images12:piece lengthi524288e6:pieces4:abcde" +
+	"e"
+
+func TestParseTorrentFiles(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Torrent  string
+		Expected string
+		Error    bool
+	}{
+		{"multi-file", testTorrent,
+			"[{tv/20010911_AZT.jpg 138502} {tv/20010911_BBC one.jpg 132614} {images_meta.xml 1234} {tv/old/old.jpg 99}]", false},
+		{"single file", "d4:infod6:lengthi42e4:name5:a.zip12:piece lengthi16e6:pieces0:ee", "[{a.zip 42}]", false},
+		{"no info", "d8:announce0:e", "", true},
+		{"cut short", testTorrent[:len(testTorrent)/2], "", true},
+		{"path escapes", "d4:infod5:filesld6:lengthi1e4:pathl2:..5:a.zipeee4:name1:xee", "", true},
+		{"not bencode", "<html>", "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			files, err := parseTorrentFiles(strings.NewReader(tc.Torrent))
+			if tc.Error {
+				if err == nil {
+					t.Errorf("expected an error, but got %v", files)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := fmt.Sprint(files); actual != tc.Expected {
+				t.Errorf("expected %s, but got %s", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestArchiveDotOrg_Torrent(t *testing.T) {
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_, _ = w.Write([]byte(testTorrent))
+	}))
+	defer srv.Close()
+
+	s, err := Create("archive.org-torrent", BaseURL(srv.URL+"/download/images/tv"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remotes, err := s.ScrapeRemotes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotPath != "/download/images/images_archive.torrent" {
+		t.Errorf("expected the torrent to be requested, but got '%s'", gotPath)
+	}
+
+	expected := []RemoteFile{
+		{Name: "20010911_AZT.jpg", URL: srv.URL + "/download/images/tv/20010911_AZT.jpg", Size: 138502},
+		{Name: "20010911_BBC one.jpg", URL: srv.URL + "/download/images/tv/20010911_BBC%20one.jpg", Size: 132614},
+	}
+	if len(remotes) != len(expected) {
+		t.Fatalf("expected %d, but found %d: %v", len(expected), len(remotes), remotes)
+	}
+	for i, e := range expected {
+		r := remotes[i]
+		if r.Name != e.Name || r.URL != e.URL || !r.Timestamp.IsZero() || r.Size != e.Size {
+			t.Errorf("mismatch in entry %d: expected %v, got %v", i, e, r)
+		}
+	}
+}
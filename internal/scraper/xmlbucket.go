@@ -0,0 +1,220 @@
+package scraper
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// XMLBucket scrapes a cloud storage bucket using the XML "list objects" API that is shared
+// by Amazon S3 and Google Cloud Storage (and many S3-compatible services).
+// BaseURL is the bucket's endpoint (eg "https://storage.googleapis.com/my-bucket" or
+// "https://my-bucket.s3.amazonaws.com"), and objects are downloaded from BaseURL + "/" + key.
+// Only objects directly under Prefix are listed (ie, keys are not recursed into "sub-folders"),
+// to match the flat layout of the local folder.
+type XMLBucket struct {
+	BaseURL   string
+	Prefix    string
+	UserAgent string
+	Username  string // if set, the Username and Password are sent using HTTP basic auth
+	Password  string
+	Delay     time.Duration // between requests for each page
+	Retries   int           // for each page
+
+	// Header holds any extra headers sent with each request.
+	Header http.Header
+
+	// Client makes the requests (or a default client, if nil).
+	Client *http.Client
+
+	// MaxFiles caps how many objects the listing may have, across every page (or zero for
+	// DefaultMaxFiles).
+	MaxFiles int
+}
+
+func init() {
+	Register("xml-bucket", func(name string, opts ...Option) (Scraper, error) {
+		p := parseOptions(opts)
+		if len(p.baseURL) == 0 {
+			return nil, fmt.Errorf("missing required option: BaseURL")
+		}
+		// object URLs are built by appending to the bucket URL, so it must not end in a '/'
+		baseURL, err := NormalizeBaseURL(p.baseURL, TrailingSlashRemove)
+		if err != nil {
+			return nil, err
+		}
+		return &XMLBucket{
+			BaseURL:   baseURL,
+			Prefix:    p.prefix,
+			UserAgent: p.userAgent,
+			Username:  p.auth.username,
+			Password:  p.auth.password,
+			Delay:     p.delay,
+			Retries:   p.retries,
+			Header:    p.header,
+			Client:    clientWithTimeout(p.client, p.timeout),
+			MaxFiles:  p.maxFiles,
+		}, nil
+	}, Info{
+		Description: "a public S3 or Google Cloud Storage bucket (or anything with the S3 XML listing API)",
+		Required:    []string{"BaseURL"},
+		Optional:    []string{"Prefix", "UserAgent", "BasicAuth", "Header", "Timeout", "HTTPClient", "Delay", "Retries", "MaxFiles"},
+	})
+}
+
+type xmlListBucketResult struct {
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+	Contents    []struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		Size         int64  `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func (b XMLBucket) ScrapeRemotes() ([]RemoteFile, error) {
+	remotes := make([]RemoteFile, 0, 256)
+
+	// each page of results is capped by the server (usually at 1000), so follow the markers
+	// until the listing is no longer truncated
+	var marker string
+	for {
+		listURL, err := b.listURL(marker)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest("GET", listURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make new GET request: %w", err)
+		}
+		b.setHeaders(req)
+
+		resp, err := doWithRetry(clientFor(b.Client, b.Header), req, b.Retries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to do request: %w", err)
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected request status %d", resp.StatusCode)
+		}
+
+		var next string
+		remotes, next, err = b.scrapePage(resp.Body, remotes)
+		resp.Body.Close()
+		if errors.Is(err, ErrTooManyFiles) {
+			return remotes, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(next) == 0 {
+			return remotes, nil
+		}
+		if next == marker {
+			return nil, fmt.Errorf("listing is truncated, but marker did not advance past '%s'", marker)
+		}
+		marker = next
+		time.Sleep(b.Delay)
+	}
+}
+
+// ScrapeFromReader parses a single page of list objects XML.
+func (b XMLBucket) ScrapeFromReader(r io.Reader, remotes []RemoteFile) ([]RemoteFile, error) {
+	remotes, _, err := b.scrapePage(r, remotes)
+	return remotes, err
+}
+
+// StatRemote looks up a single object under the Prefix, using a HEAD request.
+func (b XMLBucket) StatRemote(name string) (RemoteFile, error) {
+	req, err := http.NewRequest("HEAD", b.objectURL(b.Prefix+name), nil)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to make new HEAD request: %w", err)
+	}
+	b.setHeaders(req)
+	return statWithHead(clientFor(b.Client, b.Header), req, b.Retries, name, 0)
+}
+
+// setHeaders adds the user agent, credentials, and extra headers (if any) to the request
+func (b XMLBucket) setHeaders(req *http.Request) {
+	if len(b.UserAgent) > 0 {
+		req.Header.Set("User-Agent", b.UserAgent)
+	}
+	if len(b.Username) > 0 || len(b.Password) > 0 {
+		req.SetBasicAuth(b.Username, b.Password)
+	}
+	addHeaders(req, b.Header)
+}
+
+func (b XMLBucket) listURL(marker string) (string, error) {
+	u, err := url.Parse(b.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse base url '%s': %w", b.BaseURL, err)
+	}
+	q := u.Query()
+	q.Set("delimiter", "/")
+	if len(b.Prefix) > 0 {
+		q.Set("prefix", b.Prefix)
+	}
+	if len(marker) > 0 {
+		q.Set("marker", marker)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// scrapePage parses one page of results, and returns the marker for the next page, if the
+// results were truncated.
+func (b XMLBucket) scrapePage(r io.Reader, remotes []RemoteFile) ([]RemoteFile, string, error) {
+	var result xmlListBucketResult
+	if err := xml.NewDecoder(r).Decode(&result); err != nil {
+		return remotes, "", fmt.Errorf("error parsing list objects xml: %w", err)
+	}
+
+	var lastKey string
+	for _, c := range result.Contents {
+		lastKey = c.Key
+		name := strings.TrimPrefix(c.Key, b.Prefix)
+		// skip "folder" placeholder objects, and anything that would land in a sub-folder
+		if len(name) == 0 || strings.Contains(name, "/") {
+			continue
+		}
+
+		lastModified, err := time.Parse(time.RFC3339Nano, c.LastModified)
+		if err != nil {
+			return remotes, "", fmt.Errorf("failed to parse time '%s' for '%s': %w", c.LastModified, c.Key, err)
+		}
+
+		if err := checkFileLimit(len(remotes), b.MaxFiles); err != nil {
+			return remotes, "", err
+		}
+		remotes = append(remotes, RemoteFile{
+			Name:      name,
+			SortName:  SortName(name),
+			URL:       b.objectURL(c.Key),
+			Timestamp: lastModified.UTC(),
+			Size:      c.Size,
+		})
+	}
+
+	if !result.IsTruncated {
+		return remotes, "", nil
+	}
+	if len(result.NextMarker) > 0 {
+		return remotes, result.NextMarker, nil
+	}
+	return remotes, lastKey, nil
+}
+
+func (b XMLBucket) objectURL(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return b.BaseURL + "/" + strings.Join(parts, "/")
+}
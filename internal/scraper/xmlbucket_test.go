@@ -0,0 +1,127 @@
+package scraper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestXMLBucket_ScrapedContents(t *testing.T) {
+	cases := []struct {
+		File     string
+		BaseURL  string
+		Expected []RemoteFile
+	}{
+		{"bucket.s3.xml", "https://example-bucket.s3.amazonaws.com", []RemoteFile{
+			{Name: "20010911_AZT.jpg", URL: "https://example-bucket.s3.amazonaws.com/images/tv/20010911_AZT.jpg",
+				Timestamp: time.Date(2017, 11, 13, 23, 6, 12, 0, time.UTC), Size: 138502},
+			{Name: "20010911_BBC one.jpg", URL: "https://example-bucket.s3.amazonaws.com/images/tv/20010911_BBC%20one.jpg",
+				Timestamp: time.Date(2017, 11, 13, 23, 6, 14, 0, time.UTC), Size: 132614},
+			{Name: "20010911_CNN.jpg", URL: "https://example-bucket.s3.amazonaws.com/images/tv/20010911_CNN.jpg",
+				Timestamp: time.Date(2017, 11, 13, 23, 7, 1, 0, time.UTC), Size: 152746},
+		}},
+		{"bucket.gcs.xml", "https://storage.googleapis.com/example-bucket", []RemoteFile{
+			{Name: "20010911_NHK.jpg", URL: "https://storage.googleapis.com/example-bucket/images/tv/20010911_NHK.jpg",
+				Timestamp: time.Date(2017, 11, 13, 23, 6, 12, 104000000, time.UTC), Size: 144739},
+			{Name: "20010911_NTV.jpg", URL: "https://storage.googleapis.com/example-bucket/images/tv/20010911_NTV.jpg",
+				Timestamp: time.Date(2017, 11, 13, 23, 6, 13, 517000000, time.UTC), Size: 124739},
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.File, func(t *testing.T) {
+			f, err := os.Open("testdata/" + tc.File)
+			if err != nil {
+				t.Fatalf("error opening '%s': %v", tc.File, err)
+			}
+			defer f.Close()
+
+			s := XMLBucket{BaseURL: tc.BaseURL, Prefix: "images/tv/"}
+			remotes, err := s.ScrapeFromReader(f, nil)
+			if err != nil {
+				t.Fatalf("unexpected error in ScrapeFromReader: %v", err)
+			}
+
+			if len(remotes) != len(tc.Expected) {
+				t.Fatalf("expected %d, but found %d: %v", len(tc.Expected), len(remotes), remotes)
+			}
+			for i, e := range tc.Expected {
+				r := remotes[i]
+				if r.Name != e.Name || r.URL != e.URL || !r.Timestamp.Equal(e.Timestamp) || r.Size != e.Size {
+					t.Errorf("mismatch in entry %d: expected %v, got %v", i, e, r)
+				}
+			}
+		})
+	}
+}
+
+func TestXMLBucket_FollowsMarkers(t *testing.T) {
+	pageOne, err := os.ReadFile("testdata/bucket.s3.xml")
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+	pageTwo, err := os.ReadFile("testdata/bucket.gcs.xml")
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") != "images/tv/" || r.URL.Query().Get("delimiter") != "/" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("marker") {
+		case "":
+			_, _ = w.Write(pageOne)
+		case "images/tv/20010911_CNN.jpg":
+			_, _ = w.Write(pageTwo)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := Create("xml-bucket", BaseURL(srv.URL+"/"), Prefix("images/tv/"))
+	if err != nil {
+		t.Fatalf("unexpected error creating scraper: %v", err)
+	}
+	remotes, err := s.ScrapeRemotes()
+	if err != nil {
+		t.Fatalf("unexpected error scraping: %v", err)
+	}
+	if len(remotes) != 5 {
+		t.Errorf("expected 5 files across both pages, but found %d", len(remotes))
+	}
+}
+
+func TestXMLBucket_BasicAuth(t *testing.T) {
+	page, err := os.ReadFile("testdata/bucket.gcs.xml")
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "me" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "HEAD" {
+			w.Header().Set("Content-Length", "10")
+			return
+		}
+		_, _ = w.Write(page)
+	}))
+	defer srv.Close()
+
+	s, err := Create("xml-bucket", BaseURL(srv.URL), Prefix("images/tv/"), BasicAuth("me", "secret"))
+	if err != nil {
+		t.Fatalf("unexpected error creating scraper: %v", err)
+	}
+	if _, err := s.ScrapeRemotes(); err != nil {
+		t.Errorf("unexpected error scraping: %v", err)
+	}
+	if _, err := s.(RemoteStatter).StatRemote("a.jpg"); err != nil {
+		t.Errorf("unexpected error in StatRemote: %v", err)
+	}
+}